@@ -26,14 +26,17 @@
 package parser
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"log/slog"
+	"os"
 	"strconv"
 
 	command "github.com/rcornwell/S370/command/command"
 	config "github.com/rcornwell/S370/config/configparser"
 	core "github.com/rcornwell/S370/emu/core"
+	"github.com/rcornwell/S370/emu/memory"
 	ch "github.com/rcornwell/S370/emu/sys_channel"
 )
 
@@ -58,6 +61,18 @@ var cmdList = []cmd{
 		return line.matchDevice(command.ValidRewind, false)
 	}},
 	{Name: "reset", Min: 5, Process: reset, Complete: DeviceComplete},
+	{Name: "save", Min: 2, Process: save},
+	{Name: "load", Min: 2, Process: load},
+	{Name: "dump", Min: 2, Process: dump},
+	{Name: "restore", Min: 4, Process: restore},
+	{Name: "break", Min: 2, Process: setBreak},
+	{Name: "nobreak", Min: 3, Process: clearBreak},
+	{Name: "trap", Min: 4, Process: setTrap},
+	{Name: "notrap", Min: 3, Process: clearTrap},
+	{Name: "step", Min: 2, Process: step},
+	{Name: "interrupt", Min: 3, Process: interrupt},
+	{Name: "diag", Min: 4, Process: diag},
+	{Name: "vary", Min: 4, Process: vary, Complete: DeviceComplete},
 }
 
 // Handle attach commands.
@@ -175,6 +190,23 @@ func start(_ *cmdLine, core *core.Core) (bool, error) {
 	return false, nil
 }
 
+// Press the interrupt key.
+func interrupt(_ *cmdLine, core *core.Core) (bool, error) {
+	slog.Debug("Command Interrupt")
+	core.SendInterrupt()
+	return false, nil
+}
+
+// Run built in CPU diagnostics.
+func diag(_ *cmdLine, core *core.Core) (bool, error) {
+	slog.Debug("Command Diag")
+	if core.IsRunning() {
+		return false, errors.New("can't run diagnostics when CPU is running")
+	}
+	core.SendDiag()
+	return false, nil
+}
+
 // Process the show command.
 func show(line *cmdLine, _ *core.Core) (bool, error) {
 	slog.Debug("Command Show")
@@ -264,15 +296,212 @@ func rewind(line *cmdLine, _ *core.Core) (bool, error) {
 	return false, device.Rewind()
 }
 
+// Set breakpoint, or list breakpoints if no address given.
+func setBreak(line *cmdLine, core *core.Core) (bool, error) {
+	slog.Debug("Command Break")
+	line.skipSpace()
+	if line.isEOL() {
+		for _, addr := range core.CPU().Breakpoints() {
+			fmt.Printf("Break %06x\n", addr)
+		}
+		return false, nil
+	}
+	addr, err := line.getHex()
+	if err != nil {
+		return false, err
+	}
+	core.CPU().SetBreak(addr)
+	return false, nil
+}
+
+// Clear breakpoint at address, or all breakpoints.
+func clearBreak(line *cmdLine, core *core.Core) (bool, error) {
+	slog.Debug("Command Nobreak")
+	line.skipSpace()
+	if line.isEOL() {
+		return false, errors.New("nobreak must be address or all")
+	}
+	addr, err := line.getHex()
+	if err != nil {
+		if line.getWord(false) != "all" {
+			return false, errors.New("nobreak must be address or all")
+		}
+		core.CPU().ClearAllBreaks()
+		return false, nil
+	}
+	core.CPU().ClearBreak(addr)
+	return false, nil
+}
+
+// Stop CPU on program interrupt code, or list codes if none given.
+func setTrap(line *cmdLine, core *core.Core) (bool, error) {
+	slog.Debug("Command Trap")
+	line.skipSpace()
+	if line.isEOL() {
+		for _, code := range core.CPU().Traps() {
+			fmt.Printf("Trap %02x\n", code)
+		}
+		return false, nil
+	}
+	code, err := line.getHex()
+	if err != nil || code > 0xff {
+		return false, errors.New("trap must be program interrupt code")
+	}
+	core.CPU().SetTrap(uint16(code))
+	return false, nil
+}
+
+// Clear stop on program interrupt code, or all codes.
+func clearTrap(line *cmdLine, core *core.Core) (bool, error) {
+	slog.Debug("Command Notrap")
+	line.skipSpace()
+	if line.isEOL() {
+		return false, errors.New("notrap must be code or all")
+	}
+	code, err := line.getHex()
+	if err != nil {
+		if line.getWord(false) != "all" {
+			return false, errors.New("notrap must be code or all")
+		}
+		core.CPU().ClearAllTraps()
+		return false, nil
+	}
+	core.CPU().ClearTrap(uint16(code))
+	return false, nil
+}
+
+// Step CPU given number of instructions, default one.
+func step(line *cmdLine, core *core.Core) (bool, error) {
+	slog.Debug("Command Step")
+	if core.IsRunning() {
+		return false, errors.New("can't step when CPU is running")
+	}
+	count := uint32(1)
+	line.skipSpace()
+	if !line.isEOL() {
+		var err error
+		count, err = line.getNumber()
+		if err != nil {
+			return false, err
+		}
+	}
+	core.SendStep(int(count))
+	return false, nil
+}
+
+// Get file name for save and restore.
+func (line *cmdLine) getFileName() (string, error) {
+	line.skipSpace()
+	fileName, ok := line.parseQuoteString()
+	if !ok || fileName == "" {
+		return "", errors.New("file name required")
+	}
+	return fileName, nil
+}
+
+// Save system state to a file.
+func save(line *cmdLine, core *core.Core) (bool, error) {
+	slog.Debug("Command Save")
+	fileName, err := line.getFileName()
+	if err != nil {
+		return false, err
+	}
+	var buf bytes.Buffer
+	err = core.SaveSystem(&buf)
+	if err != nil {
+		return false, err
+	}
+	return false, os.WriteFile(fileName, buf.Bytes(), 0o644)
+}
+
+// Restore system state from a file.
+func restore(line *cmdLine, core *core.Core) (bool, error) {
+	slog.Debug("Command Restore")
+	fileName, err := line.getFileName()
+	if err != nil {
+		return false, err
+	}
+	file, err := os.Open(fileName)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	return false, core.LoadSystem(file)
+}
+
+// Load a host file into storage.
+func load(line *cmdLine, _ *core.Core) (bool, error) {
+	slog.Debug("Command Load")
+	addr, err := line.getHex()
+	if err != nil {
+		return false, errors.New("load requires address")
+	}
+	fileName, err := line.getFileName()
+	if err != nil {
+		return false, err
+	}
+	num, err := memory.LoadFile(fileName, addr)
+	if err != nil {
+		return false, err
+	}
+	fmt.Printf("Loaded %x bytes at %06x\n", num, addr)
+	return false, nil
+}
+
+// Dump a range of storage to a host file.
+func dump(line *cmdLine, _ *core.Core) (bool, error) {
+	slog.Debug("Command Dump")
+	addr, err := line.getHex()
+	if err != nil {
+		return false, errors.New("dump requires address")
+	}
+	num, err := line.getHex()
+	if err != nil {
+		return false, errors.New("dump requires length")
+	}
+	fileName, err := line.getFileName()
+	if err != nil {
+		return false, err
+	}
+	return false, memory.DumpFile(fileName, addr, int(num))
+}
+
+// Vary a device online or offline.
+func vary(line *cmdLine, _ *core.Core) (bool, error) {
+	slog.Debug("Command Vary")
+	devNum, err := line.getHex()
+	if err != nil {
+		return false, err
+	}
+	switch line.getWord(false) {
+	case "online":
+		return false, ch.SetOnline(uint16(devNum), true)
+	case "offline":
+		return false, ch.SetOnline(uint16(devNum), false)
+	}
+	return false, errors.New("vary must be followed by online or offline")
+}
+
 // Reset a device.
-func reset(line *cmdLine, _ *core.Core) (bool, error) {
+func reset(line *cmdLine, sys *core.Core) (bool, error) {
 	slog.Debug("Command Reset")
 	// Get device number make sure it is valid.
 	devNum, err := line.getHex()
 	if err != nil || line.isEOL() {
 		name := line.getWord(false)
-		if name != "all" {
-			return false, errors.New("set must be device number, empty or all")
+		switch name {
+		case "program":
+			sys.SendReset(core.ProgramReset)
+			return false, nil
+		case "system":
+			sys.SendReset(core.SystemReset)
+			return false, nil
+		case "poweron":
+			sys.SendReset(core.PowerOnReset)
+			return false, nil
+		case "all":
+		default:
+			return false, errors.New("reset must be device number, all, program, system or poweron")
 		}
 
 		// If no unit number of all reset all devices.
@@ -216,6 +216,43 @@ func (line *cmdLine) parseMemoryOptions(options *memoryOpts, file bool) (byte, e
 	}
 }
 
+// Convert float64 to long floating point value, false if out of range.
+func floatToLong(val float64) (uint64, bool) {
+	var sign uint64
+
+	// Quick exit if zero
+	if val == 0 {
+		return 0, true
+	}
+
+	// Extract sign
+	if val < 0 {
+		sign = cpu.MSIGNL
+		val = -val
+	}
+
+	char := 64
+	// Determine exponent
+	for val >= 1 && char < 128 {
+		char++
+		val /= 16
+	}
+
+	for val < 1/16. && char >= 0 {
+		char--
+		val *= 16
+	}
+
+	if char < 0 || char >= 128 {
+		return 0, false
+	}
+
+	val *= 1 << 24
+	f := sign | (uint64(char) << 56) | (uint64(val) << 32)
+	f |= uint64((val - float64(uint32(val))) * float64((uint64(1) << 32)))
+	return f, true
+}
+
 // Parse a deposit item.
 func (line *cmdLine) parseDepositReg(num int) ([]uint32, error) {
 	memData := []uint32{}
@@ -262,6 +299,51 @@ func (line *cmdLine) parseDepositReg(num int) ([]uint32, error) {
 	return memData, nil
 }
 
+// Parse floating point register values, hex or decimal if -d given.
+func (line *cmdLine) parseDepositFloat(num int, options *memoryOpts) ([]uint64, error) {
+	regData := []uint64{}
+	line.skipSpace()
+	fields := strings.FieldsFunc(line.line[line.pos:], func(r rune) bool {
+		return r == ',' || r == ';' || unicode.IsSpace(r)
+	})
+	line.pos = len(line.line)
+	if len(fields) > num+1 {
+		return []uint64{}, fmt.Errorf("too many register values: %d", len(fields))
+	}
+
+	for _, field := range fields {
+		if options.decimal {
+			f, err := strconv.ParseFloat(field, 64)
+			if err != nil {
+				return []uint64{}, fmt.Errorf("not a floating point number: %s", field)
+			}
+			value, ok := floatToLong(f)
+			if !ok {
+				return []uint64{}, fmt.Errorf("value out of range: %s", field)
+			}
+			regData = append(regData, value)
+			continue
+		}
+
+		size := 8
+		if options.long {
+			size = 16
+		}
+		if len(field) > size {
+			return []uint64{}, fmt.Errorf("value out of range: %s", field)
+		}
+		value, err := strconv.ParseUint(field, 16, 64)
+		if err != nil {
+			return []uint64{}, fmt.Errorf("non hex digit encountered: %s", field)
+		}
+		if !options.long {
+			value <<= 32
+		}
+		regData = append(regData, value)
+	}
+	return regData, nil
+}
+
 // Parse a deposit item.
 func (line *cmdLine) parseDepositHex(wordSize int) ([]byte, error) {
 	memData := []byte{}
@@ -637,7 +719,7 @@ func dumpSymbolic(options *memoryOpts) {
 }
 
 // Dump register values.
-func dumpRegister(options *memoryOpts) error {
+func dumpRegister(proc *cpu.CPU, options *memoryOpts) error {
 	// Check if registers in range.
 	if options.lowRange > 15 || options.highRange > 15 {
 		return errors.New("register number too high")
@@ -647,7 +729,7 @@ func dumpRegister(options *memoryOpts) error {
 		var str string
 		switch options.regType {
 		case Dv.FPRegister:
-			value, ok := cpu.GetFPReg(int(options.lowRange), options.long)
+			value, ok := proc.GetFPReg(int(options.lowRange), options.long)
 			if !ok {
 				return fmt.Errorf("invalid register number: %d", options.lowRange)
 			}
@@ -667,7 +749,7 @@ func dumpRegister(options *memoryOpts) error {
 			options.lowRange += 2
 
 		case Dv.Register, Dv.CtlRegister:
-			value, ok := cpu.GetReg(options.regType, uint8(options.lowRange))
+			value, ok := proc.GetReg(options.regType, uint8(options.lowRange))
 			if !ok {
 				return errors.New("invalid register number")
 			}
@@ -682,7 +764,7 @@ func dumpRegister(options *memoryOpts) error {
 }
 
 // Examine memory/CPU command.
-func examine(line *cmdLine, _ *core.Core) (bool, error) {
+func examine(line *cmdLine, core *core.Core) (bool, error) {
 	var options memoryOpts
 
 	// Get options settings.
@@ -725,13 +807,13 @@ func examine(line *cmdLine, _ *core.Core) (bool, error) {
 		dumpSymbolic(&options)
 
 	case Dv.FPRegister, Dv.Register, Dv.CtlRegister:
-		err = dumpRegister(&options)
+		err = dumpRegister(core.CPU(), &options)
 
 	case Dv.PSWRegister:
-		fmt.Fprintln(options.file, cpu.GetPSW())
+		fmt.Fprintln(options.file, core.CPU().PSW())
 
 	case Dv.PCRegister:
-		fmt.Fprintf(options.file, "PC=%06x\n", cpu.GetPC())
+		fmt.Fprintf(options.file, "PC=%06x\n", core.CPU().PC())
 	}
 
 	return false, err
@@ -803,12 +885,25 @@ func deposit(line *cmdLine, core *core.Core) (bool, error) {
 			return false, regerr
 		}
 		for i, value := range regData {
-			cpu.SetReg(options.regType, uint8(i+int(options.lowRange)), value)
+			core.CPU().SetReg(options.regType, uint8(i+int(options.lowRange)), value)
 		}
 		return false, nil
 
 	case Dv.FPRegister:
-		return false, errors.New("can't deposit to floating point registers yet")
+		if (options.lowRange&1) != 0 || options.lowRange > 6 {
+			return false, fmt.Errorf("invalid register number: %d", options.lowRange)
+		}
+		num := int(options.highRange-options.lowRange) / 2
+		regData, regerr := line.parseDepositFloat(num, &options)
+		if regerr != nil {
+			return false, regerr
+		}
+		for i, value := range regData {
+			if !core.CPU().SetFPReg(int(options.lowRange)+2*i, value, options.long || options.decimal) {
+				return false, fmt.Errorf("invalid register number: %d", int(options.lowRange)+2*i)
+			}
+		}
+		return false, nil
 
 	case Dv.PSWRegister:
 		err = errors.New("can't deposit into PSW")
@@ -816,7 +911,7 @@ func deposit(line *cmdLine, core *core.Core) (bool, error) {
 	case Dv.PCRegister:
 		regData, regerr := line.parseDepositReg(1)
 		if regerr == nil {
-			cpu.SetPC(regData[0])
+			core.CPU().SetPC(regData[0])
 		}
 		return false, regerr
 	}
@@ -0,0 +1,87 @@
+/*
+ * S370 - Memory and register command tests.
+ *
+ * Copyright 2024, Richard Cornwell
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package parser
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	core "github.com/rcornwell/S370/emu/core"
+)
+
+// Run command and return what it printed.
+func runCommand(t *testing.T, sys *core.Core, command string) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	_, cmdErr := ProcessCommand(command, sys)
+	os.Stdout = stdout
+	w.Close()
+	out, _ := io.ReadAll(r)
+	if cmdErr != nil {
+		t.Fatalf("%s failed: %v", command, cmdErr)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// Deposit and examine floating point registers.
+func TestFPRegCommand(t *testing.T) {
+	sys := core.NewCPU(nil, nil, 0)
+
+	runCommand(t, sys, "deposit -l fp[0] 4128000000000000")
+	if v, _ := sys.CPU().GetFPReg(0, true); v != 0x4128000000000000 {
+		t.Errorf("FPR0 expected %016x got: %016x", uint64(0x4128000000000000), v)
+	}
+	if out := runCommand(t, sys, "examine -l fp[0]"); out != "F[0] = 4128000000000000 2.500000" {
+		t.Errorf("Examine FPR0 got: %q", out)
+	}
+
+	// Short value leaves low word alone.
+	runCommand(t, sys, "deposit -l fp[2] 00000000ffffffff")
+	runCommand(t, sys, "deposit fp[2] c1180000")
+	if v, _ := sys.CPU().GetFPReg(2, true); v != 0xc1180000ffffffff {
+		t.Errorf("FPR2 expected %016x got: %016x", uint64(0xc1180000ffffffff), v)
+	}
+	if out := runCommand(t, sys, "examine fp[2]"); out != "F[2] = c1180000 -1.500000" {
+		t.Errorf("Examine FPR2 got: %q", out)
+	}
+
+	// Decimal value.
+	runCommand(t, sys, "deposit -d fp[4] -0.25")
+	if v, _ := sys.CPU().GetFPReg(4, true); v != 0xc040000000000000 {
+		t.Errorf("FPR4 expected %016x got: %016x", uint64(0xc040000000000000), v)
+	}
+
+	if _, err := ProcessCommand("deposit fp[1] 41100000", sys); err == nil {
+		t.Error("Deposit to odd floating point register accepted")
+	}
+}
@@ -26,6 +26,7 @@
 package reader
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log/slog"
@@ -37,7 +38,21 @@ import (
 
 var Line *liner.State
 
-func ConsoleReader(core *core.Core) {
+// Read one line from console, tests may replace it.
+var readLine = func(prompt string) (string, error) {
+	return Line.Prompt(prompt)
+}
+
+// Line read from console.
+type input struct {
+	command string
+	err     error
+}
+
+// Read and process console commands until quit or ctx is canceled.
+// Prompt blocks, so it is run on its own goroutine which is left
+// behind if ctx is canceled while waiting for input.
+func ConsoleReader(ctx context.Context, core *core.Core) {
 	Line = liner.NewLiner()
 	defer Line.Close()
 
@@ -46,11 +61,38 @@ func ConsoleReader(core *core.Core) {
 		return parser.CompleteCmd(line)
 	})
 
+	done := make(chan struct{})
+	defer close(done)
+	ready := make(chan struct{}, 1)
+	lines := make(chan input)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-ready:
+			}
+			command, err := readLine("S370> ")
+			select {
+			case <-done:
+				return
+			case lines <- input{command: command, err: err}:
+			}
+		}
+	}()
+
 	for {
-		command, err := Line.Prompt("S370> ")
-		if err == nil {
-			Line.AppendHistory(command)
-			quit, cmderr := parser.ProcessCommand(command, core)
+		ready <- struct{}{}
+		var in input
+		select {
+		case <-ctx.Done():
+			return
+		case in = <-lines:
+		}
+
+		if in.err == nil {
+			Line.AppendHistory(in.command)
+			quit, cmderr := parser.ProcessCommand(in.command, core)
 			if cmderr != nil {
 				fmt.Println("Error: " + cmderr.Error())
 			}
@@ -60,9 +102,9 @@ func ConsoleReader(core *core.Core) {
 			continue
 		}
 
-		if errors.Is(err, liner.ErrPromptAborted) {
+		if errors.Is(in.err, liner.ErrPromptAborted) {
 			return
 		}
-		slog.Error("error reading line: " + err.Error())
+		slog.Error("error reading line: " + in.err.Error())
 	}
 }
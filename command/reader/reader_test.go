@@ -0,0 +1,65 @@
+/*
+ * S370 - Command reader tests.
+ *
+ * Copyright 2024, Richard Cornwell
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package reader
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// Canceling context returns from reader while waiting for input.
+func TestConsoleReaderCancel(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	prompted := make(chan struct{}, 1)
+	save := readLine
+	defer func() { readLine = save }()
+	readLine = func(_ string) (string, error) {
+		prompted <- struct{}{}
+		<-release
+		return "", nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		ConsoleReader(ctx, nil)
+		close(done)
+	}()
+
+	select {
+	case <-prompted:
+	case <-time.After(time.Second):
+		t.Fatal("Reader never prompted")
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Reader did not return after cancel")
+	}
+}
@@ -0,0 +1,163 @@
+/*
+ * S370 - JSON and YAML machine description loader
+ *
+ * Copyright 2024, Richard Cornwell
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package configparser
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+/* Machine description in JSON, selected by .json file extension:
+ *
+ *  {
+ *    "cpu":      { "model": "145", "options": ["nofloat"] },
+ *    "memory":   "512K",
+ *    "channels": [ { "address": "0", "options": ["mpx", "sub=32"] } ],
+ *    "devices":  [ { "model": "2400", "address": "130-131" } ],
+ *    "directives": [ "offline 131", "ipl 130" ]
+ *  }
+ *
+ * The same description may be written in YAML, selected by .yaml or .yml
+ * file extension:
+ *
+ *  cpu: { model: "145", options: [nofloat] }
+ *  memory: 512K
+ *  channels:
+ *    - { address: "0", options: [mpx, sub=32] }
+ *  devices:
+ *    - { model: "2400", address: 130-131 }
+ *  directives: [offline 131, ipl 130]
+ *
+ * Each entry is turned into the matching configuration line and parsed
+ * the same way as a text file, in the order above. Error line numbers
+ * give the entry number.
+ */
+
+// Machine description.
+type machineDesc struct {
+	CPU        *entryDesc  `json:"cpu" yaml:"cpu"`               // CPU model and features.
+	Memory     string      `json:"memory" yaml:"memory"`         // Memory size.
+	Channels   []entryDesc `json:"channels" yaml:"channels"`     // Channels by number.
+	Devices    []entryDesc `json:"devices" yaml:"devices"`       // I/O devices.
+	Directives []string    `json:"directives" yaml:"directives"` // Any other configuration lines.
+}
+
+// One channel, device or CPU entry.
+type entryDesc struct {
+	Model   string   `json:"model" yaml:"model"`     // Device or CPU model.
+	Address string   `json:"address" yaml:"address"` // Channel or device address.
+	Options []string `json:"options" yaml:"options"` // Options as written in text file.
+}
+
+// Quote value after = if it would not parse as a plain string.
+func quoteOption(opt string) string {
+	name, value, ok := strings.Cut(opt, "=")
+	if !ok || value == "" || value[0] == '"' || !strings.ContainsAny(value, " \t,#\"") {
+		return opt
+	}
+	return name + "=\"" + strings.ReplaceAll(value, "\"", "\"\"") + "\""
+}
+
+// Build configuration line from directive, address and options.
+func (entry *entryDesc) line(directive string) string {
+	fields := []string{directive, entry.Address}
+	for _, opt := range entry.Options {
+		fields = append(fields, quoteOption(opt))
+	}
+	return strings.Join(fields, " ")
+}
+
+// Return configuration lines for machine description.
+func (desc *machineDesc) lines() []string {
+	lines := []string{}
+	if desc.CPU != nil {
+		cpu := entryDesc{Address: "MODEL", Options: append([]string{desc.CPU.Model}, desc.CPU.Options...)}
+		lines = append(lines, cpu.line("CPU"))
+	}
+	if desc.Memory != "" {
+		lines = append(lines, "MEMSIZE "+desc.Memory)
+	}
+	for _, channel := range desc.Channels {
+		lines = append(lines, channel.line("CHANNEL"))
+	}
+	for _, device := range desc.Devices {
+		lines = append(lines, device.line(device.Model))
+	}
+	return append(lines, desc.Directives...)
+}
+
+// Load in a JSON machine description.
+func loadJSONFile(name string) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var desc machineDesc
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&desc); err != nil {
+		return &ConfigError{File: name, Err: err}
+	}
+	return loadDesc(name, &desc)
+}
+
+// Load in a YAML machine description.
+func loadYAMLFile(name string) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var desc machineDesc
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&desc); err != nil {
+		return &ConfigError{File: name, Err: err}
+	}
+	return loadDesc(name, &desc)
+}
+
+// Parse configuration lines built from machine description.
+func loadDesc(name string, desc *machineDesc) error {
+	lineNumber = 0
+	for _, text := range desc.lines() {
+		lineNumber++
+		slog.Debug("entry " + text)
+		line := optionLine{line: text}
+		if err := line.parseLine(); err != nil {
+			return fileError(name, err)
+		}
+	}
+	slog.Debug(strings.Join(ModelList, ", "))
+	return nil
+}
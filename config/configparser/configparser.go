@@ -31,6 +31,7 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"unicode"
@@ -54,9 +55,10 @@ type modelName struct {
 
 // Option after model.
 type FirstOption struct {
-	devNum uint16 // Value of option if hex.
-	isAddr bool   // Valid address in devNum
-	value  string // String value of option.
+	devNum  uint16 // Value of option if hex.
+	lastNum uint16 // Last address of range, same as devNum if no range.
+	isAddr  bool   // Valid address in devNum
+	value   string // String value of option.
 }
 
 // Current option line being parsed.
@@ -72,7 +74,7 @@ type optionLine struct {
  *            'logfile' <quoteopt> |
  *            'log' <string> *(<commaopt>)
  * <model> := <string> ['-' <letter>|<number>] ['/' <letter>|<number>]
- * <address> ::= <string> | <hexnumber>| <number><K|M>
+ * <address> ::= <string> | <hexnumber> ['-' <hexnumber>] | <number><K|M>
  * <options> ::= *(<option> *(<whitespace>))
  * <option> ::= *<value> (<whitespace> | <eol>
  * <value> ::= <opt> *(',' *(<whitespace>) <string>
@@ -175,12 +177,26 @@ func createModel(mod string, first *FirstOption, options []Option) error {
 	if first.devNum == D.NoDev {
 		return errors.New("Model: " + mod + " requires device number")
 	}
-	slog.Debug("Creating device " + mod + " number " + first.value)
-	err := model.create(first.devNum, "", options)
-	if err == nil {
-		ModelList = append(ModelList, first.value)
+	if first.lastNum <= first.devNum {
+		slog.Debug("Creating device " + mod + " number " + first.value)
+		err := model.create(first.devNum, "", options)
+		if err == nil {
+			ModelList = append(ModelList, first.value)
+		}
+		return err
+	}
+
+	// Create each device in range.
+	for devNum := first.devNum; devNum <= first.lastNum; devNum++ {
+		value := fmt.Sprintf("%03x", devNum)
+		slog.Debug("Creating device " + mod + " number " + value)
+		err := model.create(devNum, "", options)
+		if err != nil {
+			return err
+		}
+		ModelList = append(ModelList, value)
 	}
-	return err
+	return nil
 }
 
 // Create a option with one parameter.
@@ -242,8 +258,46 @@ func createFile(mod string, fileName string) error {
 	return model.create(D.NoDev, fileName, nil)
 }
 
-// Load in a configuration file.
+// Error found while loading a configuration file.
+type ConfigError struct {
+	File  string // Name of configuration file
+	Line  int    // Line number in file
+	Token string // Directive or value in error
+	Err   error  // Cause of error
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("%s:%d: %s: %v", e.File, e.Line, e.Token, e.Err)
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// Create error for token on current line.
+func configError(token string, err error) error {
+	return &ConfigError{Line: lineNumber, Token: token, Err: err}
+}
+
+// Add file name to error found on current line.
+func fileError(name string, err error) error {
+	var cfgErr *ConfigError
+	if !errors.As(err, &cfgErr) {
+		cfgErr = &ConfigError{Line: lineNumber, Err: err}
+	}
+	cfgErr.File = name
+	return cfgErr
+}
+
+// Load in a configuration file, .json and .yaml files hold a machine description.
 func LoadConfigFile(name string) error {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+		return loadJSONFile(name)
+	case ".yaml", ".yml":
+		return loadYAMLFile(name)
+	}
+
 	file, err := os.Open(name)
 	if err != nil {
 		return err
@@ -276,8 +330,7 @@ func LoadConfigFile(name string) error {
 		slog.Debug(msg)
 		err = line.parseLine()
 		if err != nil {
-			err := fmt.Errorf("line %d: %w", lineNumber, err)
-			return err
+			return fileError(name, err)
 		}
 		line.line = ""
 	}
@@ -295,74 +348,77 @@ func (line *optionLine) parseLine() error {
 	case TypeModel, TypeDash, TypeSlash:
 		// Get device number
 		first := line.parseFirst()
-		if first == nil || !first.isAddr {
-			return fmt.Errorf("device %s: requires device address, line: %d", model.model, lineNumber)
+		if first == nil {
+			return configError(model.model, errors.New("requires device address"))
+		}
+		if !first.isAddr {
+			return configError(first.value, fmt.Errorf("invalid device address for %s", model.model))
 		}
 
 		// Get any remaining options.
 		options, err := line.parseOptions()
 		if err != nil {
-			return err
+			return configError(model.model, err)
 		}
 
 		// Try and create the device.
 		err = createModel(model.model, first, options)
 		if err != nil {
-			return fmt.Errorf("device %s: %w, line: %d %s", model.model, err, lineNumber, line.line)
+			return configError(first.value, fmt.Errorf("device %s: %w", model.model, err))
 		}
 
 	case TypeOption:
 		first := line.parseFirst()
 		line.skipSpace()
 		if !line.isEOL() || first == nil {
-			return fmt.Errorf("option %s: not followed by value. line: %d", model.model, lineNumber)
+			return configError(model.model, errors.New("not followed by value"))
 		}
 		err := createOption(model.model, first)
 		if err != nil {
-			return fmt.Errorf("option %s: %w, line: %d %s", model.model, err, lineNumber, line.line)
+			return configError(first.value, fmt.Errorf("option %s: %w", model.model, err))
 		}
 
 	case TypeOptions:
 		first := line.parseFirst()
 		if first == nil {
-			return fmt.Errorf("option %s: not followed by value, line: %d", model.model, lineNumber)
+			return configError(model.model, errors.New("not followed by value"))
 		}
 		options, err := line.parseOptions()
 		if err != nil {
-			return err
+			return configError(model.model, err)
 		}
 		err = createOptions(model.model, first, options)
 		if err != nil {
-			return fmt.Errorf("option %s: %w, line: %d %s", model.model, err, lineNumber, line.line)
+			return configError(first.value, fmt.Errorf("option %s: %w", model.model, err))
 		}
 
 	case TypeSwitch:
 		line.skipSpace()
 		if !line.isEOL() {
-			return fmt.Errorf("switch %s: followed by options, line: %d", model.model, lineNumber)
+			return configError(model.model, errors.New("switch followed by options"))
 		}
 		err := createSwitch(model.model)
 		if err != nil {
-			return fmt.Errorf("switch %s: %w, line: %d %s", model.model, err, lineNumber, line.line)
+			return configError(model.model, err)
 		}
 
 	case TypeFile:
 		line.skipSpace()
 		line.pos-- // Back up one position.
 		if line.isEOL() {
-			return fmt.Errorf("file %s: requires a file name, line %d", model.model, lineNumber)
+			return configError(model.model, errors.New("requires a file name"))
 		}
 		v, ok := line.parseQuoteString()
 		if !ok {
-			return fmt.Errorf("invalid quoted string line: %d [%d]", lineNumber, line.pos)
+			return configError(model.model, fmt.Errorf("invalid quoted string at %d", line.pos))
 		}
 		err := createFile(model.model, v)
 		if err != nil {
-			return fmt.Errorf("file %s: %w, line: %d %s", model.model, err, lineNumber, line.line)
+			return configError(v, fmt.Errorf("file %s: %w", model.model, err))
 		}
 
 	case 0:
-		return fmt.Errorf("no type: %s registered, line: %d", model.model, lineNumber)
+		return configError(model.model, errors.New("unknown directive"))
 	}
 	return nil
 }
@@ -462,14 +518,38 @@ func (line *optionLine) parseFirst() *FirstOption {
 		break
 	}
 
-	option := FirstOption{devNum: D.NoDev, value: value}
+	option := FirstOption{devNum: D.NoDev, lastNum: D.NoDev, value: value}
 
 	devNum, ok := strconv.ParseUint(value, 16, 12)
 
 	if ok == nil {
 		option.devNum = uint16(devNum)
+		option.lastNum = option.devNum
 		option.isAddr = true
 	}
+
+	// Check for range of addresses.
+	if option.isAddr && !line.isEOL() && line.line[line.pos] == '-' {
+		line.pos++
+		last := ""
+		for !line.isEOL() {
+			by := line.line[line.pos]
+			if !unicode.IsLetter(rune(by)) && !unicode.IsNumber(rune(by)) {
+				break
+			}
+			last += string([]byte{by})
+			line.pos++
+		}
+		lastNum, ok := strconv.ParseUint(last, 16, 12)
+		if ok != nil || uint16(lastNum) < option.devNum {
+			option.isAddr = false
+			option.devNum = D.NoDev
+			option.lastNum = D.NoDev
+			return &option
+		}
+		option.lastNum = uint16(lastNum)
+		option.value += "-" + last
+	}
 	return &option
 }
 
@@ -520,7 +600,7 @@ func (line *optionLine) getName() (string, error) {
 	by := line.line[line.pos]
 	if !unicode.IsLetter(rune(by)) && !unicode.IsNumber(rune(by)) {
 		if !line.isEOL() {
-			return "", fmt.Errorf("invalid option encountered line: %d [%d]", lineNumber, line.pos)
+			return "", fmt.Errorf("invalid option encountered at %d", line.pos)
 		}
 		return "", nil
 	}
@@ -564,7 +644,7 @@ func (line *optionLine) parseOption() (*Option, error) {
 		if ok {
 			option.EqualOpt = v
 		} else {
-			return nil, fmt.Errorf("invalid quoted string line: %d [%d]", lineNumber, line.pos)
+			return nil, fmt.Errorf("invalid quoted string at %d", line.pos)
 		}
 	}
 
@@ -26,6 +26,10 @@
 package configparser
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	D "github.com/rcornwell/S370/emu/device"
@@ -828,3 +832,114 @@ func TestParseLineModelOptionsQuote3(t *testing.T) {
 		t.Errorf("ParseLine gave device some extra options: %d", len(testOptions))
 	}
 }
+
+// Test parsing of model with range of addresses.
+func TestParseLineModelRange(t *testing.T) {
+	cleanUpConfig()
+
+	devices := []uint16{}
+	RegisterModel("testDevice", TypeModel, func(devNum uint16, _ string, _ []Option) error {
+		devices = append(devices, devNum)
+		return nil
+	})
+
+	line := optionLine{line: "testDevice 130-133 opt", pos: 0}
+	err := line.parseLine()
+	if err != nil {
+		t.Errorf("ParseLine failed to parse address range: %v", err)
+	}
+	if len(devices) != 4 {
+		t.Fatalf("ParseLine created %d devices wanted: %d", len(devices), 4)
+	}
+	for i, devNum := range devices {
+		if devNum != uint16(0x130+i) {
+			t.Errorf("ParseLine device %d got: %03x wanted: %03x", i, devNum, 0x130+i)
+		}
+	}
+
+	devices = devices[:0]
+	line = optionLine{line: "testDevice 133-130", pos: 0}
+	err = line.parseLine()
+	if err == nil {
+		t.Errorf("ParseLine accepted reversed address range")
+	}
+	if len(devices) != 0 {
+		t.Errorf("ParseLine created devices for reversed range")
+	}
+}
+
+// Errors from config file report file, line and token.
+func TestLoadConfigFileErrors(t *testing.T) {
+	cleanUpConfig()
+
+	devices := map[uint16]bool{}
+	RegisterModel("testDevice", TypeModel, func(devNum uint16, _ string, _ []Option) error {
+		if devices[devNum] {
+			return errors.New("device already exists")
+		}
+		devices[devNum] = true
+		return nil
+	})
+
+	tests := []struct {
+		name  string
+		cfg   string
+		line  int
+		token string
+		cause string
+	}{
+		{"unknown directive", "testDevice 130\n# comment\nbogus 131\n", 3, "BOGUS", "unknown directive"},
+		{"bad address", "\ntestDevice zzz\n", 2, "zzz", "invalid device address"},
+		{"duplicate address", "testDevice 140\ntestDevice 141\ntestDevice 140 opt\n", 3, "140", "already exists"},
+	}
+
+	dir := t.TempDir()
+	name := filepath.Join(dir, "test.cfg")
+	for _, test := range tests {
+		clear(devices)
+		if err := os.WriteFile(name, []byte(test.cfg), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		err := LoadConfigFile(name)
+		var cfgErr *ConfigError
+		if !errors.As(err, &cfgErr) {
+			t.Errorf("%s returned %v, wanted ConfigError", test.name, err)
+			continue
+		}
+		if cfgErr.File != name {
+			t.Errorf("%s file got: %s wanted: %s", test.name, cfgErr.File, name)
+		}
+		if cfgErr.Line != test.line {
+			t.Errorf("%s line got: %d wanted: %d", test.name, cfgErr.Line, test.line)
+		}
+		if cfgErr.Token != test.token {
+			t.Errorf("%s token got: %s wanted: %s", test.name, cfgErr.Token, test.token)
+		}
+		if !strings.Contains(cfgErr.Error(), test.cause) {
+			t.Errorf("%s cause missing %q got: %s", test.name, test.cause, cfgErr.Error())
+		}
+	}
+	cleanUpConfig()
+}
+
+// Machine description gives same lines as text configuration.
+func TestMachineDescLines(t *testing.T) {
+	desc := machineDesc{
+		CPU:        &entryDesc{Model: "145", Options: []string{"nofloat"}},
+		Memory:     "512K",
+		Channels:   []entryDesc{{Address: "0", Options: []string{"mpx", "sub=32"}}},
+		Devices:    []entryDesc{{Model: "1403", Address: "00e", Options: []string{"file=a b.log"}}},
+		Directives: []string{"ipl 00c"},
+	}
+	want := []string{
+		"CPU MODEL 145 nofloat",
+		"MEMSIZE 512K",
+		"CHANNEL 0 mpx sub=32",
+		"1403 00e file=\"a b.log\"",
+		"ipl 00c",
+	}
+	got := desc.lines()
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Machine lines got: %q wanted: %q", got, want)
+	}
+}
@@ -204,6 +204,7 @@ var opMap = map[string]opcode{
 	"XC":    {op.OpXC, tySS, 0},
 	"TR":    {op.OpTR, tySS, 0},
 	"TRT":   {op.OpTRT, tySS, 0},
+	"TRTR":  {op.OpTRTR, tySS, 0},
 	"ED":    {op.OpED, tySS, 0},
 	"EDMK":  {op.OpEDMK, tySS, 0},
 	"MVCIN": {op.OpMVCIN, tySS, 0},
@@ -0,0 +1,95 @@
+/*
+   System checkpoint save and restore.
+
+   Copyright (c) 2024, Richard Cornwell
+
+   Permission is hereby granted, free of charge, to any person obtaining a
+   copy of this software and associated documentation files (the "Software"),
+   to deal in the Software without restriction, including without limitation
+   the rights to use, copy, modify, merge, publish, distribute, sublicense,
+   and/or sell copies of the Software, and to permit persons to whom the
+   Software is furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in
+   all copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.  IN NO EVENT SHALL
+   ROBERT M SUPNIK BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+   IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+   CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+*/
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/rcornwell/S370/emu/event"
+	mem "github.com/rcornwell/S370/emu/memory"
+	syschannel "github.com/rcornwell/S370/emu/sys_channel"
+)
+
+/*
+ * System checkpoint layout:
+ *
+ *   Magic:     "S370SYS"   (7 bytes)
+ *   Version:   1 byte
+ *   CPU:       CPU state and storage keys
+ *   Memory:    size and contents of main memory
+ *   Channels:  channel, subchannel and device state
+ */
+
+const (
+	systemMagic   = "S370SYS"
+	systemVersion = 1
+)
+
+// Write state of CPU, memory, channels and devices to w.
+func (core *Core) SaveSystem(w io.Writer) error {
+	if core.Panel.Running() {
+		return errors.New("can't save when CPU is running")
+	}
+	if _, err := w.Write(append([]byte(systemMagic), systemVersion)); err != nil {
+		return err
+	}
+	if err := core.proc.SaveState(w); err != nil {
+		return err
+	}
+	if err := mem.Save(w); err != nil {
+		return err
+	}
+	return syschannel.SaveState(w)
+}
+
+// Read state of CPU, memory, channels and devices from r. The system must
+// be configured with the same channels and devices as when saved.
+func (core *Core) LoadSystem(r io.Reader) error {
+	if core.Panel.Running() {
+		return errors.New("can't restore when CPU is running")
+	}
+	hdr := make([]byte, len(systemMagic)+1)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return err
+	}
+	if string(hdr[:len(systemMagic)]) != systemMagic {
+		return errors.New("not a system checkpoint")
+	}
+	if hdr[len(systemMagic)] != systemVersion {
+		return fmt.Errorf("unsupported system checkpoint version: %d", hdr[len(systemMagic)])
+	}
+	if err := core.proc.LoadState(r); err != nil {
+		return err
+	}
+	if err := mem.Load(r); err != nil {
+		return err
+	}
+
+	// Devices reschedule their own events.
+	event.Reset()
+	return syschannel.LoadState(r)
+}
@@ -25,8 +25,10 @@
 package core
 
 import (
+	"fmt"
 	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	cpu "github.com/rcornwell/S370/emu/cpu"
@@ -37,17 +39,27 @@ import (
 )
 
 type Core struct {
-	wg      sync.WaitGroup
-	done    chan struct{} // Signal to shutdown simulator.
-	running bool          // Indicate when simulator should run or not.
-	Master  chan master.Packet
+	wg     sync.WaitGroup
+	done   chan struct{} // Signal to shutdown simulator.
+	steps  int           // Number of instructions left to single step.
+	pacer  throttle      // Pace CPU to wall clock.
+	clock  event.Clock   // Time base for timers.
+	proc   *cpu.CPU      // Processor run by this instance.
+	tick   time.Time     // Time of last timer update.
+	Panel  Panel         // Operator control panel.
+	Master chan master.Packet
 }
 
-// Create instance of CPU.
-func NewCPU(master chan master.Packet) *Core {
+// Create instance of CPU with address, nil clock uses host clock.
+func NewCPU(master chan master.Packet, clock event.Clock, addr uint16) *Core {
+	event.SetClock(clock)
+	clock = event.TimeBase()
 	return &Core{
 		Master: master,
 		done:   make(chan struct{}),
+		clock:  clock,
+		proc:   cpu.New(addr),
+		tick:   clock.Now(),
 	}
 }
 
@@ -55,16 +67,46 @@ func NewCPU(master chan master.Packet) *Core {
 func (core *Core) Start() {
 	core.wg.Add(1)
 	defer core.wg.Done()
-	cpu.InitializeCPU()
-	cpu.SetTod()
+	// Resume from checkpoint if one was loaded.
+	if !core.proc.Restored() {
+		core.proc.Initialize()
+		core.autoIPL()
+	}
+	core.proc.SetTod()
+	core.tick = core.clock.Now()
+	core.pacer.rate = throttleRate
+	core.pacer.reset()
 	for {
-		if core.running {
-			var cycle int
-			cycle, core.running = cpu.CycleCPU()
+		if extKey.Swap(false) {
+			core.proc.PostExtIrq()
+		}
+		idle := false
+		if core.Panel.Running() {
+			cycle, running := core.proc.Cycle()
+			if !running {
+				core.Panel.SetRun(false)
+			}
+			// With no events pending only a packet can wake the CPU.
+			idle = running && core.proc.Idle() && !event.AnyEvent()
 			event.Advance(cycle)
+			core.pacer.pace(cycle)
+			if core.steps > 0 && running {
+				core.steps--
+				if core.steps == 0 {
+					core.Panel.SetRun(false)
+					slog.Info(fmt.Sprintf("Step %06x %s", core.proc.PC(), core.proc.PSW()))
+				}
+			}
 		} else if event.AnyEvent() {
 			event.Advance(1)
 		}
+		if idle {
+			if !core.waitPacket() {
+				return
+			}
+			core.pacer.reset()
+			continue
+		}
 		select {
 		case <-core.done:
 			// Shutdone all devices.
@@ -77,6 +119,32 @@ func (core *Core) Start() {
 	}
 }
 
+// Block until a packet arrives, return false if shutting down.
+func (core *Core) waitPacket() bool {
+	select {
+	case <-core.done:
+		cpu.Shutdown()
+		return false
+	case packet := <-core.Master:
+		core.processPacket(packet)
+	}
+	return true
+}
+
+// IPL device given in configuration, CPU is left stopped if it fails.
+func (core *Core) autoIPL() {
+	if cpu.IPLDev == device.NoDev {
+		return
+	}
+	err := core.proc.IPLDevice(cpu.IPLDev)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Auto IPL of %03x failed: %s", cpu.IPLDev, err.Error()))
+		return
+	}
+	slog.Info(fmt.Sprintf("Auto IPL from %03x", cpu.IPLDev))
+	core.Panel.SetRun(true)
+}
+
 // Stop a running server.
 func (core *Core) Stop() {
 	slog.Info("Shutting down CPU")
@@ -96,9 +164,12 @@ func (core *Core) Stop() {
 	}
 }
 
+// Interrupt key pressed on a console, taken by next core to run.
+var extKey atomic.Bool
+
 // Post an external interrupt to CPU.
 func PostExtIrq() {
-	cpu.PostExtIrq()
+	extKey.Store(true)
 }
 
 // Start CPU.
@@ -116,14 +187,59 @@ func (core *Core) SendIPL(devNum uint16) {
 	core.Master <- master.Packet{DevNum: devNum, Msg: master.IPLdevice}
 }
 
+// Step CPU count instructions.
+func (core *Core) SendStep(count int) {
+	core.Master <- master.Packet{Msg: master.Step, Count: count}
+}
+
+// Types of reset.
+const (
+	ProgramReset = iota
+	SystemReset
+	PowerOnReset
+)
+
+// Reset system, CPU is stopped.
+func (core *Core) SendReset(kind int) {
+	core.Master <- master.Packet{Msg: master.Reset, Count: kind}
+}
+
+// Press interrupt key.
+func (core *Core) SendInterrupt() {
+	core.Master <- master.Packet{Msg: master.ExtInterrupt}
+}
+
+// Run built in diagnostics, storage is destroyed.
+func (core *Core) SendDiag() {
+	core.Master <- master.Packet{Msg: master.Diagnostic}
+}
+
+// Update timers once for each tick period passed on clock.
+func (core *Core) updateTimers() {
+	now := core.clock.Now()
+	// Too far behind, don't try to catch up.
+	if now.Sub(core.tick) > time.Second {
+		core.tick = now.Add(-event.TickPeriod)
+	}
+	for now.Sub(core.tick) >= event.TickPeriod {
+		core.tick = core.tick.Add(event.TickPeriod)
+		core.proc.UpdateTimer()
+	}
+}
+
 // Tell channel to post Device End for device.
 func (core *Core) SendDeviceEnd(devNum uint16) {
 	core.Master <- master.Packet{DevNum: devNum, Msg: master.DeviceEnd}
 }
 
+// Return processor run by this instance.
+func (core *Core) CPU() *cpu.CPU {
+	return core.proc
+}
+
 // Tell if CPU is currently running.
 func (core *Core) IsRunning() bool {
-	return core.running
+	return core.Panel.Running()
 }
 
 // Process a packet sent to system simulation.
@@ -136,19 +252,43 @@ func (core *Core) processPacket(packet master.Packet) {
 	case master.TelReceive:
 		syschannel.SendReceiveChar(packet.DevNum, packet.Data)
 	case master.TimeClock:
-		cpu.UpdateTimer()
+		core.updateTimers()
 	case master.IPLdevice:
-		err := cpu.IPLDevice(packet.DevNum)
+		err := core.proc.IPLDevice(packet.DevNum)
 		if err != nil {
 			slog.Error(err.Error())
 		} else {
-			core.running = true
+			core.Panel.SetRun(true)
+			core.pacer.reset()
 		}
 	case master.DeviceEnd:
 		syschannel.SetDevAttn(packet.DevNum, device.CStatusDevEnd)
+	case master.ExtInterrupt:
+		core.proc.PostExtIrq()
+	case master.Reset:
+		core.steps = 0
+		core.Panel.SetRun(false)
+		switch packet.Count {
+		case ProgramReset:
+			core.proc.ProgramReset()
+		case SystemReset:
+			core.proc.SystemReset()
+		case PowerOnReset:
+			core.proc.PowerOnReset()
+		}
+	case master.Diagnostic:
+		core.steps = 0
+		core.Panel.SetRun(false)
+		runDiags(core.proc, diagTests)
 	case master.Start:
-		core.running = true
+		core.steps = 0
+		core.Panel.SetRun(true)
+		core.pacer.reset()
 	case master.Stop:
-		core.running = false
+		core.steps = 0
+		core.Panel.SetRun(false)
+	case master.Step:
+		core.steps = packet.Count
+		core.Panel.SetRun(packet.Count > 0)
 	}
 }
@@ -0,0 +1,516 @@
+/*
+   System checkpoint tests.
+
+   Copyright (c) 2024, Richard Cornwell
+
+   Permission is hereby granted, free of charge, to any person obtaining a
+   copy of this software and associated documentation files (the "Software"),
+   to deal in the Software without restriction, including without limitation
+   the rights to use, copy, modify, merge, publish, distribute, sublicense,
+   and/or sell copies of the Software, and to permit persons to whom the
+   Software is furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in
+   all copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.  IN NO EVENT SHALL
+   ROBERT M SUPNIK BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+   IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+   CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+*/
+
+package core
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	config "github.com/rcornwell/S370/config/configparser"
+	cpu "github.com/rcornwell/S370/emu/cpu"
+	dev "github.com/rcornwell/S370/emu/device"
+	"github.com/rcornwell/S370/emu/event"
+	"github.com/rcornwell/S370/emu/master"
+	mem "github.com/rcornwell/S370/emu/memory"
+	ch "github.com/rcornwell/S370/emu/sys_channel"
+	Td "github.com/rcornwell/S370/emu/test_dev"
+)
+
+// Run CPU for a number of cycles.
+func runCycles(proc *cpu.CPU, cycles int) {
+	for range cycles {
+		c, _ := proc.Cycle()
+		if c == 0 {
+			c = 1
+		}
+		event.Advance(c)
+	}
+}
+
+// Checkpoint in the middle of a read, restore and let it finish.
+func TestCheckpointTransfer(t *testing.T) {
+	mem.SetSize(64)
+	core := NewCPU(nil, nil, 0)
+	event.Reset()
+	ch.InitializeChannels()
+	ch.AddChannel(0, dev.TypeMux, 192)
+	td := &Td.TestDev{Addr: 0xf, Mask: 0xff}
+	_ = ch.AddDevice(td, nil, 0xf)
+	_ = td.InitDev()
+	for i := range 0x10 {
+		td.Data[i] = uint8(0xf0 + i)
+	}
+	td.Max = 0x10
+
+	mem.SetMemory(0x40, 0)
+	mem.SetMemory(0x44, 0)
+	mem.SetMemory(0x78, 0)
+	mem.SetMemory(0x7c, 0x420)
+	mem.SetMemory(0x48, 0x500)
+	mem.SetMemory(0x400, 0x9c00000f) // SIO 00f
+	mem.SetMemory(0x404, 0x82000410) // LPSW 0410
+	mem.SetMemory(0x410, 0xff060000) // Wait PSW
+	mem.SetMemory(0x414, 0x14000408)
+	mem.SetMemory(0x420, 0x47f00420) // B 420
+	mem.SetMemory(0x500, 0x02000600) // Read 16 bytes
+	mem.SetMemory(0x504, 0x00000010)
+	for i := uint32(0x600); i < 0x610; i += 4 {
+		mem.SetMemory(i, 0x55555555)
+	}
+	core.proc.SetPC(0x400)
+
+	runCycles(core.proc, 80)
+	if mem.GetMemory(0x600) != 0xf0f1f2f3 || mem.GetMemory(0x60c) != 0x55555555 {
+		t.Fatalf("Transfer not in progress got: %08x %08x", mem.GetMemory(0x600), mem.GetMemory(0x60c))
+	}
+
+	var buf bytes.Buffer
+	if err := core.SaveSystem(&buf); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	// Wipe state so only the checkpoint can finish the transfer.
+	event.Reset()
+	ch.ResetChannels()
+	core.proc.Initialize()
+	clear(td.Data[:])
+	for i := uint32(0x400); i < 0x700; i += 4 {
+		mem.SetMemory(i, 0)
+	}
+
+	if err := core.LoadSystem(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if !core.proc.Restored() {
+		t.Error("CPU not marked restored after load")
+	}
+	runCycles(core.proc, 500)
+
+	if v := mem.GetMemory(0x40); v != 0x00000508 {
+		t.Errorf("CSW1 expected %08x got: %08x", 0x00000508, v)
+	}
+	if v := mem.GetMemory(0x44); v != 0x0c000000 {
+		t.Errorf("CSW2 expected %08x got: %08x", 0x0c000000, v)
+	}
+	if v := mem.GetMemory(0x38); v != 0xff06000f {
+		t.Errorf("Old I/O PSW expected %08x got: %08x", 0xff06000f, v)
+	}
+	for i := range uint32(4) {
+		v := mem.GetMemory(0x600 + i*4)
+		e := 0xf0f1f2f3 + i*0x04040404
+		if v != e {
+			t.Errorf("Data %03x expected %08x got: %08x", 0x600+i*4, e, v)
+		}
+	}
+}
+
+// Enabled wait blocks for a packet, device end wakes CPU to I/O handler.
+func TestIdleWake(t *testing.T) {
+	mem.SetSize(64)
+	core := NewCPU(make(chan master.Packet), nil, 0)
+	event.Reset()
+	ch.InitializeChannels()
+	ch.AddChannel(0, dev.TypeMux, 192)
+	td := &Td.TestDev{Addr: 0xf, Mask: 0xff}
+	_ = ch.AddDevice(td, nil, 0xf)
+	_ = td.InitDev()
+
+	mem.SetMemory(0x38, 0)
+	mem.SetMemory(0x3c, 0)
+	mem.SetMemory(0x78, 0)
+	mem.SetMemory(0x7c, 0x420)
+	mem.SetMemory(0x400, 0x82000410) // LPSW 0410
+	mem.SetMemory(0x410, 0xff060000) // Wait PSW
+	mem.SetMemory(0x414, 0x14000408)
+	mem.SetMemory(0x420, 0x47f00420) // B 420
+	core.proc.SetPC(0x400)
+
+	runCycles(core.proc, 5)
+	if !core.proc.Idle() {
+		t.Fatal("CPU not idle in wait state")
+	}
+	if event.AnyEvent() {
+		t.Fatal("Events pending in wait state")
+	}
+
+	go core.SendDeviceEnd(0xf)
+	if !core.waitPacket() {
+		t.Fatal("Wait for packet reported shutdown")
+	}
+
+	runCycles(core.proc, 1)
+	if core.proc.Idle() {
+		t.Error("CPU still idle after device end")
+	}
+	if v := core.proc.PC(); v != 0x420 {
+		t.Errorf("PC expected %06x got: %06x", 0x420, v)
+	}
+	if v := mem.GetMemory(0x38); v != 0xff06000f {
+		t.Errorf("Old I/O PSW expected %08x got: %08x", 0xff06000f, v)
+	}
+}
+
+// IPL device from configuration file and run to IPL PSW address.
+func TestAutoIPL(t *testing.T) {
+	mem.SetSize(64)
+	event.Reset()
+	ch.InitializeChannels()
+	ch.AddChannel(0, dev.TypeMux, 192)
+	td := &Td.TestDev{Addr: 0xf, Mask: 0xff}
+	_ = ch.AddDevice(td, nil, 0xf)
+	_ = td.InitDev()
+	defer func() { cpu.IPLDev = dev.NoDev }()
+
+	// IPL PSW and a single NOP CCW.
+	record := []uint8{
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x05, 0x00,
+		0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	copy(td.Data[:], record)
+	mem.SetMemory(0x500, 0x47f00500) // B 500
+
+	name := filepath.Join(t.TempDir(), "ipl.cfg")
+	if err := os.WriteFile(name, []byte("IPL 00f\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.LoadConfigFile(name); err != nil {
+		t.Fatalf("Config failed: %v", err)
+	}
+	if cpu.IPLDev != 0xf {
+		t.Fatalf("IPL device expected %03x got: %03x", 0xf, cpu.IPLDev)
+	}
+
+	core := NewCPU(nil, nil, 0)
+	core.autoIPL()
+	if !core.Panel.Running() {
+		t.Fatal("CPU not running after auto IPL")
+	}
+	// Reset by IPL clears record length, device reads it on first event.
+	td.Max = len(record)
+	runCycles(core.proc, 500)
+	if v := core.proc.PC(); v != 0x500 {
+		t.Errorf("PC expected %06x got: %06x", 0x500, v)
+	}
+
+	// Missing device leaves CPU stopped.
+	cpu.IPLDev = 0xe
+	core = NewCPU(nil, nil, 0)
+	core.autoIPL()
+	if core.Panel.Running() {
+		t.Error("CPU running after failed auto IPL")
+	}
+}
+
+// Run CPU throttled and check wall time.
+func TestThrottle(t *testing.T) {
+	mem.SetSize(64)
+	proc := cpu.New(0)
+	event.Reset()
+	mem.SetMemory(0x400, 0x47f00400) // B 400
+	proc.SetPC(0x400)
+
+	if err := setThrottle(0, "500K", nil); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { throttleRate = 0 }()
+	if throttleRate != 500000 {
+		t.Errorf("Throttle rate got: %d wanted: %d", throttleRate, 500000)
+	}
+
+	pacer := throttle{rate: throttleRate}
+	pacer.reset()
+	start := time.Now()
+	total := 0
+	for total < 50000 {
+		c, _ := proc.Cycle()
+		if c == 0 {
+			c = 1
+		}
+		total += c
+		event.Advance(c)
+		pacer.pace(c)
+	}
+	elapsed := time.Since(start)
+	want := time.Duration(total) * time.Second / 500000
+	if elapsed < want-(want/10) || elapsed > want+(time.Second/2) {
+		t.Errorf("Throttle elapsed got: %v wanted: %v", elapsed, want)
+	}
+
+	for _, rate := range []string{"12X", "1Z0", "50"} {
+		if err := setThrottle(0, rate, nil); err == nil {
+			t.Errorf("Throttle %s did not give error", rate)
+		}
+	}
+}
+
+// Manual clock advanced to clock comparator value gives interrupt on that tick.
+func TestClockComparator(t *testing.T) {
+	mem.SetSize(64)
+	event.Reset()
+	clk := event.NewManualClock(time.Date(2024, time.June, 30, 0, 0, 0, 0, time.UTC))
+	core := NewCPU(nil, clk, 0)
+	defer event.SetClock(nil)
+	core.proc.PowerOnReset()
+
+	mem.SetMemory(0x50, 0x7fff0000) // Keep interval timer quiet
+	mem.SetMemory(0x58, 0x00000000) // External new PSW
+	mem.SetMemory(0x5c, 0x00000500)
+	mem.SetMemory(0x400, 0xb2050600) // STCK 600
+	mem.SetMemory(0x404, 0xb2060608) // SCKC 608
+	mem.SetMemory(0x408, 0xb7000610) // LCTL 0,0,610
+	mem.SetMemory(0x40c, 0x82000618) // LPSW 618
+	mem.SetMemory(0x500, 0x47f00500) // B 500
+	mem.SetMemory(0x610, 0x00000800) // Clock comparator subclass
+	mem.SetMemory(0x618, 0x01020000) // Enabled wait PSW
+	mem.SetMemory(0x61c, 0x00000700)
+	core.proc.SetPC(0x400)
+	runCycles(core.proc, 1)
+
+	// Compare value is reached on third tick.
+	tod := (uint64(mem.GetMemory(0x600)) << 32) | uint64(mem.GetMemory(0x604))
+	cmp := tod + 3*26666666 - 1
+	mem.SetMemory(0x608, uint32(cmp>>32))
+	mem.SetMemory(0x60c, uint32(cmp))
+	runCycles(core.proc, 10)
+
+	for tick := 1; tick <= 3; tick++ {
+		clk.Advance(event.TickPeriod / 2)
+		core.processPacket(master.Packet{Msg: master.TimeClock})
+		clk.Advance(event.TickPeriod / 2)
+		core.processPacket(master.Packet{Msg: master.TimeClock})
+		runCycles(core.proc, 10)
+		pc := core.proc.PC()
+		if tick < 3 && pc == 0x500 {
+			t.Fatalf("Clock comparator interrupt taken early on tick %d", tick)
+		}
+		if tick == 3 && pc != 0x500 {
+			t.Fatalf("Clock comparator interrupt not taken on tick %d PC: %06x", tick, pc)
+		}
+	}
+	if code := mem.GetMemory(0x18) & 0xffff; code != 0x1004 {
+		t.Errorf("Clock comparator code got: %04x wanted: %04x", code, 0x1004)
+	}
+}
+
+// Interrupt key gives external interrupt.
+func TestInterruptKey(t *testing.T) {
+	mem.SetSize(64)
+	core := NewCPU(make(chan master.Packet), nil, 0)
+	event.Reset()
+	ch.InitializeChannels()
+
+	mem.SetMemory(0x18, 0)
+	mem.SetMemory(0x1c, 0)
+	mem.SetMemory(0x58, 0)
+	mem.SetMemory(0x5c, 0x420)
+	mem.SetMemory(0x84, 0)
+	mem.SetMemory(0x400, 0x82000410) // LPSW 0410
+	mem.SetMemory(0x410, 0x010a0000) // EC Wait PSW, external enabled
+	mem.SetMemory(0x414, 0x00000408)
+	mem.SetMemory(0x420, 0x47f00420) // B 420
+	core.proc.SetPC(0x400)
+
+	runCycles(core.proc, 5)
+	if !core.proc.Idle() {
+		t.Fatal("CPU not idle in wait state")
+	}
+
+	go core.SendInterrupt()
+	if !core.waitPacket() {
+		t.Fatal("Wait for packet reported shutdown")
+	}
+
+	runCycles(core.proc, 1)
+	if v := core.proc.PC(); v != 0x420 {
+		t.Errorf("PC expected %06x got: %06x", 0x420, v)
+	}
+	if v := mem.GetMemory(0x18); v != 0x010a0000 {
+		t.Errorf("External old PSW expected %08x got: %08x", 0x010a0000, v)
+	}
+	if v := mem.GetMemory(0x1c); v != 0x00000408 {
+		t.Errorf("External old PSW expected %08x got: %08x", 0x00000408, v)
+	}
+	if v := mem.GetMemory(0x84) & 0xffff; v != 0x0040 {
+		t.Errorf("External interrupt code expected %04x got: %04x", 0x0040, v)
+	}
+}
+
+// IPL from device and start at address in IPL PSW.
+func TestIPL(t *testing.T) {
+	mem.SetSize(64)
+	event.Reset()
+	ch.InitializeChannels()
+	ch.AddChannel(0, dev.TypeMux, 192)
+	td := &Td.TestDev{Addr: 0xf, Mask: 0xff}
+	_ = ch.AddDevice(td, nil, 0xf)
+
+	for i := uint32(0); i < 0x20; i += 4 {
+		mem.SetMemory(i, 0xffffffff)
+	}
+	mem.SetMemory(0x600, 0x41100005) // LA 1,5
+	mem.SetMemory(0x604, 0x47f00604) // B 604
+
+	core := NewCPU(make(chan master.Packet), nil, 0)
+	core.processPacket(master.Packet{Msg: master.IPLdevice, DevNum: 0xf})
+	if !core.Panel.Running() {
+		t.Fatal("CPU not running after IPL")
+	}
+
+	// IPL record, PSW followed by NOP CCW.
+	record := []uint8{
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x06, 0x00,
+		0x03, 0x00, 0x00, 0x00, 0x20, 0x00, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	copy(td.Data[:], record)
+	td.Max = len(record)
+
+	for range 2000 {
+		runCycles(core.proc, 1)
+		if core.proc.PC() == 0x604 {
+			break
+		}
+	}
+	if v := core.proc.PC(); v != 0x604 {
+		t.Fatalf("PC expected %06x got: %06x", 0x604, v)
+	}
+	if v, _ := core.proc.GetReg(dev.Register, 1); v != 5 {
+		t.Errorf("Register 1 expected %08x got: %08x", 5, v)
+	}
+	if v := mem.GetMemory(0x8); v != 0x03000000 {
+		t.Errorf("IPL CCW expected %08x got: %08x", 0x03000000, v)
+	}
+	if v := mem.GetMemory(0xb8) & 0xffff; v != 0xf {
+		t.Errorf("IPL device expected %04x got: %04x", 0xf, v)
+	}
+}
+
+// Store data switches through operator panel.
+func TestPanelStore(t *testing.T) {
+	mem.SetSize(64)
+	mem.SetMemory(0x1234, 0)
+
+	core := NewCPU(make(chan master.Packet), nil, 0)
+	core.Panel.SetAddress(0x1234)
+	core.Panel.SetData(0xdeadbeef)
+	core.Panel.SetLoadUnit(0x00c)
+	if v := core.Panel.LoadUnit(); v != 0x00c {
+		t.Errorf("Load unit expected %03x got: %03x", 0x00c, v)
+	}
+	if err := core.Panel.Store(); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	if v := mem.GetMemory(0x1234); v != 0xdeadbeef {
+		t.Errorf("Store expected %08x got: %08x", 0xdeadbeef, v)
+	}
+	if v, err := core.Panel.Display(); err != nil || v != 0xdeadbeef {
+		t.Errorf("Display expected %08x got: %08x %v", 0xdeadbeef, v, err)
+	}
+
+	core.Panel.SetAddress(0x1236)
+	if err := core.Panel.Store(); err == nil {
+		t.Error("Store to unaligned address did not fail")
+	}
+	core.Panel.SetAddress(0x10000)
+	if err := core.Panel.Store(); err == nil {
+		t.Error("Store past end of memory did not fail")
+	}
+
+	// Start and stop keys set panel run state.
+	core.processPacket(master.Packet{Msg: master.Start})
+	if !core.Panel.Running() || !core.IsRunning() {
+		t.Error("CPU not running after start")
+	}
+	core.Panel.SetAddress(0x1234)
+	if err := core.Panel.Store(); err == nil {
+		t.Error("Store while running did not fail")
+	}
+	core.processPacket(master.Packet{Msg: master.Stop})
+	if core.Panel.Running() {
+		t.Error("CPU running after stop")
+	}
+}
+
+// Run built in diagnostics through CPU.
+func TestDiagnostics(t *testing.T) {
+	mem.SetSize(64)
+	event.Reset()
+	ch.InitializeChannels()
+	proc := cpu.New(0)
+
+	for _, test := range diagTests {
+		pass, err := test.run(proc)
+		if err != nil {
+			t.Errorf("Diagnostic %s error: %v", test.name, err)
+		}
+		if !pass {
+			t.Errorf("Diagnostic %s failed at %06x", test.name, proc.PC())
+		}
+	}
+
+	// Broken test must report failure.
+	bad := []diagTest{{name: "bad", code: []string{"LA 1,1", "LTR 1,1", "BC 7,300", "BC 15,308"}}}
+	if failed := runDiags(proc, bad); failed != 1 {
+		t.Errorf("Diagnostic failures expected %d got: %d", 1, failed)
+	}
+	if failed := runDiags(proc, diagTests); failed != 0 {
+		t.Errorf("Diagnostic failures expected %d got: %d", 0, failed)
+	}
+}
+
+// Each CPU instance stores its own address with STAP.
+func TestStoreCPUAddr(t *testing.T) {
+	mem.SetSize(64)
+	event.Reset()
+
+	cores := []*Core{NewCPU(nil, nil, 0), NewCPU(nil, nil, 1)}
+	mem.SetMemory(0x400, 0xb2120500) // STAP 500
+	mem.SetMemory(0x410, 0xb2120504) // STAP 504
+	mem.SetMemory(0x500, 0xffffffff)
+	mem.SetMemory(0x504, 0xffffffff)
+	for i, core := range cores {
+		core.proc.SetPC(0x400 + uint32(i)*0x10)
+	}
+
+	// Both instances run side by side.
+	for _, core := range cores {
+		if _, running := core.proc.Cycle(); !running {
+			t.Fatalf("CPU %d stopped", core.proc.Addr())
+		}
+	}
+	for i, core := range cores {
+		want := uint32(i)<<16 | 0xffff
+		if v := mem.GetMemory(0x500 + uint32(i)*4); v != want {
+			t.Errorf("CPU %d STAP expected %08x got: %08x", i, want, v)
+		}
+		if pc := core.proc.PC(); pc != 0x404+uint32(i)*0x10 {
+			t.Errorf("CPU %d PC expected %06x got: %06x", i, 0x404+uint32(i)*0x10, pc)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+/*
+   Built in CPU self test diagnostics.
+
+   Copyright (c) 2024, Richard Cornwell
+
+   Permission is hereby granted, free of charge, to any person obtaining a
+   copy of this software and associated documentation files (the "Software"),
+   to deal in the Software without restriction, including without limitation
+   the rights to use, copy, modify, merge, publish, distribute, sublicense,
+   and/or sell copies of the Software, and to permit persons to whom the
+   Software is furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in
+   all copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.  IN NO EVENT SHALL
+   ROBERT M SUPNIK BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+   IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+   CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+*/
+
+package core
+
+import (
+	"fmt"
+	"log/slog"
+
+	assembler "github.com/rcornwell/S370/emu/assemble"
+	cpu "github.com/rcornwell/S370/emu/cpu"
+	"github.com/rcornwell/S370/emu/event"
+	mem "github.com/rcornwell/S370/emu/memory"
+)
+
+/*
+   Each diagnostic is loaded at diagStart and run until the CPU stops
+   in a disabled wait. Branching to diagFailAddr or taking a program
+   check ends in a wait at diagFail, branching to diagPassAddr ends in
+   a wait at diagPass.
+*/
+
+const (
+	diagStart    uint32 = 0x400  // Start of diagnostic code
+	diagFailAddr uint32 = 0x300  // Branch here on failure
+	diagPassAddr uint32 = 0x308  // Branch here on success
+	diagFail     uint32 = 0xe00  // Wait PSW address of failed test
+	diagPass     uint32 = 0xf00  // Wait PSW address of passed test
+	diagCycles   int    = 100000 // Most cycles a test may run
+)
+
+// One known answer test.
+type diagTest struct {
+	name string   // Name of test
+	code []string // Assembler source
+}
+
+// Diagnostics run by diag command.
+var diagTests = []diagTest{
+	{name: "arithmetic", code: []string{
+		"LA 1,5",
+		"LA 2,7",
+		"AR 1,2", // 5 + 7 = 12
+		"LA 3,0c",
+		"CR 1,3",
+		"BC 7,300",
+		"SR 1,2", // 12 - 7 = 5
+		"LR 5,1",
+		"LA 6,3",
+		"MR 4,6", // 5 * 3 = 15
+		"LA 3,0f",
+		"CR 5,3",
+		"BC 7,300",
+		"DR 4,6", // 15 / 3 = 5 remainder 0
+		"LA 3,5",
+		"CR 5,3",
+		"BC 7,300",
+		"LTR 4,4",
+		"BC 7,300",
+		"BC 15,308",
+	}},
+	{name: "branch", code: []string{
+		"LA 1,3",
+		"SR 2,2",
+		"BALR 12,0",
+		"LA 2,1(2)", // Loop three times
+		"BCT 1,0(12)",
+		"LA 3,3",
+		"CR 2,3",
+		"BC 7,300",
+		"LTR 1,1",
+		"BC 7,300",
+		"BC 0,300", // Never taken
+		"BC 15,308",
+	}},
+}
+
+// Load handler and diagnostic code into storage.
+func (test *diagTest) load() error {
+	mem.Clear()
+	mem.SetMemory(0x68, 0x00020000) // Program new PSW, wait at fail
+	mem.SetMemory(0x6c, diagFail)
+	mem.SetMemory(diagFailAddr, 0x82000310) // LPSW 310
+	mem.SetMemory(diagPassAddr, 0x82000318) // LPSW 318
+	mem.SetMemory(0x310, 0x00020000)
+	mem.SetMemory(0x314, diagFail)
+	mem.SetMemory(0x318, 0x00020000)
+	mem.SetMemory(0x31c, diagPass)
+
+	addr := diagStart
+	for _, line := range test.code {
+		inst, err := assembler.Assemble(line)
+		if err != nil {
+			return fmt.Errorf("diagnostic %s: %s: %w", test.name, line, err)
+		}
+		mem.SetBytes(addr, inst)
+		addr += uint32(len(inst))
+	}
+	return nil
+}
+
+// Run one diagnostic, return true if it passed.
+func (test *diagTest) run(proc *cpu.CPU) (bool, error) {
+	proc.SystemReset()
+	if err := test.load(); err != nil {
+		return false, err
+	}
+	proc.SetPC(diagStart)
+	for range diagCycles {
+		cycle, running := proc.Cycle()
+		event.Advance(cycle)
+		if !running {
+			break
+		}
+	}
+	return proc.PC() == diagPass, nil
+}
+
+// Run diagnostics, report results to logger. Storage is destroyed.
+// Return number of tests that failed.
+func runDiags(proc *cpu.CPU, tests []diagTest) int {
+	failed := 0
+	for _, test := range tests {
+		pass, err := test.run(proc)
+		switch {
+		case err != nil:
+			slog.Error(err.Error())
+			failed++
+		case pass:
+			slog.Info("Diagnostic " + test.name + " passed")
+		default:
+			slog.Error(fmt.Sprintf("Diagnostic %s failed at %06x %s", test.name, proc.PC(), proc.PSW()))
+			failed++
+		}
+	}
+	proc.SystemReset()
+	return failed
+}
@@ -0,0 +1,125 @@
+/*
+   Operator control panel.
+
+   Copyright (c) 2024, Richard Cornwell
+
+   Permission is hereby granted, free of charge, to any person obtaining a
+   copy of this software and associated documentation files (the "Software"),
+   to deal in the Software without restriction, including without limitation
+   the rights to use, copy, modify, merge, publish, distribute, sublicense,
+   and/or sell copies of the Software, and to permit persons to whom the
+   Software is furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in
+   all copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.  IN NO EVENT SHALL
+   ROBERT M SUPNIK BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+   IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+   CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+*/
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	mem "github.com/rcornwell/S370/emu/memory"
+)
+
+// Manual controls on operator panel.
+type Panel struct {
+	lock    sync.Mutex
+	address uint32      // Address switches
+	data    uint32      // Data switches
+	loadDev uint16      // Load unit switches
+	run     atomic.Bool // CPU running, clear when stopped
+}
+
+// Set address switches.
+func (panel *Panel) SetAddress(addr uint32) {
+	panel.lock.Lock()
+	panel.address = addr & mem.AMASK
+	panel.lock.Unlock()
+}
+
+// Return address switches.
+func (panel *Panel) Address() uint32 {
+	panel.lock.Lock()
+	defer panel.lock.Unlock()
+	return panel.address
+}
+
+// Set data switches.
+func (panel *Panel) SetData(data uint32) {
+	panel.lock.Lock()
+	panel.data = data
+	panel.lock.Unlock()
+}
+
+// Return data switches.
+func (panel *Panel) Data() uint32 {
+	panel.lock.Lock()
+	defer panel.lock.Unlock()
+	return panel.data
+}
+
+// Set load unit switches.
+func (panel *Panel) SetLoadUnit(devNum uint16) {
+	panel.lock.Lock()
+	panel.loadDev = devNum
+	panel.lock.Unlock()
+}
+
+// Return load unit switches.
+func (panel *Panel) LoadUnit() uint16 {
+	panel.lock.Lock()
+	defer panel.lock.Unlock()
+	return panel.loadDev
+}
+
+// Set run/stop state, CPU stops before next instruction when cleared.
+func (panel *Panel) SetRun(run bool) {
+	panel.run.Store(run)
+}
+
+// Return true if CPU is running.
+func (panel *Panel) Running() bool {
+	return panel.run.Load()
+}
+
+// Store data switches at word given by address switches.
+func (panel *Panel) Store() error {
+	if panel.Running() {
+		return errors.New("can't store when CPU is running")
+	}
+	panel.lock.Lock()
+	defer panel.lock.Unlock()
+	if (panel.address & 3) != 0 {
+		return fmt.Errorf("store address %06x not on word boundary", panel.address)
+	}
+	if mem.PutWord(panel.address, panel.data) {
+		return fmt.Errorf("store address %06x not in memory", panel.address)
+	}
+	return nil
+}
+
+// Return word at address given by address switches.
+func (panel *Panel) Display() (uint32, error) {
+	panel.lock.Lock()
+	defer panel.lock.Unlock()
+	if (panel.address & 3) != 0 {
+		return 0, fmt.Errorf("display address %06x not on word boundary", panel.address)
+	}
+	word, err := mem.GetWord(panel.address)
+	if err {
+		return 0, fmt.Errorf("display address %06x not in memory", panel.address)
+	}
+	return word, nil
+}
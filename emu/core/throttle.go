@@ -0,0 +1,127 @@
+/*
+   Pace CPU to wall clock time.
+
+   Copyright (c) 2024, Richard Cornwell
+
+   Permission is hereby granted, free of charge, to any person obtaining a
+   copy of this software and associated documentation files (the "Software"),
+   to deal in the Software without restriction, including without limitation
+   the rights to use, copy, modify, merge, publish, distribute, sublicense,
+   and/or sell copies of the Software, and to permit persons to whom the
+   Software is furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in
+   all copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.  IN NO EVENT SHALL
+   ROBERT M SUPNIK BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+   IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+   CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+*/
+
+package core
+
+import (
+	"errors"
+	"time"
+	"unicode"
+
+	config "github.com/rcornwell/S370/config/configparser"
+)
+
+/*
+   Config file:
+
+      THROTTLE 500K
+
+   Run CPU at given number of cycles per second rather than as fast as
+   possible. Interval timer and TOD clock are driven from the host clock
+   so they advance at real rates.
+*/
+
+// Number of checks per second.
+const throttleSlices = 100
+
+// Cycles per second from config file, zero runs at full speed.
+var throttleRate int
+
+type throttle struct {
+	rate    int       // Cycles per second, zero for no throttle.
+	cycles  int       // Cycles since start.
+	pending int       // Cycles since last check.
+	start   time.Time // Wall time of start.
+}
+
+// Restart timing, used when CPU starts or wakes up.
+func (th *throttle) reset() {
+	th.cycles = 0
+	th.pending = 0
+	th.start = time.Now()
+}
+
+// Account for cycles and sleep if ahead of wall clock.
+func (th *throttle) pace(cycles int) {
+	if th.rate == 0 {
+		return
+	}
+	th.pending += cycles
+	if th.pending < th.rate/throttleSlices {
+		return
+	}
+	th.cycles += th.pending
+	th.pending = 0
+	want := time.Duration(th.cycles) * time.Second / time.Duration(th.rate)
+	elapsed := time.Since(th.start)
+	switch {
+	case want > elapsed:
+		time.Sleep(want - elapsed)
+	case elapsed-want > time.Second/10:
+		// Too far behind, don't try to catch up.
+		th.reset()
+		return
+	}
+	// Rebase each second to keep counts small.
+	if th.cycles >= th.rate {
+		th.start = th.start.Add(want)
+		th.cycles = 0
+	}
+}
+
+// Set throttle rate.
+func setThrottle(_ uint16, number string, _ []config.Option) error {
+	rate := 0
+	multiplier := ' '
+	for i, digit := range number {
+		if !unicode.IsDigit(digit) {
+			if i == len(number)-1 {
+				multiplier = digit
+				break
+			}
+			return errors.New("throttle rate not a number: " + number)
+		}
+		rate = (rate * 10) + (int(digit) - '0')
+	}
+
+	switch multiplier {
+	case 'k', 'K':
+		rate *= 1000
+	case 'm', 'M':
+		rate *= 1000 * 1000
+	case ' ':
+	default:
+		return errors.New("throttle rate invalid multiplier: " + number)
+	}
+	if rate != 0 && rate < throttleSlices {
+		return errors.New("throttle rate too small: " + number)
+	}
+	throttleRate = rate
+	return nil
+}
+
+// register options on initialize.
+func init() {
+	config.RegisterOption("THROTTLE", setThrottle)
+}
@@ -28,12 +28,12 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
-	"time"
 	"unicode"
 
 	config "github.com/rcornwell/S370/config/configparser"
 	Dv "github.com/rcornwell/S370/emu/device"
 	disassembler "github.com/rcornwell/S370/emu/disassemble"
+	event "github.com/rcornwell/S370/emu/event"
 	mem "github.com/rcornwell/S370/emu/memory"
 	op "github.com/rcornwell/S370/emu/opcodemap"
 	ch "github.com/rcornwell/S370/emu/sys_channel"
@@ -84,69 +84,168 @@ import (
 
 */
 
-// Holds state of CPU.
-var sysCPU cpuState
+// Processor instance with its own registers, PSW and CPU address.
+type CPU struct {
+	state *cpuState
+}
+
+// Create CPU with address returned by STAP.
+func New(addr uint16) *CPU {
+	state := &cpuState{cpuAddr: addr}
+	state.initialize()
+	return &CPU{state: state}
+}
+
+// Return address of CPU.
+func (c *CPU) Addr() uint16 {
+	return c.state.cpuAddr
+}
+
+// Initialize CPU to basic state.
+func (c *CPU) Initialize() {
+	c.state.initialize()
+}
+
+// Execute one instruction or take an interrupt.
+func (c *CPU) Cycle() (int, bool) {
+	return c.state.cycle()
+}
+
+// Return true if last cycle was in wait state with no interrupt to take.
+func (c *CPU) Idle() bool {
+	return c.state.idle
+}
+
+// Return CPU PC.
+func (c *CPU) PC() uint32 {
+	return c.state.PC
+}
+
+// Set CPU PC.
+func (c *CPU) SetPC(newPC uint32) {
+	c.state.setPC(newPC)
+}
+
+// Return PSW as string.
+func (c *CPU) PSW() string {
+	return c.state.pswString()
+}
+
+// Post an external interrupt to CPU.
+func (c *CPU) PostExtIrq() {
+	c.state.extIrq = true
+	slog.Debug("CPU: Post ext")
+}
+
+// Set TOD to current date.
+func (c *CPU) SetTod() {
+	c.state.setTod()
+}
+
+// Update current interval timer and TOD clock.
+func (c *CPU) UpdateTimer() {
+	c.state.updateClock()
+}
+
+// Program reset, clear pending interrupts and reset channels.
+func (c *CPU) ProgramReset() {
+	c.state.programReset()
+}
+
+// System reset, reset CPU to initial state and reset channels.
+func (c *CPU) SystemReset() {
+	c.state.systemReset()
+}
+
+// Power on reset, system reset and clear storage, keys and TOD clock.
+func (c *CPU) PowerOnReset() {
+	c.state.powerOnReset()
+}
+
+// Reset CPU and load program from device.
+func (c *CPU) IPLDevice(devNum uint16) error {
+	return c.state.iplDevice(devNum)
+}
+
+// Store CPU status in assigned storage locations.
+func (c *CPU) StoreStatus() {
+	c.state.storeStatus()
+}
+
+// Use instruction prefetch buffer.
+var prefetchEnb = true
+
+// Compare blocks of storage at a time in CLCL.
+var blockCompare = true
 
-// Holds number of memory cycles the current instruction too.
-// Used for advancing the timer close to system speed.
-var memCycle int
+// Let host CPU idle while in wait state.
+var idleEnb = true
+
+// VM Assist feature installed.
+var vmaFeature bool
 
 // Initialize CPU to basic state.
-func InitializeCPU() {
-	sysCPU.createTable()
-	sysCPU.PC = 0
-	sysCPU.sysMask = 0
-	sysCPU.stKey = 0
-	sysCPU.cc = 0
-	sysCPU.ilc = 0
-	sysCPU.progMask = 0
-	sysCPU.flags = 0
-	sysCPU.perRegMod = 0
-	sysCPU.perAddr = 0
-	sysCPU.perCode = 0
-	sysCPU.clkCmp[0] = FMASK
-	sysCPU.clkCmp[1] = FMASK
-	sysCPU.timerTics = 0
-	sysCPU.cpuTimer[0] = 0
-	sysCPU.cpuTimer[1] = 0
-	sysCPU.perEnb = false
-	sysCPU.ecMode = false
-	sysCPU.pageEnb = false
-	sysCPU.irqEnb = false
-	sysCPU.extEnb = false
-	sysCPU.extIrq = false
-	sysCPU.intIrq = false
-	sysCPU.intEnb = false
-	sysCPU.todEnb = false
-	sysCPU.todIrq = false
-	sysCPU.vmaEnb = false
+func (cpu *cpuState) initialize() {
+	cpu.createTable()
+	cpu.PC = 0
+	cpu.sysMask = 0
+	cpu.stKey = 0
+	cpu.cc = 0
+	cpu.ilc = 0
+	cpu.progMask = 0
+	cpu.flags = 0
+	cpu.perRegMod = 0
+	cpu.perAddr = 0
+	cpu.perCode = 0
+	cpu.clkCmp[0] = FMASK
+	cpu.clkCmp[1] = FMASK
+	cpu.timerTics = 0
+	cpu.cpuTimer[0] = 0
+	cpu.cpuTimer[1] = 0
+	cpu.perEnb = false
+	cpu.ecMode = false
+	cpu.pageEnb = false
+	cpu.prefix = 0
+	cpu.irqEnb = false
+	cpu.extEnb = false
+	cpu.extIrq = false
+	cpu.intIrq = false
+	cpu.mchkIrq = false
+	cpu.intEnb = false
+	cpu.todEnb = false
+	cpu.todIrq = false
+	cpu.clkIrq = false
+	cpu.vmaEnb = false
+	cpu.vmAssist = vmaFeature
+	cpu.ibufValid = false
+	cpu.trapPend = false
 
 	// Clear registers
 	for i := range 16 {
-		sysCPU.regs[i] = 0
-		sysCPU.cregs[i] = 0
+		cpu.regs[i] = 0
+		cpu.cregs[i] = 0
 	}
 
 	// Initialize Control regisers to default
-	sysCPU.cregs[0] = 0x000000e0
-	sysCPU.cregs[2] = 0xffffffff
-	sysCPU.cregs[14] = 0xc2000000
-	sysCPU.cregs[15] = 512
+	cpu.cregs[0] = 0x000000e0
+	cpu.cregs[2] = 0xffffffff
+	cpu.cregs[14] = 0xc2000000
+	cpu.cregs[15] = 512
 
 	// Clear floating point registers
 	for i := range 8 {
-		sysCPU.fpregs[i] = 0
+		cpu.fpregs[i] = 0
 	}
 
 	// Clear TBL tables
 	for i := range 256 {
-		sysCPU.tlb[i] = 0
+		cpu.tlb[i] = 0
 	}
 
 	// Set clock to current time
-	if !sysCPU.todSet {
+	if !cpu.todSet {
 		// Set TOD to current time
-		now := time.Now()
+		now := event.TimeBase().Now()
 		sec := now.Unix()
 
 		// IBM measures time from 1900, Unix starts at 1970
@@ -155,25 +254,44 @@ func InitializeCPU() {
 		sec *= 1000000
 		sec <<= 12
 		usec := uint64(sec)
-		sysCPU.todClock[0] = uint32((usec >> 32) & LMASKL)
-		sysCPU.todClock[1] = uint32(usec & LMASKL)
-		sysCPU.todSet = true
+		cpu.todClock[0] = uint32((usec >> 32) & LMASKL)
+		cpu.todClock[1] = uint32(usec & LMASKL)
+		cpu.todSet = true
 	}
 
-	sysCPU.pageMask = 0
+	cpu.pageMask = 0
 }
 
-func IPLDevice(devNum uint16) error {
-	InitializeCPU()
-	sysCPU.flags = wait
-	sysCPU.sysMask = 0xffff
-	return ch.IPLDevice(devNum)
+// Program reset, clear pending interrupts and reset channels.
+// Registers, PSW, storage and keys are left alone.
+func (cpu *cpuState) programReset() {
+	cpu.extIrq = false
+	cpu.intIrq = false
+	cpu.clkIrq = false
+	cpu.todIrq = false
+	cpu.ibufValid = false
+	ch.ResetChannels()
 }
 
-// Post an external interrupt to CPU.
-func PostExtIrq() {
-	sysCPU.extIrq = true
-	slog.Debug("CPU: Post ext")
+// System reset, reset CPU to initial state and reset channels.
+// Storage and storage keys are preserved.
+func (cpu *cpuState) systemReset() {
+	cpu.initialize()
+	ch.ResetChannels()
+}
+
+// Power on reset, system reset and clear storage, keys and TOD clock.
+func (cpu *cpuState) powerOnReset() {
+	mem.Clear()
+	cpu.todSet = false
+	cpu.systemReset()
+}
+
+func (cpu *cpuState) iplDevice(devNum uint16) error {
+	cpu.initialize()
+	cpu.flags = wait
+	cpu.sysMask = 0xffff
+	return ch.IPLDevice(devNum)
 }
 
 // Shutdown the CPU, request channel to shutdown all devices.
@@ -191,39 +309,35 @@ func Debug(opt string) error {
 	return nil
 }
 
-// Return CPU GetPC.
-func GetPC() uint32 {
-	return sysCPU.PC
+func (cpu *cpuState) setPC(newPC uint32) {
+	cpu.PC = newPC
+	cpu.ibufValid = false
+	cpu.trapPend = false
 }
 
-// Set CPU PC.
-func SetPC(newPC uint32) {
-	sysCPU.PC = newPC
-}
-
-// Return PSW as string.
-func GetPSW() string {
-	word1, word2 := sysCPU.getPSW()
+func (cpu *cpuState) pswString() string {
+	word1, word2 := cpu.getPSW()
 
 	return fmt.Sprintf("PSW %08x %08x", word1, word2)
 }
 
 // Return a register value.
-func GetReg(regType int, number uint8) (uint32, bool) {
+func (c *CPU) GetReg(regType int, number uint8) (uint32, bool) {
+	cpu := c.state
 	switch regType {
 	case Dv.Register:
 		if number > 15 {
 			return 0, false
 		}
-		return sysCPU.regs[number], true
+		return cpu.regs[number], true
 
 	case Dv.CtlRegister:
 		if number > 15 {
 			return 0, false
 		}
-		return sysCPU.cregs[number], true
+		return cpu.cregs[number], true
 	case Dv.PSWRegister:
-		word1, word2 := sysCPU.getPSW()
+		word1, word2 := cpu.getPSW()
 		switch number {
 		case 0:
 			return word1, true
@@ -236,19 +350,34 @@ func GetReg(regType int, number uint8) (uint32, bool) {
 }
 
 // Return a floating point register.
-func GetFPReg(num int, long bool) (uint64, bool) {
+func (c *CPU) GetFPReg(num int, long bool) (uint64, bool) {
+	cpu := c.state
 	if num > 6 {
 		return 0, false
 	}
-	value := sysCPU.fpregs[num&0x6]
+	value := cpu.fpregs[num&0x6]
 	if !long {
 		value &= HMASKL
 	}
 	return value, true
 }
 
-// Return a register value.
-func SetReg(regType int, number uint8, value uint32) bool {
+// Set a floating point register, short values only change the high word.
+func (c *CPU) SetFPReg(num int, value uint64, long bool) bool {
+	cpu := c.state
+	if num > 6 || num < 0 || (num&1) != 0 {
+		return false
+	}
+	if !long {
+		value = (cpu.fpregs[num] & LMASKL) | (value & HMASKL)
+	}
+	cpu.fpregs[num] = value
+	return true
+}
+
+// Set a register value.
+func (c *CPU) SetReg(regType int, number uint8, value uint32) bool {
+	cpu := c.state
 	if number > 15 {
 		return false
 	}
@@ -257,22 +386,22 @@ func SetReg(regType int, number uint8, value uint32) bool {
 		return false
 
 	case Dv.Register:
-		sysCPU.regs[number] = value
+		cpu.regs[number] = value
 
 	case Dv.FPRegister:
 		if number > 6 {
 			return false
 		}
 		if (number & 1) != 0 {
-			sysCPU.fpregs[number&0x6] &= HMASKL
-			sysCPU.fpregs[number&0x6] |= uint64(value)
+			cpu.fpregs[number&0x6] &= HMASKL
+			cpu.fpregs[number&0x6] |= uint64(value)
 		} else {
-			sysCPU.fpregs[number&0x6] &= LMASKL
-			sysCPU.fpregs[number&0x6] |= uint64(value) << 32
+			cpu.fpregs[number&0x6] &= LMASKL
+			cpu.fpregs[number&0x6] |= uint64(value) << 32
 		}
 
 	case Dv.CtlRegister:
-		sysCPU.loadControl(number, value)
+		cpu.loadControl(number, value)
 
 	case Dv.PSWRegister: // PSW Register can't be set.
 		return false
@@ -281,84 +410,109 @@ func SetReg(regType int, number uint8, value uint32) bool {
 }
 
 // Execute one instruction or take an interrupt.
-func CycleCPU() (int, bool) {
-	memCycle = 1 // Default to one cycle.
+func (cpu *cpuState) cycle() (int, bool) {
+	cpu.memCycle = 1 // Default to one cycle.
+	cpu.idle = false
+
+	// Continue after stopping on program interrupt.
+	if cpu.trapPend {
+		cpu.takeTrap()
+		return cpu.memCycle, true
+	}
+
+	// Machine check is taken first when enabled.
+	if cpu.mchkIrq && (cpu.flags&mCheck) != 0 {
+		cpu.mchkIrq = false
+		debug.Debugf("CPU", debugMsk, debugIRQ, "Machine check")
+		cpu.suppress(oMPSW, 0)
+		return cpu.memCycle, true
+	}
 
 	// Check if we should see if an IRQ is pending
-	irq := ch.ChanScan(sysCPU.sysMask, sysCPU.irqEnb)
+	irq := ch.ChanScan(cpu.sysMask, cpu.irqEnb)
 	if irq != Dv.NoDev {
-		sysCPU.ilc = 0
+		cpu.ilc = 0
 		if ch.Loading != Dv.NoDev {
 			// For IPL, save device after saving load complete
 			word1 := mem.GetMemory(0)
 			word2 := mem.GetMemory(4)
 
-			memCycle++
+			cpu.memCycle++
 			_ = mem.PutWordMask(0, uint32(ch.Loading), LMASK)
-			memCycle++
+			cpu.memCycle++
 			_ = mem.PutWordMask(0xba, uint32(ch.Loading), LMASK)
 
-			sysCPU.lpsw(word1, word2)
+			cpu.lpsw(word1, word2)
 			ch.Loading = Dv.NoDev
 		} else {
-			sysCPU.suppress(oIOPSW, irq)
+			cpu.suppress(oIOPSW, irq)
 		}
-		return memCycle, true
+		return cpu.memCycle, true
 	}
 
 	// Check for external interrupts
-	if sysCPU.extEnb {
-		if sysCPU.extIrq {
-			if !sysCPU.ecMode || (sysCPU.cregs[0]&0x20) != 0 ||
-				(sysCPU.cregs[6]&0x40) != 0 {
-				sysCPU.extIrq = false
+	if cpu.extEnb {
+		if cpu.extIrq {
+			if !cpu.ecMode || (cpu.cregs[0]&0x20) != 0 ||
+				(cpu.cregs[6]&0x40) != 0 {
+				cpu.extIrq = false
 				debug.Debugf("CPU", debugMsk, debugIRQ, "Ext IRQ")
-				sysCPU.suppress(oEPSW, 0x40)
-				return memCycle, true
+				cpu.suppress(oEPSW, 0x40)
+				return cpu.memCycle, true
 			}
 		}
 
-		if sysCPU.intIrq && (sysCPU.cregs[0]&0x80) != 0 {
-			sysCPU.intIrq = false
-			sysCPU.suppress(oEPSW, 0x80)
-			return memCycle, true
+		if cpu.intIrq && (cpu.cregs[0]&0x80) != 0 {
+			cpu.intIrq = false
+			cpu.suppress(oEPSW, 0x80)
+			return cpu.memCycle, true
 		}
-		if sysCPU.clkIrq && sysCPU.intEnb {
-			sysCPU.clkIrq = false
-			sysCPU.suppress(oEPSW, 0x1005)
-			return memCycle, true
+		if cpu.clkIrq && cpu.intEnb {
+			cpu.clkIrq = false
+			cpu.suppress(oEPSW, 0x1005)
+			return cpu.memCycle, true
 		}
-		if sysCPU.todIrq && sysCPU.todEnb {
-			sysCPU.todIrq = false
-			sysCPU.suppress(oEPSW, 0x1004)
-			return memCycle, true
+		if cpu.todIrq && cpu.todEnb {
+			cpu.todIrq = false
+			cpu.suppress(oEPSW, 0x1004)
+			return cpu.memCycle, true
 		}
 	}
 
 	// Check if we have wait we can't exit
-	if ch.Loading == Dv.NoDev && !sysCPU.irqEnb && (sysCPU.flags&wait != 0) {
-		msg := fmt.Sprintf("Uninterupable wait state %08x %s", sysCPU.PC, GetPSW())
+	if ch.Loading == Dv.NoDev && !cpu.irqEnb && !cpu.extEnb && (cpu.flags&wait != 0) {
+		msg := fmt.Sprintf("Uninterupable wait state %08x %s", cpu.PC, cpu.pswString())
 		slog.Warn(msg)
 		return 1, false
 	}
 
 	// If we have wait flag or loading, nothing more to do
-	if ch.Loading != Dv.NoDev || (sysCPU.flags&wait) != 0 {
+	if ch.Loading != Dv.NoDev || (cpu.flags&wait) != 0 {
 		/* CPU IDLE */
-		if !sysCPU.irqEnb && !sysCPU.extEnb {
-			return memCycle, true
-		}
-		return memCycle, true
+		cpu.idle = idleEnb && ch.Loading == Dv.NoDev
+		return cpu.memCycle, true
+	}
+
+	// Stop before instruction at a breakpoint.
+	if len(cpu.breakPoints) != 0 && cpu.checkBreak() {
+		return 0, false
 	}
 
-	return sysCPU.fetch()
+	cycles, running := cpu.fetch()
+
+	// Stop after taking program interrupt that is armed.
+	if cpu.trapHit {
+		cpu.trapHit = false
+		return cycles, false
+	}
+	return cycles, running
 }
 
 // Fetch and execute an instruction.
 func (cpu *cpuState) fetch() (int, bool) {
 	if (cpu.PC & 1) != 0 {
 		cpu.suppress(oPPSW, ircSpec)
-		return memCycle, true
+		return cpu.memCycle, true
 	}
 
 	// Check if triggered PER event.
@@ -367,13 +521,16 @@ func (cpu *cpuState) fetch() (int, bool) {
 	}
 
 	var opr uint32
-	var step stepInfo
+
+	// Reuse decode area, a local would escape through the dispatch table.
+	step := &cpu.step
+	*step = stepInfo{}
 
 	// Fetch the next instruction
-	word, err := cpu.readFullAligned(cpu.PC)
+	word, err := cpu.fetchWord(cpu.PC)
 	if err != 0 {
 		cpu.suppress(oPPSW, err)
-		return memCycle, true
+		return cpu.memCycle, true
 	}
 
 	// Save instruction
@@ -391,7 +548,7 @@ func (cpu *cpuState) fetch() (int, bool) {
 
 	//brop := (step.opcode == op.OpBC || step.opcode == op.OpBCR)
 	//if cpu.iPC == cpu.PC && brop && (step.reg&0xf0) == 0xf0 {
-	//	return memCycle, false
+	//	return cpu.memCycle, false
 	//}
 	cpu.perRegMod = 0
 	cpu.perCode = 0
@@ -399,9 +556,6 @@ func (cpu *cpuState) fetch() (int, bool) {
 	cpu.iPC = cpu.PC
 
 	cpu.PC += 2
-	inst := make([]byte, 6)
-	inst[0] = step.opcode
-	inst[1] = step.reg
 
 	// if cpu.iPC != 0x0002026 {
 	// 	fmt.Printf("Op: %08x %02x %02x ", cpu.iPC, uint32(step.opcode), uint32(step.reg))
@@ -412,18 +566,18 @@ func (cpu *cpuState) fetch() (int, bool) {
 		cpu.ilc++
 		// Check if we need new word?
 		if (cpu.PC & 2) == 0 {
-			word, err = cpu.readFullAligned(cpu.PC)
+			word, err = cpu.fetchWord(cpu.PC)
 			if err != 0 {
+				// Old PSW points to start of instruction.
+				cpu.PC = cpu.iPC
 				cpu.suppress(oPPSW, err)
-				return memCycle, true
+				return cpu.memCycle, true
 			}
 			step.address1 = (word >> 16)
 		} else {
 			step.address1 = word
 		}
 		step.address1 &= 0xffff
-		inst[2] = byte(step.address1 >> 8)
-		inst[3] = byte(step.address1 & 0xff)
 		cpu.PC += 2
 	}
 
@@ -432,28 +586,36 @@ func (cpu *cpuState) fetch() (int, bool) {
 		cpu.ilc++
 		// Do we need another word?
 		if (cpu.PC & 2) == 0 {
-			word, err = cpu.readFullAligned(cpu.PC)
+			word, err = cpu.fetchWord(cpu.PC)
 			if err != 0 {
+				// Old PSW points to start of instruction.
+				cpu.PC = cpu.iPC
 				cpu.suppress(oPPSW, err)
-				return memCycle, true
+				return cpu.memCycle, true
 			}
 			step.address2 = (word >> 16)
 		} else {
 			step.address2 = word
 		}
 		step.address2 &= 0xffff
-		inst[4] = byte(step.address2 >> 8)
-		inst[5] = byte(step.address2 & 0xff)
 		cpu.PC += 2
 	}
 
 	// if cpu.iPC != 0x0002026 {
 	if (debugMsk & debugInst) != 0 {
-		str := disassembler.PrintLine(cpu.iPC, inst)
+		inst := step.bytes()
+		str := disassembler.PrintLine(cpu.iPC, inst[:])
 		debug.Debugf("CPU", debugMsk, debugInst, str)
 	}
 
-	err = cpu.execute(&step)
+	if (debugMsk & debugTrace) != 0 {
+		addr := cpu.traceAddr(step)
+		inst := step.bytes()
+		err = cpu.execute(step)
+		cpu.trace(cpu.iPC, inst[:], addr)
+	} else {
+		err = cpu.execute(step)
+	}
 	if err != 0 {
 		cpu.suppress(oPPSW, err)
 	}
@@ -462,7 +624,12 @@ func (cpu *cpuState) fetch() (int, bool) {
 	if cpu.perEnb && cpu.perCode != 0 {
 		cpu.suppress(oPPSW, 0)
 	}
-	return memCycle, true
+
+	// Add in execution time for model.
+	if model.timing != nil {
+		cpu.memCycle += int(model.timing[step.opcode])
+	}
+	return cpu.memCycle, true
 }
 
 // Generate addresses for operands and if
@@ -624,7 +791,7 @@ func (cpu *cpuState) createTable() {
 		cpu.opUnk, cpu.opUnk, cpu.opUnk, cpu.opUnk, cpu.opUnk, cpu.opUnk, cpu.opUnk, cpu.opUnk, // Cx
 		cpu.opUnk, cpu.opUnk, cpu.opUnk, cpu.opUnk, cpu.opUnk, cpu.opUnk, cpu.opUnk, cpu.opUnk,
 
-		cpu.opUnk, cpu.opMem, cpu.opMVC, cpu.opMem, cpu.opMem, cpu.opCLC, cpu.opMem, cpu.opMem, // Dx
+		cpu.opTRTR, cpu.opMem, cpu.opMVC, cpu.opMem, cpu.opMem, cpu.opCLC, cpu.opMem, cpu.opMem, // Dx
 		cpu.opUnk, cpu.opUnk, cpu.opUnk, cpu.opUnk, cpu.opTR, cpu.opTR, cpu.opED, cpu.opED,
 
 		cpu.opUnk, cpu.opUnk, cpu.opUnk, cpu.opUnk, cpu.opUnk, cpu.opUnk, cpu.opUnk, cpu.opUnk, // Ex
@@ -633,21 +800,40 @@ func (cpu *cpuState) createTable() {
 		cpu.opSRP, cpu.opMVO, cpu.opPACK, cpu.opUNPK, cpu.opUnk, cpu.opUnk, cpu.opUnk, cpu.opUnk, // Fx
 		cpu.opDecAdd, cpu.opDecAdd, cpu.opDecAdd, cpu.opDecAdd, cpu.opMP, cpu.opDP, cpu.opUnk, cpu.opUnk,
 	}
+	cpu.removeFeatures()
 }
 
 // Suppress execution of instruction.
 func (cpu *cpuState) suppress(code uint32, irc uint16) {
+	// Stop before taking armed program interrupt.
+	if code == oPPSW && len(cpu.trapCodes) != 0 && cpu.holdTrap(irc) {
+		return
+	}
 	irqaddr := cpu.storePSW(code, irc)
 
-	memCycle++
-	src1, _ := mem.GetWord(irqaddr)
-	memCycle++
-	src2, _ := mem.GetWord(irqaddr + 0x4)
+	cpu.memCycle++
+	src1, _ := mem.GetWord(cpu.absAddr(irqaddr))
+	cpu.memCycle++
+	src2, _ := mem.GetWord(cpu.absAddr(irqaddr + 0x4))
 	cpu.lpsw(src1, src2)
 }
 
 // Load new processor status double word.
 func (cpu *cpuState) lpsw(src1, src2 uint32) {
+	cpu.ibufValid = false
+	cpu.UnpackPSW((uint64(src1) << 32) | uint64(src2))
+	ch.IrqPending = true
+	debug.Debugf("CPU", debugMsk, debugDetail, "LPSW %08x: %08x %08x", cpu.iPC, src1, src2)
+	//	sim_debug(DEBUG_INST, &cpu_dev, "PSW=%08x %08x  ", src1, src2)
+	if cpu.ecMode && ((src1&0xb800c0ff) != 0 || (src2&0xff000000) != 0) {
+		cpu.suppress(oPPSW, ircSpec)
+	}
+}
+
+// Set CPU state from PSW.
+func (cpu *cpuState) UnpackPSW(psw uint64) {
+	src1 := uint32(psw >> 32)
+	src2 := uint32(psw & LMASKL)
 	cpu.ecMode = (src1 & 0x00080000) != 0
 	cpu.extEnb = (src1 & 0x01000000) != 0
 
@@ -670,117 +856,86 @@ func (cpu *cpuState) lpsw(src1, src2 uint32) {
 		cpu.perEnb = false
 		cpu.cc = uint8((src2 >> 28) & 0x3)
 		cpu.progMask = uint8((src2 >> 24) & 0xf)
+		cpu.ilc = uint8((src2 >> 30) & 0x3)
 		cpu.pageEnb = false
 	}
-	ch.IrqPending = true
 	cpu.stKey = uint8((src1 >> 16) & 0xf0)
 	cpu.flags = uint8((src1 >> 16) & 0x7)
 	cpu.PC = src2 & AMASK
-	debug.Debugf("CPU", debugMsk, debugDetail, "LPSW %08x: %08x %08x", cpu.iPC, src1, src2)
-	//	sim_debug(DEBUG_INST, &cpu_dev, "PSW=%08x %08x  ", src1, src2)
-	if cpu.ecMode && ((src1&0xb800c0ff) != 0 || (src2&0xff000000) != 0) {
-		cpu.suppress(oPPSW, ircSpec)
-	}
 }
 
-// Get PSW as pair of words.
-func (cpu *cpuState) getPSW() (uint32, uint32) {
-	word1 := (uint32(sysCPU.stKey) << 16) | (uint32(sysCPU.flags) << 16)
-	word2 := sysCPU.PC
-	if sysCPU.extEnb {
-		word1 |= 1 << 24
+// Build PSW from CPU state, BC mode interrupt code is left zero.
+func (cpu *cpuState) PackPSW() uint64 {
+	word1 := (uint32(cpu.stKey) << 16) | (uint32(cpu.flags) << 16)
+	word2 := cpu.PC & AMASK
+	if cpu.extEnb {
+		word1 |= uint32(extEnable) << 24
 	}
-	if sysCPU.ecMode {
+	if cpu.ecMode {
 		word1 |= 0x80000
-		word1 |= (uint32(sysCPU.cc) << 12) | (uint32(sysCPU.progMask) << 8)
-		if sysCPU.pageEnb {
-			word1 |= 1 << 26
+		word1 |= (uint32(cpu.cc) << 12) | (uint32(cpu.progMask) << 8)
+		if cpu.pageEnb {
+			word1 |= uint32(datEnable) << 24
 		}
-		if sysCPU.perEnb {
-			word1 |= 1 << 30
+		if cpu.perEnb {
+			word1 |= uint32(perEnable) << 24
 		}
-		if sysCPU.intIrq {
-			word1 |= 1 << 25
+		if cpu.irqEnb {
+			word1 |= uint32(irqEnable) << 24
 		}
 	} else {
-		word1 |= (uint32(sysCPU.sysMask&0xfe00) << 16)
-		word2 |= (uint32(sysCPU.ilc) << 30) | (uint32(sysCPU.cc) << 28) | (uint32(sysCPU.progMask) << 24)
+		word1 |= uint32(cpu.sysMask&0xfe00) << 16
+		word2 |= (uint32(cpu.ilc) << 30) | (uint32(cpu.cc) << 28) | (uint32(cpu.progMask) << 24)
 	}
-	return word1, word2
+	return (uint64(word1) << 32) | uint64(word2)
+}
+
+// Get PSW as pair of words.
+func (cpu *cpuState) getPSW() (uint32, uint32) {
+	psw := cpu.PackPSW()
+	return uint32(psw >> 32), uint32(psw & LMASKL)
 }
 
 // Store the PSW at given address with irq value.
 func (cpu *cpuState) storePSW(vector uint32, irqcode uint16) (irqaddr uint32) {
-	var word1, word2 uint32
 	irqaddr = vector + 0x40
 
 	if vector == oPPSW && cpu.perEnb && cpu.perCode != 0 {
 		irqcode |= ircPer
 	}
 
+	word1, word2 := cpu.getPSW()
 	if cpu.ecMode {
-		// Generate first word
-		word1 = uint32(0x80000) |
-			(uint32(cpu.stKey) << 16) |
-			(uint32(cpu.flags) << 16) |
-			(uint32(cpu.cc) << 12) |
-			(uint32(cpu.progMask) << 8)
-		if cpu.pageEnb {
-			word1 |= uint32(datEnable) << 24
-		}
-		if cpu.perEnb {
-			word1 |= uint32(perEnable) << 24
-		}
-		if cpu.irqEnb {
-			word1 |= uint32(irqEnable) << 24
-		}
-
 		// Save code where 370 expects it to be
 		switch vector {
 		case oEPSW:
-			memCycle++
-			mem.SetMemoryMask(0x84, uint32(irqcode), LMASK)
+			cpu.memCycle++
+			mem.SetMemoryMask(cpu.absAddr(0x84), uint32(irqcode), LMASK)
 		case oSPSW:
-			memCycle++
-			mem.SetMemory(0x88, ((uint32(cpu.ilc) << 17) | uint32(irqcode)))
+			cpu.memCycle++
+			mem.SetMemory(cpu.absAddr(0x88), ((uint32(cpu.ilc) << 17) | uint32(irqcode)))
 		case oPPSW:
-			memCycle++
-			mem.SetMemory(0x8c, ((uint32(cpu.ilc) << 17) | uint32(irqcode)))
+			cpu.memCycle++
+			mem.SetMemory(cpu.absAddr(0x8c), ((uint32(cpu.ilc) << 17) | uint32(irqcode)))
 		case oIOPSW:
-			memCycle++
-			mem.SetMemory(0xb8, uint32(irqcode))
+			cpu.memCycle++
+			mem.SetMemory(cpu.absAddr(0xb8), uint32(irqcode))
 		}
 		if (irqcode & ircPer) != 0 {
-			memCycle++
-			mem.SetMemory(150, (uint32(cpu.perCode)<<16)|(cpu.perAddr>>16))
-			memCycle++
-			mem.SetMemoryMask(154, (cpu.perAddr&0xffff)<<16, LMASK)
+			cpu.memCycle++
+			mem.SetMemoryMask(cpu.absAddr(0x94), uint32(cpu.perCode), LMASK) // PER code at 0x96
+			cpu.memCycle++
+			mem.SetMemory(cpu.absAddr(0x98), cpu.perAddr)
 		}
-		// Generate second word.
-		word2 = cpu.PC
 	} else {
-		// Generate first word.
-		word1 = (uint32(cpu.sysMask&0xfe00) << 16) |
-			(uint32(cpu.stKey) << 16) |
-			(uint32(cpu.flags) << 16) |
-			uint32(irqcode)
-
-		// Generate second word. */
-		word2 = (uint32(cpu.ilc) << 30) |
-			(uint32(cpu.cc) << 28) |
-			(uint32(cpu.progMask) << 24) |
-			(cpu.PC & AMASK)
-	}
-
-	if cpu.extEnb {
-		word1 |= uint32(extEnable) << 24
+		word1 |= uint32(irqcode)
 	}
 
 	debug.Debugf("CPU", debugMsk, debugDetail, "Store PSW: %08x %04x %08x %08x", vector, irqcode, word1, word2)
-	memCycle++
-	mem.SetMemory(vector, word1)
-	memCycle++
-	mem.SetMemory(vector+4, word2)
+	cpu.memCycle++
+	mem.SetMemory(cpu.absAddr(vector), word1)
+	cpu.memCycle++
+	mem.SetMemory(cpu.absAddr(vector+4), word2)
 	return irqaddr
 }
 
@@ -811,7 +966,7 @@ func (cpu *cpuState) transAddr(virtAddr uint32) (uint32, uint16) {
 
 	// If paging not enabled, return address.
 	if !cpu.pageEnb {
-		return addr, 0
+		return cpu.absAddr(addr), 0
 	}
 
 	// Extract page address is on
@@ -827,7 +982,7 @@ func (cpu *cpuState) transAddr(virtAddr uint32) (uint32, uint16) {
 	entry = cpu.tlb[page]
 	if (entry&tlbValid) != 0 && ((entry^seg)&tlbSeg) == 0 {
 		addr = (virtAddr & cpu.pageMask) | ((entry & tlbPhy) << cpu.pageShift)
-		return addr, 0
+		return cpu.absAddr(addr), 0
 	}
 
 	// TLB entry does not match, replace it.
@@ -842,8 +997,8 @@ func (cpu *cpuState) transAddr(virtAddr uint32) (uint32, uint16) {
 	if seg > cpu.segLen {
 		// segment above length of table,
 		// write failed address and 90, then trigger trap.
-		_ = mem.PutWord(0x90, virtAddr)
-		memCycle++
+		_ = mem.PutWord(cpu.absAddr(0x90), virtAddr)
+		cpu.memCycle++
 		cpu.PC = cpu.iPC
 		return 0, ircSeg
 	}
@@ -853,7 +1008,7 @@ func (cpu *cpuState) transAddr(virtAddr uint32) (uint32, uint16) {
 	addr = ((seg << 2) + cpu.segAddr) & AMASK
 
 	// Get entry on error throw trap.
-	memCycle++
+	cpu.memCycle++
 	entry, err = mem.GetWord(addr)
 	if err {
 		return 0, ircAddr
@@ -864,8 +1019,8 @@ func (cpu *cpuState) transAddr(virtAddr uint32) (uint32, uint16) {
 
 	/* Check if entry valid and in correct length */
 	if (entry&pteValid) != 0 || (page>>cpu.pteLenShift) >= addr {
-		memCycle++
-		mem.SetMemory(0x90, virtAddr)
+		cpu.memCycle++
+		mem.SetMemory(cpu.absAddr(0x90), virtAddr)
 		cpu.PC = cpu.iPC
 		if (entry & pteValid) != 0 {
 			return 0, ircSeg
@@ -875,29 +1030,29 @@ func (cpu *cpuState) transAddr(virtAddr uint32) (uint32, uint16) {
 
 	// Now we need to fetch the actual entry
 	addr = ((entry & pteAddr) + (page << 1)) & AMASK
-	memCycle++
+	cpu.memCycle++
 	entry, err = mem.GetWord(addr)
 	if err {
 		return 0, ircAddr
 	}
 
-	// extract actual PTE entry
-	if (addr & 2) != 0 {
-		entry = (addr >> 16)
+	// extract actual PTE entry, even entries are in upper half of word
+	if (addr & 2) == 0 {
+		entry >>= 16
 	}
 	entry &= 0xffff
 
 	if (entry & cpu.pteMBZ) != 0 {
-		memCycle++
-		mem.SetMemory(0x90, virtAddr)
+		cpu.memCycle++
+		mem.SetMemory(cpu.absAddr(0x90), virtAddr)
 		cpu.PC = cpu.iPC
 		return 0, ircSpec
 	}
 
 	// Check if entry valid and in correct length
 	if (entry & cpu.pteAvail) != 0 {
-		memCycle++
-		mem.SetMemory(0x90, virtAddr)
+		cpu.memCycle++
+		mem.SetMemory(cpu.absAddr(0x90), virtAddr)
 		cpu.PC = cpu.iPC
 		return 0, ircPage
 	}
@@ -910,7 +1065,19 @@ func (cpu *cpuState) transAddr(virtAddr uint32) (uint32, uint16) {
 	cpu.tlb[page&0xff] = entry
 	// Compute physical address
 	addr = (virtAddr & cpu.pageMask) | (((entry & tlbPhy) << cpu.pageShift) & AMASK)
-	return addr, 0
+	return cpu.absAddr(addr), 0
+}
+
+// Convert real address to absolute address. The first 4K of real storage
+// is moved to the prefix and the 4K at the prefix is moved to zero.
+func (cpu *cpuState) absAddr(addr uint32) uint32 {
+	switch addr & (AMASK &^ 0xfff) {
+	case 0:
+		return addr | cpu.prefix
+	case cpu.prefix:
+		return addr & 0xfff
+	}
+	return addr
 }
 
 // Check for protection violation.
@@ -921,11 +1088,11 @@ func (cpu *cpuState) checkProtect(addr uint32, write bool) bool {
 	}
 	key := mem.GetKey(addr)
 	if write {
-		if (key & 0xf0) != cpu.stKey {
+		if (key & mem.KeyMask) != cpu.stKey {
 			return true
 		}
 	} else {
-		if (key&0x8) != 0 && (key&0xf0) != cpu.stKey {
+		if (key&mem.KeyFetch) != 0 && (key&mem.KeyMask) != cpu.stKey {
 			return true
 		}
 	}
@@ -975,7 +1142,7 @@ func (cpu *cpuState) readFull(virtAddr uint32) (uint32, uint16) {
 	}
 
 	// Read actual data
-	memCycle++
+	cpu.memCycle++
 	word, err := mem.GetWord(physAddr)
 	if err {
 		return 0, ircAddr
@@ -1002,7 +1169,7 @@ func (cpu *cpuState) readFull(virtAddr uint32) (uint32, uint16) {
 		}
 	}
 
-	memCycle++
+	cpu.memCycle++
 	word2, err := mem.GetWord(physAddr2)
 	if err {
 		return 0, ircAddr
@@ -1032,7 +1199,7 @@ func (cpu *cpuState) readFullAligned(virtAddr uint32) (uint32, uint16) {
 	}
 
 	// Read actual data
-	memCycle++
+	cpu.memCycle++
 	word, err := mem.GetWord(physAddr)
 	if err {
 		return 0, ircAddr
@@ -1041,6 +1208,39 @@ func (cpu *cpuState) readFullAligned(virtAddr uint32) (uint32, uint16) {
 	return word, 0
 }
 
+// Read a word of instructions. The last word read is held in a prefetch
+// buffer and reused until any store to memory is made, or the PSW, storage
+// keys or translation tables change.
+func (cpu *cpuState) fetchWord(virtAddr uint32) (uint32, uint16) {
+	virtAddr &^= 3
+	if prefetchEnb && cpu.ibufValid && cpu.ibufAddr == virtAddr && cpu.ibufGen == mem.Generation() {
+		cpu.memCycle++
+		return cpu.ibufWord, 0
+	}
+	cpu.ibufValid = false
+
+	physAddr, pageErr := cpu.transAddr(virtAddr)
+	if pageErr != 0 {
+		return 0, pageErr
+	}
+
+	if cpu.checkProtect(physAddr, false) {
+		return 0, ircProt
+	}
+
+	cpu.memCycle++
+	word, err := mem.GetWord(physAddr)
+	if err {
+		return 0, ircAddr
+	}
+
+	cpu.ibufAddr = virtAddr
+	cpu.ibufGen = mem.Generation()
+	cpu.ibufWord = word
+	cpu.ibufValid = true
+	return word, 0
+}
+
 /*
  * Read a half word from memory, checking protection
  * and alignment restrictions. Return 1 if failure, 0 if
@@ -1061,7 +1261,7 @@ func (cpu *cpuState) readHalf(virtAddr uint32) (uint32, uint16) {
 	}
 
 	// Get data
-	memCycle++
+	cpu.memCycle++
 	word, err := mem.GetWord(physAddr)
 	if err {
 		return 0, ircAddr
@@ -1089,7 +1289,7 @@ func (cpu *cpuState) readHalf(virtAddr uint32) (uint32, uint16) {
 			}
 		}
 
-		memCycle++
+		cpu.memCycle++
 		if word2, err := mem.GetWord(physAddr2); err {
 			return 0, ircAddr
 		} else {
@@ -1112,8 +1312,6 @@ func (cpu *cpuState) readHalf(virtAddr uint32) (uint32, uint16) {
  * success.
  */
 func (cpu *cpuState) readByte(virtAddr uint32) (uint32, uint16) {
-	offset := virtAddr & 3
-
 	// Validate address
 	physAddr, pageErr := cpu.transAddr(virtAddr)
 	if pageErr != 0 {
@@ -1125,15 +1323,11 @@ func (cpu *cpuState) readByte(virtAddr uint32) (uint32, uint16) {
 	}
 
 	// Read actual data
-	memCycle++
-	word, err := mem.GetWord(physAddr)
-	if err {
+	cpu.memCycle++
+	if !mem.CheckAddr(physAddr) {
 		return 0, ircAddr
 	}
-
-	word = (word >> (8 * (3 - offset))) & 0xff
-	// sim_debug(DEBUG_DATA, &cpu_dev, "RD B=%08x %08x\n", addr, *v)
-	return word, 0
+	return uint32(mem.GetByte(physAddr)), 0
 }
 
 func (cpu *cpuState) perAddrCheck(virtAddr uint32, code uint16) {
@@ -1202,23 +1396,23 @@ func (cpu *cpuState) writeFull(virtAddr, data uint32) uint16 {
 
 	switch offset {
 	case 0:
-		memCycle++
+		cpu.memCycle++
 		err1 = mem.PutWord(physAddr, data)
 		err2 = false
 	case 1:
-		memCycle++
+		cpu.memCycle++
 		err1 = mem.PutWordMask(physAddr, data>>8, 0x00ffffff)
-		memCycle++
+		cpu.memCycle++
 		err2 = mem.PutWordMask(physAddr2, data<<24, 0xff000000)
 	case 2:
-		memCycle++
+		cpu.memCycle++
 		err1 = mem.PutWordMask(physAddr, data>>16, 0x0000ffff)
-		memCycle++
+		cpu.memCycle++
 		err2 = mem.PutWordMask(physAddr2, data<<16, 0xffff0000)
 	case 3:
-		memCycle++
+		cpu.memCycle++
 		err1 = mem.PutWordMask(physAddr, data>>24, 0x000000ff)
-		memCycle++
+		cpu.memCycle++
 		err2 = mem.PutWordMask(physAddr2, data<<8, 0xffffff00)
 	}
 
@@ -1255,13 +1449,13 @@ func (cpu *cpuState) writeHalf(virtAddr, data uint32) uint16 {
 
 	switch offset {
 	case 0:
-		memCycle++
+		cpu.memCycle++
 		err = mem.PutWordMask(physAddr, data<<16, 0xffff0000)
 	case 1:
-		memCycle++
+		cpu.memCycle++
 		err = mem.PutWordMask(physAddr, data<<8, 0x00ffff00)
 	case 2:
-		memCycle++
+		cpu.memCycle++
 		err = mem.PutWordMask(physAddr, data, LMASK)
 	case 3:
 		virtAddr2 := virtAddr + 1
@@ -1282,8 +1476,8 @@ func (cpu *cpuState) writeHalf(virtAddr, data uint32) uint16 {
 			}
 		}
 
-		memCycle++
-		memCycle++
+		cpu.memCycle++
+		cpu.memCycle++
 		err = mem.PutWordMask(physAddr, data>>8, 0x000000ff)
 		err2 := mem.PutWordMask(physAddr2, data<<24, 0xff000000)
 		if err || err2 {
@@ -1302,8 +1496,6 @@ func (cpu *cpuState) writeHalf(virtAddr, data uint32) uint16 {
  * success.
  */
 func (cpu *cpuState) writeByte(virtAddr, data uint32) uint16 {
-	var err bool
-
 	// Validate address
 	physAddr, pageErr := cpu.transAddr(virtAddr)
 	if pageErr != 0 {
@@ -1316,14 +1508,11 @@ func (cpu *cpuState) writeByte(virtAddr, data uint32) uint16 {
 
 	cpu.perCheck(virtAddr)
 
-	var mask uint32 = 0x000000ff
-
-	offset := 8 * (3 - (virtAddr & 0x3))
-	memCycle++
-	if err = mem.PutWordMask(physAddr, data<<offset, mask<<offset); err {
+	cpu.memCycle++
+	if !mem.CheckAddr(physAddr) {
 		return ircAddr
 	}
-	//	sim_debug(DEBUG_DATA, &cpu_dev, "WR A=%08x %02x\n", addr, data)
+	mem.SetByte(physAddr, uint8(data))
 	return 0
 }
 
@@ -1343,6 +1532,8 @@ func (cpu *cpuState) storeDouble(reg uint8, value uint64) {
 // register a device on initialize.
 func init() {
 	config.RegisterSwitch("VMASSIST", setVMA)
+	config.RegisterSwitch("NOIDLE", setNoIdle)
+	config.RegisterModel("CPU", config.TypeOptions, setCPU)
 	config.RegisterOption("MEMSIZE", setMemSize)
 	// Temporary for testing.
 	config.RegisterModel("IPL", config.TypeOption, setIPLDev)
@@ -1352,7 +1543,13 @@ func init() {
 
 // Enable VM Assist feature.
 func setVMA(_ uint16, _ string, _ []config.Option) error {
-	sysCPU.vmaEnb = true
+	vmaFeature = true
+	return nil
+}
+
+// Keep running cycles while in wait state.
+func setNoIdle(_ uint16, _ string, _ []config.Option) error {
+	idleEnb = false
 	return nil
 }
 
@@ -1381,18 +1578,22 @@ func setMemSize(_ uint16, number string, _ []config.Option) error {
 		return errors.New("Invalid size multipler: " + string(multiplier))
 	}
 
+	// Addresses are 24 bits.
+	if size > mem.MaxSize {
+		return errors.New("Mem size larger than 16M: " + number)
+	}
+
 	// Memory should be in multiples of 8K. Force for to nearest 8k value.
 	if size < 8192 {
 		size = 8192
 	}
-	size = (size / 8192) * 8
-	mem.SetSize(size)
-	return nil
+	return mem.SetSizeBytes((size / 8192) * 8192)
 }
 
-var IPLDev uint16
+// Device to IPL at startup, NoDev for none.
+var IPLDev = Dv.NoDev
 
-// Set size of memory.
+// Select device to IPL at startup.
 func setIPLDev(devNum uint16, _ string, _ []config.Option) error {
 	if devNum == Dv.NoDev {
 		return errors.New("IPL requires devie number")
@@ -0,0 +1,128 @@
+/*
+   CPU instruction breakpoints.
+
+   Copyright (c) 2024, Richard Cornwell
+
+   Permission is hereby granted, free of charge, to any person obtaining a
+   copy of this software and associated documentation files (the "Software"),
+   to deal in the Software without restriction, including without limitation
+   the rights to use, copy, modify, merge, publish, distribute, sublicense,
+   and/or sell copies of the Software, and to permit persons to whom the
+   Software is furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in
+   all copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.  IN NO EVENT SHALL
+   ROBERT M SUPNIK BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+   IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+   CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+*/
+
+package cpu
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+)
+
+// Set instruction breakpoint at address.
+func (c *CPU) SetBreak(addr uint32) {
+	if c.state.breakPoints == nil {
+		c.state.breakPoints = map[uint32]bool{}
+	}
+	c.state.breakPoints[addr&AMASK] = true
+}
+
+// Remove instruction breakpoint at address.
+func (c *CPU) ClearBreak(addr uint32) {
+	delete(c.state.breakPoints, addr&AMASK)
+}
+
+// Remove all instruction breakpoints.
+func (c *CPU) ClearAllBreaks() {
+	clear(c.state.breakPoints)
+	c.state.breakHit = false
+}
+
+// Return list of armed breakpoints in address order.
+func (c *CPU) Breakpoints() []uint32 {
+	list := make([]uint32, 0, len(c.state.breakPoints))
+	for addr := range c.state.breakPoints {
+		list = append(list, addr)
+	}
+	slices.Sort(list)
+	return list
+}
+
+// Check if next instruction is at a breakpoint. The instruction at a
+// breakpoint just stopped at is allowed to run when CPU is continued.
+func (cpu *cpuState) checkBreak() bool {
+	if cpu.breakHit && cpu.PC == cpu.breakAddr {
+		cpu.breakHit = false
+		return false
+	}
+	cpu.breakHit = false
+	if !cpu.breakPoints[cpu.PC] {
+		return false
+	}
+	cpu.breakHit = true
+	cpu.breakAddr = cpu.PC
+	slog.Info(fmt.Sprintf("Breakpoint %06x %s", cpu.PC, cpu.pswString()))
+	return true
+}
+
+// Stop CPU when program interrupt with code is taken.
+func (c *CPU) SetTrap(code uint16) {
+	if c.state.trapCodes == nil {
+		c.state.trapCodes = map[uint16]bool{}
+	}
+	c.state.trapCodes[code] = true
+}
+
+// Remove stop on program interrupt code.
+func (c *CPU) ClearTrap(code uint16) {
+	delete(c.state.trapCodes, code)
+}
+
+// Remove all program interrupt stops.
+func (c *CPU) ClearAllTraps() {
+	clear(c.state.trapCodes)
+	c.state.trapHit = false
+}
+
+// Return list of program interrupt codes that stop CPU.
+func (c *CPU) Traps() []uint16 {
+	list := make([]uint16, 0, len(c.state.trapCodes))
+	for code := range c.state.trapCodes {
+		list = append(list, code)
+	}
+	slices.Sort(list)
+	return list
+}
+
+// Check if program interrupt should stop CPU. The interrupt is held with
+// PC at the instruction that caused it, and is taken when CPU is continued.
+func (cpu *cpuState) holdTrap(code uint16) bool {
+	if cpu.trapPend || !cpu.trapCodes[code] {
+		return false
+	}
+	cpu.trapHit = true
+	cpu.trapPend = true
+	cpu.trapCode = code
+	cpu.trapPC = cpu.PC
+	cpu.PC = cpu.iPC
+	slog.Info(fmt.Sprintf("Trap %02x at %06x %s", code, cpu.PC, cpu.pswString()))
+	return true
+}
+
+// Take program interrupt held when CPU stopped.
+func (cpu *cpuState) takeTrap() {
+	cpu.PC = cpu.trapPC
+	cpu.suppress(oPPSW, cpu.trapCode)
+	cpu.trapPend = false
+}
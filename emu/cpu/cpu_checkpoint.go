@@ -0,0 +1,191 @@
+/*
+   CPU checkpoint save and restore.
+
+   Copyright (c) 2024, Richard Cornwell
+
+   Permission is hereby granted, free of charge, to any person obtaining a
+   copy of this software and associated documentation files (the "Software"),
+   to deal in the Software without restriction, including without limitation
+   the rights to use, copy, modify, merge, publish, distribute, sublicense,
+   and/or sell copies of the Software, and to permit persons to whom the
+   Software is furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in
+   all copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.  IN NO EVENT SHALL
+   ROBERT M SUPNIK BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+   IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+   CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+*/
+
+package cpu
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	mem "github.com/rcornwell/S370/emu/memory"
+)
+
+/*
+ * Checkpoint layout, all values big endian:
+ *
+ *   Magic:     "S370CPU"             (7 bytes)
+ *   Version:   checkpointVersion     (1 byte)
+ *   State:     cpuCheckpoint
+ *   Key count: number of storage keys (4 bytes)
+ *   Keys:      one byte per 2K block
+ */
+
+const (
+	checkpointMagic   = "S370CPU"
+	checkpointVersion = 2
+)
+
+// Architected CPU state saved in a checkpoint.
+type cpuCheckpoint struct {
+	PC       uint32     // Instruction address
+	Flags    uint8      // System flags
+	StKey    uint8      // PSW key
+	EcMode   bool       // EC mode PSW
+	CC       uint8      // Condition code
+	ILC      uint8      // Instruction length code
+	ProgMask uint8      // Program mask
+	SysMask  uint16     // Channel interrupt mask
+	PageEnb  bool       // Translation enabled
+	IrqEnb   bool       // I/O interrupts enabled
+	ExtEnb   bool       // External interrupts enabled
+	PerEnb   bool       // PER enabled
+	Regs     [16]uint32 // General registers
+	FPRegs   [4]uint64  // Floating point registers
+	CRegs    [16]uint32 // Control registers
+	TodClock [2]uint32  // Time of day clock
+	ClkCmp   [2]uint32  // Clock comparator
+	CPUTimer [2]uint32  // CPU timer
+	Prefix   uint32     // Prefix register
+}
+
+// Write CPU state and storage keys to w.
+func (c *CPU) SaveState(w io.Writer) error {
+	cpu := c.state
+	state := cpuCheckpoint{
+		PC:       cpu.PC,
+		Flags:    cpu.flags,
+		StKey:    cpu.stKey,
+		EcMode:   cpu.ecMode,
+		CC:       cpu.cc,
+		ILC:      cpu.ilc,
+		ProgMask: cpu.progMask,
+		SysMask:  cpu.sysMask,
+		PageEnb:  cpu.pageEnb,
+		IrqEnb:   cpu.irqEnb,
+		ExtEnb:   cpu.extEnb,
+		PerEnb:   cpu.perEnb,
+		Regs:     cpu.regs,
+		CRegs:    cpu.cregs,
+		TodClock: cpu.todClock,
+		ClkCmp:   cpu.clkCmp,
+		CPUTimer: cpu.cpuTimer,
+		Prefix:   cpu.prefix,
+	}
+	for i := range state.FPRegs {
+		state.FPRegs[i] = cpu.fpregs[i*2]
+	}
+
+	if _, err := w.Write(append([]byte(checkpointMagic), checkpointVersion)); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.BigEndian, &state); err != nil {
+		return err
+	}
+
+	// Save storage keys.
+	numKeys := (mem.GetSize() + 0x7ff) >> 11
+	keys := make([]byte, numKeys)
+	for i := range numKeys {
+		keys[i] = mem.GetKey(i << 11)
+	}
+	if err := binary.Write(w, binary.BigEndian, numKeys); err != nil {
+		return err
+	}
+	_, err := w.Write(keys)
+	return err
+}
+
+// Read CPU state and storage keys from r.
+func (c *CPU) LoadState(r io.Reader) error {
+	hdr := make([]byte, len(checkpointMagic)+1)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return err
+	}
+	if string(hdr[:len(checkpointMagic)]) != checkpointMagic {
+		return errors.New("not a CPU checkpoint")
+	}
+	if hdr[len(checkpointMagic)] != checkpointVersion {
+		return fmt.Errorf("unsupported CPU checkpoint version: %d", hdr[len(checkpointMagic)])
+	}
+
+	var state cpuCheckpoint
+	if err := binary.Read(r, binary.BigEndian, &state); err != nil {
+		return err
+	}
+
+	var numKeys uint32
+	if err := binary.Read(r, binary.BigEndian, &numKeys); err != nil {
+		return err
+	}
+	if numKeys > (mem.GetSize()+0x7ff)>>11 {
+		return errors.New("checkpoint memory larger than current memory")
+	}
+	keys := make([]byte, numKeys)
+	if _, err := io.ReadFull(r, keys); err != nil {
+		return err
+	}
+
+	cpu := c.state
+	cpu.initialize()
+	cpu.ecMode = state.EcMode
+	cpu.irqEnb = state.IrqEnb
+	for i := range uint8(16) {
+		cpu.cregs[i] = state.CRegs[i]
+		cpu.loadControl(i, state.CRegs[i])
+	}
+	cpu.PC = state.PC
+	cpu.flags = state.Flags
+	cpu.stKey = state.StKey
+	cpu.cc = state.CC
+	cpu.ilc = state.ILC
+	cpu.progMask = state.ProgMask
+	cpu.sysMask = state.SysMask
+	cpu.pageEnb = state.PageEnb
+	cpu.extEnb = state.ExtEnb
+	cpu.perEnb = state.PerEnb
+	cpu.regs = state.Regs
+	for i := range state.FPRegs {
+		cpu.fpregs[i*2] = state.FPRegs[i]
+	}
+	cpu.todClock = state.TodClock
+	cpu.todSet = true
+	cpu.clkCmp = state.ClkCmp
+	cpu.cpuTimer = state.CPUTimer
+	cpu.prefix = state.Prefix
+
+	for i := range numKeys {
+		mem.PutKey(i<<11, keys[i])
+	}
+	cpu.restored = true
+	return nil
+}
+
+// Return true once if CPU state was restored, so start up should not reset it.
+func (c *CPU) Restored() bool {
+	r := c.state.restored
+	c.state.restored = false
+	return r
+}
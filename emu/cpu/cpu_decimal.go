@@ -257,12 +257,12 @@ func (cpu *cpuState) opSRP(step *stepInfo) uint16 {
 	var err uint16
 	var value [32]uint8
 	var sign bool
-	var cy uint8
 
 	overflow := false
 	zero := true
 	addr := step.address1
 	length := int(step.R1)
+	digits := 2*length + 1 // Number of digits, not including sign
 	shift := int(step.address2 & 0x3f)
 
 	// Load operand
@@ -271,20 +271,21 @@ func (cpu *cpuState) opSRP(step *stepInfo) uint16 {
 		return err
 	}
 
-	if (shift & 0x20) != 0 { // shift to right
-		var i, j int
-
-		shift = 0x3f & (^shift + 1)
-		if (value[shift] + step.R2) > 0x9 {
+	switch {
+	case (shift & 0x20) != 0: // Shift to right
+		shift = 0x40 - shift
+		// Rounding digit only checked when used
+		if step.R2 > 0x9 {
+			return ircData
+		}
+		cy := uint8(0)
+		if shift <= digits && (value[shift]+step.R2) > 0x9 {
 			cy = 1
 		}
-		j = shift + 1
-		for i = 1; i < length; i++ {
-			var acc uint8
-			if j > length {
-				acc = cy
-			} else {
-				acc = value[j] + cy
+		for i := 1; i <= digits; i++ {
+			acc := cy
+			if i+shift <= digits {
+				acc += value[i+shift]
 			}
 			if acc > 0x9 {
 				acc += 0x6
@@ -294,33 +295,28 @@ func (cpu *cpuState) opSRP(step *stepInfo) uint16 {
 			if value[i] != 0 {
 				zero = false
 			}
-			j++
 		}
-	} else if shift != 0 { // Shift to left
-		var i, j int
-
+	case shift != 0: // Shift to left
 		// Check if we would move out of any non-zero digits
-		for j = length; j > shift; j-- {
-			if value[j] != 0 {
+		for i := digits; i > digits-shift && i > 0; i-- {
+			if value[i] != 0 {
 				overflow = true
 			}
 		}
-		// Now shift digits
-		for i = length; j > 0; i-- {
-			value[i] = value[j]
+		// Now shift digits, filling with zeros at bottom
+		for i := digits; i > 0; i-- {
+			if i > shift {
+				value[i] = value[i-shift]
+			} else {
+				value[i] = 0
+			}
 			if value[i] != 0 {
 				zero = false
 			}
-			j--
 		}
-		// Now fill zeros until at bottom
-		for i > 0 {
-			value[i] = 0
-			i--
-		}
-	} else {
+	default:
 		// Check if number is zero
-		for i := 1; i < length; i++ {
+		for i := 1; i <= digits; i++ {
 			if value[i] != 0 {
 				zero = false
 				break
@@ -0,0 +1,72 @@
+/*
+   CPU DIAGNOSE functions.
+
+   Copyright (c) 2024, Richard Cornwell
+
+   Permission is hereby granted, free of charge, to any person obtaining a
+   copy of this software and associated documentation files (the "Software"),
+   to deal in the Software without restriction, including without limitation
+   the rights to use, copy, modify, merge, publish, distribute, sublicense,
+   and/or sell copies of the Software, and to permit persons to whom the
+   Software is furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in
+   all copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.  IN NO EVENT SHALL
+   ROBERT M SUPNIK BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+   IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+   CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+*/
+
+package cpu
+
+import (
+	"log/slog"
+	"strings"
+
+	"github.com/rcornwell/S370/util/xlat"
+)
+
+/*
+   DIAGNOSE  83 R1 R3 B2 D2
+
+   The function code is the effective address of the second operand.
+   R1 and R3 are passed to the function as arguments.
+*/
+
+// Handler for one DIAGNOSE function code, returns program interrupt code.
+type diagFunc func(cpu *cpuState, r1, r3 uint8) uint16
+
+const (
+	diagMsgMax = 132 // Longest console message
+)
+
+var diagTable = map[uint32]diagFunc{
+	0x008: diagMessage, // Write message to log
+}
+
+// Write EBCDIC message at address in R1, length in R3, to the log.
+func diagMessage(cpu *cpuState, r1, r3 uint8) uint16 {
+	addr := cpu.regs[r1] & AMASK
+	length := cpu.regs[r3]
+	if length > diagMsgMax {
+		return ircSpec
+	}
+
+	var msg strings.Builder
+	for range length {
+		by, err := cpu.readByte(addr)
+		if err != 0 {
+			return err
+		}
+		msg.WriteByte(xlat.EBCDICToASCII[by&0xff])
+		addr = (addr + 1) & AMASK
+	}
+	slog.Info("DIAG: " + msg.String())
+	cpu.cc = 0
+	return 0
+}
@@ -24,6 +24,8 @@
 
 package cpu
 
+import "math/bits"
+
 // Floating point half register.
 func (cpu *cpuState) opFPHalf(step *stepInfo) uint16 {
 	var err uint16
@@ -320,9 +322,11 @@ func (cpu *cpuState) opFPAdd(step *stepInfo) uint16 {
 				if (cpu.progMask & EXPUNDER) != 0 {
 					err = ircExpUnder
 				} else {
+					// Result is a true zero
 					sum = 0
 					sign1 = false
 					exponent1 = 0
+					cpu.cc = 0
 				}
 			}
 		}
@@ -540,9 +544,11 @@ func (cpu *cpuState) opFPAddD(step *stepInfo) uint16 {
 				if (cpu.progMask & EXPUNDER) != 0 {
 					err = ircExpUnder
 				} else {
+					// Result is a true zero
 					sum = 0
 					sign1 = false
 					exponent1 = 0
+					cpu.cc = 0
 				}
 			}
 		}
@@ -623,10 +629,6 @@ func (cpu *cpuState) opFPMul(step *stepInfo) uint16 {
 	}
 
 	var err uint16
-	// Check for overflow
-	if exponent1 >= 128 {
-		err = ircExpOver
-	}
 
 	// Align the results
 	if product != 0 {
@@ -635,6 +637,11 @@ func (cpu *cpuState) opFPMul(step *stepInfo) uint16 {
 			exponent1--
 		}
 
+		// Check for overflow
+		if exponent1 >= 128 {
+			err = ircExpOver
+		}
+
 		// Check if underflow
 		if exponent1 < 0 {
 			if (cpu.progMask & EXPUNDER) != 0 {
@@ -737,10 +744,6 @@ func (cpu *cpuState) opFPDiv(step *stepInfo) uint16 {
 	}
 
 	var err uint16
-	// Check for overflow
-	if exponent1 >= 128 {
-		err = ircExpOver
-	}
 
 	// Align the results
 	if quotent != 0 {
@@ -749,6 +752,11 @@ func (cpu *cpuState) opFPDiv(step *stepInfo) uint16 {
 			exponent1--
 		}
 
+		// Check for overflow
+		if exponent1 >= 128 {
+			err = ircExpOver
+		}
+
 		// Check if underflow
 		if exponent1 < 0 {
 			if (cpu.progMask & EXPUNDER) != 0 {
@@ -781,7 +789,8 @@ func (cpu *cpuState) opFPDiv(step *stepInfo) uint16 {
 // Extended precision load round.
 func (cpu *cpuState) opLRER(step *stepInfo) uint16 {
 	var err uint16
-	value := step.fsrc2
+	// Short operand fetch drops low half, use full register.
+	value := cpu.fpregs[step.R2]
 
 	// Check if round bit is one.
 	if (value & RMASKL) != 0 {
@@ -836,180 +845,142 @@ func (cpu *cpuState) opLRDR(step *stepInfo) uint16 {
 	return err
 }
 
+// Fetch extended register pair as 112 bit fraction.
+func (cpu *cpuState) getExtended(r uint8) (bool, int, uint64, uint64) {
+	high := cpu.fpregs[r]
+	low := cpu.fpregs[r|2]
+	fracHigh := (high & MMASKL) >> 8
+	fracLow := ((high & MMASKL) << 56) | (low & MMASKL)
+	return (high & MSIGNL) != 0, int((high & EMASKL) >> 56), fracHigh, fracLow
+}
+
+// Store 112 bit fraction into extended register pair, zero fraction is true zero.
+func (cpu *cpuState) putExtended(r uint8, sign bool, exponent int, fracHigh, fracLow uint64) {
+	high := ((fracHigh << 8) | (fracLow >> 56)) & MMASKL
+	low := fracLow & MMASKL
+	if high == 0 && low == 0 {
+		cpu.fpregs[r] = 0
+		cpu.fpregs[r|2] = 0
+		return
+	}
+	high |= (uint64(exponent) << 56) & EMASKL
+	low |= (uint64(exponent-14) << 56) & EMASKL
+	if sign {
+		high |= MSIGNL
+		low |= MSIGNL
+	}
+	cpu.fpregs[r] = high
+	cpu.fpregs[r|2] = low
+}
+
+// Shift 128 bit value right.
+func shiftRight128(high, low uint64, shift uint) (uint64, uint64) {
+	if shift >= 64 {
+		return 0, high >> (shift - 64)
+	}
+	return high >> shift, (low >> shift) | (high << (64 - shift))
+}
+
+// Shift 128 bit value left.
+func shiftLeft128(high, low uint64, shift uint) (uint64, uint64) {
+	if shift >= 64 {
+		return low << (shift - 64), 0
+	}
+	return (high << shift) | (low >> (64 - shift)), low << shift
+}
+
 // Handle extended floating point add.
 func (cpu *cpuState) opAXR(step *stepInfo) uint16 {
 	if (step.R1&0xb) != 0 || (step.R2&0xb) != 0 {
 		return ircSpec
 	}
 	var err uint16
-	value1Low := cpu.fpregs[step.R1]
-	value1High := cpu.fpregs[step.R1|2] & MMASKL
-	value2Low := cpu.fpregs[step.R2]
-	value2High := cpu.fpregs[step.R2|2] & MMASKL
-
-	// Extract numbers
-	exponent1 := int((value1Low & EMASKL) >> 56)
-	sign1 := (value1Low & MSIGNL) != 0
-	value1Low = (value1Low & MMASKL) + 1
-	exponent2 := int((value2Low & EMASKL) >> 56)
-	sign2 := (value2Low & MSIGNL) != 0
-	value2Low = (value2Low & MMASKL) + 1
+	sign1, exponent1, high1, low1 := cpu.getExtended(step.R1)
+	sign2, exponent2, high2, low2 := cpu.getExtended(step.R2)
 	if (step.opcode & 1) != 0 {
 		sign2 = !sign2
 	}
 
-	// Create Guard digits.
-	value1Low <<= 4
-	value2Low <<= 4
+	// Create guard digits.
+	high1, low1 = shiftLeft128(high1, low1, 4)
+	high2, low2 = shiftLeft128(high2, low2, 4)
 
 	// Align values
 	expDiff := exponent1 - exponent2
 	if expDiff > 0 {
-		if expDiff > 15 {
-			value2Low = 0
-			value2High = 0
+		if expDiff > 29 {
+			high2, low2 = 0, 0
 		} else {
-			for range expDiff {
-				value2Low >>= 4
-				value2Low |= (value2High & 0xf) << 60
-				value2High >>= 4
-			}
+			high2, low2 = shiftRight128(high2, low2, uint(expDiff*4))
 		}
 	} else if expDiff < 0 {
-		if expDiff < -15 {
-			value1Low = 0
-			value1High = 0
+		if expDiff < -29 {
+			high1, low1 = 0, 0
 		} else {
-			for range -expDiff {
-				value1Low >>= 4
-				value1Low |= (value1High & 0xf) << 60
-				value1High >>= 4
-			}
+			high1, low1 = shiftRight128(high1, low1, uint(-expDiff*4))
 		}
 		exponent1 = exponent2
 	}
-	// Exponents should be equal now.
 
 	// Add results
+	var carry uint64
 	if sign1 != sign2 {
-		// Different signs do subtract
-		value2High ^= XMASKL
-		value2Low ^= XMASKL
-		if value2Low == XMASKL {
-			value2High++
-		}
-		value2Low++
-		// Do actual add
-		value1Low += value2Low
-		value1High += value2High
-		// Check if overflow lower value
-		if (value1Low & CMASKL) != 0 {
-			value1Low &= XMASKL
-			value1High++
-		}
-		// Check if carry out, if not change sign of result
-		if (value1High & CMASKL) != 0 {
-			value1High &= XMASKL
-		} else {
+		// Different signs do subtract, change sign if result negative.
+		if high1 < high2 || (high1 == high2 && low1 < low2) {
+			high1, high2 = high2, high1
+			low1, low2 = low2, low1
 			sign1 = !sign1
-			value1Low ^= XMASKL
-			value1High ^= XMASKL
-			if value1Low == XMASKL {
-				value1High++
-			}
-			value1Low++
 		}
+		low1, carry = bits.Sub64(low1, low2, 0)
+		high1, _ = bits.Sub64(high1, high2, carry)
 	} else {
-		// Do add
-		value1Low += value2Low
-		value1High += value2High
-		// If lower overflowed, increment upper
-		if (value1Low & CMASKL) != 0 {
-			value1Low &= XMASKL
-			value1High++
-		}
-	}
-	value1Low += value2Low
-	value1High += value2High
-	if (value1Low & CMASKL) != 0 {
-		value1Low &= XMASKL
-		value1High++
-	}
-
-	// If overflow shift right 4 bits
-	if (value1High & NMASKL) != 0 {
-		value1Low >>= 4
-		value1Low |= (value1High & 0xf) << 60
-		value1High >>= 4
-		exponent1++
-		if exponent1 >= 128 {
-			err = ircExpOver
+		low1, carry = bits.Add64(low1, low2, 0)
+		high1, _ = bits.Add64(high1, high2, carry)
+		// If overflow shift right 4 bits
+		if (high1 >> 52) != 0 {
+			high1, low1 = shiftRight128(high1, low1, 4)
+			exponent1++
+			if exponent1 >= 128 {
+				err = ircExpOver
+			}
 		}
 	}
 
 	// Set condition codes
 	cpu.cc = 0
-	if (value1Low | value1High) != 0 {
-		if sign1 {
-			cpu.cc = 1
-		} else {
-			cpu.cc = 2
+	if (high1 | low1) == 0 {
+		cpu.putExtended(step.R1, false, 0, 0, 0)
+		if (cpu.progMask & SIGMASK) != 0 {
+			return ircSignif
 		}
+		return err
+	}
+	if sign1 {
+		cpu.cc = 1
 	} else {
-		sign1 = false
-		exponent1 = 0
+		cpu.cc = 2
 	}
 
-	// Check signifigance exception
-	if cpu.cc == 0 && (cpu.progMask&SIGMASK) != 0 {
-		cpu.fpregs[step.R1] = 0
-		cpu.fpregs[step.R1|2] = 0
-		return ircSignif
-	}
-
-	// Check if we need to normalize results
-	if cpu.cc != 0 { // Only if non-zero result
-		for (value1High & NMASKL) == 0 {
-			value1High <<= 4
-			value1High |= (value1Low >> 60) & 0xf
-			value1Low <<= 4
-			value1Low &= UMASKL
-			exponent1--
-		}
-		// Check if underflow
-		if exponent1 < 0 {
-			if (cpu.progMask & EXPUNDER) != 0 {
-				err = ircExpUnder
-			} else {
-				value1Low = 0
-				value1High = 0
-				exponent1 = 0
-				sign1 = false
-			}
-		}
-	} else { // true zero
-		value1Low = 0
-		value1High = 0
-		exponent1 = 0
-		sign1 = false
+	// Normalize result
+	for (high1 & (0xf << 48)) == 0 {
+		high1, low1 = shiftLeft128(high1, low1, 4)
+		exponent1--
 	}
 
-	// Remmove the guard digit
-	value1Low >>= 4
-
-	// Store result
-	if exponent1 != 0 {
-		value1High |= (uint64(exponent1) << 56) & EMASKL
-		value1Low |= (uint64(exponent1-14) << 56) & EMASKL
-		if sign1 {
-			value1Low |= MSIGNL
-			value1High |= MMASKL
+	// Check if underflow
+	if exponent1 < 0 {
+		if (cpu.progMask & EXPUNDER) == 0 {
+			// Result is a true zero
+			cpu.cc = 0
+			cpu.putExtended(step.R1, false, 0, 0, 0)
+			return err
 		}
+		err = ircExpUnder
 	}
 
-	cpu.fpregs[step.R1] = value1High
-	cpu.fpregs[step.R1|2] = value1Low
-
+	// Remove the guard digit and store result.
+	high1, low1 = shiftRight128(high1, low1, 4)
+	cpu.putExtended(step.R1, sign1, exponent1, high1, low1)
 	return err
 }
 
@@ -1021,187 +992,107 @@ func (cpu *cpuState) opMXD(step *stepInfo) uint16 {
 	}
 
 	// Extract number and adjust
-	exponent1 := int((step.fsrc1 & EMASKL) >> 56)
-	exponent2 := int((step.fsrc2 & EMASKL) >> 56)
-	sign1 := (step.fsrc1 & MSIGNL) != (step.fsrc2 & MSIGNL)
-
-	// Make 32 bit and create guard digit
+	exponent := int((step.fsrc1&EMASKL)>>56) + int((step.fsrc2&EMASKL)>>56) - 64
+	sign := (step.fsrc1 & MSIGNL) != (step.fsrc2 & MSIGNL)
 	value1 := step.fsrc1 & MMASKL
 	value2 := step.fsrc2 & MMASKL
 
-	// Pre-nomalize v1 and v2 */
-	if value1 != 0 {
-		for (value1 & NMASKL) == 0 {
-			value1 <<= 4
-			exponent1--
-		}
+	// Zero operand gives true zero result.
+	if value1 == 0 || value2 == 0 {
+		cpu.putExtended(step.R1, false, 0, 0, 0)
+		return 0
 	}
-	if value2 != 0 {
-		for (value2 & NMASKL) == 0 {
-			value2 <<= 4
-			exponent2--
-		}
-	}
-	// Compute exponent
-	exponent1 = exponent1 + exponent2 - 65
-
-	// Add in guard digits
-	value1 <<= 4
-	value2 <<= 4
-	var product uint64
 
-	// Do actual multiply
-	for range 56 {
-		// Add if we need too
-		if (value1 & 1) != 0 {
-			product += value2
-		}
-		value1 >>= 1
-		// Shift right by one
-		if (product & 1) != 0 {
-			value1 |= MSIGNL
-		}
-		product >>= 1
+	// Pre-normalize values
+	for (value1 & NMASKL) == 0 {
+		value1 <<= 4
+		exponent--
 	}
+	for (value2 & NMASKL) == 0 {
+		value2 <<= 4
+		exponent--
+	}
+
+	// Product is exact 112 bit fraction.
+	high, low := bits.Mul64(value1, value2)
+	return cpu.storeExtProduct(step.R1, sign, exponent, high, low)
+}
 
+// Normalize extended product and store result.
+func (cpu *cpuState) storeExtProduct(r uint8, sign bool, exponent int, high, low uint64) uint16 {
 	var err uint16
-	// If overflow, shift right 4 bits
-	if (product & EMASKL) != 0 {
-		value1 >>= 4
-		value1 |= (product & 0xf) << 60
-		product >>= 4
-		exponent1++
 
-		// Check for overflow
-		if exponent1 >= 128 {
-			err = ircExpOver
-		}
+	// At most one digit of normalization needed.
+	if (high & (0xf << 44)) == 0 {
+		high, low = shiftLeft128(high, low, 4)
+		exponent--
 	}
 
-	// Align the results
-	if product != 0 {
-		for (product & NMASKL) == 0 {
-			product <<= 4
-			product |= (value1 >> 60) & 0xf
-			value1 <<= 4
-			exponent1--
-		}
-		// Make 32 bit and create guard digit
-		// Check if underflow
-		if exponent1 < 0 {
-			if (cpu.progMask & EXPUNDER) != 0 {
-				err = ircExpUnder
-			} else {
-				product = 0
-				value1 = 0
-				sign1 = false
-				exponent1 = 0
-			}
-		} else {
-			exponent1 = 0
-			sign1 = false
-		}
-	}
-
-	// Store result
-	if exponent1 == 0 {
-		product |= (uint64(exponent1) << 56) & EMASKL
-		value1 |= (uint64(exponent1-14) << 56) & EMASKL
-		if sign1 {
-			product |= MSIGNL
-			value1 |= MSIGNL
+	if exponent >= 128 {
+		err = ircExpOver
+	} else if exponent < 0 {
+		if (cpu.progMask & EXPUNDER) == 0 {
+			// Result is a true zero
+			cpu.putExtended(r, false, 0, 0, 0)
+			return 0
 		}
+		err = ircExpUnder
 	}
-	cpu.fpregs[step.R1] = product
-	cpu.fpregs[step.R1|2] = value1
+	cpu.putExtended(r, sign, exponent, high, low)
 	return err
 }
 
+// Floating point multiply extended.
 func (cpu *cpuState) opMXR(step *stepInfo) uint16 {
 	if (step.R1&0xb) != 0 || (step.R2&0xb) != 0 {
 		return ircSpec
 	}
-	// Extract number and adjust
-	exponent1 := int((step.fsrc1 & EMASKL) >> 56)
-	exponent2 := int((step.fsrc2 & EMASKL) >> 56)
-	sign := (step.fsrc1 & MSIGNL) != (step.fsrc2 & MSIGNL)
-
-	// Make 32 bit and create guard digit
-	value1High := step.fsrc1 & MMASKL
-	value1Low := cpu.fpregs[step.R1|2] & MMASKL
-	value2High := step.fsrc2 & MMASKL
-	value2Low := cpu.fpregs[step.R2|2] & MMASKL
-
-	// Pre-nomalize v1 and v2 */
-	if value1High != 0 {
-		for (value1High & NMASKL) == 0 {
-			value1High <<= 4
-			value1High |= (value1Low >> 56) & 0xf
-			value1Low <<= 4
-			exponent1--
-		}
-	}
-	if value2High != 0 {
-		for (value2High & NMASKL) == 0 {
-			value2High <<= 4
-			value2High |= (value2Low >> 56) & 0xf
-			value2Low <<= 4
-			exponent2--
-		}
-	}
-
-	// Create guard digit
-	value1Low <<= 4
-	value1Low &= UMASKL
-
-	// Compute exponent
-	exponent1 = exponent1 + exponent2 - 64
-
-	// Do multiply
-	productLow := uint64(0)
-	productHigh := uint64(0)
-	for range 112 {
-		if (value1Low & 1) != 0 {
-			productLow += value2Low
-			productHigh += value2High
-			if (productLow & CMASKL) != 0 {
-				productHigh++
-			}
-			productLow &= XMASKL
-		}
-		// Shift right by one.
-		productLow >>= 1
-		productHigh >>= 1
-		if (productLow & 0x8) != 0 {
-			productHigh |= CMASKL >> 4
-		}
-		if (value1High & 1) != 0 {
-			value1Low |= CMASKL >> 4
-		}
-	}
-
-	var err uint16
-	// If overflow, shift right 4 bits
-	if (productHigh & EMASKL) != 0 {
-		productLow >>= 4
-		productLow |= (productHigh & 0xf) << 60
-		productHigh >>= 4
-		exponent1++
-		if exponent1 >= 128 {
-			err = ircExpOver
-		}
-	}
-	// Remove guard digit
-	productLow >>= 4
-	if exponent1 != 0 {
-		productHigh |= (uint64(exponent1) << 56) & EMASKL
-		productLow |= (uint64(exponent1-14) << 56) & EMASKL
-		if sign {
-			productHigh |= MSIGNL
-			productLow |= MSIGNL
-		}
+	sign1, exponent1, high1, low1 := cpu.getExtended(step.R1)
+	sign2, exponent2, high2, low2 := cpu.getExtended(step.R2)
+
+	// Zero operand gives true zero result.
+	if (high1|low1) == 0 || (high2|low2) == 0 {
+		cpu.putExtended(step.R1, false, 0, 0, 0)
+		return 0
+	}
+
+	// Pre-normalize values
+	for (high1 & (0xf << 44)) == 0 {
+		high1, low1 = shiftLeft128(high1, low1, 4)
+		exponent1--
+	}
+	for (high2 & (0xf << 44)) == 0 {
+		high2, low2 = shiftLeft128(high2, low2, 4)
+		exponent2--
+	}
+
+	// Form 224 bit product from partial products.
+	var prod [4]uint64
+	var carry uint64
+	h, l := bits.Mul64(low1, low2)
+	prod[0] = l
+	prod[1] = h
+	h, l = bits.Mul64(high1, low2)
+	prod[1], carry = bits.Add64(prod[1], l, 0)
+	prod[2], carry = bits.Add64(prod[2], h, carry)
+	prod[3] += carry
+	h, l = bits.Mul64(low1, high2)
+	prod[1], carry = bits.Add64(prod[1], l, 0)
+	prod[2], carry = bits.Add64(prod[2], h, carry)
+	prod[3] += carry
+	h, l = bits.Mul64(high1, high2)
+	prod[2], carry = bits.Add64(prod[2], l, 0)
+	prod[3] += h + carry
+
+	// Keep upper 112 bits plus one digit for normalization.
+	high := (prod[2] >> 44) | (prod[3] << 20)
+	low := (prod[1] >> 44) | (prod[2] << 20)
+	sign := sign1 != sign2
+	exponent := exponent1 + exponent2 - 64
+	if (high & (0xf << 48)) != 0 {
+		high, low = shiftRight128(high, low, 4)
+	} else {
+		exponent--
 	}
-	cpu.fpregs[step.R1] = productHigh
-	cpu.fpregs[step.R1|2] = productLow
-	return err
+	return cpu.storeExtProduct(step.R1, sign, exponent, high, low)
 }
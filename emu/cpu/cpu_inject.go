@@ -0,0 +1,67 @@
+/*
+   Interrupt injection for testing.
+
+   Copyright (c) 2024, Richard Cornwell
+
+   Permission is hereby granted, free of charge, to any person obtaining a
+   copy of this software and associated documentation files (the "Software"),
+   to deal in the Software without restriction, including without limitation
+   the rights to use, copy, modify, merge, publish, distribute, sublicense,
+   and/or sell copies of the Software, and to permit persons to whom the
+   Software is furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in
+   all copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.  IN NO EVENT SHALL
+   ROBERT M SUPNIK BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+   IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+   CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+*/
+
+package cpu
+
+import (
+	ch "github.com/rcornwell/S370/emu/sys_channel"
+)
+
+// Interrupts waiting to be taken by the CPU.
+type Pending struct {
+	External     bool     // External interrupt key or signal
+	Interval     bool     // Interval timer
+	ClockComp    bool     // Clock comparator
+	CPUTimer     bool     // CPU timer
+	MachineCheck bool     // Machine check
+	IO           []uint16 // Devices with pending I/O interrupt
+}
+
+// Queue a synthetic external interrupt.
+func (c *CPU) InjectExternal() {
+	c.state.extIrq = true
+}
+
+// Queue a synthetic machine check interrupt.
+func (c *CPU) InjectMachineCheck() {
+	c.state.mchkIrq = true
+}
+
+// Queue a synthetic I/O interrupt from device with given unit status.
+func InjectIO(devNum uint16, status uint8) error {
+	return ch.InjectIO(devNum, status)
+}
+
+// Return interrupts currently pending, whether enabled or not.
+func (c *CPU) PendingInterrupts() Pending {
+	cpu := c.state
+	return Pending{
+		External:     cpu.extIrq,
+		Interval:     cpu.intIrq,
+		ClockComp:    cpu.todIrq,
+		CPUTimer:     cpu.clkIrq,
+		MachineCheck: cpu.mchkIrq,
+		IO:           ch.PendingIO(),
+	}
+}
@@ -0,0 +1,179 @@
+/*
+   CPU model selection.
+
+   Copyright (c) 2024, Richard Cornwell
+
+   Permission is hereby granted, free of charge, to any person obtaining a
+   copy of this software and associated documentation files (the "Software"),
+   to deal in the Software without restriction, including without limitation
+   the rights to use, copy, modify, merge, publish, distribute, sublicense,
+   and/or sell copies of the Software, and to permit persons to whom the
+   Software is furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in
+   all copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.  IN NO EVENT SHALL
+   ROBERT M SUPNIK BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+   IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+   CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+*/
+
+package cpu
+
+import (
+	"errors"
+	"strings"
+
+	config "github.com/rcornwell/S370/config/configparser"
+	op "github.com/rcornwell/S370/emu/opcodemap"
+)
+
+/*
+   Config file:
+
+      CPU MODEL 145 [FLOAT|NOFLOAT] [DECIMAL|NODECIMAL] [DAT|NODAT]
+
+   Selects model reported by STIDP and which optional features are present.
+   Features not given take the default for the model. Instructions of an
+   absent feature give an operation exception.
+
+   Models with a timing table add execution cycles for each opcode to the
+   storage cycles used, models without one just count storage cycles.
+*/
+
+// Characteristics of one CPU model.
+type cpuModel struct {
+	number  uint16      // Model number stored by STIDP
+	float   bool        // Floating point feature installed
+	decimal bool        // Decimal feature installed
+	dat     bool        // Dynamic address translation installed
+	timing  *[256]uint8 // Extra cycles per opcode, nil for none
+}
+
+var cpuModels = map[string]cpuModel{
+	"115": {number: 0x0115, float: false, decimal: true, dat: true},
+	"125": {number: 0x0125, float: false, decimal: true, dat: true},
+	"135": {number: 0x0135, float: true, decimal: true, dat: true, timing: timing135},
+	"138": {number: 0x0138, float: true, decimal: true, dat: true},
+	"145": {number: 0x0145, float: true, decimal: true, dat: true},
+	"148": {number: 0x0148, float: true, decimal: true, dat: true},
+	"155": {number: 0x0155, float: true, decimal: true, dat: false},
+	"158": {number: 0x0158, float: true, decimal: true, dat: true},
+	"165": {number: 0x0165, float: true, decimal: true, dat: false},
+	"168": {number: 0x0168, float: true, decimal: true, dat: true, timing: timing168},
+}
+
+// Instruction timing, cycles by format RR, RX, RS/SI, SS then
+// floating point, multiply and divide.
+var (
+	timing135 = makeTiming(4, 6, 8, 20, 30, 25, 40)
+	timing168 = makeTiming(0, 0, 1, 4, 2, 2, 6)
+)
+
+// Build timing table from instruction class costs.
+func makeTiming(rr, rx, rs, ss, float, mult, div uint8) *[256]uint8 {
+	table := [256]uint8{}
+	for i := range table {
+		switch i & 0xc0 {
+		case 0x00:
+			table[i] = rr
+		case 0x40:
+			table[i] = rx
+		case 0x80:
+			table[i] = rs
+		case 0xc0:
+			table[i] = ss
+		}
+	}
+	for i := 0x20; i < 0x40; i++ {
+		table[i] = float
+		table[i+0x40] = float
+	}
+	for _, i := range []uint8{op.OpMR, op.OpM, op.OpMH, op.OpMP} {
+		table[i] = mult
+	}
+	for _, i := range []uint8{op.OpDR, op.OpD, op.OpDP} {
+		table[i] = div
+	}
+	return &table
+}
+
+// Decimal feature opcodes.
+var decimalOps = []uint8{
+	op.OpSRP, op.OpZAP, op.OpCP, op.OpAP, op.OpSP, op.OpMP, op.OpDP, op.OpED, op.OpEDMK,
+}
+
+// Currently selected model.
+var model = cpuModels["145"]
+
+// Select CPU model by name, features may be added or removed.
+func SetModel(name string, features ...string) error {
+	m, ok := cpuModels[name]
+	if !ok {
+		return errors.New("unknown CPU model: " + name)
+	}
+	for _, feature := range features {
+		switch strings.ToUpper(feature) {
+		case "FLOAT":
+			m.float = true
+		case "NOFLOAT":
+			m.float = false
+		case "DECIMAL":
+			m.decimal = true
+		case "NODECIMAL":
+			m.decimal = false
+		case "DAT":
+			m.dat = true
+		case "NODAT":
+			m.dat = false
+		default:
+			return errors.New("unknown CPU feature: " + feature)
+		}
+	}
+	model = m
+	return nil
+}
+
+// Remove instructions for features not on current model.
+func (cpu *cpuState) removeFeatures() {
+	if !model.float {
+		for i := 0x20; i < 0x40; i++ {
+			cpu.table[i] = cpu.opUnk
+		}
+		for i := 0x60; i < 0x80; i++ {
+			cpu.table[i] = cpu.opUnk
+		}
+	}
+	if !model.decimal {
+		for _, i := range decimalOps {
+			cpu.table[i] = cpu.opUnk
+		}
+	}
+	if !model.dat {
+		cpu.table[op.OpLRA] = cpu.opUnk
+	}
+}
+
+// Process CPU config line.
+func setCPU(_ uint16, option string, options []config.Option) error {
+	switch strings.ToUpper(option) {
+	case "MODEL":
+		if len(options) == 0 {
+			return errors.New("cpu model requires model number")
+		}
+		features := []string{}
+		for _, opt := range options {
+			if opt.EqualOpt != "" || len(opt.Value) != 0 {
+				return errors.New("cpu model options can't have equals or values")
+			}
+			features = append(features, opt.Name)
+		}
+		return SetModel(features[0], features[1:]...)
+	default:
+		return errors.New("cpu option invalid: " + option)
+	}
+}
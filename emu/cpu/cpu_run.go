@@ -0,0 +1,60 @@
+/*
+   Run CPU until a condition is met.
+
+   Copyright (c) 2024, Richard Cornwell
+
+   Permission is hereby granted, free of charge, to any person obtaining a
+   copy of this software and associated documentation files (the "Software"),
+   to deal in the Software without restriction, including without limitation
+   the rights to use, copy, modify, merge, publish, distribute, sublicense,
+   and/or sell copies of the Software, and to permit persons to whom the
+   Software is furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in
+   all copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.  IN NO EVENT SHALL
+   ROBERT M SUPNIK BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+   IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+   CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+*/
+
+package cpu
+
+import (
+	"errors"
+	"fmt"
+
+	event "github.com/rcornwell/S370/emu/event"
+)
+
+// Returned by RunUntil when the cycle budget runs out.
+var ErrCycleBudget = errors.New("cycle budget exhausted")
+
+// Run CPU and pending events until done returns true. Returns an error
+// wrapping ErrCycleBudget if maxCycles pass first, or an error if the
+// CPU stops.
+func (c *CPU) RunUntil(done func() bool, maxCycles int) error {
+	for cycles := 0; cycles < maxCycles; {
+		if done() {
+			return nil
+		}
+		cycle, running := c.state.cycle()
+		if !running {
+			if done() {
+				return nil
+			}
+			return fmt.Errorf("CPU stopped at %06x", c.state.PC)
+		}
+		cycle = max(cycle, 1)
+		event.Advance(cycle)
+		cycles += cycle
+	}
+	if done() {
+		return nil
+	}
+	return fmt.Errorf("%w: PC %06x after %d cycles", ErrCycleBudget, c.state.PC, maxCycles)
+}
@@ -25,6 +25,9 @@
 package cpu
 
 import (
+	"bytes"
+
+	mem "github.com/rcornwell/S370/emu/memory"
 	op "github.com/rcornwell/S370/emu/opcodemap"
 )
 
@@ -662,6 +665,9 @@ func (cpu *cpuState) opSTCM(step *stepInfo) uint16 {
 
 // Test and set.
 func (cpu *cpuState) opTS(step *stepInfo) uint16 {
+	mem.Lock()
+	defer mem.Unlock()
+
 	// Read original
 	orig, err := cpu.readByte(step.address1)
 	if err != 0 {
@@ -1024,7 +1030,8 @@ func (cpu *cpuState) opCLC(step *stepInfo) uint16 {
 	}
 }
 
-// Translate memory and Translate and Test.
+// Translate memory and Translate and Test. The table is read one byte at a
+// time, so if it overlaps the field later bytes use the translated values.
 func (cpu *cpuState) opTR(step *stepInfo) uint16 {
 	err := cpu.testAccess(step.address1, uint32(step.reg), true)
 	if err != 0 {
@@ -1036,7 +1043,7 @@ func (cpu *cpuState) opTR(step *stepInfo) uint16 {
 	}
 
 	if step.opcode == op.OpTRT {
-		sysCPU.cc = 0
+		cpu.cc = 0
 	}
 
 	for {
@@ -1078,6 +1085,51 @@ func (cpu *cpuState) opTR(step *stepInfo) uint16 {
 	}
 }
 
+// Translate and test reverse, scan from right to left.
+func (cpu *cpuState) opTRTR(step *stepInfo) uint16 {
+	err := cpu.testAccess(step.address1, uint32(step.reg), true)
+	if err != 0 {
+		return err
+	}
+	err = cpu.testAccess(step.address2, 256, false)
+	if err != 0 {
+		return err
+	}
+
+	cpu.cc = 0
+	step.address1 += uint32(step.reg)
+	for {
+		var source, xlatValue uint32
+		source, err = cpu.readByte(step.address1 & AMASK)
+		if err != 0 {
+			return err
+		}
+
+		xlatValue, err = cpu.readByte(step.address2 + (source & 0xff))
+		if err != 0 {
+			return err
+		}
+		if xlatValue != 0 {
+			cpu.regs[1] &= 0xff000000
+			cpu.regs[1] |= step.address1 & AMASK
+			cpu.regs[2] &= 0xffffff00
+			cpu.regs[2] |= xlatValue & 0xff
+			cpu.perRegMod |= 6
+			if step.reg == 0 {
+				cpu.cc = 2
+			} else {
+				cpu.cc = 1
+			}
+			return 0
+		}
+		step.address1--
+		step.reg--
+		if step.reg == 0xff {
+			return 0
+		}
+	}
+}
+
 // Move with offset.
 func (cpu *cpuState) opMVO(step *stepInfo) uint16 {
 	err := cpu.testAccess(step.address1, uint32(step.R2), true)
@@ -1257,6 +1309,14 @@ func (cpu *cpuState) opCLCL(step *stepInfo) uint16 {
 	fill := (cpu.regs[step.R2|1] >> 24) & 0xff
 	cpu.cc = 0
 
+	// Skip over equal part of operands quickly
+	if same := cpu.equalBytes(addr1, addr2, min(len1, len2)); same != 0 {
+		addr1 += same
+		len1 -= same
+		addr2 += same
+		len2 -= same
+	}
+
 	// Preform compare
 	for len1 != 0 || len2 != 0 {
 		if len1 == 0 {
@@ -1312,6 +1372,36 @@ func (cpu *cpuState) opCLCL(step *stepInfo) uint16 {
 	return err
 }
 
+// Return number of leading bytes that are equal in two operands. Only
+// compares when operands are in real storage with no protection checks,
+// the remaining bytes are left for the byte loop. Each read stays within
+// a 4K page so prefixing applies to the whole block.
+func (cpu *cpuState) equalBytes(addr1, addr2, length uint32) uint32 {
+	if !blockCompare || cpu.pageEnb || cpu.stKey != 0 {
+		return 0
+	}
+	size := mem.GetSize()
+	if addr1+length > size || addr2+length > size {
+		return 0
+	}
+
+	var buf1, buf2 [256]byte
+	count := uint32(0)
+	for count < length {
+		a1 := addr1 + count
+		a2 := addr2 + count
+		n := min(length-count, uint32(len(buf1)), 0x1000-(a1&0xfff), 0x1000-(a2&0xfff))
+		mem.ReadBytes(cpu.absAddr(a1), buf1[:n])
+		mem.ReadBytes(cpu.absAddr(a2), buf2[:n])
+		if !bytes.Equal(buf1[:n], buf2[:n]) {
+			break
+		}
+		count += n
+	}
+	cpu.memCycle += int(2 * count)
+	return count
+}
+
 // Pack characters into digits.
 func (cpu *cpuState) opPACK(step *stepInfo) uint16 {
 	var source, result uint32
@@ -1485,13 +1575,15 @@ func (cpu *cpuState) opCVB(step *stepInfo) uint16 {
 	}
 
 	r := uint16(0)
-	// Check if too big
-	if (result&OMASKL) != 0 && result != uint64(MSIGN) {
+	neg := sign == 0xb || sign == 0xd
+
+	// Check if too big, only negative value may be 2^31
+	if result > uint64(MSIGN) || (result == uint64(MSIGN) && !neg) {
 		r = ircFixDiv
 	}
 
 	// two's compliment if needed
-	if sign == 0xb || sign == 0xd {
+	if neg {
 		result = ^result + 1
 	}
 
@@ -1538,6 +1630,16 @@ func (cpu *cpuState) opCVD(step *stepInfo) uint16 {
 
 // Edit string, mark saves address of significant digit.
 func (cpu *cpuState) opED(step *stepInfo) uint16 {
+	// Scan source digits first so data exception leaves pattern unchanged.
+	check := *step
+	if err := cpu.editField(&check, false); err != 0 {
+		return err
+	}
+	return cpu.editField(step, true)
+}
+
+// Process edit pattern, only store results and update registers if store set.
+func (cpu *cpuState) editField(step *stepInfo, store bool) uint16 {
 	var err uint16
 	var src1f, src2f uint32 // Full word source
 	var src1, src2 uint8    // Working source digit
@@ -1557,7 +1659,6 @@ func (cpu *cpuState) opED(step *stepInfo) uint16 {
 	cctemp = 0
 	sig = false
 	need = true
-	cpu.cc = 0
 
 	src2f, err = cpu.readFull(step.address2 & WMASK)
 	if err != 0 {
@@ -1593,7 +1694,7 @@ func (cpu *cpuState) opED(step *stepInfo) uint16 {
 
 			// Prepare for next trip
 			src2 = (src2 & 0xf) << 4
-			if step.opcode == op.OpEDMK && !sig && temp != 0 {
+			if store && step.opcode == op.OpEDMK && !sig && temp != 0 {
 				cpu.regs[1] &= 0xff000000
 				cpu.regs[1] |= step.address1 & AMASK
 				cpu.perRegMod |= 2
@@ -1636,9 +1737,11 @@ func (cpu *cpuState) opED(step *stepInfo) uint16 {
 		}
 
 		// Save result
-		err = cpu.writeByte(step.address1, uint32(digit))
-		if err != 0 {
-			return err
+		if store {
+			err = cpu.writeByte(step.address1, uint32(digit))
+			if err != 0 {
+				return err
+			}
 		}
 		step.address1++
 		if step.reg == 0 {
@@ -1654,9 +1757,11 @@ func (cpu *cpuState) opED(step *stepInfo) uint16 {
 		src1 = uint8((src1f >> (8 * (3 - (step.address1 & 0x3)))) & 0xff)
 		digit = src1
 	}
-	cpu.cc = cctemp
-	if sig && cpu.cc == 2 {
-		cpu.cc = 1
+	if store {
+		cpu.cc = cctemp
+		if sig && cpu.cc == 2 {
+			cpu.cc = 1
+		}
 	}
 
 	return 0
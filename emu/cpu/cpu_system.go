@@ -47,7 +47,8 @@ func (cpu *cpuState) opSSK(step *stepInfo) uint16 {
 	if !memory.CheckAddr(step.address1) {
 		return ircAddr
 	}
-	memory.PutKey(step.address1, uint8(step.src1&0xf8))
+	memory.PutKey(step.address1, uint8(step.src1&0xfe))
+	cpu.ibufValid = false
 	return 0
 }
 
@@ -101,6 +102,7 @@ func (cpu *cpuState) opSSM(step *stepInfo) uint16 {
 	if err != 0 {
 		return err
 	}
+	cpu.ibufValid = false
 
 	// If in EC Mode, update various flags.
 	cpu.extEnb = (newSSM & uint32(extEnable)) != 0
@@ -163,6 +165,9 @@ func (cpu *cpuState) opCS(step *stepInfo) uint16 {
 	if (step.address1 & 0x3) != 0 {
 		return ircSpec
 	}
+	memory.Lock()
+	defer memory.Unlock()
+
 	orig, err = cpu.readFull(step.address1)
 	if err != 0 {
 		return err
@@ -191,6 +196,9 @@ func (cpu *cpuState) opCDS(step *stepInfo) uint16 {
 	if (step.address1&0x7) != 0 || (step.R1&1) != 0 || (step.R2&1) != 0 {
 		return ircSpec
 	}
+	memory.Lock()
+	defer memory.Unlock()
+
 	origl, err = cpu.readFull(step.address1)
 	if err != 0 {
 		return err
@@ -256,7 +264,7 @@ func (cpu *cpuState) opLRA(step *stepInfo) uint16 {
 	addr := ((seg << 2) + cpu.segAddr) & AMASK
 
 	// If over size of memory, trap
-	memCycle++
+	cpu.memCycle++
 	entry, err = memory.GetWord(addr)
 	if err {
 		return ircAddr
@@ -283,15 +291,15 @@ func (cpu *cpuState) opLRA(step *stepInfo) uint16 {
 
 	// Now we need to fetch the actual entry
 	addr = ((entry & pteAddr) + (page << 1)) & AMASK
-	memCycle++
+	cpu.memCycle++
 	entry, err = memory.GetWord(addr)
 	if err {
 		return ircAddr
 	}
 
-	// extract actual PTE entry
-	if (addr & 2) != 0 {
-		entry = (addr >> 16)
+	// extract actual PTE entry, even entries are in upper half of word
+	if (addr & 2) == 0 {
+		entry >>= 16
 	}
 	entry &= 0xffff
 
@@ -373,14 +381,38 @@ func (cpu *cpuState) opSIGP(_ *stepInfo) uint16 {
 	return ircOper // Not supported
 }
 
+// Store CPU timer, clock comparator, PSW, prefix and registers in this
+// CPU's prefixed save area.
+func (cpu *cpuState) storeStatus() {
+	memory.SetMemory(cpu.absAddr(0xd8), cpu.cpuTimer[0])
+	memory.SetMemory(cpu.absAddr(0xdc), cpu.cpuTimer[1])
+	memory.SetMemory(cpu.absAddr(0xe0), cpu.clkCmp[0])
+	memory.SetMemory(cpu.absAddr(0xe4), cpu.clkCmp[1])
+	word1, word2 := cpu.getPSW()
+	memory.SetMemory(cpu.absAddr(0x100), word1)
+	memory.SetMemory(cpu.absAddr(0x104), word2)
+	memory.SetMemory(cpu.absAddr(0x108), cpu.prefix)
+	for i := range uint32(4) {
+		fpr := cpu.fpregs[i*2]
+		memory.SetMemory(cpu.absAddr(0x160+(i*8)), uint32(fpr>>32))
+		memory.SetMemory(cpu.absAddr(0x164+(i*8)), uint32(fpr&LMASKL))
+	}
+	for i := range uint32(16) {
+		memory.SetMemory(cpu.absAddr(0x180+(i*4)), cpu.regs[i])
+		memory.SetMemory(cpu.absAddr(0x1c0+(i*4)), cpu.cregs[i])
+	}
+}
+
 // Machine check.
 func (cpu *cpuState) opMC(step *stepInfo) uint16 {
 	if (step.reg & 0xf0) != 0 {
 		return ircSpec
 	}
-	if (cpu.cregs[8] & (1 << step.reg)) != 0 {
-		memCycle++
-		memory.SetMemoryMask(0x94, uint32(step.reg)<<16, HMASK)
+	// Class masks are bits 16-31 of control register 8.
+	if (cpu.cregs[8] & (0x8000 >> step.reg)) != 0 {
+		cpu.memCycle++
+		memory.SetMemoryMask(cpu.absAddr(0x94), uint32(step.reg)<<16, HMASK)
+		memory.SetMemory(cpu.absAddr(0x9c), step.address1)
 		return ircMCE
 	}
 	return 0
@@ -398,6 +430,8 @@ func (cpu *cpuState) opSTxSM(step *stepInfo) uint16 {
 	var oldSSM uint8
 	var newSSM uint8
 
+	cpu.ibufValid = false
+
 	if cpu.ecMode {
 		if cpu.pageEnb {
 			oldSSM |= datEnable
@@ -426,8 +460,7 @@ func (cpu *cpuState) opSTxSM(step *stepInfo) uint16 {
 	}
 
 	// Store original value
-
-	if err := cpu.writeByte(step.address1, uint32(newSSM)); err != 0 {
+	if err := cpu.writeByte(step.address1, uint32(oldSSM)); err != 0 {
 		return err
 	}
 
@@ -565,6 +598,7 @@ func (cpu *cpuState) opLCTL(step *stepInfo) uint16 {
 		}
 		cpu.cregs[step.R1] = temp
 		cpu.loadControl(step.R1, temp)
+		cpu.ibufValid = false
 
 		if step.R1 == step.R2 {
 			break
@@ -604,18 +638,21 @@ func (cpu *cpuState) opSTCTL(step *stepInfo) uint16 {
 	return 0
 }
 
-// CPU Diagnostic instruction.
+// CPU Diagnostic instruction, unknown function codes are specification errors.
 func (cpu *cpuState) opDIAG(step *stepInfo) uint16 {
 	if (cpu.flags & problem) != 0 {
 		return ircPriv
 	}
-	cpu.storePSW(oMPSW, uint16(step.reg))
-	return 0
+	fn, ok := diagTable[step.address1&0xffff]
+	if !ok {
+		return ircSpec
+	}
+	return fn(cpu, step.R1, step.R2)
 }
 
 // Handle special 370 opcodes.
 func (cpu *cpuState) opB2(step *stepInfo) uint16 {
-	if step.reg > 0x13 {
+	if step.reg > 0x13 && step.reg != 0x2c {
 		return ircOper
 	}
 	if step.reg != 5 && (cpu.flags&problem) != 0 {
@@ -624,6 +661,7 @@ func (cpu *cpuState) opB2(step *stepInfo) uint16 {
 		//                            break;
 		return ircPriv
 	}
+	cpu.ibufValid = false
 	switch step.reg {
 	case 0x00: // CONCS
 		// Connect channel set
@@ -643,12 +681,12 @@ func (cpu *cpuState) opB2(step *stepInfo) uint16 {
 		if err != 0 {
 			return err
 		}
-		t2 := uint32(0x145) << 16
+		t2 := uint32(model.number) << 16
 		return cpu.writeFull(step.address1+4, t2)
 
 	case 0x03: // STIDC
 		// Store channel id
-		testChan := uint16(step.address1 & HMASK)
+		testChan := uint16(step.address1 & 0xfff)
 		var result uint32
 		switch ch.GetType(testChan) {
 		case dev.TypeUNA:
@@ -661,7 +699,7 @@ func (cpu *cpuState) opB2(step *stepInfo) uint16 {
 		default:
 			// Nop
 		}
-		memory.SetMemory(0xA8, result)
+		memory.SetMemory(cpu.absAddr(0xa8), result)
 		cpu.cc = 0
 		return 0
 
@@ -700,7 +738,7 @@ func (cpu *cpuState) opB2(step *stepInfo) uint16 {
 		low = cpu.todClock[0]
 		high = cpu.todClock[1]
 		// Update clock based on time before next irq
-		high &= 0xffff000
+		high &= 0xfffff000
 		err := cpu.writeFull(step.address1, low)
 		if err != 0 {
 			return err
@@ -787,7 +825,7 @@ func (cpu *cpuState) opB2(step *stepInfo) uint16 {
 		low = cpu.cpuTimer[0]
 		high = cpu.cpuTimer[1]
 		// Update clock based on time before next irq
-		high &= 0xffff000
+		high &= 0xfffff000
 		err := cpu.writeFull(step.address1, low)
 		if err != 0 {
 			return err
@@ -804,23 +842,53 @@ func (cpu *cpuState) opB2(step *stepInfo) uint16 {
 		cpu.perRegMod |= 1 << 2
 
 	case 0x0d: // PTLB
+		if !model.dat {
+			return ircOper
+		}
 		for i := range 256 {
 			cpu.tlb[i] = 0
 		}
 	case 0x10: // SPX
-		return ircOper
+		// Must be on word boundary
+		if (step.address1 & 3) != 0 {
+			return ircSpec
+		}
+		value, err := cpu.readFull(step.address1)
+		if err != 0 {
+			return err
+		}
+		value &= AMASK &^ 0xfff
+		if !memory.CheckAddr(value) {
+			return ircAddr
+		}
+		cpu.prefix = value
+		cpu.ibufValid = false
+		for i := range 256 {
+			cpu.tlb[i] = 0
+		}
 
-	case 0x11: // SPTX
-		return ircOper
+	case 0x11: // STPX
+		if (step.address1 & 3) != 0 {
+			return ircSpec
+		}
+		return cpu.writeFull(step.address1, cpu.prefix)
 
 	case 0x12: // STAP
-		return ircOper
+		if (step.address1 & 1) != 0 {
+			return ircSpec
+		}
+		return cpu.writeHalf(step.address1, uint32(cpu.cpuAddr))
 
 	case 0x13: // RRB
-		// Set storage block reference bit to zero
-		key := memory.GetKey(step.address1)
-		memory.PutKey(step.address1, key&0xfb)
-		cpu.cc = (key >> 1) & 0x3
+		// Set storage block reference bit to zero, cc is old reference and change bits.
+		if !memory.CheckAddr(step.address1) {
+			return ircAddr
+		}
+		key := memory.ResetRef(step.address1)
+		cpu.cc = (key & (memory.KeyRef | memory.KeyChange)) >> 1
+
+	case 0x2c: // TB
+		return cpu.testBlock(cpu.regs[step.address1&0xf])
 
 	default:
 		return ircOper
@@ -828,6 +896,32 @@ func (cpu *cpuState) opB2(step *stepInfo) uint16 {
 	return 0
 }
 
+// Test Block, clear 4K block at real address. There is no bad storage
+// so block is always usable.
+func (cpu *cpuState) testBlock(addr uint32) uint16 {
+	addr &= AMASK &^ 0xfff
+	if !memory.CheckAddr(addr) {
+		return ircAddr
+	}
+
+	// Low address protection covers first 512 bytes.
+	if addr == 0 && (cpu.cregs[0]&0x10000000) != 0 {
+		return ircProt
+	}
+
+	// Check storage key of both 2K halves.
+	if cpu.checkProtect(addr, true) || cpu.checkProtect(addr+0x800, true) {
+		return ircProt
+	}
+
+	for i := uint32(0); i < 0x1000; i += 4 {
+		cpu.memCycle++
+		_ = memory.PutWord(addr+i, 0)
+	}
+	cpu.cc = 0
+	return 0
+}
+
 // Start I/O Operation.
 func (cpu *cpuState) opSIO(step *stepInfo) uint16 {
 	if (cpu.flags & problem) != 0 {
@@ -843,6 +937,12 @@ func (cpu *cpuState) opTIO(step *stepInfo) uint16 {
 	if (cpu.flags & problem) != 0 {
 		return ircPriv
 	}
+	// CLRIO if bit 15 set.
+	if (step.reg & 1) != 0 {
+		cpu.cc = ch.ClearIO(uint16(step.address1 & 0xfff))
+		debug.Debugf("CPU", debugMsk, debugIO, "CLRIO %08x %03x %d", cpu.iPC, step.address1, cpu.cc)
+		return 0
+	}
 	cpu.cc = ch.TestIO(uint16(step.address1 & 0xfff))
 	// fmt.Printf("TIO %08x %03x %d\n", cpu.iPC, step.address1, cpu.cc)
 	return 0
@@ -853,6 +953,12 @@ func (cpu *cpuState) opHIO(step *stepInfo) uint16 {
 	if (cpu.flags & problem) != 0 {
 		return ircPriv
 	}
+	// HDV if bit 15 set.
+	if (step.reg & 1) != 0 {
+		cpu.cc = ch.HaltDevice(uint16(step.address1 & 0xfff))
+		debug.Debugf("CPU", debugMsk, debugIO, "HDV %08x %03x %d", cpu.iPC, step.address1, cpu.cc)
+		return 0
+	}
 	cpu.cc = ch.HaltIO(uint16(step.address1 & 0xfff))
 	debug.Debugf("CPU", debugMsk, debugIO, "HIO %08x %03x %d", cpu.iPC, step.address1, cpu.cc)
 	return 0
@@ -27,11 +27,26 @@
 package cpu
 
 import (
+	"bytes"
 	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
 	"math"
+	"math/big"
 	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 
+	config "github.com/rcornwell/S370/config/configparser"
+	"github.com/rcornwell/S370/emu/event"
 	"github.com/rcornwell/S370/emu/memory"
 
 	op "github.com/rcornwell/S370/emu/opcodemap"
@@ -310,32 +325,123 @@ func TestRandFloat(t *testing.T) {
 
 var trapFlag bool
 
+var (
+	opCover = flag.Bool("opcover", true, "report implemented opcodes not executed by tests")
+	opExec  [256]bool // Opcodes executed by tests.
+)
+
+// Check every implemented opcode was executed when whole suite is run.
+func TestMain(m *testing.M) {
+	flag.Parse()
+	code := m.Run()
+	whole := flag.Lookup("test.run").Value.String() == "" && flag.Lookup("test.skip").Value.String() == ""
+	if code == 0 && *opCover && whole {
+		if gaps := opcodeGaps(); len(gaps) != 0 {
+			fmt.Printf("Opcodes not executed: %s\n", strings.Join(gaps, " "))
+			code = 1
+		}
+	}
+	os.Exit(code)
+}
+
+// Wrap function table to record opcodes executed.
+func (cpu *cpuState) recordOpcodes() {
+	unk := reflect.ValueOf(cpu.opUnk).Pointer()
+	for i, fn := range cpu.table {
+		if reflect.ValueOf(fn).Pointer() == unk {
+			continue
+		}
+		cpu.table[i] = func(step *stepInfo) uint16 {
+			opExec[step.opcode] = true
+			return fn(step)
+		}
+	}
+}
+
+// Return implemented opcodes never executed.
+func opcodeGaps() []string {
+	var cpu cpuState
+	cpu.createTable()
+	unk := reflect.ValueOf(cpu.opUnk).Pointer()
+	gaps := []string{}
+	for i, fn := range cpu.table {
+		if reflect.ValueOf(fn).Pointer() != unk && !opExec[i] {
+			gaps = append(gaps, fmt.Sprintf("%02x", i))
+		}
+	}
+	return gaps
+}
+
+// CPU exercised by tests.
+var sysCPU cpuState
+
+// Return test CPU as an exported instance.
+func testCPU() *CPU {
+	return &CPU{state: &sysCPU}
+}
+
 func setup() {
 	memory.SetSize(64)
-	InitializeCPU()
+	sysCPU.initialize()
+	if *opCover {
+		sysCPU.recordOpcodes()
+	}
 	sysCPU.flags = 0
 	sysCPU.cc = 3
+	testStop = 0
+}
+
+// Address testInst stops at, when zero stop at end of instructions.
+var testStop uint32
+
+// Find address following block of instructions starting at addr.
+// Instructions are walked by length code up to the zero halfword that
+// ends the block, so zero operand fields are skipped.
+func testEnd(addr uint32) uint32 {
+	for range 64 {
+		w := memory.GetMemory(addr)
+		if (addr & 2) == 0 {
+			w >>= 16
+		}
+		if (w & 0xffff) == 0 {
+			break
+		}
+		addr += [4]uint32{2, 4, 4, 6}[(w>>14)&3]
+	}
+	return addr
 }
 
+// Run instructions at 0x400 until program interrupt or control reaches
+// the end of a block of instructions.
 func (cpu *cpuState) testInst(mask uint8) {
 	cpu.PC = 0x400
 	cpu.progMask = mask & 0xf
 	memory.SetMemory(0x68, 0)
 	memory.SetMemory(0x6c, 0x800)
 	trapFlag = false
+	start := cpu.PC
+	end := testEnd(start)
 	for range 20 {
-		_, _ = CycleCPU()
+		_, _ = sysCPU.cycle()
 
+		// Program interrupt new PSW reached.
 		if cpu.PC == 0x800 {
 			trapFlag = true
+			break
 		}
-		// Stop it next opcode = 0
-		w := memory.GetMemory(cpu.PC)
-		if (cpu.PC & 2) == 0 {
-			w >>= 16
+		if testStop != 0 {
+			if cpu.PC == testStop {
+				break
+			}
+			continue
 		}
-		if (w & 0xffff) == 0 {
-			break
+		// Follow branch or fall through to next block.
+		if cpu.PC < start || cpu.PC >= end {
+			start = cpu.PC
+			end = testEnd(start)
+			if end == start {
+				break
+			}
 		}
 	}
 }
@@ -2027,6 +2133,28 @@ func TestCycleLA(t *testing.T) {
 	if sysCPU.cc != 3 {
 		t.Errorf("LA CC not correct got: %x wanted: %x", sysCPU.cc, 3)
 	}
+
+	// Sum carries into high byte, only 24 bits are kept.
+	sysCPU.cc = 3
+	memory.SetMemory(0x400, 0x41565fff) // LA 5, fff(6,5)
+	sysCPU.regs[5] = 0x12fff000
+	sysCPU.regs[6] = 0xff000002
+	sysCPU.testInst(0)
+	v = sysCPU.regs[5]
+	if v != 0x00000001 {
+		t.Errorf("LA wrapped Register not correct got: %08x wanted: %08x", v, 0x00000001)
+	}
+	if sysCPU.cc != 3 {
+		t.Errorf("LA CC not correct got: %x wanted: %x", sysCPU.cc, 3)
+	}
+
+	// LR keeps all 32 bits of the same value.
+	memory.SetMemory(0x400, 0x18560000) // LR 5,6
+	sysCPU.testInst(0)
+	v = sysCPU.regs[5]
+	if v != 0xff000002 {
+		t.Errorf("LR Register not correct got: %08x wanted: %08x", v, 0xff000002)
+	}
 }
 
 // Test STC.
@@ -2102,6 +2230,46 @@ func TestCycleEX(t *testing.T) {
 	if !trapFlag {
 		t.Errorf("EX of EX did not trap")
 	}
+	if v := memory.GetMemory(0x28) & 0xffff; v != uint32(ircExec) {
+		t.Errorf("EX of EX interrupt code expected %02x got: %02x", ircExec, v)
+	}
+
+	tests := []struct {
+		name   string
+		target uint32 // Word at 0x5800
+		key    uint8  // Storage key of 0x5800
+		inst   uint32 // EX instruction
+		code   uint16 // Expected interrupt code
+	}{
+		{"fetch protected", 0x1a450000, 0x38, 0x44006800, ircProt}, // AR 4,5
+		{"odd address", 0x001a4500, 0x00, 0x44006801, ircSpec},     // AR 4,5 at odd address
+		{"undefined opcode", 0x01450000, 0x00, 0x44006800, ircOper},
+	}
+	for _, test := range tests {
+		setup()
+		sysCPU.stKey = 0x20
+		memory.SetMemory(0x28, 0)
+		memory.SetMemory(0x5800, test.target)
+		memory.PutKey(0x5800, test.key)
+		sysCPU.regs[4] = 0x100
+		sysCPU.regs[5] = 0x200
+		sysCPU.regs[6] = 0x5000
+		memory.SetMemory(0x400, test.inst)  // EX 0,800(0,6)
+		memory.SetMemory(0x404, 0x00000000) // Prevent fetch of next instruction
+		sysCPU.testInst(0)
+		memory.PutKey(0x5800, 0)
+		sysCPU.stKey = 0
+
+		if !trapFlag {
+			t.Errorf("EX %s did not trap", test.name)
+		}
+		if v := memory.GetMemory(0x28) & 0xffff; v != uint32(test.code) {
+			t.Errorf("EX %s interrupt code expected %02x got: %02x", test.name, test.code, v)
+		}
+		if sysCPU.regs[4] != 0x100 {
+			t.Errorf("EX %s target executed got: %08x", test.name, sysCPU.regs[4])
+		}
+	}
 }
 
 // Test BAL.
@@ -2156,6 +2324,61 @@ func TestCycleBALR(t *testing.T) {
 	}
 }
 
+// Test BASR.
+func TestCycleBASR(t *testing.T) {
+	setup()
+	sysCPU.regs[1] = 0
+	sysCPU.regs[2] = 0x12005678
+	memory.SetMemory(0x400, 0x0d120000) // BASR 1,2
+	memory.SetMemory(0x5678, 0)
+	sysCPU.ilc = 0
+	sysCPU.cc = 3
+	sysCPU.testInst(0xa)
+	v := sysCPU.regs[1]
+	if v != 0x00000402 {
+		t.Errorf("BASR Register 1 not correct got: %08x wanted: %08x", v, 0x00000402)
+	}
+	if sysCPU.PC != 0x00005678 {
+		t.Errorf("BASR PC not correct got: %08x wanted: %08x", sysCPU.PC, 0x00005678)
+	}
+
+	// Branch and save with no branch
+	setup()
+	sysCPU.regs[1] = 0
+	sysCPU.regs[2] = 0x12005678
+	memory.SetMemory(0x400, 0x0d100000) // BASR 1,0
+	memory.SetMemory(0x404, 0)
+	sysCPU.ilc = 0
+	sysCPU.cc = 3
+	sysCPU.testInst(0xa)
+	v = sysCPU.regs[1]
+	if v != 0x00000402 {
+		t.Errorf("BASR Register 1 not correct got: %08x wanted: %08x", v, 0x00000402)
+	}
+	if sysCPU.PC != 0x402 {
+		t.Errorf("BASR PC not correct got: %08x wanted: %08x", sysCPU.PC, 0x402)
+	}
+}
+
+// Test BAS.
+func TestCycleBAS(t *testing.T) {
+	setup()
+	sysCPU.regs[1] = 0xffffffff
+	sysCPU.regs[2] = 0x12005000
+	memory.SetMemory(0x400, 0x4d120678) // BAS 1,678(0,2)
+	memory.SetMemory(0x5678, 0)
+	sysCPU.ilc = 0
+	sysCPU.cc = 3
+	sysCPU.testInst(0xa)
+	v := sysCPU.regs[1]
+	if v != 0x00000404 {
+		t.Errorf("BAS Register 1 not correct got: %08x wanted: %08x", v, 0x00000404)
+	}
+	if sysCPU.PC != 0x00005678 {
+		t.Errorf("BAS PC not correct got: %08x wanted: %08x", sysCPU.PC, 0x00005678)
+	}
+}
+
 // Test BCT.
 func TestCycleBCT(t *testing.T) {
 	setup()
@@ -2461,6 +2684,69 @@ func TestCycleBXLE(t *testing.T) {
 	}
 }
 
+// Reference BXH and BXLE, compare value taken before first operand updated.
+func bxRef(first, incr, comp uint32, high bool) (uint32, bool) {
+	sum := first + incr
+	if high {
+		return sum, int32(sum) > int32(comp)
+	}
+	return sum, int32(sum) <= int32(comp)
+}
+
+// Compare BXH and BXLE against reference for odd and even R3.
+func TestCycleBXRandom(t *testing.T) {
+	setup()
+	edges := []uint32{0, 1, 0xffffffff, 0x7fffffff, 0x80000000}
+	rnum := rand.New(rand.NewSource(42))
+	value := func() uint32 {
+		if rnum.Intn(4) == 0 {
+			return edges[rnum.Intn(len(edges))]
+		}
+		return rnum.Uint32()
+	}
+
+	tests := []struct {
+		name string
+		inst uint32
+		r1   int
+		r3   int
+		high bool
+	}{
+		{"BXH even", 0x86142200, 1, 4, true},      // BXH 1,4,200(2)
+		{"BXH odd", 0x86152200, 1, 5, true},       // BXH 1,5,200(2)
+		{"BXH R1 comp", 0x86542200, 5, 4, true},   // BXH 5,4,200(2)
+		{"BXLE even", 0x87142200, 1, 4, false},    // BXLE 1,4,200(2)
+		{"BXLE odd", 0x87152200, 1, 5, false},     // BXLE 1,5,200(2)
+		{"BXLE R1 comp", 0x87542200, 5, 4, false}, // BXLE 5,4,200(2)
+	}
+	for _, test := range tests {
+		for range testCycles {
+			sysCPU.regs[1] = value()
+			sysCPU.regs[4] = value()
+			sysCPU.regs[5] = value()
+			sysCPU.regs[2] = 0x1000 // Branch target
+			first := sysCPU.regs[test.r1]
+			incr := sysCPU.regs[test.r3]
+			comp := sysCPU.regs[test.r3|1]
+			memory.SetMemory(0x400, test.inst)
+			memory.SetMemory(0x1200, 0)
+			sysCPU.testInst(0)
+
+			sum, branch := bxRef(first, incr, comp, test.high)
+			if sysCPU.regs[test.r1] != sum {
+				t.Errorf("%s %08x %08x %08x register got: %08x wanted: %08x", test.name, first, incr, comp, sysCPU.regs[test.r1], sum)
+			}
+			pc := uint32(0x404)
+			if branch {
+				pc = 0x1200
+			}
+			if sysCPU.PC != pc {
+				t.Errorf("%s %08x %08x %08x PC got: %08x wanted: %08x", test.name, first, incr, comp, sysCPU.PC, pc)
+			}
+		}
+	}
+}
+
 // Test and instruction.
 func TestCycleN(t *testing.T) {
 	setup()
@@ -2914,6 +3200,70 @@ func TestCycleSRDL(t *testing.T) {
 	}
 }
 
+// Odd register pair gives specification exception and changes nothing.
+func TestCycleOddPair(t *testing.T) {
+	tests := []struct {
+		name string
+		inst uint32
+	}{
+		{"MR", 0x1c120000},      // MR 1,2
+		{"DR", 0x1d120000},      // DR 1,2
+		{"M", 0x5c100100},       // M 1,100
+		{"D", 0x5d100100},       // D 1,100
+		{"SRDL", 0x8c100004},    // SRDL 1,4
+		{"SLDL", 0x8d100004},    // SLDL 1,4
+		{"SRDA", 0x8e100004},    // SRDA 1,4
+		{"SLDA", 0x8f100004},    // SLDA 1,4
+		{"CDS R1", 0xbb120100},  // CDS 1,2,100
+		{"CDS R3", 0xbb230100},  // CDS 2,3,100
+		{"MXR", 0x26240000},     // MXR 2,4
+		{"MXD", 0x67200100},     // MXD 2,100
+		{"MVCL R1", 0x0e120000}, // MVCL 1,2
+		{"MVCL R2", 0x0e230000}, // MVCL 2,3
+		{"CLCL R1", 0x0f120000}, // CLCL 1,2
+		{"CLCL R2", 0x0f230000}, // CLCL 2,3
+	}
+
+	for _, test := range tests {
+		setup()
+		memory.SetMemory(0x28, 0)
+		memory.SetMemory(0x100, 0x12345678)
+		memory.SetMemory(0x104, 0x9abcdef0)
+		memory.SetMemory(0x400, test.inst)
+		memory.SetMemory(0x404, 0)
+		var regs [16]uint32
+		for i := range regs {
+			regs[i] = 0x01010101 * uint32(i+1)
+			sysCPU.regs[i] = regs[i]
+		}
+		var fpregs [8]uint64
+		for i := range fpregs {
+			fpregs[i] = 0x4110000000000000 + uint64(i)
+			sysCPU.fpregs[i] = fpregs[i]
+		}
+		sysCPU.testInst(0)
+
+		if !trapFlag {
+			t.Errorf("%s did not trap", test.name)
+		}
+		if v := memory.GetMemory(0x28) & 0xffff; v != uint32(ircSpec) {
+			t.Errorf("%s interrupt code not correct got: %02x wanted: %02x", test.name, v, ircSpec)
+		}
+		if sysCPU.regs != regs {
+			t.Errorf("%s registers changed got: %08x", test.name, sysCPU.regs)
+		}
+		if sysCPU.fpregs != fpregs {
+			t.Errorf("%s floating registers changed got: %016x", test.name, sysCPU.fpregs)
+		}
+		if v := memory.GetMemory(0x100); v != 0x12345678 {
+			t.Errorf("%s memory changed got: %08x", test.name, v)
+		}
+		if v := memory.GetMemory(0x104); v != 0x9abcdef0 {
+			t.Errorf("%s memory changed got: %08x", test.name, v)
+		}
+	}
+}
+
 // Shift left double logical.
 func TestCycleSLDL(t *testing.T) {
 	setup()
@@ -3438,6 +3788,37 @@ func TestCycleCVB(t *testing.T) {
 	if v != mv {
 		t.Errorf("CVB 12 Register 7 not correct got: %08x wanted: %08x", v, mv)
 	}
+
+	// Values at the edge of 32 bits.
+	edge := []struct {
+		high, low uint32 // Packed decimal value
+		result    uint32 // Expected register
+		trap      bool   // Should overflow
+	}{
+		{0x00000214, 0x7483647c, 0x7fffffff, false}, // 2147483647+
+		{0x00000214, 0x7483648c, 0x80000000, true},  // 2147483648+
+		{0x00000214, 0x7483648d, 0x80000000, false}, // 2147483648-
+		{0x00000214, 0x7483649d, 0x7fffffff, true},  // 2147483649-
+	}
+	for i, test := range edge {
+		sysCPU.cc = 3
+		sysCPU.regs[5] = 50
+		sysCPU.regs[6] = 900
+		memory.SetMemory(0x28, 0)
+		memory.SetMemory(1000, test.high)
+		memory.SetMemory(1004, test.low)
+		memory.SetMemory(0x400, 0x4f756032) // CVB 7,32(5,6)
+		sysCPU.testInst(0)
+		if trapFlag != test.trap {
+			t.Errorf("CVB edge %d trap expected %v got: %v", i, test.trap, trapFlag)
+		}
+		if code := memory.GetMemory(0x28) & 0xffff; test.trap && code != uint32(ircFixDiv) {
+			t.Errorf("CVB edge %d code expected %02x got: %02x", i, ircFixDiv, code)
+		}
+		if v := sysCPU.regs[7]; v != test.result {
+			t.Errorf("CVB edge %d Register 7 not correct got: %08x wanted: %08x", i, v, test.result)
+		}
+	}
 }
 
 // Test convert to decimal.
@@ -3474,6 +3855,26 @@ func TestCycleCVD(t *testing.T) {
 	if v != mv {
 		t.Errorf("CVD Memory 2 not correct got: %08x wanted: %08x", v, mv)
 	}
+
+	// Largest negative and positive values.
+	for _, test := range []struct{ value, high, low uint32 }{
+		{0x80000000, 0x00000214, 0x7483648d},
+		{0x7fffffff, 0x00000214, 0x7483647c},
+	} {
+		sysCPU.regs[1] = test.value
+		sysCPU.regs[13] = 0x00007600
+		memory.SetMemory(0x400, 0x4e10d008) // CVD 1,8(0,13)
+		sysCPU.testInst(0)
+		if trapFlag {
+			t.Errorf("CVD %08x trapped", test.value)
+		}
+		if v := memory.GetMemory(0x7608); v != test.high {
+			t.Errorf("CVD %08x Memory 1 not correct got: %08x wanted: %08x", test.value, v, test.high)
+		}
+		if v := memory.GetMemory(0x760c); v != test.low {
+			t.Errorf("CVD %08x Memory 2 not correct got: %08x wanted: %08x", test.value, v, test.low)
+		}
+	}
 }
 
 // Move immeditate.
@@ -3705,6 +4106,162 @@ func TestCycleMVC(t *testing.T) {
 	if v != mv {
 		t.Errorf("MVC Memory 2 not correct got: %08x wanted: %08x", v, mv)
 	}
+
+	// Maximum length move of 256 bytes.
+	for i := uint32(0); i < 0x104; i += 4 {
+		memory.SetMemory(0x1000+i, 0x55555555)
+		memory.SetMemory(0x2000+i, 0x01010101*(i/4))
+	}
+	sysCPU.regs[1] = 0x1000
+	sysCPU.regs[2] = 0x2000
+	memory.SetMemory(0x400, 0xd2ff1000)
+	memory.SetMemory(0x404, 0x20000000) // MVC 0(256,1),0(2)
+	sysCPU.testInst(0)
+	for i := uint32(0); i < 0x100; i += 4 {
+		if v := memory.GetMemory(0x1000 + i); v != 0x01010101*(i/4) {
+			t.Errorf("MVC 256 Memory %06x not correct got: %08x wanted: %08x", 0x1000+i, v, 0x01010101*(i/4))
+		}
+	}
+	if v := memory.GetMemory(0x1100); v != 0x55555555 {
+		t.Errorf("MVC 256 Memory past end changed got: %08x", v)
+	}
+
+	// Overlapped move propagates first byte.
+	memory.SetMemory(0x1000, 0x40123456)
+	sysCPU.regs[1] = 0x1000
+	memory.SetMemory(0x400, 0xd2fe1001)
+	memory.SetMemory(0x404, 0x10000000) // MVC 1(255,1),0(1)
+	sysCPU.testInst(0)
+	for i := uint32(0); i < 0x100; i += 4 {
+		if v := memory.GetMemory(0x1000 + i); v != 0x40404040 {
+			t.Errorf("MVC fill Memory %06x not correct got: %08x wanted: %08x", 0x1000+i, v, 0x40404040)
+		}
+	}
+	if v := memory.GetMemory(0x1100); v != 0x55555555 {
+		t.Errorf("MVC fill Memory past end changed got: %08x", v)
+	}
+}
+
+// Move across page boundaries with translation enabled.
+func TestCycleMVCDAT(t *testing.T) {
+	setup()
+	defer func() {
+		sysCPU.pageEnb = false
+		sysCPU.ecMode = false
+		sysCPU.cregs[0] = 0x000000e0
+		sysCPU.loadControl(0, sysCPU.cregs[0])
+	}()
+
+	// 4K pages, 64K segments. Page 0 maps to itself, virtual page 1 is at
+	// 5000, page 2 is at 3000 and the rest are invalid.
+	for i := range uint32(16) {
+		pte := uint32(0x0008)
+		switch i {
+		case 0:
+			pte = 0x0000
+		case 1:
+			pte = 0x0050
+		case 2:
+			pte = 0x0030
+		}
+		memory.SetMemoryMask(0x7000+(i&^1)*2, pte<<(16*(1-(i&1))), 0xffff<<(16*(1-(i&1))))
+	}
+	memory.SetMemory(0x7100, 0xf0007000) // Segment 0, 16 pages
+	sysCPU.cregs[0] = 0x008000e0
+	sysCPU.loadControl(0, sysCPU.cregs[0])
+	sysCPU.cregs[1] = 0x00007100
+	sysCPU.loadControl(1, sysCPU.cregs[1])
+	sysCPU.ecMode = true
+	sysCPU.pageEnb = true
+
+	for i := uint32(0); i < 0x100; i += 4 {
+		memory.SetMemory(0x0f80+i, 0x55555555)
+	}
+	for i := uint32(0); i < 0x80; i += 4 {
+		memory.SetMemory(0x5f80+i, 0x01010101*(i/4))
+		memory.SetMemory(0x3000+i, 0x01010101*(i/4+0x20))
+		memory.SetMemory(0x5000+i, 0x55555555)
+	}
+
+	sysCPU.regs[1] = 0x0f80
+	sysCPU.regs[2] = 0x1f80
+	memory.SetMemory(0x400, 0xd2ff1000)
+	memory.SetMemory(0x404, 0x20000000) // MVC 0(256,1),0(2)
+	sysCPU.testInst(0)
+	if trapFlag {
+		t.Fatalf("MVC DAT trapped code: %04x", memory.GetMemory(0x8c)&0xffff)
+	}
+
+	// Virtual 0f80 to 0fff is real 0f80, 1000 to 107f is real 5000.
+	for i := uint32(0); i < 0x80; i += 4 {
+		if v := memory.GetMemory(0x0f80 + i); v != 0x01010101*(i/4) {
+			t.Errorf("MVC DAT Memory %06x not correct got: %08x wanted: %08x", 0x0f80+i, v, 0x01010101*(i/4))
+		}
+		if v := memory.GetMemory(0x5000 + i); v != 0x01010101*(i/4+0x20) {
+			t.Errorf("MVC DAT Memory %06x not correct got: %08x wanted: %08x", 0x5000+i, v, 0x01010101*(i/4+0x20))
+		}
+	}
+	if v := memory.GetMemory(0x1000); v != 0x55555555 {
+		t.Errorf("MVC DAT untranslated Memory changed got: %08x", v)
+	}
+
+	// Source crossing into invalid page gives page translation exception.
+	memory.SetMemory(0x8c, 0)
+	sysCPU.regs[2] = 0x2f80
+	sysCPU.testInst(0)
+	if !trapFlag {
+		t.Error("MVC DAT invalid page did not trap")
+	}
+	if code := memory.GetMemory(0x8c) & 0xffff; code != uint32(ircPage) {
+		t.Errorf("MVC DAT invalid page code expected %02x got: %02x", ircPage, code)
+	}
+}
+
+// Load real address through segment and page tables.
+func TestCycleLRA(t *testing.T) {
+	setup()
+	defer func() {
+		sysCPU.cregs[0] = 0x000000e0
+		sysCPU.loadControl(0, sysCPU.cregs[0])
+	}()
+
+	// 4K pages, 64K segments. Virtual page 1 is at 5000, page 2 is at
+	// 3000 and page 3 is invalid.
+	memory.SetMemory(0x7000, 0x00000050)
+	memory.SetMemory(0x7004, 0x00300008)
+	memory.SetMemory(0x7100, 0xf0007000) // Segment 0, 16 pages
+	sysCPU.cregs[0] = 0x008000e0
+	sysCPU.loadControl(0, sysCPU.cregs[0])
+	sysCPU.cregs[1] = 0x00007100
+	sysCPU.loadControl(1, sysCPU.cregs[1])
+
+	tests := []struct {
+		addr uint32
+		want uint32
+		cc   uint8
+	}{
+		{0x1234, 0x5234, 0},
+		{0x2010, 0x3010, 0},
+		{0x3010, 0x7006, 3},
+	}
+	memory.SetMemory(0x400, 0xb1102000) // LRA 1,0(0,2)
+	memory.SetMemory(0x404, 0)
+	for _, test := range tests {
+		sysCPU.regs[2] = test.addr
+		sysCPU.testInst(0)
+		if trapFlag {
+			t.Errorf("LRA %06x trapped", test.addr)
+		}
+		if sysCPU.regs[1] != test.want || sysCPU.cc != test.cc {
+			t.Errorf("LRA %06x got: %06x cc: %d wanted: %06x cc: %d", test.addr, sysCPU.regs[1], sysCPU.cc, test.want, test.cc)
+		}
+	}
+
+	sysCPU.flags = problem
+	sysCPU.testInst(0)
+	if code := memory.GetMemory(0x28) & 0xffff; !trapFlag || code != uint32(ircPriv) {
+		t.Errorf("LRA problem state code expected %02x got: %02x", ircPriv, code)
+	}
 }
 
 // Move zones.
@@ -3827,6 +4384,76 @@ func TestCycleUNPK(t *testing.T) {
 	}
 }
 
+// Pack into field too short, high order digits are lost.
+func TestCyclePACKTruncate(t *testing.T) {
+	setup()
+
+	sysCPU.regs[12] = 0x00001000
+	sysCPU.regs[13] = 0x00002000
+	memory.SetMemory(0x2000, 0xf1f2f3f4)
+	memory.SetMemory(0x2004, 0xf5f6f7c8)
+	memory.SetMemory(0x1000, 0xffffffff)
+	memory.SetMemory(0x400, 0xf227c000)
+	memory.SetMemory(0x404, 0xd0000000) // PACK 0(3, 12), 0(8, 13)
+	sysCPU.testInst(0)
+	v := memory.GetMemory(0x1000)
+	mv := uint32(0x45678cff)
+	if v != mv {
+		t.Errorf("PACK truncate Memory not correct got: %08x wanted: %08x", v, mv)
+	}
+
+	// Maximum length source into maximum length destination.
+	for i := range uint32(4) {
+		memory.SetMemory(0x2000+(i*4), 0xf9f8f7f6)
+		memory.SetMemory(0x1000+(i*4), 0xffffffff)
+	}
+	memory.SetMemory(0x200c, 0xf9f8f7d6)
+	memory.SetMemory(0x400, 0xf2ffc000)
+	memory.SetMemory(0x404, 0xd0000000) // PACK 0(16, 12), 0(16, 13)
+	sysCPU.testInst(0)
+	mvs := []uint32{0x00000000, 0x00000009, 0x87698769, 0x8769876d}
+	for i, mv := range mvs {
+		v := memory.GetMemory(0x1000 + uint32(i*4))
+		if v != mv {
+			t.Errorf("PACK maximum Memory %d not correct got: %08x wanted: %08x", i, v, mv)
+		}
+	}
+}
+
+// Unpack short field into maximum length field.
+func TestCycleUNPKLong(t *testing.T) {
+	setup()
+
+	sysCPU.regs[12] = 0x00001000
+	sysCPU.regs[13] = 0x00002000
+	memory.SetMemory(0x2000, 0x123cffff)
+	for i := range uint32(5) {
+		memory.SetMemory(0x1000+(i*4), 0x55555555)
+	}
+	memory.SetMemory(0x400, 0xf3f1c000)
+	memory.SetMemory(0x404, 0xd0000000) // UNPK 0(16, 12), 0(2, 13)
+	sysCPU.testInst(0)
+	mvs := []uint32{0xf0f0f0f0, 0xf0f0f0f0, 0xf0f0f0f0, 0xf0f1f2c3, 0x55555555}
+	for i, mv := range mvs {
+		v := memory.GetMemory(0x1000 + uint32(i*4))
+		if v != mv {
+			t.Errorf("UNPK long Memory %d not correct got: %08x wanted: %08x", i, v, mv)
+		}
+	}
+
+	// Unpack into field too short, high order digits are lost.
+	memory.SetMemory(0x2000, 0x1234567c)
+	memory.SetMemory(0x1000, 0x55555555)
+	memory.SetMemory(0x400, 0xf323c000)
+	memory.SetMemory(0x404, 0xd0000000) // UNPK 0(3, 12), 0(4, 13)
+	sysCPU.testInst(0)
+	v := memory.GetMemory(0x1000)
+	mv := uint32(0xf5f6c755)
+	if v != mv {
+		t.Errorf("UNPK truncate Memory not correct got: %08x wanted: %08x", v, mv)
+	}
+}
+
 // And characters.
 func TestCycleNC(t *testing.T) {
 	setup()
@@ -3973,6 +4600,55 @@ func TestCycleTR(t *testing.T) {
 	}
 }
 
+// Translate full 256 byte field.
+func TestCycleTRFull(t *testing.T) {
+	setup()
+
+	// Table complements each byte, field holds every byte value.
+	for i := uint32(0); i < 256; i += 4 {
+		memory.SetMemory(0x1000+i, ^((i << 24) | ((i + 1) << 16) | ((i + 2) << 8) | (i + 3)))
+		memory.SetMemory(0x2000+i, (i<<24)|((i+1)<<16)|((i+2)<<8)|(i+3))
+	}
+	memory.SetMemory(0x2100, 0x55555555)
+	sysCPU.regs[12] = 0x00002000
+	sysCPU.regs[15] = 0x00001000
+	memory.SetMemory(0x400, 0xdcffc000)
+	memory.SetMemory(0x404, 0xf0000000) // TR 0(256,12),0(15)
+	sysCPU.testInst(0)
+	for i := uint32(0); i < 256; i++ {
+		b := memory.GetByte(0x2000 + i)
+		if b != uint8(0xff-i) {
+			t.Errorf("TR Memory %02x not correct got: %02x wanted: %02x", i, b, 0xff-i)
+		}
+	}
+	v := memory.GetMemory(0x2100)
+	if v != 0x55555555 {
+		t.Errorf("TR Memory past end changed got: %08x wanted: %08x", v, 0x55555555)
+	}
+}
+
+// Translate with table overlapping field, table is read a byte at a time
+// so later bytes see the results already stored.
+func TestCycleTROverlap(t *testing.T) {
+	setup()
+
+	memory.SetMemory(0x2000, 0x05000102)
+	memory.SetMemory(0x2004, 0x00400000)
+	sysCPU.regs[12] = 0x00002000
+	memory.SetMemory(0x400, 0xdc03c000)
+	memory.SetMemory(0x404, 0xc0000000) // TR 0(4,12),0(12)
+	sysCPU.testInst(0)
+	v := memory.GetMemory(0x2000)
+	mv := uint32(0x40404040)
+	if v != mv {
+		t.Errorf("TR Overlap not correct got: %08x wanted: %08x", v, mv)
+	}
+	v = memory.GetMemory(0x2004)
+	if v != 0x00400000 {
+		t.Errorf("TR Overlap table changed got: %08x wanted: %08x", v, 0x00400000)
+	}
+}
+
 // Translate and test.
 func TestCycleTRT(t *testing.T) {
 	setup()
@@ -4068,6 +4744,41 @@ func TestCycleSPM(t *testing.T) {
 	}
 }
 
+// Test SPM loads cc and mask and leaves rest of PSW alone.
+func TestCycleSPMFields(t *testing.T) {
+	setup()
+
+	sysCPU.sysMask = 0xfe00
+	sysCPU.stKey = 0x50
+	sysCPU.flags = 0x0
+	sysCPU.cc = 0
+	sysCPU.regs[1] = 0x2b5a5a5a
+	memory.SetMemory(0x400, 0x04100000) // SPM 1
+	memory.SetMemory(0x404, 0x00000000)
+	sysCPU.testInst(0x4)
+	if sysCPU.cc != 2 {
+		t.Errorf("SPM CC not correct got: %x wanted: %x", sysCPU.cc, 2)
+	}
+	if sysCPU.progMask != 0xb {
+		t.Errorf("SPM Mask not correct got: %02x wanted: %02x", sysCPU.progMask, 0xb)
+	}
+	if sysCPU.PC != 0x402 {
+		t.Errorf("SPM PC not correct got: %08x wanted: %08x", sysCPU.PC, 0x402)
+	}
+	if sysCPU.sysMask != 0xfe00 {
+		t.Errorf("SPM System mask changed got: %04x wanted: %04x", sysCPU.sysMask, 0xfe00)
+	}
+	if sysCPU.stKey != 0x50 {
+		t.Errorf("SPM Key changed got: %02x wanted: %02x", sysCPU.stKey, 0x50)
+	}
+	if sysCPU.flags != 0 {
+		t.Errorf("SPM Flags changed got: %02x wanted: %02x", sysCPU.flags, 0)
+	}
+	if sysCPU.regs[1] != 0x2b5a5a5a {
+		t.Errorf("SPM Register changed got: %08x wanted: %08x", sysCPU.regs[1], 0x2b5a5a5a)
+	}
+}
+
 // Test SSM instruction.
 func TestCycleSSM(t *testing.T) {
 	setup()
@@ -4123,8 +4834,142 @@ func TestCycleSSM(t *testing.T) {
 	sysCPU.flags = 0
 }
 
-// Test lpsw instruction.
-func TestCycleLPSW(t *testing.T) {
+// Store then and or system mask.
+func TestCycleSTNSMSTOSM(t *testing.T) {
+	setup()
+	defer func() {
+		sysCPU.ecMode = false
+		sysCPU.irqEnb = false
+		sysCPU.extEnb = false
+	}()
+
+	sysCPU.ecMode = true
+	sysCPU.irqEnb = true
+	sysCPU.extEnb = true
+	memory.SetMemory(0x500, 0xffffffff)
+	memory.SetMemory(0x400, 0xacfe0500) // STNSM 500,fe
+	memory.SetMemory(0x404, 0)
+	sysCPU.testInst(0)
+	if trapFlag {
+		t.Error("STNSM trapped")
+	}
+	if v := memory.GetMemory(0x500); v != 0x03ffffff {
+		t.Errorf("STNSM stored mask got: %08x wanted: %08x", v, 0x03ffffff)
+	}
+	if sysCPU.extEnb || !sysCPU.irqEnb {
+		t.Errorf("STNSM mask not correct ext: %v irq: %v", sysCPU.extEnb, sysCPU.irqEnb)
+	}
+
+	memory.SetMemory(0x400, 0xad010500) // STOSM 500,01
+	sysCPU.testInst(0)
+	if trapFlag {
+		t.Error("STOSM trapped")
+	}
+	if v := memory.GetMemory(0x500); v != 0x02ffffff {
+		t.Errorf("STOSM stored mask got: %08x wanted: %08x", v, 0x02ffffff)
+	}
+	if !sysCPU.extEnb || !sysCPU.irqEnb {
+		t.Errorf("STOSM mask not correct ext: %v irq: %v", sysCPU.extEnb, sysCPU.irqEnb)
+	}
+
+	// Invalid bits in EC mode.
+	memory.SetMemory(0x400, 0xad080500) // STOSM 500,08
+	sysCPU.testInst(0)
+	if !trapFlag {
+		t.Error("STOSM invalid mask did not trap")
+	}
+
+	sysCPU.flags = problem
+	memory.SetMemory(0x400, 0xac000500) // STNSM 500,00
+	sysCPU.testInst(0)
+	if code := memory.GetMemory(0x28) & 0xffff; !trapFlag || code != uint32(ircPriv) {
+		t.Errorf("STNSM problem state code expected %02x got: %02x", ircPriv, code)
+	}
+}
+
+// Signal processor is not supported with single CPU.
+func TestCycleSIGP(t *testing.T) {
+	setup()
+
+	memory.SetMemory(0x400, 0xae120500) // SIGP 1,2,500
+	memory.SetMemory(0x404, 0)
+	sysCPU.testInst(0)
+	if code := memory.GetMemory(0x28) & 0xffff; !trapFlag || code != uint32(ircOper) {
+		t.Errorf("SIGP code expected %02x got: %02x", ircOper, code)
+	}
+
+	sysCPU.flags = problem
+	sysCPU.testInst(0)
+	if code := memory.GetMemory(0x28) & 0xffff; !trapFlag || code != uint32(ircPriv) {
+		t.Errorf("SIGP problem state code expected %02x got: %02x", ircPriv, code)
+	}
+}
+
+// Monitor call, event only when class enabled in control register 8.
+func TestCycleMC(t *testing.T) {
+	setup()
+	defer func() { sysCPU.cregs[8] = 0 }()
+
+	sysCPU.regs[1] = 0x100
+	memory.SetMemory(0x94, 0)
+	memory.SetMemory(0x9c, 0)
+	memory.SetMemory(0x400, 0xaf031234) // MC 234(1),3
+	memory.SetMemory(0x404, 0)
+	sysCPU.testInst(0)
+	if trapFlag {
+		t.Error("MC disabled class trapped")
+	}
+
+	sysCPU.cregs[8] = 0x00001000 // Class 3
+	sysCPU.testInst(0)
+	if code := memory.GetMemory(0x28) & 0xffff; !trapFlag || code != uint32(ircMCE) {
+		t.Errorf("MC code expected %02x got: %02x", ircMCE, code)
+	}
+	if v := memory.GetMemory(0x94); v != 0x00030000 {
+		t.Errorf("MC class got: %08x wanted: %08x", v, 0x00030000)
+	}
+	if v := memory.GetMemory(0x9c); v != 0x334 {
+		t.Errorf("MC code got: %08x wanted: %08x", v, 0x334)
+	}
+
+	memory.SetMemory(0x400, 0xaf131234) // MC 234(1),13
+	sysCPU.testInst(0)
+	if code := memory.GetMemory(0x28) & 0xffff; !trapFlag || code != uint32(ircSpec) {
+		t.Errorf("MC invalid class code expected %02x got: %02x", ircSpec, code)
+	}
+}
+
+// Compare and swap.
+func TestCycleCS(t *testing.T) {
+	setup()
+
+	memory.SetMemory(0x500, 0x11223344)
+	sysCPU.regs[2] = 0x11223344
+	sysCPU.regs[3] = 0x55667788
+	memory.SetMemory(0x400, 0xba230500) // CS 2,3,500
+	memory.SetMemory(0x404, 0)
+	sysCPU.testInst(0)
+	if v := memory.GetMemory(0x500); v != 0x55667788 || sysCPU.cc != 0 {
+		t.Errorf("CS equal got: %08x cc: %d", v, sysCPU.cc)
+	}
+
+	sysCPU.testInst(0)
+	if v := memory.GetMemory(0x500); v != 0x55667788 || sysCPU.cc != 1 {
+		t.Errorf("CS unequal got: %08x cc: %d", v, sysCPU.cc)
+	}
+	if sysCPU.regs[2] != 0x55667788 {
+		t.Errorf("CS unequal register 2 got: %08x wanted: %08x", sysCPU.regs[2], 0x55667788)
+	}
+
+	memory.SetMemory(0x400, 0xba230502) // CS 2,3,502
+	sysCPU.testInst(0)
+	if code := memory.GetMemory(0x28) & 0xffff; !trapFlag || code != uint32(ircSpec) {
+		t.Errorf("CS unaligned code expected %02x got: %02x", ircSpec, code)
+	}
+}
+
+// Test lpsw instruction.
+func TestCycleLPSW(t *testing.T) {
 	setup()
 
 	sysCPU.stKey = 0
@@ -4164,6 +5009,107 @@ func TestCycleLPSW(t *testing.T) {
 	sysCPU.stKey = 0x00
 }
 
+// Unaligned control operands give specification exception, FP doubleword does not.
+func TestCycleAlignSpec(t *testing.T) {
+	tests := []struct {
+		name string
+		inst uint32
+		trap bool
+	}{
+		{"LPSW word", 0x82000104, true},     // LPSW 104
+		{"LPSW half", 0x82000102, true},     // LPSW 102
+		{"LCTL", 0xb7000102, true},          // LCTL 0,0,102
+		{"STCTL", 0xb6000102, true},         // STCTL 0,0,102
+		{"LD", 0x68000102, false},           // LD 0,102
+		{"STD", 0x60000104, false},          // STD 0,104
+		{"LCTL aligned", 0xb7000104, false}, // LCTL 0,0,104
+	}
+
+	for _, test := range tests {
+		setup()
+		sysCPU.flags = 0x0 // privileged
+		sysCPU.cregs[0] = 0
+		memory.SetMemory(0x28, 0)
+		memory.SetMemory(0x100, 0x12345678)
+		memory.SetMemory(0x104, 0x00000000)
+		memory.SetMemory(0x108, 0x9abcdef0)
+		memory.SetMemory(0x400, test.inst)
+		memory.SetMemory(0x404, 0)
+		sysCPU.testInst(0)
+
+		if trapFlag != test.trap {
+			t.Errorf("%s trap got: %v wanted: %v", test.name, trapFlag, test.trap)
+			continue
+		}
+		if !test.trap {
+			continue
+		}
+		if v := memory.GetMemory(0x28) & 0xffff; v != uint32(ircSpec) {
+			t.Errorf("%s interrupt code not correct got: %02x wanted: %02x", test.name, v, ircSpec)
+		}
+		if v := memory.GetMemory(0x100); v != 0x12345678 {
+			t.Errorf("%s memory changed got: %08x", test.name, v)
+		}
+		if sysCPU.cregs[0] != 0 {
+			t.Errorf("%s control register changed got: %08x", test.name, sysCPU.cregs[0])
+		}
+	}
+}
+
+// Program interrupt after loading EC mode PSW stores code at 0x8c.
+func TestCycleLPSWECMode(t *testing.T) {
+	setup()
+	defer func() { sysCPU.ecMode = false }()
+
+	sysCPU.flags = 0x0 // privileged
+	memory.SetMemory(0x28, 0)
+	memory.SetMemory(0x2c, 0)
+	memory.SetMemory(0x8c, 0)
+	memory.SetMemory(0x500, 0x00082000) // EC mode, CC 2
+	memory.SetMemory(0x504, 0x00000600) // Start at 600
+	memory.SetMemory(0x400, 0x82000500) // LPSW 500
+	memory.SetMemory(0x600, 0xb2ff0000) // Invalid B2 operation
+	sysCPU.testInst(0)
+	if !trapFlag {
+		t.Fatal("EC mode invalid operation did not trap")
+	}
+	if v := memory.GetMemory(0x8c); v != 0x00040000|uint32(ircOper) {
+		t.Errorf("EC mode interrupt code expected %08x got: %08x", 0x00040000|uint32(ircOper), v)
+	}
+	if v := memory.GetMemory(0x28); v != 0x00082000 {
+		t.Errorf("EC mode old PSW 1 expected %08x got: %08x", 0x00082000, v)
+	}
+	if v := memory.GetMemory(0x2c); v != 0x00000604 {
+		t.Errorf("EC mode old PSW 2 expected %08x got: %08x", 0x00000604, v)
+	}
+
+	// BC mode PSW keeps code in old PSW.
+	memory.SetMemory(0x8c, 0)
+	memory.SetMemory(0x500, 0x00000000) // BC mode
+	sysCPU.testInst(0)
+	if v := memory.GetMemory(0x8c); v != 0 {
+		t.Errorf("BC mode stored interrupt code at 0x8c: %08x", v)
+	}
+	if v := memory.GetMemory(0x28); v != uint32(ircOper) {
+		t.Errorf("BC mode old PSW 1 expected %08x got: %08x", uint32(ircOper), v)
+	}
+	if v := memory.GetMemory(0x2c); v != 0x80000604 {
+		t.Errorf("BC mode old PSW 2 expected %08x got: %08x", 0x80000604, v)
+	}
+
+	// Only 24 bit addresses in EC mode.
+	memory.SetMemory(0x500, 0x00080000)
+	memory.SetMemory(0x504, 0x01000600)
+	sysCPU.ecMode = false
+	sysCPU.testInst(0)
+	if !trapFlag {
+		t.Fatal("EC mode 31 bit address did not trap")
+	}
+	if v := memory.GetMemory(0x8c) & 0xffff; v != uint32(ircSpec) {
+		t.Errorf("EC mode address interrupt code expected %02x got: %02x", ircSpec, v)
+	}
+}
+
 // Supervisory call.
 func TestCycleSVC(t *testing.T) {
 	setup()
@@ -4241,8 +5187,8 @@ func TestCycleSSK(t *testing.T) {
 	memory.PutKey(0x5600, 0)
 	memory.SetMemory(0x400, 0x08120000) // SSK 1,2
 	sysCPU.testInst(0)
-	if memory.GetKey(0x5600) != 0x40 {
-		t.Errorf("SSK privileged did not changed key got: %02x expected: %02x", memory.GetKey(0x5600), 0x40)
+	if memory.GetKey(0x5600) != 0x44 {
+		t.Errorf("SSK privileged did not changed key got: %02x expected: %02x", memory.GetKey(0x5600), 0x44)
 	}
 
 	sysCPU.flags = 0x0          // privileged
@@ -4259,6 +5205,43 @@ func TestCycleSSK(t *testing.T) {
 	}
 }
 
+// SSK sets reference and change bits, ISK returns them in EC mode.
+func TestCycleSSKISKRefChange(t *testing.T) {
+	setup()
+
+	sysCPU.flags = 0x0 // privileged
+	sysCPU.ecMode = true
+	sysCPU.regs[1] = 0xffffff9f // Key 9, fetch, reference, change
+	sysCPU.regs[2] = 0x00005600
+	sysCPU.regs[3] = 0
+	memory.PutKey(0x5600, 0)
+	memory.SetMemory(0x400, 0x08120932) // SSK 1,2; ISK 3,2
+	memory.SetMemory(0x404, 0)
+	sysCPU.testInst(0)
+	if trapFlag {
+		t.Error("SSK/ISK trapped")
+	}
+	if k := memory.GetKey(0x5600); k != 0x9e {
+		t.Errorf("SSK key not correct got: %02x expected: %02x", k, 0x9e)
+	}
+	if sysCPU.regs[3] != 0x9e {
+		t.Errorf("ISK Register 3 not correct got: %08x wanted: %08x", sysCPU.regs[3], 0x9e)
+	}
+
+	// BC mode ISK only returns key and fetch bit.
+	setup()
+	sysCPU.flags = 0x0
+	sysCPU.ecMode = false
+	sysCPU.regs[2] = 0x00005600
+	sysCPU.regs[3] = 0
+	memory.PutKey(0x5600, 0x9e)
+	memory.SetMemory(0x400, 0x09320000) // ISK 3,2
+	sysCPU.testInst(0)
+	if sysCPU.regs[3] != 0x98 {
+		t.Errorf("ISK BC Register 3 not correct got: %08x wanted: %08x", sysCPU.regs[3], 0x98)
+	}
+}
+
 // ISK reads the storage key.
 func TestCycleISK(t *testing.T) {
 	setup()
@@ -4302,6 +5285,301 @@ func TestCycleISK(t *testing.T) {
 	}
 }
 
+// Store sets change bit and RRB resets reference bit.
+func TestCycleRRB(t *testing.T) {
+	setup()
+	sysCPU.ecMode = true
+	defer func() { sysCPU.ecMode = false }()
+
+	sysCPU.regs[2] = 0x5800
+	sysCPU.regs[3] = 0x12345678
+	memory.PutKey(0x5800, 0x30)
+	memory.SetMemory(0x400, 0x50302000) // ST 3,0(2)
+	memory.SetMemory(0x404, 0x0912b213) // ISK 1,2; RRB 0(2)
+	memory.SetMemory(0x408, 0x20000000)
+	sysCPU.testInst(0)
+	if trapFlag {
+		t.Fatal("RRB trapped")
+	}
+	if v := sysCPU.regs[1] & 0xff; v != 0x36 {
+		t.Errorf("ISK after store expected %02x got: %02x", 0x36, v)
+	}
+	if sysCPU.cc != 3 {
+		t.Errorf("RRB CC expected %d got: %d", 3, sysCPU.cc)
+	}
+	if k := memory.GetKey(0x5800); k != 0x32 {
+		t.Errorf("RRB key expected %02x got: %02x", 0x32, k)
+	}
+
+	// Reference already reset, only change bit remains.
+	memory.SetMemory(0x400, 0xb2132000) // RRB 0(2)
+	memory.SetMemory(0x404, 0)
+	sysCPU.testInst(0)
+	if sysCPU.cc != 1 {
+		t.Errorf("RRB second CC expected %d got: %d", 1, sysCPU.cc)
+	}
+
+	// Fetch sets reference bit only.
+	memory.PutKey(0x5800, 0x30)
+	memory.SetMemory(0x400, 0x58402000) // L 4,0(2)
+	memory.SetMemory(0x404, 0xb2132000) // RRB 0(2)
+	memory.SetMemory(0x408, 0)
+	sysCPU.testInst(0)
+	if sysCPU.cc != 2 {
+		t.Errorf("RRB after fetch CC expected %d got: %d", 2, sysCPU.cc)
+	}
+
+	// Block not referenced or changed.
+	memory.PutKey(0x5800, 0x30)
+	memory.SetMemory(0x400, 0xb2132000) // RRB 0(2)
+	memory.SetMemory(0x404, 0)
+	sysCPU.testInst(0)
+	if sysCPU.cc != 0 {
+		t.Errorf("RRB untouched CC expected %d got: %d", 0, sysCPU.cc)
+	}
+	if k := memory.GetKey(0x5800); k != 0x30 {
+		t.Errorf("RRB untouched key expected %02x got: %02x", 0x30, k)
+	}
+
+	// Address outside of storage.
+	memory.SetMemory(0x8c, 0)
+	sysCPU.regs[2] = 0x100000
+	sysCPU.testInst(0)
+	if !trapFlag {
+		t.Error("RRB outside storage should have trapped")
+	}
+	if code := memory.GetMemory(0x8c) & 0xffff; code != uint32(ircAddr) {
+		t.Errorf("RRB outside storage code expected %02x got: %02x", ircAddr, code)
+	}
+
+	sysCPU.ecMode = true // Interrupt loaded BC mode PSW
+	sysCPU.flags = 0x1   // unprivileged
+	sysCPU.regs[2] = 0x5800
+	memory.PutKey(0x5800, 0x36)
+	memory.SetMemory(0x8c, 0)
+	memory.SetMemory(0x400, 0xb2132000) // RRB 0(2)
+	memory.SetMemory(0x404, 0)
+	sysCPU.testInst(0)
+	if !trapFlag {
+		t.Error("RRB unprivileged should have trapped")
+	}
+	if code := memory.GetMemory(0x8c) & 0xffff; code != uint32(ircPriv) {
+		t.Errorf("RRB unprivileged code expected %02x got: %02x", ircPriv, code)
+	}
+	if k := memory.GetKey(0x5800); k != 0x36 {
+		t.Errorf("RRB unprivileged changed key expected %02x got: %02x", 0x36, k)
+	}
+}
+
+// Test block clears block, honoring key and low address protection.
+func TestCycleTB(t *testing.T) {
+	setup()
+	defer func() {
+		sysCPU.stKey = 0
+		sysCPU.cregs[0] = 0x000000e0
+	}()
+
+	fill := func(addr uint32) {
+		for i := uint32(0); i < 0x1000; i += 4 {
+			memory.SetMemory(addr+i, 0x55555555)
+		}
+	}
+	check := func(name string, addr, value uint32) {
+		for i := uint32(0); i < 0x1000; i += 4 {
+			if v := memory.GetMemory(addr + i); v != value {
+				t.Errorf("TB %s %06x expected %08x got: %08x", name, addr+i, value, v)
+				return
+			}
+		}
+	}
+
+	fill(0x3000)
+	sysCPU.cc = 3
+	sysCPU.regs[2] = 0x3123
+	memory.SetMemory(0x400, 0xb22c0012) // TB 1,2
+	memory.SetMemory(0x404, 0)
+	sysCPU.testInst(0)
+	if trapFlag {
+		t.Fatal("TB trapped")
+	}
+	if sysCPU.cc != 0 {
+		t.Errorf("TB CC expected %d got: %d", 0, sysCPU.cc)
+	}
+	check("clear", 0x3000, 0)
+
+	// Key protected block left alone.
+	fill(0x5000)
+	memory.PutKey(0x5000, 0x30)
+	memory.PutKey(0x5800, 0x20)
+	memory.SetMemory(0x28, 0)
+	sysCPU.stKey = 0x30
+	sysCPU.regs[2] = 0x5000
+	sysCPU.testInst(0)
+	if !trapFlag {
+		t.Error("TB key protected did not trap")
+	}
+	if code := memory.GetMemory(0x28) & 0xffff; code != uint32(ircProt) {
+		t.Errorf("TB key protected code expected %02x got: %02x", ircProt, code)
+	}
+	check("key protected", 0x5000, 0x55555555)
+
+	// Low address protection.
+	memory.SetMemory(0x28, 0)
+	sysCPU.stKey = 0
+	sysCPU.cregs[0] |= 0x10000000
+	sysCPU.regs[2] = 0x0
+	sysCPU.testInst(0)
+	if !trapFlag {
+		t.Error("TB low address did not trap")
+	}
+	if code := memory.GetMemory(0x28) & 0xffff; code != uint32(ircProt) {
+		t.Errorf("TB low address code expected %02x got: %02x", ircProt, code)
+	}
+	if v := memory.GetMemory(0x400); v != 0xb22c0012 {
+		t.Errorf("TB low address cleared storage got: %08x", v)
+	}
+
+	// Privileged.
+	memory.SetMemory(0x28, 0)
+	sysCPU.flags = 0x1
+	sysCPU.regs[2] = 0x3000
+	sysCPU.testInst(0)
+	if code := memory.GetMemory(0x28) & 0xffff; code != uint32(ircPriv) {
+		t.Errorf("TB unprivileged code expected %02x got: %02x", ircPriv, code)
+	}
+}
+
+// Store clock and store CPU timer clear only the low 12 bits.
+func TestCycleSTCK(t *testing.T) {
+	setup()
+
+	sysCPU.todClock[0] = 0x12345678
+	sysCPU.todClock[1] = 0xfedcbabc
+	memory.SetMemory(0x400, 0xb2050500) // STCK 500
+	memory.SetMemory(0x404, 0)
+	sysCPU.testInst(0)
+	if v := memory.GetMemory(0x500); v != 0x12345678 {
+		t.Errorf("STCK high word expected %08x got: %08x", 0x12345678, v)
+	}
+	if v := memory.GetMemory(0x504); v != 0xfedcb000 {
+		t.Errorf("STCK low word expected %08x got: %08x", 0xfedcb000, v)
+	}
+
+	sysCPU.cpuTimer[0] = 0x87654321
+	sysCPU.cpuTimer[1] = 0xf0f0ffff
+	memory.SetMemory(0x400, 0xb2090500) // STCPT 500
+	sysCPU.testInst(0)
+	if v := memory.GetMemory(0x500); v != 0x87654321 {
+		t.Errorf("STCPT high word expected %08x got: %08x", 0x87654321, v)
+	}
+	if v := memory.GetMemory(0x504); v != 0xf0f0f000 {
+		t.Errorf("STCPT low word expected %08x got: %08x", 0xf0f0f000, v)
+	}
+}
+
+// Set prefix relocates low storage, store prefix and CPU address.
+func TestCycleSPX(t *testing.T) {
+	setup()
+	defer func() {
+		sysCPU.prefix = 0
+	}()
+
+	memory.SetMemory(0x500, 0xff002345) // New prefix, low bits ignored
+	memory.SetMemory(0x400, 0xb2100500) // SPX 500
+	memory.SetMemory(0x404, 0x47000000) // Absolute page zero, not reached
+	memory.SetMemory(0x408, 0x47000000)
+	memory.SetMemory(0x40c, 0)
+	memory.SetMemory(0x2404, 0xb2110508) // STPX 508
+	memory.SetMemory(0x2408, 0xb212050c) // STAP 50c
+	memory.SetMemory(0x240c, 0)
+	memory.SetMemory(0x250c, 0xffffffff)
+	sysCPU.testInst(0)
+	if trapFlag {
+		t.Fatal("SPX trapped")
+	}
+	if sysCPU.prefix != 0x2000 {
+		t.Errorf("SPX prefix expected %06x got: %06x", 0x2000, sysCPU.prefix)
+	}
+	if v := memory.GetMemory(0x2508); v != 0x2000 {
+		t.Errorf("STPX expected %08x got: %08x", 0x2000, v)
+	}
+	if v := memory.GetMemory(0x250c); v != 0x0000ffff {
+		t.Errorf("STAP expected %08x got: %08x", 0x0000ffff, v)
+	}
+
+	// Prefix outside of storage.
+	sysCPU.prefix = 0
+	memory.SetMemory(0x28, 0)
+	memory.SetMemory(0x500, 0x00fff000)
+	sysCPU.testInst(0)
+	if !trapFlag {
+		t.Error("SPX invalid address did not trap")
+	}
+	if code := memory.GetMemory(0x28) & 0xffff; code != uint32(ircAddr) {
+		t.Errorf("SPX invalid address code expected %02x got: %02x", ircAddr, code)
+	}
+	if sysCPU.prefix != 0 {
+		t.Errorf("SPX invalid address changed prefix to: %06x", sysCPU.prefix)
+	}
+
+	// Problem state.
+	memory.SetMemory(0x500, 0x00002000)
+	sysCPU.flags |= problem
+	sysCPU.testInst(0)
+	if !trapFlag {
+		t.Error("SPX problem state did not trap")
+	}
+	if code := memory.GetMemory(0x28) & 0xffff; code != uint32(ircPriv) {
+		t.Errorf("SPX problem state code expected %02x got: %02x", ircPriv, code)
+	}
+}
+
+// Two CPUs with distinct prefixes store old PSW in their own PSA.
+func TestCyclePrefixSMP(t *testing.T) {
+	setup()
+
+	cpus := []*CPU{New(0), New(1)}
+	prefix := []uint32{0x2000, 0x3000}
+	memory.SetMemory(0x28, 0xffffffff)
+	memory.SetMemory(0x2c, 0xffffffff)
+	memory.SetMemory(0xf00, prefix[0])
+	memory.SetMemory(0xf04, prefix[1])
+	memory.SetMemory(0x5000, 0xb2100f00) // SPX f00
+	memory.SetMemory(0x5004, 0x00000000) // Invalid operation
+	memory.SetMemory(0x5010, 0xb2100f04) // SPX f04
+	memory.SetMemory(0x5014, 0x00000000) // Invalid operation
+	for i, cpu := range cpus {
+		memory.SetMemory(prefix[i]+0x68, 0)
+		memory.SetMemory(prefix[i]+0x6c, 0x900+uint32(i)*0x100)
+		cpu.SetPC(0x5000 + uint32(i)*0x10)
+	}
+
+	// Both instances run side by side.
+	for range 2 {
+		for _, cpu := range cpus {
+			_, _ = cpu.Cycle()
+		}
+	}
+
+	for i, cpu := range cpus {
+		if cpu.state.prefix != prefix[i] {
+			t.Errorf("CPU %d prefix expected %08x got: %08x", i, prefix[i], cpu.state.prefix)
+		}
+		if code := memory.GetMemory(prefix[i]+0x28) & 0xffff; code != uint32(ircOper) {
+			t.Errorf("CPU %d old PSW code expected %02x got: %02x", i, ircOper, code)
+		}
+		if addr := memory.GetMemory(prefix[i]+0x2c) & AMASK; addr != 0x5006+uint32(i)*0x10 {
+			t.Errorf("CPU %d old PSW address expected %06x got: %06x", i, 0x5006+i*0x10, addr)
+		}
+		if cpu.PC() != 0x900+uint32(i)*0x100 {
+			t.Errorf("CPU %d new PSW address expected %06x got: %06x", i, 0x900+i*0x100, cpu.PC())
+		}
+	}
+	if v := memory.GetMemory(0x28); v != 0xffffffff {
+		t.Errorf("Absolute old PSW changed got: %08x", v)
+	}
+}
+
 // Protection check. unmatched key.
 func TestCycleProt(t *testing.T) {
 	setup()
@@ -4434,6 +5712,60 @@ func TestCycleProt(t *testing.T) {
 	memory.PutKey(0x5600, 0)
 }
 
+// Instruction fetch from fetch protected page with wrong key.
+func TestCycleProtFetch(t *testing.T) {
+	tests := []struct {
+		name   string
+		start  uint32 // Branch target
+		inst1  uint32 // Word at 0x4ffc
+		inst2  uint32 // Word at 0x5000
+		stKey  uint8
+		trap   bool
+		oldPSW uint32
+	}{
+		{"wrong key", 0x5000, 0, 0x1a120000, 0x20, true, 0x5000},                  // AR 1,2
+		{"matching key", 0x5000, 0, 0x1a120000, 0x40, false, 0},                   // AR 1,2
+		{"key zero", 0x5000, 0, 0x1a120000, 0x00, false, 0},                       // AR 1,2
+		{"crosses into page", 0x4ffe, 0x00005a10, 0x20000000, 0x20, true, 0x4ffe}, // A 1,0(2)
+	}
+	for _, test := range tests {
+		setup()
+		sysCPU.flags = 0x1 // unprivileged
+		sysCPU.stKey = test.stKey
+		sysCPU.regs[1] = 0x1
+		sysCPU.regs[2] = 0x2
+		sysCPU.regs[3] = test.start
+		memory.PutKey(0x4800, test.stKey)
+		memory.PutKey(0x5000, 0x48)
+		memory.SetMemory(0x400, 0x07f30000) // BR 3
+		memory.SetMemory(0x4ffc, test.inst1)
+		memory.SetMemory(0x5000, test.inst2)
+		memory.SetMemory(0x5004, 0)
+		sysCPU.testInst(0)
+		memory.PutKey(0x4800, 0)
+		memory.PutKey(0x5000, 0)
+		if trapFlag != test.trap {
+			t.Errorf("%s trap got: %v wanted: %v", test.name, trapFlag, test.trap)
+			continue
+		}
+		if !test.trap {
+			if sysCPU.regs[1] != 0x3 {
+				t.Errorf("%s register 1 got: %08x wanted: %08x", test.name, sysCPU.regs[1], 0x3)
+			}
+			continue
+		}
+		if code := memory.GetMemory(0x28) & 0xffff; code != uint32(ircProt) {
+			t.Errorf("%s code got: %02x wanted: %02x", test.name, code, ircProt)
+		}
+		if sysCPU.regs[1] != 0x1 {
+			t.Errorf("%s executed instruction register 1 got: %08x", test.name, sysCPU.regs[1])
+		}
+		if addr := memory.GetMemory(0x2c) & AMASK; addr != test.oldPSW {
+			t.Errorf("%s old PSW address got: %06x wanted: %06x", test.name, addr, test.oldPSW)
+		}
+	}
+}
+
 // Test and set.
 func TestCycleTS(t *testing.T) {
 	setup()
@@ -4466,12 +5798,69 @@ func TestCycleTS(t *testing.T) {
 	}
 }
 
-// Edit test.
-func TestCycleED(t *testing.T) {
+// Two processors contend for a lock byte with TS, release it with CS.
+func TestTSInterlock(t *testing.T) {
 	setup()
+	memory.SetMemory(0x1000, 0)
+	const loops = 10000
+
+	var inside, entries atomic.Int32
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			cpu := &cpuState{}
+			ts := &stepInfo{opcode: op.OpTS, address1: 0x1000}
+			cs := &stepInfo{opcode: op.OpCS, R1: 1, R2: 2, address1: 0x1000}
+			for range loops {
+				// Spin until lock byte was zero.
+				for {
+					if err := cpu.opTS(ts); err != 0 {
+						t.Errorf("TS error: %x", err)
+						return
+					}
+					if cpu.cc == 0 {
+						break
+					}
+					runtime.Gosched()
+				}
+				if inside.Add(1) != 1 {
+					t.Error("TS two processors in critical section")
+				}
+				entries.Add(1)
+				runtime.Gosched()
+				inside.Add(-1)
+
+				// Release lock.
+				cpu.regs[1] = 0xff000000
+				cpu.regs[2] = 0
+				if err := cpu.opCS(cs); err != 0 || cpu.cc != 0 {
+					t.Errorf("CS release failed err: %x cc: %d", err, cpu.cc)
+					return
+				}
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
 
-	sysCPU.regs[12] = 0x1000
-	memory.SetMemory(0x1200, 0x0257426c)
+	if v := entries.Load(); v != 2*loops {
+		t.Errorf("TS entries not correct got: %d wanted: %d", v, 2*loops)
+	}
+	if v := memory.GetMemory(0x1000); v != 0 {
+		t.Errorf("TS lock not released got: %08x", v)
+	}
+}
+
+// Edit test.
+func TestCycleED(t *testing.T) {
+	setup()
+
+	sysCPU.regs[12] = 0x1000
+	memory.SetMemory(0x1200, 0x0257426c)
 	memory.SetMemory(0x1000, 0x4020206b)
 	memory.SetMemory(0x1004, 0x2020214b)
 	memory.SetMemory(0x1008, 0x202040c3)
@@ -5337,6 +6726,46 @@ func TestCycleDP(t *testing.T) {
 	}
 }
 
+// Test DP reports decimal divide and data exceptions.
+func TestCycleDPExceptions(t *testing.T) {
+	cases := []struct {
+		name     string
+		dividend uint32
+		divisor  uint32
+		l2       uint32
+		code     uint16
+	}{
+		{"zero divisor", 0x0000123c, 0x0c000000, 0, ircDecDiv},
+		{"quotient too large", 0x1234567c, 0x1c000000, 0, ircDecDiv},
+		{"invalid sign", 0x00001234, 0x2c000000, 0, ircData},
+		{"invalid divisor sign", 0x0000123c, 0x00000000, 1, ircData},
+	}
+	for _, c := range cases {
+		setup()
+		sysCPU.regs[12] = 0x1000
+		sysCPU.regs[13] = 0x2000
+		memory.SetMemory(0x28, 0)
+		memory.SetMemory(0x2c, 0)
+		memory.SetMemory(0x1000, c.dividend)
+		memory.SetMemory(0x2000, c.divisor)
+		memory.SetMemory(0x400, 0xfd30c000|(c.l2<<16))
+		memory.SetMemory(0x404, 0xd0000000) // DP 0(4, 12), 0(l2, 13)
+		memory.SetMemory(0x408, 0)
+		sysCPU.testInst(0)
+		if !trapFlag {
+			t.Errorf("DP %s did not trap", c.name)
+			continue
+		}
+		code := memory.GetMemory(0x28) & 0xffff
+		if code != uint32(c.code) {
+			t.Errorf("DP %s interrupt got: %02x wanted: %02x", c.name, code, c.code)
+		}
+		if v := memory.GetMemory(0x1000); v != c.dividend {
+			t.Errorf("DP %s dividend changed got: %08x wanted: %08x", c.name, v, c.dividend)
+		}
+	}
+}
+
 // Do a bunch of canned tests with Packed Decimal instructions.
 var hexDigits = "0123456789abcdef"
 
@@ -5383,7 +6812,7 @@ var cases = []decCase{
 	{op.OpMP, "012345", "654321", "012345", 0, 7},
 	{op.OpMP, "5c", "5c", "5c", 0, 6},
 	{op.OpMP, "005c", "5c", "025c", 0, 0},
-	{op.OpMP, "005c", "005c", "025c", 0, 6},
+	{op.OpMP, "005c", "005c", "005c", 0, 6},
 	{op.OpMP, "005c", "012c", "005c", 0, 6},
 	{op.OpMP, "006c", "013c", "006c", 0, 6},
 	{op.OpMP, "00004c", "017c", "00068c", 0, 0},
@@ -5392,7 +6821,7 @@ var cases = []decCase{
 	{op.OpMP, "00004c", "023c", "00092c", 0, 0},
 	{op.OpMP, "007c", "9c", "063c", 0, 0},
 	{op.OpMP, "009d", "8c", "072d", 0, 0},
-	{op.OpMP, "018c", "2c", "036c", 0, 7},
+	{op.OpMP, "018c", "2c", "018c", 0, 7},
 	{op.OpMP, "008d", "3d", "024c", 0, 0},
 	{op.OpMP, "001d", "0c", "000d", 0, 0},
 	{op.OpMP, "000c", "052d", "000c", 0, 6},
@@ -5548,17 +6977,43 @@ var cases = []decCase{
 	{op.OpCP, "027c", "000000235d", "027c", 2, 0},
 	{op.OpCP, "5c", "000000235d", "5c", 2, 0},
 	{op.OpCP, "12345c", "54321c", "12345c", 1, 0},
-	{op.OpED, "20204021", "a0", "fa204021", 0, 7},
+	{op.OpED, "20204021", "a0", "20204021", 0, 7},
 	{op.OpED, "ee2020202120", "00023c", "eeeeeeeef2f3", 2, 0},
 	{op.OpED, "ee2020202120", "0c1c012c", "eeeef1eef1f2", 2, 0},
 	{op.OpED, "ee2020202120", "0d1d012d", "eeeef1f0f1f2", 1, 0},
 	{op.OpED, "ee202022202120", "0c1c012e", "eeeef1eeeef1f2", 2, 0},
-	{op.OpED, "ee202020", "00b0", "eeeeee20", 0, 7},
-	{op.OpED, "ee202020", "00c0", "eeeeee20", 0, 7},
+	{op.OpED, "ee202020", "00b0", "ee202020", 0, 7},
+	{op.OpED, "ee202020", "00c0", "ee202020", 0, 7},
 	{op.OpED, "ee212020", "000f", "eeeef0f0", 0, 0},
 	{op.OpED, "ee2020202020202020202020202020", "013b026c00129c789a", "eeeef1f3f0f2f6eeeef1f2f9f7f8f9", 2, 0},
 	{op.OpED, "402020402120", "X1", "40f4f040f2f0", 1, 0},
 	{op.OpAP, "3c", "5c", "8c", 2, 0},
+	{op.OpAP, "012c", "0a5c", "012c", 0, 7},
+	{op.OpAP, "0a2c", "015c", "0a2c", 0, 7},
+	{op.OpAP, "012c", "0159", "012c", 0, 7},
+	{op.OpAP, "0125", "015c", "0125", 0, 7},
+	{op.OpSP, "012c", "0b5c", "012c", 0, 7},
+	{op.OpZAP, "012c", "0f5c", "012c", 0, 7},
+	{op.OpZAP, "012c", "0153", "012c", 0, 7},
+	{op.OpCP, "012c", "0a5c", "012c", 0, 7},
+	{op.OpCP, "0a2c", "015c", "0a2c", 0, 7},
+	{op.OpCP, "012c", "0158", "012c", 0, 7},
+	{op.OpCP, "0127", "015c", "0127", 0, 7},
+	{op.OpED, "40202120", "0a5c", "4040f540", 2, 0},
+	{op.OpED, "40202020202120", "01a25c", "40202020202120", 0, 7},
+	{op.OpED, "4020202020202120", "0125b6", "4020202020202120", 0, 7},
+	// SRP second operand is shift count then rounding digit.
+	{op.OpSRP, "00123c", "0200", "12300c", 2, 0},
+	{op.OpSRP, "12345c", "0200", "34500c", 3, 10},
+	{op.OpSRP, "12345c", "3f05", "01235c", 2, 0},
+	{op.OpSRP, "12344c", "3f05", "01234c", 2, 0},
+	{op.OpSRP, "12345d", "3e05", "00123d", 1, 0},
+	{op.OpSRP, "12355d", "3e05", "00124d", 1, 0},
+	{op.OpSRP, "00004c", "3f05", "00000c", 0, 0},
+	{op.OpSRP, "00004d", "3f05", "00000c", 0, 0},
+	{op.OpSRP, "12345c", "0000", "12345c", 2, 0},
+	{op.OpSRP, "91234c", "3805", "00000c", 0, 0},
+	{op.OpSRP, "12345c", "3f0a", "12345c", 0, 7},
 }
 
 // Run group of decimal test cases.
@@ -5590,14 +7045,22 @@ func TestCycleDecimalTest(t *testing.T) {
 			l2 = len(arg)
 		}
 		inst := (uint32(test.op) << 24) | 0xa000
-		if test.op == op.OpED {
+		inst2 := uint32(0xc0000000)
+		switch test.op {
+		case op.OpED:
 			inst |= uint32(l1-1) << 16
-		} else {
+		case op.OpSRP:
+			// Shift count in D2 with no base, rounding digit in I3.
+			arg, _ := hex.DecodeString(test.i2)
+			inst |= uint32(l1-1) << 20
+			inst |= uint32(arg[1]&0xf) << 16
+			inst2 = uint32(arg[0]&0x3f) << 16
+		default:
 			inst |= uint32(l1-1) << 20
 			inst |= uint32(l2-1) << 16
 		}
 		memory.SetMemory(0x400, inst)
-		memory.SetMemory(0x404, 0xc0000000)
+		memory.SetMemory(0x404, inst2)
 		memory.SetMemory(0x800, 0)
 		memory.SetMemory(0x28, 0)
 		memory.SetMemory(0x2c, 0)
@@ -5624,6 +7087,9 @@ func TestCycleDecimalTest(t *testing.T) {
 			if v != uint32(test.ex) {
 				t.Errorf("Test %d did not trap correctly got: %04x expected: %04x", i, v, test.ex)
 			}
+			if uint16(test.ex) != ircDecOver && result != test.out {
+				t.Errorf("Test %d changed result got: %s expected: %s", i, result, test.out)
+			}
 		} else {
 			if result != test.out {
 				t.Errorf("Test %d did get correct result got: %s expected: %s", i, result, test.out)
@@ -5979,6 +7445,256 @@ func TestCycleLNDR(t *testing.T) {
 	}
 }
 
+// Load long and load and test long registers.
+func TestCycleLDR(t *testing.T) {
+	setup()
+
+	setFloatLong(4, 0x92345678aabbccdd)
+	memory.SetMemory(0x400, 0x28240000) // LDR 2,4
+	sysCPU.testInst(0)
+	if v := getFloatLong(2); v != 0x92345678aabbccdd {
+		t.Errorf("LDR Register 2 not correct got: %016x wanted: %016x", v, uint64(0x92345678aabbccdd))
+	}
+	if sysCPU.cc != 3 {
+		t.Errorf("LDR changed CC got: %d wanted: %d", sysCPU.cc, 3)
+	}
+
+	tests := []struct {
+		value uint64
+		cc    uint8
+	}{
+		{0x4110000000000000, 2},
+		{0xc110000000000000, 1},
+		{0x4100000000000000, 0},
+		{0x8000000000000000, 0},
+	}
+	memory.SetMemory(0x400, 0x22240000) // LTDR 2,4
+	for _, test := range tests {
+		setFloatLong(4, test.value)
+		sysCPU.testInst(0)
+		if v := getFloatLong(2); v != test.value {
+			t.Errorf("LTDR Register 2 not correct got: %016x wanted: %016x", v, test.value)
+		}
+		if sysCPU.cc != test.cc {
+			t.Errorf("LTDR %016x CC not set correctly got: %d wanted: %d", test.value, sysCPU.cc, test.cc)
+		}
+	}
+}
+
+// Load positive, negative and test short registers.
+func TestCycleLPERLNERLTER(t *testing.T) {
+	setup()
+
+	tests := []struct {
+		name  string
+		inst  uint32
+		value uint32
+		want  uint32
+		cc    uint8
+	}{
+		{"LPER", 0x30240000, 0xc1100000, 0x41100000, 2},
+		{"LPER", 0x30240000, 0x41100000, 0x41100000, 2},
+		{"LPER", 0x30240000, 0x80000000, 0x00000000, 0},
+		{"LNER", 0x31240000, 0x41100000, 0xc1100000, 1},
+		{"LNER", 0x31240000, 0xc1100000, 0xc1100000, 1},
+		{"LNER", 0x31240000, 0x00000000, 0x80000000, 0},
+		{"LTER", 0x32240000, 0xc1100000, 0xc1100000, 1},
+		{"LTER", 0x32240000, 0x41100000, 0x41100000, 2},
+		{"LTER", 0x32240000, 0x41000000, 0x41000000, 0},
+	}
+	for _, test := range tests {
+		setFloatShort(4, test.value)
+		setFloatShort(5, 0x11223344)
+		setFloatShort(3, 0xaabbccdd)
+		memory.SetMemory(0x400, test.inst)
+		sysCPU.testInst(0)
+		if v := getFloatShort(2); v != test.want {
+			t.Errorf("%s %08x Register 2 not correct got: %08x wanted: %08x", test.name, test.value, v, test.want)
+		}
+		if v := getFloatShort(3); v != 0xaabbccdd {
+			t.Errorf("%s modified lower register got: %08x", test.name, v)
+		}
+		if sysCPU.cc != test.cc {
+			t.Errorf("%s %08x CC not set correctly got: %d wanted: %d", test.name, test.value, sysCPU.cc, test.cc)
+		}
+	}
+}
+
+// Round long to short and extended to long.
+func TestCycleLRERLRDR(t *testing.T) {
+	setup()
+
+	tests := []struct {
+		value uint64
+		want  uint32
+	}{
+		{0x412345677fffffff, 0x41234567},
+		{0x4123456780000000, 0x41234568},
+		{0xc1ffffff80000000, 0xc2100000},
+	}
+	memory.SetMemory(0x400, 0x35240000) // LRER 2,4
+	for _, test := range tests {
+		setFloatLong(4, test.value)
+		setFloatShort(3, 0xaabbccdd)
+		sysCPU.testInst(0)
+		if v := getFloatShort(2); v != test.want {
+			t.Errorf("LRER %016x not correct got: %08x wanted: %08x", test.value, v, test.want)
+		}
+		if v := getFloatShort(3); v != 0xaabbccdd {
+			t.Errorf("LRER modified lower register got: %08x", v)
+		}
+	}
+
+	ext := []struct {
+		high uint64
+		low  uint64
+		want uint64
+	}{
+		{0x4112345678abcdef, 0x337fffffffffffff, 0x4112345678abcdef},
+		{0x4112345678abcdef, 0x3380000000000000, 0x4112345678abcdf0},
+		{0xc1ffffffffffffff, 0xb3ff000000000000, 0xc210000000000000},
+	}
+	memory.SetMemory(0x400, 0x25040000) // LRDR 0,4
+	for _, test := range ext {
+		setFloatLong(4, test.high)
+		setFloatLong(6, test.low)
+		sysCPU.testInst(0)
+		if v := getFloatLong(0); v != test.want {
+			t.Errorf("LRDR %016x %016x not correct got: %016x wanted: %016x", test.high, test.low, v, test.want)
+		}
+	}
+
+	// Source must be 0 or 4.
+	memory.SetMemory(0x400, 0x25020000) // LRDR 0,2
+	sysCPU.testInst(0)
+	if !trapFlag {
+		t.Error("LRDR odd register did not trap")
+	}
+}
+
+// Extended floating point register add and subtract.
+func TestCycleAXRSXR(t *testing.T) {
+	setup()
+
+	tests := []struct {
+		name   string
+		inst   uint32
+		value1 [2]uint64
+		value2 [2]uint64
+		want   [2]uint64
+		cc     uint8
+	}{
+		{"AXR", 0x36040000, [2]uint64{0x4110000000000000, 0x3300000000000000},
+			[2]uint64{0x4110000000000000, 0x3300000000000000},
+			[2]uint64{0x4120000000000000, 0x3300000000000000}, 2},
+		// Carry out of high digit.
+		{"AXR", 0x36040000, [2]uint64{0x41ffffffffffffff, 0x33ffffffffffffff},
+			[2]uint64{0x4100000000000000, 0x3300000000000001},
+			[2]uint64{0x4210000000000000, 0x3400000000000000}, 2},
+		// Align smaller operand.
+		{"AXR", 0x36040000, [2]uint64{0x4210000000000000, 0x3400000000000000},
+			[2]uint64{0x4110000000000000, 0x3300000000000001},
+			[2]uint64{0x4211000000000000, 0x3400000000000000}, 2},
+		{"AXR", 0x36040000, [2]uint64{0xc120000000000000, 0xb300000000000000},
+			[2]uint64{0x4110000000000000, 0x3300000000000000},
+			[2]uint64{0xc110000000000000, 0xb300000000000000}, 1},
+		{"SXR", 0x37040000, [2]uint64{0x4130000000000000, 0x3300000000000000},
+			[2]uint64{0x4110000000000000, 0x3300000000000000},
+			[2]uint64{0x4120000000000000, 0x3300000000000000}, 2},
+		{"SXR", 0x37040000, [2]uint64{0x4110000000000000, 0x3300000000000000},
+			[2]uint64{0x4130000000000000, 0x3300000000000000},
+			[2]uint64{0xc120000000000000, 0xb300000000000000}, 1},
+		// Cancellation normalizes low digit into high register.
+		{"SXR", 0x37040000, [2]uint64{0x4110000000000000, 0x3300000000000001},
+			[2]uint64{0x4110000000000000, 0x3300000000000000},
+			[2]uint64{0x2610000000000000, 0x1800000000000000}, 2},
+		{"SXR", 0x37040000, [2]uint64{0x4110000000000000, 0x3300000000000001},
+			[2]uint64{0x4110000000000000, 0x3300000000000001},
+			[2]uint64{0, 0}, 0},
+	}
+	for _, test := range tests {
+		setFloatLong(0, test.value1[0])
+		setFloatLong(2, test.value1[1])
+		setFloatLong(4, test.value2[0])
+		setFloatLong(6, test.value2[1])
+		memory.SetMemory(0x400, test.inst) // xXR 0,4
+		sysCPU.testInst(0)
+		if trapFlag {
+			t.Errorf("%s trapped", test.name)
+		}
+		if v := [2]uint64{getFloatLong(0), getFloatLong(2)}; v != test.want {
+			t.Errorf("%s %016x %016x result got: %016x wanted: %016x", test.name, test.value1, test.value2, v, test.want)
+		}
+		if sysCPU.cc != test.cc {
+			t.Errorf("%s CC not set correctly got: %d wanted: %d", test.name, sysCPU.cc, test.cc)
+		}
+	}
+
+	// Zero result with significance mask set traps.
+	setFloatLong(0, 0x4110000000000000)
+	setFloatLong(2, 0x3300000000000000)
+	setFloatLong(4, 0x4110000000000000)
+	setFloatLong(6, 0x3300000000000000)
+	memory.SetMemory(0x400, 0x37040000) // SXR 0,4
+	sysCPU.testInst(SIGMASK)
+	if !trapFlag {
+		t.Error("SXR significance did not trap")
+	}
+	if code := memory.GetMemory(0x28) & 0xffff; code != uint32(ircSignif) {
+		t.Errorf("SXR significance code expected %02x got: %02x", ircSignif, code)
+	}
+}
+
+// Extended floating point multiply.
+func TestCycleMXRMXDR(t *testing.T) {
+	setup()
+
+	tests := []struct {
+		name   string
+		inst   uint32
+		value1 [2]uint64
+		value2 [2]uint64
+		want   [2]uint64
+	}{
+		{"MXR", 0x26040000, [2]uint64{0x4120000000000000, 0x3300000000000000},
+			[2]uint64{0x4130000000000000, 0x3300000000000000},
+			[2]uint64{0x4160000000000000, 0x3300000000000000}},
+		{"MXR", 0x26040000, [2]uint64{0x4112345678abcdef, 0x3312345678abcdef},
+			[2]uint64{0xc110000000000000, 0xb300000000000000},
+			[2]uint64{0xc112345678abcdef, 0xb312345678abcdef}},
+		// Product needs normalization.
+		{"MXR", 0x26040000, [2]uint64{0x4140000000000000, 0x3300000000000000},
+			[2]uint64{0x4140000000000000, 0x3300000000000000},
+			[2]uint64{0x4210000000000000, 0x3400000000000000}},
+		{"MXR", 0x26040000, [2]uint64{0x4120000000000000, 0x3300000000000000},
+			[2]uint64{0, 0}, [2]uint64{0, 0}},
+		{"MXDR", 0x27040000, [2]uint64{0x4120000000000000, 0x3300000000000000},
+			[2]uint64{0x4130000000000000, 0},
+			[2]uint64{0x4160000000000000, 0x3300000000000000}},
+		// Full 112 bit product of long operands.
+		{"MXDR", 0x27040000, [2]uint64{0x41ffffffffffffff, 0},
+			[2]uint64{0xc1ffffffffffffff, 0},
+			[2]uint64{0xc2fffffffffffffe, 0xb400000000000001}},
+	}
+	for _, test := range tests {
+		setFloatLong(0, test.value1[0])
+		setFloatLong(2, test.value1[1])
+		setFloatLong(4, test.value2[0])
+		setFloatLong(6, test.value2[1])
+		memory.SetMemory(0x400, test.inst) // xXR 0,4
+		sysCPU.testInst(0)
+		if trapFlag {
+			t.Errorf("%s trapped", test.name)
+		}
+		if v := [2]uint64{getFloatLong(0), getFloatLong(2)}; v != test.want {
+			t.Errorf("%s %016x %016x result got: %016x wanted: %016x", test.name, test.value1, test.value2, v, test.want)
+		}
+		if sysCPU.cc != 3 {
+			t.Errorf("%s changed CC got: %d wanted: %d", test.name, sysCPU.cc, 3)
+		}
+	}
+}
+
 // Test compare double.
 func TestCycleCD(t *testing.T) {
 	setup()
@@ -6294,6 +8010,165 @@ func TestCyclDD(t *testing.T) {
 	}
 }
 
+// Exponent overflow and underflow at the boundaries for AD and MD.
+func TestCycleFPExponent(t *testing.T) {
+	tests := []struct {
+		name   string
+		inst   uint32
+		op1    uint64
+		op2    uint64
+		mask   uint8
+		result uint64
+		code   uint32 // Zero if no interrupt
+		cc     uint8
+	}{
+		{"AD max", 0x6a00d000, 0x7f10000000000000, 0x7f20000000000000, 0, 0x7f30000000000000, 0, 2},
+		{"AD overflow", 0x6a00d000, 0x7f10000000000000, 0x7ff0000000000000, 0, 0x0010000000000000, uint32(ircExpOver), 2},
+		{"AD min", 0x6a00d000, 0x0110000000000000, 0x8101000000000000, 0, 0x00f0000000000000, 0, 2},
+		{"AD underflow", 0x6a00d000, 0x0010000000000001, 0x8010000000000000, EXPUNDER, 0x7310000000000000, uint32(ircExpUnder), 2},
+		{"AD underflow masked", 0x6a00d000, 0x0010000000000001, 0x8010000000000000, 0, 0, 0, 0},
+		{"MD max", 0x6c00d000, 0x6080000000000000, 0x6010000000000000, 0, 0x7f80000000000000, 0, 3},
+		{"MD overflow", 0x6c00d000, 0x6080000000000000, 0x6020000000000000, 0, 0x0010000000000000, uint32(ircExpOver), 3},
+		{"MD min", 0x6c00d000, 0x2080000000000000, 0x2020000000000000, 0, 0x0010000000000000, 0, 3},
+		{"MD underflow", 0x6c00d000, 0xa010000000000000, 0x2010000000000000, EXPUNDER, 0xff10000000000000, uint32(ircExpUnder), 3},
+		{"MD underflow masked", 0x6c00d000, 0xa010000000000000, 0x2010000000000000, 0, 0, 0, 3},
+	}
+
+	for _, test := range tests {
+		setup()
+		memory.SetMemory(0x28, 0)
+		memory.SetMemory(0x400, test.inst)
+		memory.SetMemory(0x404, 0)
+		memory.SetMemory(0x2000, uint32(test.op2>>32))
+		memory.SetMemory(0x2004, uint32(test.op2))
+		sysCPU.regs[13] = 0x2000
+		setFloatLong(0, test.op1)
+		sysCPU.testInst(test.mask)
+
+		if v := getFloatLong(0); v != test.result {
+			t.Errorf("%s result not correct got: %016x wanted: %016x", test.name, v, test.result)
+		}
+		cc := sysCPU.cc
+		if test.code == 0 {
+			if trapFlag {
+				t.Errorf("%s should not have trapped", test.name)
+			}
+		} else {
+			// Condition code from old program PSW
+			cc = uint8(memory.GetMemory(0x2c)>>28) & 3
+			if !trapFlag {
+				t.Errorf("%s did not trap", test.name)
+			}
+			if v := memory.GetMemory(0x28) & 0xffff; v != test.code {
+				t.Errorf("%s interrupt code not correct got: %02x wanted: %02x", test.name, v, test.code)
+			}
+		}
+		if cc != test.cc {
+			t.Errorf("%s CC not correct got: %d wanted: %d", test.name, cc, test.cc)
+		}
+	}
+}
+
+// Significance exception when add or subtract gives a zero fraction.
+func TestCycleFPSignificance(t *testing.T) {
+	tests := []struct {
+		name   string
+		inst   uint32
+		op1    uint64
+		op2    uint64
+		mask   uint8
+		result uint64
+		trap   bool
+	}{
+		{"SD", 0x6b00d000, 0x4312345678abcdef, 0x4312345678abcdef, SIGMASK, 0x4300000000000000, true},
+		{"SD masked", 0x6b00d000, 0x4312345678abcdef, 0x4312345678abcdef, 0, 0, false},
+		{"AD", 0x6a00d000, 0x4312345678abcdef, 0xc312345678abcdef, SIGMASK, 0x4300000000000000, true},
+		{"AD masked", 0x6a00d000, 0x4312345678abcdef, 0xc312345678abcdef, 0, 0, false},
+		{"SE", 0x7b00d000, 0x4212345600000000, 0x4212345600000000, SIGMASK, 0x4200000000000000, true},
+		{"SE masked", 0x7b00d000, 0x4212345600000000, 0x4212345600000000, 0, 0, false},
+		{"AE", 0x7a00d000, 0xc212345600000000, 0x4212345600000000, SIGMASK, 0x4200000000000000, true},
+		{"AE masked", 0x7a00d000, 0xc212345600000000, 0x4212345600000000, 0, 0, false},
+	}
+
+	for _, test := range tests {
+		setup()
+		memory.SetMemory(0x28, 0)
+		memory.SetMemory(0x400, test.inst)
+		memory.SetMemory(0x404, 0)
+		memory.SetMemory(0x2000, uint32(test.op2>>32))
+		memory.SetMemory(0x2004, uint32(test.op2))
+		sysCPU.regs[13] = 0x2000
+		setFloatLong(0, test.op1)
+		sysCPU.testInst(test.mask)
+
+		if v := getFloatLong(0); v != test.result {
+			t.Errorf("%s result not correct got: %016x wanted: %016x", test.name, v, test.result)
+		}
+		cc := sysCPU.cc
+		if test.trap {
+			if !trapFlag {
+				t.Errorf("%s did not trap", test.name)
+			}
+			if v := memory.GetMemory(0x28) & 0xffff; v != uint32(ircSignif) {
+				t.Errorf("%s interrupt code not correct got: %02x wanted: %02x", test.name, v, ircSignif)
+			}
+			// Condition code from old program PSW
+			cc = uint8(memory.GetMemory(0x2c)>>28) & 3
+		} else if trapFlag {
+			t.Errorf("%s should not have trapped", test.name)
+		}
+		if cc != 0 {
+			t.Errorf("%s CC not correct got: %d wanted: %d", test.name, cc, 0)
+		}
+	}
+}
+
+// Exact results of add and subtract with a single guard digit.
+func TestCycleFPGuardDigit(t *testing.T) {
+	tests := []struct {
+		name   string
+		inst   uint32
+		op1    uint64
+		op2    uint64
+		result uint64
+		cc     uint8
+	}{
+		// Guard digit shifted into result by normalization.
+		{"SE", 0x7b00d000, 0x4110000000000000, 0x4010000100000000, 0x40efffff00000000, 2},
+		{"SD", 0x6b00d000, 0x4110000000000000, 0x4010000000000001, 0x40efffffffffffff, 2},
+		// Result is only the guard digit.
+		{"SE guard", 0x7b00d000, 0x4110000000000000, 0x40ffffff00000000, 0x3b10000000000000, 2},
+		{"SD guard", 0x6b00d000, 0x4110000000000000, 0x40ffffffffffffff, 0x3310000000000000, 2},
+		// Digits beyond the guard digit are lost before subtracting.
+		{"SE truncate", 0x7b00d000, 0x4308210000000000, 0x4112345600000000, 0x4280ecbb00000000, 2},
+		{"SD truncate", 0x6b00d000, 0x4212345678abcdef, 0x3f987654321fedcb, 0x42122acf1368abf0, 2},
+		// Carry out of the fraction drops the guard digit.
+		{"AE carry", 0x7a00d000, 0x4110000000000000, 0x40ffffff00000000, 0x411fffff00000000, 2},
+		{"AD carry", 0x6a00d000, 0x4110000000000000, 0x40ffffffffffffff, 0x411fffffffffffff, 2},
+		{"AE", 0x7a00d000, 0x4112345600000000, 0x40ffffff00000000, 0x4122345500000000, 2},
+		{"AD", 0x6a00d000, 0x4212345678abcdef, 0x3f987654321fedcb, 0x42123dddddeeefed, 2},
+		{"AD negative", 0x6a00d000, 0xc110000000000000, 0x4010000000000001, 0xc0efffffffffffff, 1},
+	}
+
+	for _, test := range tests {
+		setup()
+		memory.SetMemory(0x400, test.inst)
+		memory.SetMemory(0x404, 0)
+		memory.SetMemory(0x2000, uint32(test.op2>>32))
+		memory.SetMemory(0x2004, uint32(test.op2))
+		sysCPU.regs[13] = 0x2000
+		setFloatLong(0, test.op1)
+		sysCPU.testInst(0)
+
+		if v := getFloatLong(0); v != test.result {
+			t.Errorf("%s result not correct got: %016x wanted: %016x", test.name, v, test.result)
+		}
+		if sysCPU.cc != test.cc {
+			t.Errorf("%s CC not correct got: %d wanted: %d", test.name, sysCPU.cc, test.cc)
+		}
+	}
+}
+
 // Add double unnormalized.
 func TestCycleAW(t *testing.T) {
 	setup()
@@ -6465,6 +8340,57 @@ func TestCycleCE(t *testing.T) {
 	}
 }
 
+// Compare normalized and unnormalized forms of values.
+func TestCycleCompareUnnorm(t *testing.T) {
+	setup()
+
+	short := []struct {
+		f1, f2 uint32
+		cc     uint8
+	}{
+		{0x41100000, 0x42010000, 0}, // 1.0 = 1.0
+		{0x42010000, 0x41100000, 0},
+		{0xc1100000, 0xc2010000, 0}, // -1.0 = -1.0
+		{0x41100000, 0x42020000, 1}, // 1.0 < 2.0
+		{0x42020000, 0x41100000, 2},
+		{0xc1100000, 0x42010000, 1}, // -1.0 < 1.0
+		{0x42010000, 0xc1100000, 2},
+		{0x41100000, 0x43000100, 2}, // 1.0 > 1/16
+		{0x40000000, 0x00000000, 0}, // Unnormalized zeros
+		{0x80000000, 0x45000000, 0},
+	}
+	for _, test := range short {
+		setFloatShort(0, test.f1)
+		setFloatShort(2, test.f2)
+		memory.SetMemory(0x400, 0x39020000) // CER 0,2
+		sysCPU.testInst(0)
+		if sysCPU.cc != test.cc {
+			t.Errorf("CER %08x %08x CC got: %d wanted: %d", test.f1, test.f2, sysCPU.cc, test.cc)
+		}
+	}
+
+	long := []struct {
+		f1, f2 uint64
+		cc     uint8
+	}{
+		{0x4110000000000000, 0x4201000000000000, 0},
+		{0x4201000000000000, 0x4110000000000000, 0},
+		{0x4110000000000001, 0x4201000000000000, 2}, // Guard digit kept
+		{0x4201000000000000, 0x4110000000000001, 1},
+		{0xc110000000000000, 0xc201000000000000, 0},
+		{0xc110000000000001, 0xc201000000000000, 1},
+	}
+	for _, test := range long {
+		setFloatLong(0, test.f1)
+		setFloatLong(2, test.f2)
+		memory.SetMemory(0x400, 0x29020000) // CDR 0,2
+		sysCPU.testInst(0)
+		if sysCPU.cc != test.cc {
+			t.Errorf("CDR %016x %016x CC got: %d wanted: %d", test.f1, test.f2, sysCPU.cc, test.cc)
+		}
+	}
+}
+
 // Half instruct rand.
 func TestCycleHE(t *testing.T) {
 	setup()
@@ -6527,13 +8453,14 @@ func TestCycleAE(t *testing.T) {
 		scale = rnum.Intn(100) - 50
 		f2 = math.Ldexp(f2, scale)
 		low := rnum.Uint32()
-		if floatToFpreg(4, f1) {
+		if !floatToFpreg(4, f1) {
 			continue
 		}
-		if floatToFpreg(2, f2) {
+		if !floatToFpreg(2, f2) {
 			continue
 		}
-		mb := f1 + f2
+		// Only short fraction takes part in add.
+		mb := cnvtShortFloat(4) + cnvtShortFloat(2)
 		setFloatShort(1, low)
 		setFloatShort(3, ^low)
 		memory.SetMemory(0x400, 0x3a420000) // AER 4,2
@@ -6594,13 +8521,14 @@ func TestCycleSE(t *testing.T) {
 		scale = rnum.Intn(100) - 50
 		f2 = math.Ldexp(f2, scale)
 		low := rnum.Uint32()
-		if floatToFpreg(0, f1) {
+		if !floatToFpreg(0, f1) {
 			continue
 		}
-		if floatToFpreg(2, f2) {
+		if !floatToFpreg(2, f2) {
 			continue
 		}
-		mb := f1 - f2
+		// Only short fraction takes part in subtract.
+		mb := cnvtShortFloat(0) - cnvtShortFloat(2)
 		setFloatShort(1, low)
 		setFloatShort(3, ^low)
 		memory.SetMemory(0x400, 0x3b020000) // SER 0,2
@@ -6657,15 +8585,16 @@ func TestCyclME(t *testing.T) {
 		scale = rnum.Intn(100) - 50
 		f2 = math.Ldexp(f2, scale)
 		low := rnum.Uint32()
-		if floatToFpreg(0, f1) {
+		if !floatToFpreg(0, f1) {
 			continue
 		}
-		if floatToFpreg(2, f2) {
+		if !floatToFpreg(2, f2) {
 			continue
 		}
 		setFloatShort(1, low)
 		setFloatShort(3, ^low)
-		mb := f1 * f2
+		// Only short fraction takes part in multiply.
+		mb := cnvtShortFloat(0) * cnvtShortFloat(2)
 		memory.SetMemory(0x400, 0x3c020000) // MER 0,2
 		sysCPU.testInst(0)
 		if math.Abs(mb) < 5.4e-79 || math.Abs(mb) > 7.2e75 {
@@ -6722,13 +8651,14 @@ func TestCyclDE(t *testing.T) {
 		scale = rnum.Intn(100) - 50
 		f2 = math.Ldexp(f2, scale)
 		low := rnum.Uint32()
-		if floatToFpreg(0, f1) {
+		if !floatToFpreg(0, f1) {
 			continue
 		}
-		if floatToFpreg(2, f2) {
+		if !floatToFpreg(2, f2) {
 			continue
 		}
-		mb := f1 / f2
+		// Only short fraction takes part in divide.
+		mb := cnvtShortFloat(0) / cnvtShortFloat(2)
 		setFloatShort(1, low)
 		setFloatShort(3, ^low)
 		memory.SetMemory(0x400, 0x3d020000) // DER 0,2
@@ -6738,16 +8668,8 @@ func TestCyclDE(t *testing.T) {
 		if ratio > 0.000001 {
 			t.Errorf("DE difference too large got: %f expected: %f", v, mb)
 		}
-		cc := uint8(0)
-		if mb != 0.0 {
-			if mb < 0.0 {
-				cc = 1
-			} else {
-				cc = 2
-			}
-		}
-		if sysCPU.cc != cc {
-			t.Errorf("DE CC not set correctly got: %d wanted: %d", sysCPU.cc, cc)
+		if sysCPU.cc != 3 {
+			t.Errorf("DE changed CC got: %d wanted: %d", sysCPU.cc, 3)
 		}
 		if low != getFloatShort(1) {
 			t.Errorf("DE modified lower regiser got: %08x expected: %08x", getFloatShort(1), low)
@@ -6797,3 +8719,1427 @@ func TestCycleSU(t *testing.T) {
 		t.Errorf("SW CC not set correctly got: %d wanted: %d", sysCPU.cc, 2)
 	}
 }
+
+// Value of hexadecimal floating point number with fraction of bits length.
+func hexFloatValue(sign bool, exponent int, fraction uint64, bits uint) *big.Float {
+	v := new(big.Float).SetPrec(256).SetUint64(fraction)
+	v.SetMantExp(v, 4*(exponent-64)-int(bits))
+	if sign {
+		v.Neg(v)
+	}
+	return v
+}
+
+// Unnormalized add and subtract keep characteristic of larger operand
+// and truncate result to guard digit.
+func TestCycleUnnormRandom(t *testing.T) {
+	setup()
+	rnum := rand.New(rand.NewSource(1588))
+	tests := []struct {
+		name  string
+		inst  uint32
+		long  bool
+		bits  uint
+		fmask uint64
+	}{
+		{"AUR", 0x3e020000, false, 24, 0xffffff},
+		{"SUR", 0x3f020000, false, 24, 0xffffff},
+		{"AWR", 0x2e020000, true, 56, MMASKL},
+		{"SWR", 0x2f020000, true, 56, MMASKL},
+	}
+
+	for _, test := range tests {
+		for i := range 500 {
+			exp1 := 0x40 + rnum.Intn(16) - 8
+			exp2 := exp1
+			if i&1 != 0 {
+				exp2 += rnum.Intn(17) - 8
+			}
+			frac1 := rnum.Uint64() & test.fmask
+			frac2 := rnum.Uint64() & test.fmask
+			// Give some unnormalized operands.
+			if i%5 == 0 {
+				frac1 >>= 4 * uint(rnum.Intn(4))
+			}
+			sign1 := rnum.Intn(2) != 0
+			sign2 := rnum.Intn(2) != 0
+
+			val1 := hexFloatValue(sign1, exp1, frac1, test.bits)
+			val2 := hexFloatValue(sign2, exp2, frac2, test.bits)
+			exact := new(big.Float).SetPrec(256)
+			if (test.inst & 0x01000000) != 0 {
+				exact.Sub(val1, val2)
+			} else {
+				exact.Add(val1, val2)
+			}
+
+			word := func(sign bool, exponent int, fraction uint64) uint64 {
+				w := (uint64(exponent) << 56) | (fraction << (56 - test.bits))
+				if sign {
+					w |= MSIGNL
+				}
+				return w
+			}
+			setFloatLong(0, word(sign1, exp1, frac1))
+			setFloatLong(2, word(sign2, exp2, frac2))
+			memory.SetMemory(0x400, test.inst)
+			sysCPU.testInst(0)
+			if trapFlag {
+				t.Fatalf("%s trapped %x %x", test.name, getFloatLong(0), getFloatLong(2))
+			}
+
+			result := getFloatLong(0)
+			if !test.long {
+				result &= HMASKL
+			}
+			rsign := (result & MSIGNL) != 0
+			rexp := int((result & EMASKL) >> 56)
+			rfrac := (result & MMASKL) >> (56 - test.bits)
+
+			if rfrac == 0 {
+				if result != 0 || sysCPU.cc != 0 {
+					t.Errorf("%s zero result %016x cc %d", test.name, result, sysCPU.cc)
+				}
+			} else {
+				cc := uint8(2)
+				if rsign {
+					cc = 1
+				}
+				if sysCPU.cc != cc {
+					t.Errorf("%s cc got: %d wanted: %d", test.name, sysCPU.cc, cc)
+				}
+
+				// Characteristic is larger operand, plus one on carry.
+				large := max(exp1, exp2)
+				if rexp != large && (rexp != large+1 || rfrac < (test.fmask+1)>>4) {
+					t.Errorf("%s %016x %016x characteristic got: %02x wanted: %02x",
+						test.name, word(sign1, exp1, frac1), word(sign2, exp2, frac2), rexp, large)
+				}
+			}
+
+			// Result within two units of last digit of reference.
+			diff := new(big.Float).SetPrec(256).Sub(hexFloatValue(rsign, rexp, rfrac, test.bits), exact)
+			ulp := hexFloatValue(false, max(rexp, max(exp1, exp2)), 2, test.bits)
+			if diff.Abs(diff).Cmp(ulp) > 0 {
+				t.Errorf("%s %016x %016x result %016x wanted: %s", test.name,
+					word(sign1, exp1, frac1), word(sign2, exp2, frac2), result, exact.Text('g', 20))
+			}
+		}
+	}
+}
+
+// Store over next instruction after it has been prefetched.
+func TestCycleModifyNext(t *testing.T) {
+	setup()
+	sysCPU.regs[1] = 5
+	sysCPU.regs[3] = 0x00001a23
+	memory.SetMemory(0x400, 0x1b224030) // SR 2,2; STH 3,406
+	memory.SetMemory(0x404, 0x04061a21) // AR 2,1
+	memory.SetMemory(0x408, 0x00000000)
+	sysCPU.testInst(0)
+	v := memory.GetMemory(0x404)
+	if v != 0x04061a23 {
+		t.Errorf("STH did not modify instruction got: %08x", v)
+	}
+	// New instruction is AR 2,3.
+	if sysCPU.regs[2] != 0x00001a23 {
+		t.Errorf("Modified instruction not executed register 2 got: %08x wanted: %08x",
+			sysCPU.regs[2], 0x00001a23)
+	}
+}
+
+// Block compare in CLCL must match byte by byte compare.
+func TestCycleCLCLBlock(t *testing.T) {
+	defer func() { blockCompare = true }()
+
+	cases := []struct {
+		len1, len2 uint32
+		diff       uint32 // Offset of different byte, 0 none
+	}{
+		{20, 20, 0},
+		{20, 10, 0},
+		{10, 20, 0},
+		{600, 600, 0},
+		{600, 600, 255},
+		{600, 600, 256},
+		{600, 600, 257},
+		{600, 600, 599},
+		{600, 300, 0},
+		{600, 300, 450},
+		{0, 600, 0},
+	}
+
+	for _, c := range cases {
+		type result struct {
+			regs [4]uint32
+			cc   uint8
+		}
+		var results [2]result
+		for i, enb := range []bool{false, true} {
+			blockCompare = enb
+			setup()
+			for j := uint32(0); j < 0x400; j += 4 {
+				memory.SetMemory(0x1000+j, 0x40404040)
+				memory.SetMemory(0x2000+j, 0x40404040)
+			}
+			if c.diff != 0 {
+				memory.SetMemory(0x1000+(c.diff&^3), 0x40414040)
+			}
+			sysCPU.regs[2] = 0x1000
+			sysCPU.regs[3] = c.len1
+			sysCPU.regs[4] = 0x2000
+			sysCPU.regs[5] = 0x40000000 | c.len2
+			memory.SetMemory(0x400, 0x0f240000) // CLCL 2,4
+			memory.SetMemory(0x404, 0)
+			sysCPU.testInst(0)
+			results[i] = result{regs: [4]uint32{sysCPU.regs[2], sysCPU.regs[3], sysCPU.regs[4], sysCPU.regs[5]}, cc: sysCPU.cc}
+		}
+		if results[0] != results[1] {
+			t.Errorf("CLCL %d,%d diff %d byte: %+v block: %+v", c.len1, c.len2, c.diff, results[0], results[1])
+		}
+	}
+}
+
+// Block compare in CLCL must apply prefix to operands in low storage.
+func TestCycleCLCLPrefix(t *testing.T) {
+	defer func() {
+		blockCompare = true
+		sysCPU.prefix = 0
+	}()
+
+	cases := []struct {
+		addr1, addr2 uint32
+		diff         uint32 // Real address of different byte
+		cc           uint8
+		r2           uint32
+	}{
+		{0x800, 0x1800, 0x880, 2, 0x880},    // First operand in prefixed page
+		{0xf80, 0x1f80, 0x1040, 2, 0x1040},  // First operand crosses out of prefixed page
+		{0x1800, 0x2f80, 0x3040, 1, 0x18c0}, // Second operand crosses into prefix page
+	}
+
+	for _, c := range cases {
+		for _, enb := range []bool{false, true} {
+			blockCompare = enb
+			setup()
+			sysCPU.prefix = 0x3000
+			// Fill operands, leaving test program area alone.
+			for i := uint32(0); i < 0x4100; i += 4 {
+				if i < 0x100 || i >= 0x800 {
+					memory.SetMemory(i, 0x40404040)
+				}
+			}
+			memory.SetByte(sysCPU.absAddr(c.diff), 0x41)
+			sysCPU.regs[2] = c.addr1
+			sysCPU.regs[3] = 0x100
+			sysCPU.regs[4] = c.addr2
+			sysCPU.regs[5] = 0x100
+			memory.SetMemory(0x3400, 0x0f240000) // CLCL 2,4 at real 0x400
+			sysCPU.PC = 0x400
+			_, _ = sysCPU.cycle()
+			if sysCPU.cc != c.cc {
+				t.Errorf("CLCL %06x,%06x block %v CC expected %d got: %d", c.addr1, c.addr2, enb, c.cc, sysCPU.cc)
+			}
+			if sysCPU.regs[2] != c.r2 {
+				t.Errorf("CLCL %06x,%06x block %v R2 expected %06x got: %06x", c.addr1, c.addr2, enb, c.r2, sysCPU.regs[2])
+			}
+		}
+	}
+}
+
+// Compare 64K with and without block compare.
+func BenchmarkCLCL(b *testing.B) {
+	for _, enb := range []bool{false, true} {
+		name := "Byte"
+		if enb {
+			name = "Block"
+		}
+		b.Run(name, func(b *testing.B) {
+			blockCompare = enb
+			defer func() { blockCompare = true }()
+			setup()
+			memory.SetSize(256)
+			for i := uint32(0); i < 0x10000; i += 4 {
+				memory.SetMemory(0x10000+i, 0xf1f2f3f4)
+				memory.SetMemory(0x20000+i, 0xf1f2f3f4)
+			}
+			memory.SetMemory(0x400, 0x0f240000) // CLCL 2,4
+			b.ResetTimer()
+			for range b.N {
+				sysCPU.regs[2] = 0x10000
+				sysCPU.regs[3] = 0x10000
+				sysCPU.regs[4] = 0x20000
+				sysCPU.regs[5] = 0x10000
+				sysCPU.PC = 0x400
+				_, _ = sysCPU.cycle()
+			}
+		})
+	}
+}
+
+// Store byte into prefetched word holding next instruction.
+func TestCycleModifyNextByte(t *testing.T) {
+	setup()
+	sysCPU.regs[1] = 5
+	sysCPU.regs[3] = 7
+	memory.SetMemory(0x400, 0x1b229223) // SR 2,2; MVI 407,23
+	memory.SetMemory(0x404, 0x04071a21) // AR 2,1
+	memory.SetMemory(0x408, 0x00000000)
+	sysCPU.testInst(0)
+	// New instruction is AR 2,3.
+	if sysCPU.regs[2] != 7 {
+		t.Errorf("Modified instruction not executed register 2 got: %08x wanted: %08x",
+			sysCPU.regs[2], 7)
+	}
+}
+
+// Run tight loop with and without prefetch buffer.
+func BenchmarkCycleLoop(b *testing.B) {
+	for _, enb := range []bool{false, true} {
+		name := "NoPrefetch"
+		if enb {
+			name = "Prefetch"
+		}
+		b.Run(name, func(b *testing.B) {
+			prefetchEnb = enb
+			defer func() { prefetchEnb = true }()
+			setup()
+			sysCPU.regs[2] = 1
+			sysCPU.regs[3] = 0x7fffffff
+			memory.SetMemory(0x400, 0x1a124630) // AR 1,2; BCT 3,400
+			memory.SetMemory(0x404, 0x04000000)
+			sysCPU.PC = 0x400
+			b.ResetTimer()
+			for range b.N {
+				_, _ = sysCPU.cycle()
+			}
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "inst/s")
+		})
+	}
+}
+
+// Time per instruction for instruction mixes, one op is one instruction.
+// Dispatch was already through cpu.table, the decode area escaping to
+// the heap through it cost one allocation per instruction. Keeping it in
+// cpuState gave (ns/inst): Arith 38 -> 24, Move 1150 -> 935, Decimal 155 -> 131.
+func BenchmarkInstMix(b *testing.B) {
+	mixes := []struct {
+		name string
+		prog []uint32
+	}{
+		// AR 1,2; SR 3,2; MR 4,2; LA 6,1(6); BCT 7,400
+		{"Arith", []uint32{0x1a121b32, 0x1c424166, 0x00014670, 0x04000000}},
+		// MVC 0(256,8),0(9); LA 6,1(6); B 400
+		{"Move", []uint32{0xd2ff8000, 0x90004166, 0x000147f0, 0x04000000}},
+		// ZAP 0(8,8),0(8,9); AP 0(8,8),8(8,9); B 400
+		{"Decimal", []uint32{0xf8778000, 0x9000fa77, 0x80009008, 0x47f00400}},
+	}
+	for _, mix := range mixes {
+		b.Run(mix.name, func(b *testing.B) {
+			setup()
+			for i, w := range mix.prog {
+				memory.SetMemory(0x400+uint32(i*4), w)
+			}
+			memory.SetMemory(0x2000, 0x00000000)
+			memory.SetMemory(0x2004, 0x0012345c)
+			memory.SetMemory(0x2008, 0x00000000)
+			memory.SetMemory(0x200c, 0x0000001c)
+			sysCPU.regs[2] = 3
+			sysCPU.regs[7] = 0x7fffffff
+			sysCPU.regs[8] = 0x1000
+			sysCPU.regs[9] = 0x2000
+			sysCPU.PC = 0x400
+			b.ResetTimer()
+			for range b.N {
+				_, _ = sysCPU.cycle()
+			}
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N), "ns/inst")
+		})
+	}
+}
+
+// Save CPU state, change it and restore it.
+func TestCheckpoint(t *testing.T) {
+	setup()
+	sysCPU.regs[1] = 0x12345678
+	sysCPU.regs[2] = 3
+	memory.SetMemory(0x400, 0x1a121a12) // AR 1,2; AR 1,2
+	memory.SetMemory(0x404, 0x00000000)
+	setFloatLong(2, 0x4110000000000000)
+	sysCPU.cregs[3] = 0x0000ffff
+	memory.PutKey(0x1000, 0x30)
+	sysCPU.testInst(0)
+	sysCPU.prefix = 0x3000
+	defer func() {
+		sysCPU.prefix = 0
+	}()
+
+	var buf bytes.Buffer
+	if err := testCPU().SaveState(&buf); err != nil {
+		t.Fatalf("SaveState failed: %v", err)
+	}
+	regs := sysCPU.regs
+	psw := sysCPU.pswString()
+
+	// Mutate state.
+	sysCPU.regs[1] = 0
+	sysCPU.regs[15] = 0xffffffff
+	sysCPU.PC = 0x800
+	sysCPU.cc = 0
+	setFloatLong(2, 0)
+	sysCPU.cregs[3] = 0
+	sysCPU.prefix = 0
+	memory.PutKey(0x1000, 0x00)
+
+	if err := testCPU().LoadState(&buf); err != nil {
+		t.Fatalf("LoadState failed: %v", err)
+	}
+	if !testCPU().Restored() {
+		t.Errorf("Restored not set after LoadState")
+	}
+	if testCPU().Restored() {
+		t.Errorf("Restored not cleared after being read")
+	}
+	if sysCPU.regs != regs {
+		t.Errorf("Registers not restored got: %08x wanted: %08x", sysCPU.regs, regs)
+	}
+	if sysCPU.regs[1] != 0x1234567e {
+		t.Errorf("Register 1 not correct got: %08x wanted: %08x", sysCPU.regs[1], 0x1234567e)
+	}
+	if sysCPU.pswString() != psw {
+		t.Errorf("PSW not restored got: %s wanted: %s", sysCPU.pswString(), psw)
+	}
+	if getFloatLong(2) != 0x4110000000000000 {
+		t.Errorf("FP register 2 not restored got: %016x", getFloatLong(2))
+	}
+	if sysCPU.cregs[3] != 0x0000ffff {
+		t.Errorf("Control register 3 not restored got: %08x", sysCPU.cregs[3])
+	}
+	if k := memory.GetKey(0x1000) & 0xf0; k != 0x30 {
+		t.Errorf("Storage key not restored got: %02x", k)
+	}
+	if sysCPU.prefix != 0x3000 {
+		t.Errorf("Prefix not restored got: %08x wanted: %08x", sysCPU.prefix, 0x3000)
+	}
+
+	// Bad header should be rejected.
+	if err := testCPU().LoadState(bytes.NewReader([]byte("S370XXX\x02"))); err == nil {
+		t.Errorf("LoadState accepted bad header")
+	}
+
+	// Checkpoint without prefix should be rejected.
+	if err := testCPU().LoadState(bytes.NewReader([]byte("S370CPU\x01"))); err == nil {
+		t.Errorf("LoadState accepted version 1 checkpoint")
+	}
+}
+
+// Trace should log mnemonic, effective address and result of each instruction.
+func TestTrace(t *testing.T) {
+	setup()
+	var buf bytes.Buffer
+	old := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	SetTrace(true)
+	defer func() {
+		SetTrace(false)
+		slog.SetDefault(old)
+	}()
+
+	memory.SetMemory(0x400, 0x18315a30) // LR 3,1; A 3,100(0,2)
+	memory.SetMemory(0x404, 0x210047f0) // BC 15,40e
+	memory.SetMemory(0x408, 0x040e0000)
+	memory.SetMemory(0x300, 0x00000001)
+	sysCPU.regs[1] = 0x12345678
+	sysCPU.regs[2] = 0x200
+	sysCPU.testInst(0)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Trace expected %d lines got: %d", 3, len(lines))
+	}
+	expect := [][]string{
+		{"pc=000400", `inst="LR    3,1"`, "R3=12345678"},
+		{"pc=000402", `inst="A     3,100(2)"`, "addr=000300", "cc=2", "R3=12345679"},
+		{"pc=000406", `inst="BC    15,40E"`, "addr=00040e"},
+	}
+	for i, fields := range expect {
+		for _, f := range fields {
+			if !strings.Contains(lines[i], f) {
+				t.Errorf("Trace line %d missing %s got: %s", i, f, lines[i])
+			}
+		}
+	}
+	if strings.Contains(lines[2], "R15") {
+		t.Errorf("Trace of branch shows mask as register: %s", lines[2])
+	}
+	if strings.Contains(lines[0], "addr=") {
+		t.Errorf("Trace of RR instruction shows address: %s", lines[0])
+	}
+}
+
+// Disassemble instructions of each format.
+func TestDisassemble(t *testing.T) {
+	tests := []struct {
+		word1  uint32
+		word2  uint32
+		text   string
+		length int
+	}{
+		{0x18310000, 0, "LR 3,1", 2},
+		{0x0af10000, 0, "SVC F1", 2},
+		{0x2a240000, 0, "ADR 2,4", 2},
+		{0x5a302100, 0, "A 3,100(2)", 4},
+		{0x47f0040e, 0, "BC 15,40E", 4},
+		{0x7a20f100, 0, "AE 2,100(15)", 4},
+		{0x90e3d00c, 0, "STM 14,3,00C(13)", 4},
+		{0x98010200, 0, "LM 0,1,200", 4},
+		{0x92f10100, 0, "MVI 100,F1", 4},
+		{0xb2020100, 0, "STIDP 100", 4},
+		{0xd2030100, 0x02000000, "MVC 100(3),200", 6},
+		{0xfa330100, 0x02000000, "AP 100(3),200(3)", 6},
+	}
+	for _, test := range tests {
+		text, length := Disassemble(test.word1, test.word2)
+		if text != test.text {
+			t.Errorf("Disassemble %08x %08x expected %q got: %q", test.word1, test.word2, test.text, text)
+		}
+		if length != test.length {
+			t.Errorf("Disassemble %08x length expected %d got: %d", test.word1, test.length, length)
+		}
+	}
+}
+
+// Execution should stop before instruction at breakpoint and continue past it.
+func TestBreakpoint(t *testing.T) {
+	setup()
+	defer testCPU().ClearAllBreaks()
+	memory.SetMemory(0x400, 0x41100001) // LA 1,1
+	memory.SetMemory(0x404, 0x1a111a11) // AR 1,1; AR 1,1
+	memory.SetMemory(0x408, 0x47f00400) // B 400
+	sysCPU.PC = 0x400
+	sysCPU.regs[1] = 0
+	testCPU().SetBreak(0x406)
+
+	running := true
+	for i := 0; running && i < 20; i++ {
+		_, running = sysCPU.cycle()
+	}
+	if running {
+		t.Fatal("CPU did not stop at breakpoint")
+	}
+	if sysCPU.PC != 0x406 {
+		t.Errorf("Breakpoint PC expected %06x got: %06x", 0x406, sysCPU.PC)
+	}
+	if sysCPU.regs[1] != 2 {
+		t.Errorf("Breakpoint register 1 expected %d got: %d", 2, sysCPU.regs[1])
+	}
+
+	// Continue runs instruction at breakpoint and stops on next pass.
+	running = true
+	for i := 0; running && i < 20; i++ {
+		_, running = sysCPU.cycle()
+	}
+	if sysCPU.PC != 0x406 || sysCPU.regs[1] != 2 {
+		t.Errorf("Second stop expected 406/2 got: %06x/%d", sysCPU.PC, sysCPU.regs[1])
+	}
+
+	testCPU().ClearBreak(0x406)
+	if len(testCPU().Breakpoints()) != 0 {
+		t.Errorf("Breakpoints not cleared: %v", testCPU().Breakpoints())
+	}
+	for range 3 {
+		if _, running = sysCPU.cycle(); !running {
+			t.Error("CPU stopped with no breakpoints")
+		}
+	}
+}
+
+// Armed operation exception stops CPU and logs code.
+func TestTrapStop(t *testing.T) {
+	setup()
+	var buf bytes.Buffer
+	old := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+	defer slog.SetDefault(old)
+	defer testCPU().ClearAllTraps()
+
+	memory.SetMemory(0x400, 0x00000000) // Undefined opcode
+	memory.SetMemory(0x68, 0)
+	memory.SetMemory(0x6c, 0x800)
+	sysCPU.PC = 0x400
+	testCPU().SetTrap(uint16(ircOper))
+	if traps := testCPU().Traps(); len(traps) != 1 || traps[0] != uint16(ircOper) {
+		t.Errorf("Traps expected [1] got: %v", traps)
+	}
+
+	memory.SetMemory(0x28, 0xffffffff)
+	memory.SetMemory(0x2c, 0xffffffff)
+	running := true
+	for i := 0; running && i < 20; i++ {
+		_, running = sysCPU.cycle()
+	}
+	if running {
+		t.Fatal("CPU did not stop on operation exception")
+	}
+	if sysCPU.PC != 0x400 {
+		t.Errorf("Trap PC expected %06x got: %06x", 0x400, sysCPU.PC)
+	}
+	if v := memory.GetMemory(0x2c); v != 0xffffffff {
+		t.Errorf("Trap stored old PSW got: %08x", v)
+	}
+	if !strings.Contains(buf.String(), "Trap 01 at 000400") {
+		t.Errorf("Trap not logged got: %s", buf.String())
+	}
+
+	// Continue takes held interrupt.
+	if _, running = sysCPU.cycle(); !running {
+		t.Error("CPU stopped again on continue")
+	}
+	if sysCPU.PC != 0x800 {
+		t.Errorf("Trap new PSW PC expected %06x got: %06x", 0x800, sysCPU.PC)
+	}
+	v := memory.GetMemory(0x28) & 0xffff
+	if v != uint32(ircOper) {
+		t.Errorf("Trap code expected %04x got: %04x", ircOper, v)
+	}
+	if v := memory.GetMemory(0x2c) & 0xffffff; v != 0x402 {
+		t.Errorf("Trap old PSW address expected %06x got: %06x", 0x402, v)
+	}
+
+	// Unarmed code does not stop.
+	testCPU().ClearTrap(uint16(ircOper))
+	sysCPU.PC = 0x400
+	if _, running = sysCPU.cycle(); !running {
+		t.Error("CPU stopped with no traps armed")
+	}
+}
+
+// Configure 256K, top word can be read and next word traps.
+func TestMemSize(t *testing.T) {
+	setup()
+	defer memory.SetSize(64)
+	if err := setMemSize(0, "256K", nil); err != nil {
+		t.Fatalf("Unable to set memory size: %v", err)
+	}
+	if memory.GetSize() != 256*1024 {
+		t.Errorf("Memory size expected %x got: %x", 256*1024, memory.GetSize())
+	}
+	if err := setMemSize(0, "32M", nil); err == nil {
+		t.Error("Memory size larger than 16M did not fail")
+	}
+
+	memory.SetMemory(0x3fffc, 0x12345678)
+	memory.SetMemory(0x400, 0x58102ffc) // L 1,ffc(0,2)
+	memory.SetMemory(0x404, 0)
+	sysCPU.regs[2] = 0x3f000
+	sysCPU.testInst(0)
+	if trapFlag {
+		t.Error("Load of top word trapped")
+	}
+	if sysCPU.regs[1] != 0x12345678 {
+		t.Errorf("Load of top word expected %08x got: %08x", 0x12345678, sysCPU.regs[1])
+	}
+
+	memory.SetMemory(0x400, 0x58103000) // L 1,0(0,3)
+	sysCPU.regs[3] = 0x40000
+	sysCPU.testInst(0)
+	if !trapFlag {
+		t.Error("Load past end of memory did not trap")
+	}
+	if v := memory.GetMemory(0x28); v != uint32(ircAddr) {
+		t.Errorf("Program interrupt code expected %08x got: %08x", ircAddr, v)
+	}
+}
+
+// DIAGNOSE 8 writes a message, unknown codes trap.
+func TestCycleDIAG(t *testing.T) {
+	setup()
+	var buf bytes.Buffer
+	old := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+	defer slog.SetDefault(old)
+
+	memory.SetMemory(0x500, 0xc8c5d3d3) // HELLO
+	memory.SetMemory(0x504, 0xd6000000)
+	sysCPU.regs[2] = 0x500
+	sysCPU.regs[3] = 5
+	memory.SetMemory(0x400, 0x83230008) // DIAG 2,3,8
+	memory.SetMemory(0x404, 0)
+	sysCPU.testInst(0)
+	if trapFlag {
+		t.Error("DIAG 8 trapped")
+	}
+	if !strings.Contains(buf.String(), "DIAG: HELLO") {
+		t.Errorf("DIAG 8 message not logged got: %s", buf.String())
+	}
+
+	memory.SetMemory(0x400, 0x83230010) // DIAG 2,3,10
+	sysCPU.testInst(0)
+	if !trapFlag {
+		t.Error("DIAG 10 should have trapped")
+	}
+	if v := memory.GetMemory(0x28); v != uint32(ircSpec) {
+		t.Errorf("DIAG 10 interrupt code expected %08x got: %08x", ircSpec, v)
+	}
+
+	sysCPU.flags = 0x1                  // unprivileged
+	memory.SetMemory(0x400, 0x83230008) // DIAG 2,3,8
+	sysCPU.testInst(0)
+	if !trapFlag {
+		t.Error("DIAG unprivileged should have trapped")
+	}
+}
+
+// Select CPU model from config file.
+func TestCPUModel(t *testing.T) {
+	defer func() { _ = SetModel("145") }()
+
+	// Load config, return model number from STIDP and if LER trapped.
+	loadModel := func(line string) (uint32, uint32, error) {
+		name := filepath.Join(t.TempDir(), "model.cfg")
+		if err := os.WriteFile(name, []byte(line+"\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := config.LoadConfigFile(name); err != nil {
+			return 0, 0, err
+		}
+		setup()
+		memory.SetMemory(0x28, 0)
+		memory.SetMemory(0x400, 0xb2020100) // STIDP 100
+		memory.SetMemory(0x404, 0x38240000) // LER 2,4
+		sysCPU.testInst(0)
+		code := uint32(0)
+		if trapFlag {
+			code = memory.GetMemory(0x28) & 0xffff
+		}
+		return memory.GetMemory(0x104) >> 16, code, nil
+	}
+
+	number, code, err := loadModel("cpu model 125")
+	if err != nil {
+		t.Fatalf("Model 125 config failed: %v", err)
+	}
+	if number != 0x125 {
+		t.Errorf("Model 125 STIDP got: %04x wanted: %04x", number, 0x125)
+	}
+	if code != uint32(ircOper) {
+		t.Errorf("Model 125 LER interrupt got: %02x wanted: %02x", code, ircOper)
+	}
+
+	number, code, err = loadModel("CPU MODEL 168")
+	if err != nil {
+		t.Fatalf("Model 168 config failed: %v", err)
+	}
+	if number != 0x168 {
+		t.Errorf("Model 168 STIDP got: %04x wanted: %04x", number, 0x168)
+	}
+	if code != 0 {
+		t.Errorf("Model 168 LER interrupt got: %02x wanted: none", code)
+	}
+
+	if _, _, err = loadModel("cpu model 999"); err == nil {
+		t.Error("Unknown model did not give error")
+	}
+}
+
+// Test optional instruction set features.
+func TestCPUFeatures(t *testing.T) {
+	defer func() { _ = SetModel("145") }()
+
+	name := filepath.Join(t.TempDir(), "model.cfg")
+	if err := os.WriteFile(name, []byte("cpu model 145 nodecimal\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.LoadConfigFile(name); err != nil {
+		t.Fatalf("Model 145 nodecimal config failed: %v", err)
+	}
+
+	setup()
+	memory.SetMemory(0x28, 0)
+	memory.SetMemory(0x100, 0x1c000000)
+	memory.SetMemory(0x200, 0x2c000000)
+	memory.SetMemory(0x400, 0xfa000100) // AP 100(1,0),200(1,0)
+	memory.SetMemory(0x404, 0x02000000)
+	sysCPU.testInst(0)
+	if !trapFlag {
+		t.Error("AP did not trap without decimal feature")
+	}
+	code := memory.GetMemory(0x28) & 0xffff
+	if code != uint32(ircOper) {
+		t.Errorf("AP interrupt got: %02x wanted: %02x", code, ircOper)
+	}
+	if v := memory.GetMemory(0x100); v != 0x1c000000 {
+		t.Errorf("AP memory changed got: %08x wanted: %08x", v, 0x1c000000)
+	}
+
+	setup()
+	memory.SetMemory(0x400, 0x1a120000) // AR 1,2
+	memory.SetMemory(0x404, 0)
+	sysCPU.regs[1] = 1
+	sysCPU.regs[2] = 2
+	sysCPU.testInst(0)
+	if trapFlag {
+		t.Error("AR trapped without decimal feature")
+	}
+	if sysCPU.regs[1] != 3 {
+		t.Errorf("AR register 1 got: %08x wanted: %08x", sysCPU.regs[1], 3)
+	}
+
+	if err := SetModel("145", "nofeature"); err == nil {
+		t.Error("Unknown feature did not give error")
+	}
+}
+
+// Test model timing changes cycles taken by program.
+func TestModelTiming(t *testing.T) {
+	defer func() { _ = SetModel("145") }()
+
+	run := func(name string) int {
+		if err := SetModel(name); err != nil {
+			t.Fatalf("Model %s failed: %v", name, err)
+		}
+		setup()
+		memory.SetMemory(0x400, 0x18121a12) // LR 1,2; AR 1,2
+		memory.SetMemory(0x404, 0x58300100) // L 3,100
+		memory.SetMemory(0x408, 0x1c240000) // MR 2,4
+		sysCPU.PC = 0x400
+		start := event.Now()
+		for range 4 {
+			cycle, _ := sysCPU.cycle()
+			event.Advance(cycle)
+		}
+		if sysCPU.PC != 0x40a {
+			t.Errorf("Model %s PC got: %08x wanted: %08x", name, sysCPU.PC, 0x40a)
+		}
+		return event.Now() - start
+	}
+
+	base := run("145")
+	slow := run("135")
+	fast := run("168")
+	if slow <= fast {
+		t.Errorf("Model 135 time %d not more than model 168 time %d", slow, fast)
+	}
+	if want := base + 4 + 4 + 6 + 25; slow != want {
+		t.Errorf("Model 135 time got: %d wanted: %d", slow, want)
+	}
+	if want := base + 2; fast != want {
+		t.Errorf("Model 168 time got: %d wanted: %d", fast, want)
+	}
+}
+
+// Test PER storage alteration event.
+func TestPERStore(t *testing.T) {
+	cases := []struct {
+		name string
+		inst uint32
+		per  bool
+	}{
+		{"in range", 0x50102004, true},      // ST 1,4(2)
+		{"out of range", 0x50103004, false}, // ST 1,4(3)
+		{"end of range", 0x421020ff, true},  // STC 1,0ff(2)
+		{"fetch only", 0x58102004, false},   // L 1,4(2)
+	}
+	for _, c := range cases {
+		setup()
+		memory.SetMemory(0x28, 0)
+		memory.SetMemory(0x2c, 0)
+		memory.SetMemory(0x8c, 0)
+		memory.SetMemory(0x94, 0)
+		memory.SetMemory(0x98, 0)
+		sysCPU.cregs[9] = 0x20000000 // Storage alteration
+		sysCPU.cregs[10] = 0x1000
+		sysCPU.cregs[11] = 0x10ff
+		sysCPU.loadControl(9, sysCPU.cregs[9])
+		sysCPU.regs[1] = 0x12345678
+		sysCPU.regs[2] = 0x1000
+		sysCPU.regs[3] = 0x2000
+		memory.SetMemory(0x400, 0x82000500) // LPSW 500
+		memory.SetMemory(0x410, c.inst)
+		memory.SetMemory(0x414, 0)
+		memory.SetMemory(0x500, 0x40080000) // EC mode, PER enabled
+		memory.SetMemory(0x504, 0x00000410)
+		sysCPU.testInst(0)
+		if trapFlag != c.per {
+			t.Errorf("PER %s trap got: %v wanted: %v", c.name, trapFlag, c.per)
+			continue
+		}
+		if !c.per {
+			if sysCPU.PC != 0x414 {
+				t.Errorf("PER %s PC got: %06x wanted: %06x", c.name, sysCPU.PC, 0x414)
+			}
+			continue
+		}
+		if code := memory.GetMemory(0x8c) & 0xffff; code != uint32(ircPer) {
+			t.Errorf("PER %s interrupt code got: %04x wanted: %04x", c.name, code, ircPer)
+		}
+		if code := memory.GetMemory(0x94) & 0xffff; code != 0x2000 {
+			t.Errorf("PER %s PER code got: %04x wanted: %04x", c.name, code, 0x2000)
+		}
+		if addr := memory.GetMemory(0x98); addr != 0x410 {
+			t.Errorf("PER %s PER address got: %06x wanted: %06x", c.name, addr, 0x410)
+		}
+		if addr := memory.GetMemory(0x2c) & 0xffffff; addr != 0x414 {
+			t.Errorf("PER %s old PSW address got: %06x wanted: %06x", c.name, addr, 0x414)
+		}
+	}
+}
+
+// Test undefined opcodes give operation exception.
+func TestCycleUndefined(t *testing.T) {
+	cases := []struct {
+		name string
+		inst uint32
+		ilc  uint32
+	}{
+		{"0000", 0x00000000, 1},
+		{"01", 0x01230000, 1},
+		{"A0", 0xa0123456, 2},
+		{"FF", 0xff123456, 3},
+	}
+	for _, c := range cases {
+		setup()
+		memory.SetMemory(0x28, 0)
+		memory.SetMemory(0x2c, 0)
+		memory.SetMemory(0x400, c.inst)
+		memory.SetMemory(0x404, 0x34560000)
+		memory.SetMemory(0x408, 0)
+		sysCPU.testInst(0)
+		if !trapFlag {
+			t.Errorf("%s did not trap", c.name)
+			continue
+		}
+		code := memory.GetMemory(0x28) & 0xffff
+		if code != uint32(ircOper) {
+			t.Errorf("%s interrupt got: %02x wanted: %02x", c.name, code, ircOper)
+		}
+		psw2 := memory.GetMemory(0x2c)
+		if ilc := psw2 >> 30; ilc != c.ilc {
+			t.Errorf("%s ILC got: %d wanted: %d", c.name, ilc, c.ilc)
+		}
+		if addr := psw2 & 0xffffff; addr != 0x400+(c.ilc*2) {
+			t.Errorf("%s old PSW address got: %06x wanted: %06x", c.name, addr, 0x400+(c.ilc*2))
+		}
+	}
+}
+
+// Opcode zero following another instruction is executed.
+func TestCycleUndefinedZero(t *testing.T) {
+	setup()
+	memory.SetMemory(0x28, 0)
+	memory.SetMemory(0x2c, 0)
+	sysCPU.regs[1] = 1
+	sysCPU.regs[2] = 2
+	memory.SetMemory(0x400, 0x1a120000) // AR 1,2; 0000
+	memory.SetMemory(0x404, 0)
+	testStop = 0x404
+	sysCPU.testInst(0)
+	if !trapFlag {
+		t.Fatal("Opcode 0000 did not trap")
+	}
+	if sysCPU.regs[1] != 3 {
+		t.Errorf("AR register 1 got: %08x wanted: %08x", sysCPU.regs[1], 3)
+	}
+	if code := memory.GetMemory(0x28) & 0xffff; code != uint32(ircOper) {
+		t.Errorf("Opcode 0000 interrupt got: %02x wanted: %02x", code, ircOper)
+	}
+	if addr := memory.GetMemory(0x2c) & 0xffffff; addr != 0x404 {
+		t.Errorf("Opcode 0000 old PSW address got: %06x wanted: %06x", addr, 0x404)
+	}
+}
+
+// Test TRTR instruction.
+func TestCycleTRTR(t *testing.T) {
+	setup()
+	for i := uint32(0); i < 256; i += 4 {
+		memory.SetMemory(0x2000+i, 0)
+	}
+	memory.SetMemory(0x2020, 0x10203040)
+	memory.SetMemory(0x3000, 0x12345621) // 21 will match table entry 20
+	memory.SetMemory(0x3004, 0x11223344) // 22 will match table entry 30
+	memory.SetMemory(0x3008, 0x55667788)
+	memory.SetMemory(0x300c, 0x99aabbcc)
+	memory.SetMemory(0x400, 0xd00f1000) // TRTR 0(16,1),0(15)
+	memory.SetMemory(0x404, 0xf0000000)
+	sysCPU.regs[1] = 0x3000
+	sysCPU.regs[2] = 0
+	sysCPU.regs[15] = 0x2000
+	sysCPU.testInst(0)
+	if sysCPU.regs[1] != 0x3005 {
+		t.Errorf("TRTR Register 1 not correct got: %08x wanted: %08x", sysCPU.regs[1], 0x3005)
+	}
+	if sysCPU.regs[2] != 0x30 {
+		t.Errorf("TRTR Register 2 not correct got: %08x wanted: %08x", sysCPU.regs[2], 0x30)
+	}
+	if sysCPU.cc != 1 {
+		t.Errorf("TRTR CC not correct got: %x wanted: %x", sysCPU.cc, 1)
+	}
+
+	// Match only on leftmost byte.
+	memory.SetMemory(0x3000, 0x21345611)
+	memory.SetMemory(0x3004, 0x11113344)
+	sysCPU.regs[1] = 0xff003000
+	sysCPU.regs[2] = 0xffffffff
+	sysCPU.testInst(0)
+	if sysCPU.regs[1] != 0xff003000 {
+		t.Errorf("TRTR Register 1 not correct got: %08x wanted: %08x", sysCPU.regs[1], 0xff003000)
+	}
+	if sysCPU.regs[2] != 0xffffff20 {
+		t.Errorf("TRTR Register 2 not correct got: %08x wanted: %08x", sysCPU.regs[2], 0xffffff20)
+	}
+	if sysCPU.cc != 2 {
+		t.Errorf("TRTR CC not correct got: %x wanted: %x", sysCPU.cc, 2)
+	}
+
+	// No match.
+	memory.SetMemory(0x3000, 0x11345611)
+	sysCPU.regs[1] = 0x3000
+	sysCPU.regs[2] = 0
+	sysCPU.testInst(0)
+	if sysCPU.regs[1] != 0x3000 || sysCPU.regs[2] != 0 {
+		t.Errorf("TRTR Registers changed got: %08x %08x", sysCPU.regs[1], sysCPU.regs[2])
+	}
+	if sysCPU.cc != 0 {
+		t.Errorf("TRTR CC not correct got: %x wanted: %x", sysCPU.cc, 0)
+	}
+}
+
+// Test CLM on all masks.
+func TestCycleCLMMasks(t *testing.T) {
+	setup()
+	memory.SetMemory(0x404, 0)
+	reg := uint32(0x12345678)
+	for _, word := range []uint32{0x12345678, 0x34567800, 0x56780000, 0x12ff0000, 0x00000000} {
+		memory.SetMemory(0x100, word)
+		for mask := range 16 {
+			// Compute expected cc.
+			cc := uint8(0)
+			pos := 24
+			for i := 3; i >= 0; i-- {
+				if (mask & (1 << i)) == 0 {
+					continue
+				}
+				rb := (reg >> (uint(i) * 8)) & 0xff
+				mb := (word >> pos) & 0xff
+				pos -= 8
+				if rb < mb {
+					cc = 1
+					break
+				}
+				if rb > mb {
+					cc = 2
+					break
+				}
+			}
+			memory.SetMemory(0x400, 0xbd100100|(uint32(mask)<<16)) // CLM 1,mask,100
+			sysCPU.regs[1] = reg
+			sysCPU.cc = 3
+			sysCPU.testInst(0)
+			if trapFlag {
+				t.Errorf("CLM mask %x trapped", mask)
+			}
+			if sysCPU.cc != cc {
+				t.Errorf("CLM mask %x memory %08x CC not correct got: %x wanted: %x", mask, word, sysCPU.cc, cc)
+			}
+			if sysCPU.regs[1] != reg {
+				t.Errorf("CLM mask %x register changed got: %08x wanted: %08x", mask, sysCPU.regs[1], reg)
+			}
+		}
+	}
+}
+
+// Test ICM and STCM with zero and high byte mask.
+func TestCycleICMSTCMMask(t *testing.T) {
+	setup()
+	memory.SetMemory(0x404, 0)
+	memory.SetMemory(0x100, 0x87654321)
+	memory.SetMemory(0x400, 0xbf100100) // ICM 1,0,100
+	sysCPU.regs[1] = 0x12345678
+	sysCPU.cc = 3
+	sysCPU.testInst(0)
+	if sysCPU.regs[1] != 0x12345678 {
+		t.Errorf("ICM 0 register 1 changed got: %08x wanted: %08x", sysCPU.regs[1], 0x12345678)
+	}
+	if sysCPU.cc != 0 {
+		t.Errorf("ICM 0 CC not correct got: %x wanted: %x", sysCPU.cc, 0)
+	}
+
+	memory.SetMemory(0x400, 0xbf180100) // ICM 1,8,100
+	sysCPU.cc = 3
+	sysCPU.testInst(0)
+	if sysCPU.regs[1] != 0x87345678 {
+		t.Errorf("ICM 8 register 1 not correct got: %08x wanted: %08x", sysCPU.regs[1], 0x87345678)
+	}
+	if sysCPU.cc != 1 {
+		t.Errorf("ICM 8 CC not correct got: %x wanted: %x", sysCPU.cc, 1)
+	}
+
+	memory.SetMemory(0x100, 0x00ff0000)
+	sysCPU.regs[1] = 0x12345678
+	sysCPU.testInst(0)
+	if sysCPU.regs[1] != 0x00345678 {
+		t.Errorf("ICM 8 register 1 not correct got: %08x wanted: %08x", sysCPU.regs[1], 0x00345678)
+	}
+	if sysCPU.cc != 0 {
+		t.Errorf("ICM 8 CC not correct got: %x wanted: %x", sysCPU.cc, 0)
+	}
+
+	memory.SetMemory(0x100, 0x7f000000)
+	sysCPU.testInst(0)
+	if sysCPU.regs[1] != 0x7f345678 {
+		t.Errorf("ICM 8 register 1 not correct got: %08x wanted: %08x", sysCPU.regs[1], 0x7f345678)
+	}
+	if sysCPU.cc != 2 {
+		t.Errorf("ICM 8 CC not correct got: %x wanted: %x", sysCPU.cc, 2)
+	}
+
+	memory.SetMemory(0x100, 0xaabbccdd)
+	memory.SetMemory(0x400, 0xbe100100) // STCM 1,0,100
+	sysCPU.regs[1] = 0x12345678
+	sysCPU.cc = 3
+	sysCPU.testInst(0)
+	if v := memory.GetMemory(0x100); v != 0xaabbccdd {
+		t.Errorf("STCM 0 memory changed got: %08x wanted: %08x", v, 0xaabbccdd)
+	}
+	if sysCPU.cc != 3 {
+		t.Errorf("STCM 0 CC changed got: %x wanted: %x", sysCPU.cc, 3)
+	}
+
+	memory.SetMemory(0x400, 0xbe180100) // STCM 1,8,100
+	sysCPU.testInst(0)
+	if v := memory.GetMemory(0x100); v != 0x12bbccdd {
+		t.Errorf("STCM 8 memory not correct got: %08x wanted: %08x", v, 0x12bbccdd)
+	}
+	if sysCPU.regs[1] != 0x12345678 {
+		t.Errorf("STCM 8 register 1 changed got: %08x wanted: %08x", sysCPU.regs[1], 0x12345678)
+	}
+	if sysCPU.cc != 3 {
+		t.Errorf("STCM 8 CC changed got: %x wanted: %x", sysCPU.cc, 3)
+	}
+}
+
+// Compare PSW fields of two CPU states.
+func comparePSW(t *testing.T, name string, a, b *cpuState) {
+	t.Helper()
+	if a.ecMode != b.ecMode || a.extEnb != b.extEnb || a.irqEnb != b.irqEnb ||
+		a.pageEnb != b.pageEnb || a.perEnb != b.perEnb {
+		t.Errorf("%s enables got: %v %v %v %v %v wanted: %v %v %v %v %v", name,
+			b.ecMode, b.extEnb, b.irqEnb, b.pageEnb, b.perEnb,
+			a.ecMode, a.extEnb, a.irqEnb, a.pageEnb, a.perEnb)
+	}
+	if a.sysMask != b.sysMask {
+		t.Errorf("%s system mask got: %04x wanted: %04x", name, b.sysMask, a.sysMask)
+	}
+	if a.stKey != b.stKey || a.flags != b.flags {
+		t.Errorf("%s key/flags got: %02x %x wanted: %02x %x", name, b.stKey, b.flags, a.stKey, a.flags)
+	}
+	if a.cc != b.cc || a.progMask != b.progMask {
+		t.Errorf("%s cc/mask got: %x %x wanted: %x %x", name, b.cc, b.progMask, a.cc, a.progMask)
+	}
+	if !a.ecMode && a.ilc != b.ilc {
+		t.Errorf("%s ILC got: %d wanted: %d", name, b.ilc, a.ilc)
+	}
+	if a.PC != b.PC {
+		t.Errorf("%s address got: %06x wanted: %06x", name, b.PC, a.PC)
+	}
+}
+
+// Test packing and unpacking PSW.
+func TestPackPSW(t *testing.T) {
+	bc := cpuState{
+		extEnb: true, irqEnb: true, sysMask: 0xfe00 | 0x3ff, stKey: 0x50,
+		flags: 0x5, cc: 2, progMask: 0xa, ilc: 2, PC: 0x123456,
+	}
+	bc.cregs[2] = 0xffffffff
+	psw := bc.PackPSW()
+	if psw != 0xff550000aa123456 {
+		t.Errorf("BC PSW got: %016x wanted: %016x", psw, uint64(0xff550000aa123456))
+	}
+	fresh := cpuState{}
+	fresh.cregs[2] = 0xffffffff
+	fresh.UnpackPSW(psw)
+	comparePSW(t, "BC", &bc, &fresh)
+
+	ec := cpuState{
+		ecMode: true, extEnb: true, irqEnb: true, pageEnb: true, perEnb: true,
+		sysMask: 0xffff, stKey: 0x30, flags: 0x2, cc: 1, progMask: 0x6, PC: 0xabcdef,
+	}
+	ec.cregs[2] = 0xffffffff
+	psw = ec.PackPSW()
+	if psw != 0x473a160000abcdef {
+		t.Errorf("EC PSW got: %016x wanted: %016x", psw, uint64(0x473a160000abcdef))
+	}
+	fresh = cpuState{}
+	fresh.cregs[2] = 0xffffffff
+	fresh.UnpackPSW(psw)
+	comparePSW(t, "EC", &ec, &fresh)
+}
+
+// Round trip valid PSWs through CPU state.
+func FuzzPackPSW(f *testing.F) {
+	f.Add(uint64(0xff550000aa123456))
+	f.Add(uint64(0x473a160000abcdef))
+	f.Add(uint64(0x0000000000000000))
+	f.Add(uint64(0x0008000000000000))
+	f.Fuzz(func(t *testing.T, psw uint64) {
+		// Clear bits not held in CPU state.
+		if (psw & 0x0008000000000000) != 0 {
+			psw &= 0x47ff3f0000ffffff
+		} else {
+			psw &= 0xfff70000ffffffff
+		}
+		cpu := cpuState{}
+		cpu.cregs[2] = 0xffffffff
+		cpu.UnpackPSW(psw)
+		if got := cpu.PackPSW(); got != psw {
+			t.Errorf("PSW round trip got: %016x wanted: %016x", got, psw)
+		}
+		other := cpuState{}
+		other.cregs[2] = 0xffffffff
+		other.UnpackPSW(cpu.PackPSW())
+		comparePSW(t, "Round trip", &cpu, &other)
+	})
+}
+
+// Test system reset keeps storage keys, power on reset clears them.
+func TestResetScope(t *testing.T) {
+	setup()
+	defer setup()
+
+	memory.SetMemory(0x1000, 0x12345678)
+	memory.PutKey(0x1000, 0x36)
+	sysCPU.regs[3] = 0x11111111
+	sysCPU.extIrq = true
+	sysCPU.intIrq = true
+	sysCPU.clkIrq = true
+	sysCPU.programReset()
+	if sysCPU.extIrq || sysCPU.intIrq || sysCPU.clkIrq {
+		t.Error("Program reset did not clear pending interrupts")
+	}
+	if sysCPU.regs[3] != 0x11111111 {
+		t.Errorf("Program reset register 3 got: %08x wanted: %08x", sysCPU.regs[3], 0x11111111)
+	}
+
+	sysCPU.extIrq = true
+	sysCPU.todIrq = true
+	sysCPU.ecMode = true
+	sysCPU.systemReset()
+	if sysCPU.extIrq || sysCPU.todIrq {
+		t.Error("System reset did not clear pending interrupts")
+	}
+	if sysCPU.ecMode {
+		t.Error("System reset did not reset PSW")
+	}
+	if k := memory.GetKey(0x1000) & 0xf0; k != 0x30 {
+		t.Errorf("System reset key got: %02x wanted: %02x", k, 0x30)
+	}
+	if v := memory.GetMemory(0x1000); v != 0x12345678 {
+		t.Errorf("System reset memory got: %08x wanted: %08x", v, 0x12345678)
+	}
+
+	sysCPU.extIrq = true
+	sysCPU.powerOnReset()
+	if sysCPU.extIrq {
+		t.Error("Power on reset did not clear pending interrupts")
+	}
+	if k := memory.GetKey(0x1000); k != 0 {
+		t.Errorf("Power on reset key got: %02x wanted: %02x", k, 0)
+	}
+	if v := memory.GetMemory(0x1000); v != 0 {
+		t.Errorf("Power on reset memory got: %08x wanted: %08x", v, 0)
+	}
+}
+
+// Test store status.
+func TestStoreStatus(t *testing.T) {
+	setup()
+
+	cpus := []*CPU{New(0), New(1)}
+	prefix := []uint32{0x2000, 0x3000}
+	for n, c := range cpus {
+		cpu := c.state
+		for i := range 16 {
+			cpu.regs[i] = 0x01010101*uint32(i) + uint32(n)
+			cpu.cregs[i] = 0x10000000 | uint32(i)
+		}
+		for i := 0; i < 8; i += 2 {
+			cpu.fpregs[i] = 0x4110000000000000 | uint64(i)
+		}
+		cpu.cpuTimer = [2]uint32{0x11223344, 0x55667788}
+		cpu.clkCmp = [2]uint32{0x99aabbcc, 0xddeeff00}
+		cpu.stKey = 0x30
+		cpu.cc = 2
+		cpu.ilc = 0
+		cpu.PC = 0x1234
+		cpu.prefix = prefix[n]
+	}
+	memory.SetMemory(0x108, 0xffffffff)
+
+	for _, c := range cpus {
+		c.StoreStatus()
+	}
+
+	for n := range cpus {
+		base := prefix[n]
+		check := func(name string, addr, want uint32) {
+			t.Helper()
+			if v := memory.GetMemory(base + addr); v != want {
+				t.Errorf("CPU %d %s at %03x got: %08x wanted: %08x", n, name, addr, v, want)
+			}
+		}
+		check("CPU timer", 0xd8, 0x11223344)
+		check("CPU timer", 0xdc, 0x55667788)
+		check("Clock comparator", 0xe0, 0x99aabbcc)
+		check("Clock comparator", 0xe4, 0xddeeff00)
+		check("PSW", 0x100, 0x00300000)
+		check("PSW", 0x104, 0x20001234)
+		check("Prefix", 0x108, prefix[n])
+		for i := range uint32(4) {
+			check("FPR", 0x160+(i*8), 0x41100000)
+			check("FPR", 0x164+(i*8), i*2)
+		}
+		for i := range uint32(16) {
+			check("GPR", 0x180+(i*4), 0x01010101*i+uint32(n))
+			check("CR", 0x1c0+(i*4), 0x10000000|i)
+		}
+	}
+	if v := memory.GetMemory(0x108); v != 0xffffffff {
+		t.Errorf("Absolute save area changed got: %08x", v)
+	}
+}
+
+// Injected external and machine check interrupts swap PSWs.
+func TestInjectInterrupt(t *testing.T) {
+	setup()
+
+	memory.SetMemory(0x18, 0)
+	memory.SetMemory(0x1c, 0)
+	memory.SetMemory(0x58, 0x00000000) // External new PSW
+	memory.SetMemory(0x5c, 0x00000900)
+	memory.SetMemory(0x30, 0)
+	memory.SetMemory(0x34, 0)
+	memory.SetMemory(0x70, 0x00000000) // Machine check new PSW
+	memory.SetMemory(0x74, 0x00000a00)
+	memory.SetMemory(0x400, 0x47000000) // BC 0,0
+
+	// Disabled, interrupt stays pending.
+	sysCPU.PC = 0x400
+	testCPU().InjectExternal()
+	if !testCPU().PendingInterrupts().External {
+		t.Fatal("External interrupt not pending")
+	}
+	_, _ = sysCPU.cycle()
+	if sysCPU.PC != 0x404 {
+		t.Errorf("Disabled external taken PC: %06x", sysCPU.PC)
+	}
+
+	// Enabled, taken on next cycle.
+	sysCPU.lpsw(0x01000000, 0x00000400)
+	_, _ = sysCPU.cycle()
+	if testCPU().PendingInterrupts().External {
+		t.Error("External interrupt still pending")
+	}
+	if sysCPU.PC != 0x900 {
+		t.Errorf("External new PSW PC expected %06x got: %06x", 0x900, sysCPU.PC)
+	}
+	if v := memory.GetMemory(0x18); v != 0x01000040 {
+		t.Errorf("External old PSW 1 expected %08x got: %08x", 0x01000040, v)
+	}
+	if v := memory.GetMemory(0x1c); v != 0x00000400 {
+		t.Errorf("External old PSW 2 expected %08x got: %08x", 0x00000400, v)
+	}
+
+	// Machine check only when enabled.
+	testCPU().InjectMachineCheck()
+	sysCPU.lpsw(0x00000000, 0x00000400)
+	_, _ = sysCPU.cycle()
+	if !testCPU().PendingInterrupts().MachineCheck {
+		t.Error("Disabled machine check was taken")
+	}
+	sysCPU.lpsw(0x00040000, 0x00000400)
+	_, _ = sysCPU.cycle()
+	if testCPU().PendingInterrupts().MachineCheck {
+		t.Error("Machine check still pending")
+	}
+	if sysCPU.PC != 0xa00 {
+		t.Errorf("Machine check new PSW PC expected %06x got: %06x", 0xa00, sysCPU.PC)
+	}
+	if v := memory.GetMemory(0x30); v != 0x00040000 {
+		t.Errorf("Machine check old PSW 1 expected %08x got: %08x", 0x00040000, v)
+	}
+}
+
+// Interval timer interrupt held by CR0 submask, code stored at 0x86 in EC mode.
+func TestIntervalTimerMask(t *testing.T) {
+	setup()
+	defer func() { sysCPU.ecMode = false }()
+
+	memory.SetMemory(0x18, 0)
+	memory.SetMemory(0x1c, 0)
+	memory.SetMemory(0x84, 0)
+	memory.SetMemory(0x58, 0x00080000) // External new PSW, EC mode
+	memory.SetMemory(0x5c, 0x00000900)
+	memory.SetMemory(0x400, 0x47000000) // BC 0,0
+	memory.SetMemory(0x404, 0x47000000) // BC 0,0
+	memory.SetMemory(timer, 0x00000200)
+
+	sysCPU.cregs[0] = 0 // Interval timer masked
+	sysCPU.lpsw(0x01080000, 0x00000400)
+	sysCPU.updateClock()
+	if !testCPU().PendingInterrupts().Interval {
+		t.Fatal("Interval timer did not expire")
+	}
+	_, _ = sysCPU.cycle()
+	if sysCPU.PC != 0x404 {
+		t.Errorf("Masked interval timer taken PC: %06x", sysCPU.PC)
+	}
+	if !testCPU().PendingInterrupts().Interval {
+		t.Error("Masked interval timer no longer pending")
+	}
+
+	sysCPU.cregs[0] = 0x80 // Interval timer enabled
+	sysCPU.lpsw(0x00080000, 0x00000400)
+	_, _ = sysCPU.cycle()
+	if sysCPU.PC != 0x404 {
+		t.Errorf("Interval timer taken with external mask off PC: %06x", sysCPU.PC)
+	}
+
+	sysCPU.lpsw(0x01080000, 0x00000404)
+	_, _ = sysCPU.cycle()
+	if testCPU().PendingInterrupts().Interval {
+		t.Error("Interval timer still pending")
+	}
+	if sysCPU.PC != 0x900 {
+		t.Errorf("External new PSW PC expected %06x got: %06x", 0x900, sysCPU.PC)
+	}
+	if v := memory.GetMemory(0x84) & 0xffff; v != 0x80 {
+		t.Errorf("Interval timer code expected %04x got: %04x", 0x80, v)
+	}
+	if v := memory.GetMemory(0x18); v != 0x01080000 {
+		t.Errorf("External old PSW 1 expected %08x got: %08x", 0x01080000, v)
+	}
+	if v := memory.GetMemory(0x1c); v != 0x00000404 {
+		t.Errorf("External old PSW 2 expected %08x got: %08x", 0x00000404, v)
+	}
+}
+
+// Guest loop should run out of cycles.
+func TestRunUntilBudget(t *testing.T) {
+	setup()
+	sysCPU.PC = 0x400
+	memory.SetMemory(0x400, 0x47f00400) // B 400
+	memory.SetMemory(0x404, 0)
+
+	err := testCPU().RunUntil(func() bool { return sysCPU.PC == 0x404 }, 1000)
+	if !errors.Is(err, ErrCycleBudget) {
+		t.Errorf("RunUntil loop expected budget error got: %v", err)
+	}
+
+	// Falls through to end.
+	sysCPU.PC = 0x400
+	memory.SetMemory(0x400, 0x47000400) // BC 0,400
+	err = testCPU().RunUntil(func() bool { return sysCPU.PC == 0x404 }, 1000)
+	if err != nil {
+		t.Errorf("RunUntil fall through got: %v", err)
+	}
+}
@@ -25,23 +25,17 @@
 package cpu
 
 import (
-	"time"
-
+	event "github.com/rcornwell/S370/emu/event"
 	mem "github.com/rcornwell/S370/emu/memory"
 )
 
-// Update current interval timer and TOD clock.
-func UpdateTimer() {
-	sysCPU.updateClock()
-}
-
 // Set TOD to current date.
-func SetTod() {
-	if sysCPU.todSet {
+func (cpu *cpuState) setTod() {
+	if cpu.todSet {
 		return
 	}
 	// Get current time
-	now := time.Now()
+	now := event.TimeBase().Now()
 	lsec := uint64(now.Unix())
 
 	// IBM measures time from 1900, Unix starts at 1970
@@ -49,15 +43,15 @@ func SetTod() {
 	lsec += ((70 * 365) + 17) * 86400
 	lsec *= 1000000
 	lsec <<= 12
-	sysCPU.todClock[0] = uint32(lsec >> 32)
-	sysCPU.todClock[1] = uint32(lsec & uint64(FMASK))
+	cpu.todClock[0] = uint32(lsec >> 32)
+	cpu.todClock[1] = uint32(lsec & uint64(FMASK))
 }
 
 // Update the current interval and TOD clock.
 func (cpu *cpuState) updateClock() {
-	timeMem := mem.GetMemory(timer)
+	timeMem := mem.GetMemory(cpu.absAddr(timer))
 	timeMem -= 0x200 // 2 * 1/300 of second.
-	mem.SetMemory(timer, timeMem)
+	mem.SetMemory(cpu.absAddr(timer), timeMem)
 
 	// Check if should signal CPU
 	if (timeMem & 0xffffe00) == 0 {
@@ -0,0 +1,97 @@
+/*
+   CPU instruction trace.
+
+   Copyright (c) 2024, Richard Cornwell
+
+   Permission is hereby granted, free of charge, to any person obtaining a
+   copy of this software and associated documentation files (the "Software"),
+   to deal in the Software without restriction, including without limitation
+   the rights to use, copy, modify, merge, publish, distribute, sublicense,
+   and/or sell copies of the Software, and to permit persons to whom the
+   Software is furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in
+   all copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.  IN NO EVENT SHALL
+   ROBERT M SUPNIK BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+   IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+   CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+*/
+
+package cpu
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	disassembler "github.com/rcornwell/S370/emu/disassemble"
+	op "github.com/rcornwell/S370/emu/opcodemap"
+)
+
+// Turn instruction trace on or off.
+func SetTrace(enable bool) {
+	if enable {
+		debugMsk |= debugTrace
+	} else {
+		debugMsk &= ^debugTrace
+	}
+}
+
+// Disassemble instruction starting in high half of word1, return text and
+// length in bytes. Operands are formatted as by the disassembler package.
+func Disassemble(word1, word2 uint32) (string, int) {
+	inst := []byte{
+		byte(word1 >> 24), byte(word1 >> 16), byte(word1 >> 8), byte(word1),
+		byte(word2 >> 24), byte(word2 >> 16),
+	}
+	str, length := disassembler.Disassemble(inst)
+	mnemonic, operands, found := strings.Cut(str, " ")
+	if found {
+		str = mnemonic + " " + strings.TrimLeft(operands, " ")
+	}
+	return strings.TrimSpace(str), length
+}
+
+// Rebuild instruction bytes from decoded step, only needed when debugging.
+func (step *stepInfo) bytes() [6]byte {
+	return [6]byte{step.opcode, step.reg,
+		byte(step.address1 >> 8), byte(step.address1),
+		byte(step.address2 >> 8), byte(step.address2)}
+}
+
+// Compute effective address of storage operand before instruction runs.
+func (cpu *cpuState) traceAddr(step *stepInfo) uint32 {
+	addr := step.address1 & 0xfff
+	if base := (step.address1 >> 12) & 0xf; base != 0 {
+		addr += cpu.regs[base]
+	}
+	if (step.opcode&0xc0) == 0x40 && step.R2 != 0 {
+		addr += cpu.regs[step.R2]
+	}
+	return addr & AMASK
+}
+
+// Log instruction just executed along with condition code and register changed.
+func (cpu *cpuState) trace(pc uint32, inst []byte, addr uint32) {
+	str, _ := disassembler.Disassemble(inst)
+	args := []any{"pc", fmt.Sprintf("%06x", pc), "inst", str}
+	if (inst[0] & 0xc0) != 0 {
+		args = append(args, "addr", fmt.Sprintf("%06x", addr))
+	}
+	args = append(args, "cc", cpu.cc)
+	r1 := (inst[1] >> 4) & 0xf
+	switch {
+	case inst[0] == op.OpBC || inst[0] == op.OpBCR:
+		// R1 is a mask, not a register.
+	case (inst[0]&0xe0) == 0x00 || (inst[0]&0xe0) == 0x40 || (inst[0]&0xe0) == 0x80:
+		args = append(args, fmt.Sprintf("R%d", r1), fmt.Sprintf("%08x", cpu.regs[r1]))
+	case (inst[0]&0xe0) == 0x20 || (inst[0]&0xe0) == 0x60:
+		args = append(args, fmt.Sprintf("F%d", r1), fmt.Sprintf("%016x", cpu.fpregs[r1&0x6]))
+	}
+	slog.Debug("TRACE", args...)
+}
@@ -51,6 +51,26 @@ type cpuState struct {
 	progMask uint8      // Program mask
 	flags    uint8      // System flags
 	pageEnb  bool       // Paging enabled
+	prefix   uint32     // Prefix register, relocates low storage
+	cpuAddr  uint16     // CPU address stored by STAP
+
+	ibufAddr  uint32 // Virtual address of prefetched word
+	ibufGen   uint32 // Memory generation when word was fetched
+	ibufWord  uint32 // Prefetched instruction word
+	ibufValid bool   // Prefetch buffer holds valid word
+
+	step     stepInfo // Instruction being decoded and executed
+	memCycle int      // Memory cycles taken by current instruction
+
+	breakPoints map[uint32]bool // Armed instruction breakpoints
+	trapCodes   map[uint16]bool // Program interrupt codes that stop CPU
+	breakHit    bool            // Stopped at a breakpoint
+	breakAddr   uint32          // Address CPU stopped at
+	trapHit     bool            // Program interrupt held that stops CPU
+	trapPend    bool            // Program interrupt held until CPU continued
+	trapCode    uint16          // Interrupt code of held program interrupt
+	trapPC      uint32          // PC following instruction that caused it
+	restored    bool            // State was loaded from a checkpoint
 
 	tlb         [256]uint32 // Translation Lookaside Buffer
 	pageShift   uint32      // Amount to shift for page
@@ -79,6 +99,7 @@ type cpuState struct {
 	extIrq   bool      // External interrupt pending
 	intIrq   bool      // Interval timer interrupt
 	intEnb   bool      // Interval timer enable
+	mchkIrq  bool      // Machine check pending
 	todClock [2]uint32 // Current Time of Day Clock
 	todSet   bool      // TOD set to correct time
 
@@ -91,6 +112,7 @@ type cpuState struct {
 	timerTics int       // Interval Timer is ever 3 tics
 	vmAssist  bool      // VM Assist functions enabled.
 	vmaEnb    bool      // VM Assist enabled.
+	idle      bool      // Last cycle found nothing to do in wait state.
 	table     [256]func(*stepInfo) uint16
 }
 
@@ -203,6 +225,7 @@ const (
 	debugDetail
 	debugIO
 	debugIRQ
+	debugTrace
 )
 
 var debugOption = map[string]int{
@@ -212,6 +235,7 @@ var debugOption = map[string]int{
 	"DETAIL": debugDetail,
 	"IO":     debugIO,
 	"IRQ":    debugIRQ,
+	"TRACE":  debugTrace, // Log each instruction executed.
 }
 
 var debugMsk int
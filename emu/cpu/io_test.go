@@ -51,21 +51,16 @@ func ioSetup() *Td.TestDev {
 
 // Read byte from main memory.
 func getMemByte(addr uint32) uint8 {
-	v := mem.GetMemory(addr)
-	b := uint8((v >> (8 * (3 - (addr & 3))) & 0xff))
-	return b
+	return mem.GetByte(addr)
 }
 
 // write byte to main memory.
 func setMemByte(addr uint32, data uint32) {
-	off := 8 * (3 - (addr & 3))
-	m := uint32(0xff << off)
-	d := (data & 0xff) << off
-	mem.SetMemoryMask(addr, d, m)
+	mem.SetByte(addr, uint8(data))
 }
 
-// Run a test of an I/O instruction.
-func (cpu *cpuState) iotestInst(steps int) {
+// Set up to run I/O test program at 0x400.
+func (cpu *cpuState) ioStart() {
 	cpu.PC = 0x400
 	cpu.progMask = 0
 	cpu.sysMask = 0x0000
@@ -73,10 +68,24 @@ func (cpu *cpuState) iotestInst(steps int) {
 	mem.SetMemory(0x68, 0)
 	mem.SetMemory(0x6c, 0x800)
 	trapFlag = false
+}
+
+// Run I/O test program until done, fail test if it does not finish.
+func (cpu *cpuState) ioRunUntil(t *testing.T, done func() bool, maxCycles int) {
+	t.Helper()
+	cpu.ioStart()
+	if err := testCPU().RunUntil(done, maxCycles); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Run a test of an I/O instruction.
+func (cpu *cpuState) iotestInst(steps int) {
+	cpu.ioStart()
 	cy := 0
 	for range steps {
 		cy++
-		c, _ := CycleCPU()
+		c, _ := sysCPU.cycle()
 
 		if cpu.PC == 0x800 {
 			trapFlag = true
@@ -114,6 +123,31 @@ func TestCycleTch(t *testing.T) {
 	}
 }
 
+// Store channel ID for existing and missing channel.
+func TestCycleSTIDC(t *testing.T) {
+	_ = ioSetup()
+	mem.SetMemory(0xa8, 0xffffffff)
+
+	mem.SetMemory(0x400, 0xb2030000) // STIDC 000
+	mem.SetMemory(0x404, 0x0530b203) // BALR 3,0; STIDC 500
+	mem.SetMemory(0x408, 0x05000540) // BALR 4,0
+
+	sysCPU.iotestInst(20)
+
+	cc := (sysCPU.regs[3] >> 28) & 3
+	if cc != 0 {
+		t.Errorf("STIDC channel 0 expected cc %d got: %d", 0, cc)
+	}
+	cc = (sysCPU.regs[4] >> 28) & 3
+	if cc != 3 {
+		t.Errorf("STIDC channel 5 expected cc %d got: %d", 3, cc)
+	}
+	v := mem.GetMemory(0xa8)
+	if v != 0x10000000 {
+		t.Errorf("STIDC channel ID expected %08x got: %08x", 0x10000000, v)
+	}
+}
+
 func TestTestIO(t *testing.T) {
 	_ = ioSetup()
 
@@ -156,7 +190,7 @@ func TestCycleSIO(t *testing.T) {
 		mem.SetMemory(i, 0x55555555)
 	}
 
-	sysCPU.iotestInst(2000)
+	sysCPU.ioRunUntil(t, func() bool { return sysCPU.PC == 0x428 }, 20000)
 
 	v := mem.GetMemory(0x40)
 	if v != 0x00000508 {
@@ -205,7 +239,7 @@ func TestCycleSIO(t *testing.T) {
 	mem.SetMemory(0x608, 0xf8f9fafb)
 	mem.SetMemory(0x60C, 0xfcfdfeff)
 
-	sysCPU.iotestInst(2000)
+	sysCPU.ioRunUntil(t, func() bool { return sysCPU.PC == 0x428 }, 20000)
 
 	v = mem.GetMemory(0x40)
 	if v != 0x00000508 {
@@ -503,6 +537,42 @@ func TestCycleRead(t *testing.T) {
 	}
 }
 
+// Channel read over next instruction while it is held in prefetch buffer.
+func TestCycleReadPrefetch(t *testing.T) {
+	d := ioSetup()
+
+	// New word is BCTR 4,5; AR 2,3.
+	d.Data[0] = 0x06
+	d.Data[1] = 0x45
+	d.Data[2] = 0x1a
+	d.Data[3] = 0x23
+	d.Max = 4
+
+	sysCPU.regs[1] = 5
+	sysCPU.regs[3] = 7
+	sysCPU.regs[4] = 500
+	sysCPU.regs[5] = 0x408
+	mem.SetMemory(0x48, 0x500)
+
+	mem.SetMemory(0x400, 0x9c00000f) // SIO 00f
+	mem.SetMemory(0x404, 0x1b220700) // SR 2,2; NOPR
+	mem.SetMemory(0x408, 0x06451a21) // BCTR 4,5; AR 2,1
+	mem.SetMemory(0x40c, 0x00000000)
+
+	mem.SetMemory(0x500, 0x02000408) // Read into 0x408
+	mem.SetMemory(0x504, 0x00000004)
+
+	sysCPU.iotestInst(2000)
+
+	if sysCPU.regs[4] != 0 {
+		t.Errorf("Read over prefetch loop did not finish register 4 got: %08x", sysCPU.regs[4])
+	}
+	if sysCPU.regs[2] != 7 {
+		t.Errorf("Read over prefetch instruction not executed register 2 got: %08x wanted: %08x",
+			sysCPU.regs[2], 7)
+	}
+}
+
 func TestCycleReadShort(t *testing.T) {
 	d := ioSetup()
 
@@ -614,6 +684,70 @@ func TestCycleReadShortSLI(t *testing.T) {
 	}
 }
 
+// Read longer than device record, incorrect length unless SLI.
+func TestCycleReadLong(t *testing.T) {
+	for _, sli := range []bool{false, true} {
+		d := ioSetup()
+
+		// Device record is 0x10 bytes.
+		for i := range 0x10 {
+			d.Data[i] = uint8(0x10 + i)
+		}
+		d.Max = 0x10
+
+		mem.SetMemory(0x40, 0xffffffff)
+		mem.SetMemory(0x44, 0xffffffff)
+		mem.SetMemory(0x78, 0)
+		mem.SetMemory(0x7c, 0x420)
+		mem.SetMemory(0x48, 0x500)
+
+		mem.SetMemory(0x400, 0x9c00000f) // SIO 00f
+		mem.SetMemory(0x404, 0x82000410) // LPSW 0410
+		mem.SetMemory(0x408, 0x47000408) // Dummy instruction
+		mem.SetMemory(0x420, 0x9d00000f) // TIO 00f
+		mem.SetMemory(0x424, 0x47700420) // BC  7,420
+		mem.SetMemory(0x410, 0xff060000) // Wait PSW
+		mem.SetMemory(0x414, 0x14000408)
+
+		mem.SetMemory(0x500, 0x02000600) // Set channel words
+		csw2 := uint32(0x0c400010)
+		if sli {
+			mem.SetMemory(0x504, 0x20000020)
+			csw2 = 0x0c000010
+		} else {
+			mem.SetMemory(0x504, 0x00000020)
+		}
+
+		for i := uint32(0x600); i < 0x640; i += 4 {
+			mem.SetMemory(i, 0x55555555)
+		}
+
+		sysCPU.iotestInst(2000)
+
+		v := mem.GetMemory(0x40)
+		if v != 0x00000508 {
+			t.Errorf("Start I/O Read Long SLI=%v CSW1 expected %08x got: %08x", sli, 0x00000508, v)
+		}
+		v = mem.GetMemory(0x44)
+		if v != csw2 {
+			t.Errorf("Start I/O Read Long SLI=%v CSW2 expected %08x got: %08x", sli, csw2, v)
+		}
+
+		for i := range 0x10 {
+			vb := getMemByte(uint32(0x600 + i))
+			if vb != uint8(0x10+i) {
+				t.Errorf("Start I/O Read Long Data expected %02x got: %02x at: %02x", 0x10+i, vb, i)
+			}
+		}
+		for i := range 0x10 {
+			vb := getMemByte(uint32(0x610 + i))
+			if vb != 0x55 {
+				t.Errorf("Start I/O Read Long Data expected %02x got: %02x at: %02x", 0x55, vb, i)
+			}
+		}
+	}
+}
+
 func TestCycleWrite(t *testing.T) {
 	d := ioSetup()
 
@@ -969,6 +1103,78 @@ func TestCycleReadCDASkip(t *testing.T) {
 	}
 }
 
+// Skip longer than record chained to data read, only data CCW stores.
+func TestCycleReadSkipChain(t *testing.T) {
+	d := ioSetup()
+	for i := range 0x10 {
+		d.Data[i] = uint8(0x10 + i)
+	}
+	d.Max = 0x10
+
+	mem.SetMemory(0x40, 0xffffffff)
+	mem.SetMemory(0x44, 0xffffffff)
+	mem.SetMemory(0x78, 0)
+	mem.SetMemory(0x7c, 0x420)
+	mem.SetMemory(0x48, 0x500)
+
+	mem.SetMemory(0x400, 0x9c00000f) // SIO 00f
+	mem.SetMemory(0x404, 0x82000410) // LPSW 0410
+	mem.SetMemory(0x408, 0x47000408) // Dummy instruction
+	mem.SetMemory(0x420, 0x9d00000f) // TIO 00f
+	mem.SetMemory(0x424, 0x47700420) // BC  7,420
+	mem.SetMemory(0x410, 0xff060000) // Wait PSW
+	mem.SetMemory(0x414, 0x14000408)
+
+	mem.SetMemory(0x500, 0x02000600) // Read skip, command chain, SLI
+	mem.SetMemory(0x504, 0x70000020)
+	mem.SetMemory(0x508, 0x02000700) // Read, SLI
+	mem.SetMemory(0x50c, 0x20000018)
+
+	for i := uint32(0); i < 0x20; i += 4 {
+		mem.SetMemory(0x600+i, 0x55555555)
+		mem.SetMemory(0x700+i, 0x55555555)
+	}
+	sysCPU.iotestInst(2000)
+
+	if v := mem.GetMemory(0x40); v != 0x00000510 {
+		t.Errorf("Read skip chain CSW1 expected %08x got: %08x", 0x00000510, v)
+	}
+	if v := mem.GetMemory(0x44); v != 0x0c000008 {
+		t.Errorf("Read skip chain CSW2 expected %08x got: %08x", 0x0c000008, v)
+	}
+	for i := range uint32(0x20) {
+		if vb := getMemByte(0x600 + i); vb != 0x55 {
+			t.Errorf("Read skip chain stored %02x at: %08x", vb, 0x600+i)
+		}
+		mb := uint8(0x55)
+		if i < 0x10 {
+			mb = uint8(0x10 + i)
+		}
+		if vb := getMemByte(0x700 + i); vb != mb {
+			t.Errorf("Read skip chain expected %02x got: %02x at: %08x", mb, vb, 0x700+i)
+		}
+	}
+
+	// Without SLI incorrect length ends chain at skip CCW.
+	mem.SetMemory(0x504, 0x50000020)
+	for i := uint32(0); i < 0x20; i += 4 {
+		mem.SetMemory(0x700+i, 0x55555555)
+	}
+	sysCPU.iotestInst(2000)
+
+	if v := mem.GetMemory(0x40); v != 0x00000508 {
+		t.Errorf("Read skip length CSW1 expected %08x got: %08x", 0x00000508, v)
+	}
+	if v := mem.GetMemory(0x44); v != 0x0c400010 {
+		t.Errorf("Read skip length CSW2 expected %08x got: %08x", 0x0c400010, v)
+	}
+	for i := range uint32(0x20) {
+		if vb := getMemByte(0x700 + i); vb != 0x55 {
+			t.Errorf("Read skip length stored %02x at: %08x", vb, 0x700+i)
+		}
+	}
+}
+
 func TestCycleReadBkwd(t *testing.T) {
 	d := ioSetup()
 
@@ -1425,6 +1631,66 @@ func TestCycleSMSTic(t *testing.T) {
 	}
 }
 
+// Device requests command retry on read.
+func TestCycleRetry(t *testing.T) {
+	d := ioSetup()
+
+	// Load Data
+	for i := range 0x10 {
+		d.Data[i] = uint8(0x10 + i)
+	}
+	d.Max = 0x10
+	d.Retry = true
+
+	mem.SetMemory(0x40, 0xffffffff)
+	mem.SetMemory(0x44, 0xffffffff)
+	mem.SetMemory(0x78, 0)
+	mem.SetMemory(0x7c, 0x420)
+	mem.SetMemory(0x48, 0x500)
+
+	mem.SetMemory(0x400, 0x9c00000f) // SIO 00f
+	mem.SetMemory(0x404, 0x82000410) // LPSW 0410
+	mem.SetMemory(0x408, 0x47000408) // Dummy instruction
+	mem.SetMemory(0x420, 0x9d00000f) // TIO 00f
+	mem.SetMemory(0x424, 0x47700420) // BC  7,420
+	mem.SetMemory(0x410, 0xff060000) // Wait PSW
+	mem.SetMemory(0x414, 0x14000408)
+
+	mem.SetMemory(0x500, 0x02000600) // Set channel words
+	mem.SetMemory(0x504, 0x00000010)
+
+	for i := uint32(0x600); i < 0x614; i += 4 {
+		mem.SetMemory(i, 0x55555555)
+	}
+
+	sysCPU.iotestInst(2000)
+
+	v := mem.GetMemory(0x40)
+	if v != 0x00000508 {
+		t.Errorf("Start I/O Retry CSW1 expected %08x got: %08x", 0x00000508, v)
+	}
+	v = mem.GetMemory(0x44)
+	if v != 0x0c000000 {
+		t.Errorf("Start I/O Retry CSW2 expected %08x got: %08x", 0x0c000000, v)
+	}
+
+	if d.Retry {
+		t.Errorf("Start I/O Retry not requested")
+	}
+
+	for i := range uint32(0x10) {
+		b := getMemByte(0x600 + i)
+		mb := uint8(0x10 + i)
+		if b != mb {
+			t.Errorf("Start I/O Retry Data expected %02x got: %02x at: %02x", mb, b, i)
+		}
+	}
+	v = mem.GetMemory(0x610)
+	if v != 0x55555555 {
+		t.Errorf("Start I/O Retry overran data got: %08x", v)
+	}
+}
+
 // Test if PCI interrupts work.
 func TestCyclePCI(t *testing.T) {
 	d := ioSetup()
@@ -1502,6 +1768,79 @@ func TestCyclePCI(t *testing.T) {
 	}
 }
 
+// PCI handler modifies a later CCW before channel fetches it.
+func TestCyclePCIModify(t *testing.T) {
+	d := ioSetup()
+	// Load Data
+	for i := range 0x40 {
+		d.Data[i] = uint8(0x10 + i)
+	}
+	d.Max = 0x40
+
+	mem.SetMemory(0x40, 0xffffffff)
+	mem.SetMemory(0x44, 0xffffffff)
+	mem.SetMemory(0x78, 0)
+	mem.SetMemory(0x7c, 0x408)
+	mem.SetMemory(0x48, 0x500)
+
+	mem.SetMemory(0x400, 0x9c00000f) // SIO 00f
+	mem.SetMemory(0x404, 0x82000430) // LPSW 0430
+	mem.SetMemory(0x408, 0x58000040) // L 0, 040
+	mem.SetMemory(0x40c, 0x58100044) // L 1, 044
+	mem.SetMemory(0x410, 0x92080517) // MVI 517,8
+	mem.SetMemory(0x414, 0x41200440) // LA 2,440
+	mem.SetMemory(0x418, 0x5020007c) // ST 2,07c
+	mem.SetMemory(0x41c, 0x82000438) // LPSW 0438
+	mem.SetMemory(0x440, 0x9d00000f) // TIO 00f
+	mem.SetMemory(0x444, 0x47700440) // BC  7,440
+	mem.SetMemory(0x448, 0)
+	mem.SetMemory(0x430, 0xff060000) // Wait PSW
+	mem.SetMemory(0x434, 0x14000404)
+	mem.SetMemory(0x438, 0xff060000) // Wait PSW
+	mem.SetMemory(0x43c, 0x14000438)
+
+	mem.SetMemory(0x500, 0x02000600) // Set channel words
+	mem.SetMemory(0x504, 0x68000004)
+	mem.SetMemory(0x508, 0x02000604)
+	mem.SetMemory(0x50c, 0x60000010)
+	mem.SetMemory(0x510, 0x02000614)
+	mem.SetMemory(0x514, 0x20000004)
+
+	for i := uint32(0x600); i < 0x624; i += 4 {
+		mem.SetMemory(i, 0x55555555) // Invalid data
+	}
+
+	sysCPU.iotestInst(2000)
+
+	v := sysCPU.regs[1] & HMASK
+	if v != 0x00800000 {
+		t.Errorf("PCI modify CSW2 PCI expected %08x got: %08x", 0x00800000, v)
+	}
+
+	v = mem.GetMemory(0x40)
+	if v != 0x00000518 {
+		t.Errorf("PCI modify CSW1 expected %08x got: %08x", 0x00000518, v)
+	}
+	v = mem.GetMemory(0x44)
+	if v != 0x0c000000 {
+		t.Errorf("PCI modify CSW2 expected %08x got: %08x", 0x0c000000, v)
+	}
+
+	// Third CCW should have transferred new count.
+	v = mem.GetMemory(0x614)
+	if v != 0x10111213 {
+		t.Errorf("PCI modify data expected %08x got: %08x", 0x10111213, v)
+	}
+	v = mem.GetMemory(0x618)
+	if v != 0x14151617 {
+		t.Errorf("PCI modify data expected %08x got: %08x", 0x14151617, v)
+	}
+	v = mem.GetMemory(0x61c)
+	if v != 0x55555555 {
+		t.Errorf("PCI modify overran data expected %08x got: %08x", 0x55555555, v)
+	}
+}
+
 func TestCycleHaltIO1(t *testing.T) {
 	d := ioSetup()
 
@@ -1925,3 +2264,578 @@ func TestCycleBusy(t *testing.T) {
 		t.Errorf("Start I/O Busy CSW2 expected %08x got: %08x", 0x04000000, v)
 	}
 }
+
+// Halt device on running read.
+func TestCycleHaltDevice(t *testing.T) {
+	d := ioSetup()
+	// Load Data
+	for i := range 0x80 {
+		d.Data[i] = uint8(0x10 + i)
+	}
+	d.Max = 0x80
+
+	mem.SetMemory(0x40, 0xffffffff)
+	mem.SetMemory(0x44, 0xffffffff)
+	mem.SetMemory(0x78, 0)
+	mem.SetMemory(0x7c, 0x440)
+	mem.SetMemory(0x48, 0x500)
+
+	mem.SetMemory(0x400, 0x9c00000f) // SIO 00f
+	mem.SetMemory(0x404, 0x41200008) // LA 2,8
+	mem.SetMemory(0x408, 0x46200408) // BCT 2,408
+	mem.SetMemory(0x40c, 0x9e01000f) // HDV 00f
+	mem.SetMemory(0x410, 0x58000040) // L 0, 040
+	mem.SetMemory(0x414, 0x58100044) // L 1, 044
+	mem.SetMemory(0x418, 0x05308200) // BALR 3,0, LPSW 430
+	mem.SetMemory(0x41c, 0x04300000)
+	mem.SetMemory(0x420, 0x47000420) // Dummy instruction
+	mem.SetMemory(0x430, 0xff060000) // Wait PSW
+	mem.SetMemory(0x434, 0x14000420)
+	mem.SetMemory(0x440, 0)
+
+	mem.SetMemory(0x500, 0x02000600) // Set channel words
+	mem.SetMemory(0x504, 0x00000080)
+	for i := range uint32(0x40) {
+		mem.SetMemory(0x600+(i*4), 0x55555555) // Invalid data
+	}
+
+	sysCPU.iotestInst(2000)
+
+	cc := (sysCPU.regs[3] >> 28) & 3
+	if cc != 1 {
+		t.Errorf("Halt Device expected cc %d got: %d", 1, cc)
+	}
+
+	v := sysCPU.regs[0]
+	if v != 0x00000508 {
+		t.Errorf("Halt Device CSW1 expected %08x got: %08x", 0x00000508, v)
+	}
+
+	residual := sysCPU.regs[1] & LMASK
+	if residual == 0 || residual >= 0x80 {
+		t.Errorf("Halt Device residual count invalid got: %04x", residual)
+	}
+
+	// Interrupt should have same residual count.
+	v = mem.GetMemory(0x44)
+	if v != (0x0c000000 | residual) {
+		t.Errorf("Halt Device CSW2 expected %08x got: %08x", 0x0c000000|residual, v)
+	}
+
+	count := 0x80 - residual
+	for i := range uint32(0x80) {
+		b := getMemByte(0x600 + i)
+		mb := uint8(0x10 + i)
+		if i >= count {
+			mb = 0x55
+		}
+		if b != mb {
+			t.Errorf("Halt Device Data expected %02x got: %02x at: %02x", mb, b, i)
+		}
+	}
+}
+
+// Clear I/O on running read.
+func TestCycleClearIO(t *testing.T) {
+	d := ioSetup()
+	// Load Data
+	for i := range 0x80 {
+		d.Data[i] = uint8(0x10 + i)
+	}
+	d.Max = 0x80
+
+	mem.SetMemory(0x40, 0xffffffff)
+	mem.SetMemory(0x44, 0xffffffff)
+	mem.SetMemory(0x48, 0x500)
+
+	mem.SetMemory(0x400, 0x9c00000f) // SIO 00f
+	mem.SetMemory(0x404, 0x41200008) // LA 2,8
+	mem.SetMemory(0x408, 0x46200408) // BCT 2,408
+	mem.SetMemory(0x40c, 0x9d01000f) // CLRIO 00f
+	mem.SetMemory(0x410, 0x05300000) // BALR 3,0
+
+	mem.SetMemory(0x500, 0x02000600) // Set channel words
+	mem.SetMemory(0x504, 0x00000080)
+
+	sysCPU.iotestInst(2000)
+
+	cc := (sysCPU.regs[3] >> 28) & 3
+	if cc != 1 {
+		t.Errorf("Clear I/O expected cc %d got: %d", 1, cc)
+	}
+
+	v := mem.GetMemory(0x40)
+	if v != 0x00000508 {
+		t.Errorf("Clear I/O CSW1 expected %08x got: %08x", 0x00000508, v)
+	}
+	residual := mem.GetMemory(0x44) & LMASK
+	if residual == 0 || residual >= 0x80 {
+		t.Errorf("Clear I/O residual count invalid got: %04x", residual)
+	}
+	// Transfer was cut off, no status yet.
+	if status := mem.GetMemory(0x44) >> 16; status != 0 {
+		t.Errorf("Clear I/O status expected %04x got: %04x", 0, status)
+	}
+
+	// Subchannel should now be available.
+	cc = uint32(ch.ClearIO(0xf))
+	if cc != 0 {
+		t.Errorf("Clear I/O second expected cc %d got: %d", 0, cc)
+	}
+
+	// Let device finish.
+	for range 100 {
+		ev.Advance(10)
+	}
+	if ch.TestIO(0xf) != 0 {
+		t.Errorf("Clear I/O device not available")
+	}
+
+	// Halted device should not post an interrupt for the cleared operation.
+	if d := ch.ChanScan(0xffff, true); d != dev.NoDev {
+		t.Errorf("Clear I/O interrupt pending for: %03x", d)
+	}
+}
+
+// Clear I/O with ending status pending stores it and clears interrupt.
+func TestCycleClearIOPending(t *testing.T) {
+	_ = ioSetup()
+
+	mem.SetMemory(0x48, 0x500)
+	mem.SetMemory(0x500, 0x02000600) // Read
+	mem.SetMemory(0x504, 0x00000010)
+	if cc := ch.StartIO(0xf); cc != 0 {
+		t.Fatalf("Clear I/O start expected cc %d got: %d", 0, cc)
+	}
+	for range 200 {
+		ev.Advance(1)
+	}
+
+	mem.SetMemory(0x40, 0xffffffff)
+	mem.SetMemory(0x44, 0xffffffff)
+	if cc := ch.ClearIO(0xf); cc != 1 {
+		t.Errorf("Clear I/O pending expected cc %d got: %d", 1, cc)
+	}
+	v := mem.GetMemory(0x40)
+	if v != 0x00000508 {
+		t.Errorf("Clear I/O CSW1 expected %08x got: %08x", 0x00000508, v)
+	}
+	v = mem.GetMemory(0x44)
+	if v != 0x0c000000 {
+		t.Errorf("Clear I/O CSW2 expected %08x got: %08x", 0x0c000000, v)
+	}
+
+	// Interrupt should be gone.
+	if d := ch.ChanScan(0xffff, true); d != dev.NoDev {
+		t.Errorf("Clear I/O interrupt still pending for: %03x", d)
+	}
+	if cc := ch.ClearIO(0xf); cc != 0 {
+		t.Errorf("Clear I/O second expected cc %d got: %d", 0, cc)
+	}
+	if cc := ch.TestIO(0xf); cc != 0 {
+		t.Errorf("Clear I/O Test I/O expected cc %d got: %d", 0, cc)
+	}
+
+	// No device, not operational.
+	if cc := ch.ClearIO(0x1f); cc != 3 {
+		t.Errorf("Clear I/O no device expected cc %d got: %d", 3, cc)
+	}
+}
+
+// Seek on one block multiplexer device lets another device transfer.
+func TestBlockMuxDisconnect(t *testing.T) {
+	_ = ioSetup()
+	ch.AddChannel(1, dev.TypeBMux, 0)
+	ch.SetBMUXenable(true)
+	defer ch.SetBMUXenable(false)
+
+	dA := &Td.TestDev{Addr: 0x110, Mask: 0xff}
+	dB := &Td.TestDev{Addr: 0x120, Mask: 0xff}
+	ch.AddDevice(dA, nil, 0x110)
+	ch.AddDevice(dB, nil, 0x120)
+	_ = dA.InitDev()
+	_ = dB.InitDev()
+	dA.Delay = 50
+	for i := range 0x10 {
+		dA.Data[i] = uint8(0xa0 + i)
+		dB.Data[i] = uint8(0xb0 + i)
+	}
+	dA.Max = 0x10
+	dB.Max = 0x10
+
+	mem.SetMemory(0x500, 0x07000600) // Seek
+	mem.SetMemory(0x504, 0x40000006)
+	mem.SetMemory(0x508, 0x02000700) // Read
+	mem.SetMemory(0x50c, 0x00000010)
+	mem.SetMemory(0x540, 0x02000740) // Read
+	mem.SetMemory(0x544, 0x00000010)
+	mem.SetMemory(0x600, 0x00010002)
+	mem.SetMemory(0x604, 0x0003ffff)
+
+	mem.SetMemory(0x48, 0x500)
+	if cc := ch.StartIO(0x110); cc != 0 {
+		t.Fatalf("Start I/O 110 expected cc %d got: %d", 0, cc)
+	}
+
+	// Channel is connected to seek transfer.
+	mem.SetMemory(0x48, 0x540)
+	if cc := ch.StartIO(0x120); cc != 2 {
+		t.Errorf("Start I/O 120 during seek expected cc %d got: %d", 2, cc)
+	}
+
+	order := []uint16{}
+	started := false
+	for range 2000 {
+		ev.Advance(1)
+		if !started {
+			mem.SetMemory(0x48, 0x540)
+			cc := ch.StartIO(0x120)
+			if cc == 0 {
+				started = true
+			} else if cc != 2 {
+				t.Fatalf("Start I/O 120 expected cc %d got: %d", 0, cc)
+			}
+		}
+		d := ch.ChanScan(0xffff, true)
+		if d != dev.NoDev {
+			csw := mem.GetMemory(0x44)
+			if csw != 0x0c000000 {
+				t.Errorf("Device %03x CSW2 expected %08x got: %08x", d, 0x0c000000, csw)
+			}
+			order = append(order, d)
+			if len(order) == 2 {
+				break
+			}
+		}
+	}
+
+	if len(order) != 2 || order[0] != 0x120 || order[1] != 0x110 {
+		t.Errorf("Block multiplexer completion order expected [120 110] got: %03x", order)
+	}
+	for i := range uint32(0x10) {
+		b := getMemByte(0x700 + i)
+		if b != uint8(0xa0+i) {
+			t.Errorf("Device 110 invalid data %02x expected: %02x got %02x", i, 0xa0+i, b)
+		}
+		b = getMemByte(0x740 + i)
+		if b != uint8(0xb0+i) {
+			t.Errorf("Device 120 invalid data %02x expected: %02x got %02x", i, 0xb0+i, b)
+		}
+	}
+}
+
+// Busy shared subchannel presents channel available once free.
+func TestChannelAvailable(t *testing.T) {
+	_ = ioSetup()
+	if err := ch.SetChanAvail(0, true); err != nil {
+		t.Fatal(err)
+	}
+	dA := &Td.TestDev{Addr: 0xc0, Mask: 0xff}
+	dB := &Td.TestDev{Addr: 0xc1, Mask: 0xff}
+	ch.AddDevice(dA, nil, 0xc0)
+	ch.AddDevice(dB, nil, 0xc1)
+	_ = dA.InitDev()
+	_ = dB.InitDev()
+	for i := range 0x10 {
+		dA.Data[i] = uint8(0xa0 + i)
+	}
+	dA.Max = 0x10
+
+	mem.SetMemory(0x500, 0x02000700) // Read
+	mem.SetMemory(0x504, 0x00000010)
+	mem.SetMemory(0x48, 0x500)
+	if cc := ch.StartIO(0xc0); cc != 0 {
+		t.Fatalf("Start I/O 0c0 expected cc %d got: %d", 0, cc)
+	}
+
+	// Second device shares the subchannel.
+	if cc := ch.StartIO(0xc1); cc != 2 {
+		t.Errorf("Start I/O 0c1 expected cc %d got: %d", 2, cc)
+	}
+
+	order := []uint16{}
+	for range 1000 {
+		ev.Advance(1)
+		d := ch.ChanScan(0xffff, true)
+		if d != dev.NoDev {
+			order = append(order, d)
+			if d == 0x000 {
+				csw1 := mem.GetMemory(0x40)
+				csw2 := mem.GetMemory(0x44)
+				if csw1 != 0 || csw2 != 0 {
+					t.Errorf("Channel available CSW expected 0 got: %08x %08x", csw1, csw2)
+				}
+				break
+			}
+		}
+	}
+
+	if len(order) != 2 || order[0] != 0x0c0 || order[1] != 0x000 {
+		t.Errorf("Channel available order expected [0c0 000] got: %03x", order)
+	}
+
+	// Only presented once.
+	for range 100 {
+		ev.Advance(1)
+		if d := ch.ChanScan(0xffff, true); d != dev.NoDev {
+			t.Errorf("Unexpected interrupt from: %03x", d)
+		}
+	}
+
+	// Channel now free for second device.
+	if cc := ch.StartIO(0xc1); cc != 0 {
+		t.Errorf("Start I/O 0c1 after free expected cc %d got: %d", 0, cc)
+	}
+}
+
+// Channel available interrupt not presented unless enabled.
+func TestChannelAvailableOff(t *testing.T) {
+	_ = ioSetup()
+	dA := &Td.TestDev{Addr: 0xc0, Mask: 0xff}
+	dB := &Td.TestDev{Addr: 0xc1, Mask: 0xff}
+	ch.AddDevice(dA, nil, 0xc0)
+	ch.AddDevice(dB, nil, 0xc1)
+	_ = dA.InitDev()
+	_ = dB.InitDev()
+	dA.Max = 0x10
+
+	mem.SetMemory(0x500, 0x02000700) // Read
+	mem.SetMemory(0x504, 0x00000010)
+	mem.SetMemory(0x48, 0x500)
+	if cc := ch.StartIO(0xc0); cc != 0 {
+		t.Fatalf("Start I/O 0c0 expected cc %d got: %d", 0, cc)
+	}
+	if cc := ch.StartIO(0xc1); cc != 2 {
+		t.Errorf("Start I/O 0c1 expected cc %d got: %d", 2, cc)
+	}
+
+	order := []uint16{}
+	for range 1000 {
+		ev.Advance(1)
+		if d := ch.ChanScan(0xffff, true); d != dev.NoDev {
+			order = append(order, d)
+		}
+	}
+
+	if len(order) != 1 || order[0] != 0x0c0 {
+		t.Errorf("Channel available disabled order expected [0c0] got: %03x", order)
+	}
+}
+
+// Device no longer owning a shared subchannel must not transfer or end.
+func TestCycleStaleDevice(t *testing.T) {
+	_ = ioSetup()
+	for _, addr := range []uint16{0xc0, 0xc1} {
+		d := &Td.TestDev{Addr: addr, Mask: 0xff}
+		ch.AddDevice(d, nil, addr)
+		_ = d.InitDev()
+		d.Max = 0x10
+	}
+
+	mem.SetMemory(0x40, 0xffffffff)
+	mem.SetMemory(0x44, 0xffffffff)
+	mem.SetMemory(0x48, 0x500)
+	mem.SetMemory(0x500, 0x02000600) // Read 16 bytes
+	mem.SetMemory(0x504, 0x00000010)
+	mem.SetMemory(0x600, 0xffffffff)
+
+	mem.SetMemory(0x400, 0x9c0000c0) // SIO 0c0
+	mem.SetMemory(0x404, 0)
+	sysCPU.iotestInst(3)
+	if sysCPU.cc != 0 {
+		t.Errorf("Stale SIO CC expected %d got: %d", 0, sysCPU.cc)
+	}
+
+	// 0c1 no longer connected, all requests should be refused.
+	if !ch.ChanWriteByte(0xc1, 0x55) {
+		t.Errorf("Stale write not aborted")
+	}
+	if v := mem.GetMemory(0x600); v != 0xffffffff {
+		t.Errorf("Stale write data expected %08x got: %08x", 0xffffffff, v)
+	}
+	if _, end := ch.ChanReadByte(0xc1); !end {
+		t.Errorf("Stale read not aborted")
+	}
+	ch.ChanEnd(0xc1, dev.CStatusChnEnd|dev.CStatusDevEnd)
+
+	// Subchannel still working for 0c0.
+	mem.SetMemory(0x400, 0x9d0000c1) // TIO 0c1
+	sysCPU.iotestInst(3)
+	if sysCPU.cc != 2 {
+		t.Errorf("Stale TIO busy CC expected %d got: %d", 2, sysCPU.cc)
+	}
+
+	for ev.AnyEvent() {
+		ev.Advance(1)
+	}
+	mem.SetMemory(0x400, 0x9d0000c0) // TIO 0c0
+	sysCPU.iotestInst(3)
+	if sysCPU.cc != 1 {
+		t.Errorf("Stale TIO status CC expected %d got: %d", 1, sysCPU.cc)
+	}
+	if v := mem.GetMemory(0x40); v != 0x00000508 {
+		t.Errorf("Stale TIO status CSW1 expected %08x got: %08x", 0x00000508, v)
+	}
+	if v := mem.GetMemory(0x44); v != 0x0c000000 {
+		t.Errorf("Stale TIO status CSW2 expected %08x got: %08x", 0x0c000000, v)
+	}
+}
+
+// Two devices on a shared subchannel, TIO to one while other is busy.
+func TestCycleTIOShared(t *testing.T) {
+	_ = ioSetup()
+	for _, addr := range []uint16{0xc0, 0xc1} {
+		d := &Td.TestDev{Addr: addr, Mask: 0xff}
+		ch.AddDevice(d, nil, addr)
+		_ = d.InitDev()
+		d.Max = 0x10
+	}
+
+	mem.SetMemory(0x40, 0xffffffff)
+	mem.SetMemory(0x44, 0xffffffff)
+	mem.SetMemory(0x48, 0x500)
+	mem.SetMemory(0x500, 0x02000600) // Read 16 bytes
+	mem.SetMemory(0x504, 0x00000010)
+
+	mem.SetMemory(0x400, 0x9c0000c0) // SIO 0c0
+	mem.SetMemory(0x404, 0x9d0000c1) // TIO 0c1
+	mem.SetMemory(0x408, 0)
+	sysCPU.iotestInst(10)
+	if sysCPU.cc != 2 {
+		t.Errorf("TIO shared busy CC expected %d got: %d", 2, sysCPU.cc)
+	}
+	if v := mem.GetMemory(0x44); v != 0xffffffff {
+		t.Errorf("TIO shared busy CSW2 expected %08x got: %08x", 0xffffffff, v)
+	}
+
+	// Let read finish, status is held for 0c0.
+	for ev.AnyEvent() {
+		ev.Advance(1)
+	}
+	mem.SetMemory(0x400, 0x9d0000c1) // TIO 0c1
+	mem.SetMemory(0x404, 0)
+	sysCPU.iotestInst(10)
+	if sysCPU.cc != 2 {
+		t.Errorf("TIO shared pending CC expected %d got: %d", 2, sysCPU.cc)
+	}
+	if v := mem.GetMemory(0x44); v != 0xffffffff {
+		t.Errorf("TIO shared pending CSW2 expected %08x got: %08x", 0xffffffff, v)
+	}
+
+	mem.SetMemory(0x400, 0x9d0000c0) // TIO 0c0
+	sysCPU.iotestInst(10)
+	if sysCPU.cc != 1 {
+		t.Errorf("TIO shared status CC expected %d got: %d", 1, sysCPU.cc)
+	}
+	if v := mem.GetMemory(0x40); v != 0x00000508 {
+		t.Errorf("TIO shared status CSW1 expected %08x got: %08x", 0x00000508, v)
+	}
+	if v := mem.GetMemory(0x44); v != 0x0c000000 {
+		t.Errorf("TIO shared status CSW2 expected %08x got: %08x", 0x0c000000, v)
+	}
+
+	mem.SetMemory(0x400, 0x9d0000c1) // TIO 0c1
+	sysCPU.iotestInst(10)
+	if sysCPU.cc != 0 {
+		t.Errorf("TIO shared free CC expected %d got: %d", 0, sysCPU.cc)
+	}
+
+	// Channel end only, device stays busy.
+	mem.SetMemory(0x44, 0xffffffff)
+	mem.SetMemory(0x500, 0x13000600)
+	mem.SetMemory(0x504, 0x00000001)
+	mem.SetMemory(0x400, 0x9c0000c1) // SIO 0c1
+	mem.SetMemory(0x404, 0x9d0000c1) // TIO 0c1
+	mem.SetMemory(0x408, 0)
+	sysCPU.iotestInst(3)
+	if sysCPU.cc != 1 {
+		t.Errorf("TIO device busy CC expected %d got: %d", 1, sysCPU.cc)
+	}
+	if v := mem.GetMemory(0x44) & 0xffff0000; v != 0x10000000 {
+		t.Errorf("TIO device busy CSW2 expected %08x got: %08x", 0x10000000, v)
+	}
+}
+
+// Idle device raises attention, taken once I/O interrupts enabled.
+func TestCycleAttention(t *testing.T) {
+	_ = ioSetup()
+
+	mem.SetMemory(0x38, 0)
+	mem.SetMemory(0x3c, 0)
+	mem.SetMemory(0x40, 0xffffffff)
+	mem.SetMemory(0x44, 0xffffffff)
+	mem.SetMemory(0x78, 0x00060000) // I/O new PSW
+	mem.SetMemory(0x7c, 0x00000420)
+
+	mem.SetMemory(0x400, 0x47000400) // BC 0,400
+	mem.SetMemory(0x404, 0)
+	mem.SetMemory(0x420, 0)
+
+	ch.PostAttention(0xf)
+
+	// Not taken while channel masked.
+	sysCPU.iotestInst(10)
+	if sysCPU.PC == 0x420 {
+		t.Fatal("Attention taken while masked")
+	}
+
+	mem.SetMemory(0x400, 0x82000410) // LPSW 410
+	mem.SetMemory(0x408, 0x47000408) // Dummy instruction
+	mem.SetMemory(0x410, 0xff060000) // Enabled wait PSW
+	mem.SetMemory(0x414, 0x14000408)
+	sysCPU.ioRunUntil(t, func() bool { return sysCPU.PC == 0x420 }, 1000)
+
+	if v := mem.GetMemory(0x38); v != 0xff06000f {
+		t.Errorf("Attention OIOPSW1 expected %08x got: %08x", 0xff06000f, v)
+	}
+	if v := mem.GetMemory(0x40); v != 0 {
+		t.Errorf("Attention CSW1 expected %08x got: %08x", 0, v)
+	}
+	if v := mem.GetMemory(0x44); v != 0x80000000 {
+		t.Errorf("Attention CSW2 expected %08x got: %08x", 0x80000000, v)
+	}
+
+	// Only presented once.
+	for range 10 {
+		if d := ch.ChanScan(0xffff, true); d == 0xf {
+			t.Error("Attention presented twice")
+		}
+	}
+}
+
+// Injected device status gives I/O interrupt with CSW.
+func TestInjectIO(t *testing.T) {
+	ioSetup()
+
+	if err := InjectIO(0x0e, dev.CStatusAttn); err == nil {
+		t.Error("Inject to missing device did not fail")
+	}
+	if err := InjectIO(0xf, dev.CStatusAttn|dev.CStatusDevEnd); err != nil {
+		t.Fatal(err)
+	}
+	pend := testCPU().PendingInterrupts().IO
+	if len(pend) != 1 || pend[0] != 0xf {
+		t.Fatalf("Pending I/O expected [00f] got: %03x", pend)
+	}
+
+	mem.SetMemory(0x38, 0)
+	mem.SetMemory(0x3c, 0)
+	mem.SetMemory(0x40, 0xffffffff)
+	mem.SetMemory(0x44, 0xffffffff)
+	mem.SetMemory(0x78, 0)
+	mem.SetMemory(0x7c, 0x420)
+	sysCPU.lpsw(0xff000000, 0x00000400)
+	_, _ = sysCPU.cycle()
+	if sysCPU.PC != 0x420 {
+		t.Errorf("I/O new PSW PC expected %06x got: %06x", 0x420, sysCPU.PC)
+	}
+	if v := mem.GetMemory(0x38); v != 0xff00000f {
+		t.Errorf("I/O old PSW expected %08x got: %08x", 0xff00000f, v)
+	}
+	if v := mem.GetMemory(0x44); v != 0x84000000 {
+		t.Errorf("CSW2 expected %08x got: %08x", 0x84000000, v)
+	}
+	if len(testCPU().PendingInterrupts().IO) != 0 {
+		t.Errorf("Pending I/O after interrupt got: %03x", testCPU().PendingInterrupts().IO)
+	}
+}
@@ -22,6 +22,8 @@ ibm370 IBM 370 Channel Interface functions
 */
 package device
 
+import "io"
+
 // Interface for devices to handle commands.
 type Device interface {
 	StartIO() uint8           // Start of command chain.
@@ -32,6 +34,12 @@ type Device interface {
 	Debug(debug string) error // Enable debug option.
 }
 
+// Interface for devices which can be saved in a checkpoint.
+type Checkpoint interface {
+	SaveState(w io.Writer) error // Save device state.
+	LoadState(r io.Reader) error // Restore device state.
+}
+
 // Channel types.
 const (
 	TypeDis  int = 0 // Channel disabled
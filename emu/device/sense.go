@@ -0,0 +1,48 @@
+/*
+ibm370 Device sense data
+
+	Copyright (c) 2024, Richard Cornwell
+
+	Permission is hereby granted, free of charge, to any person obtaining a
+	copy of this software and associated documentation files (the "Software"),
+	to deal in the Software without restriction, including without limitation
+	the rights to use, copy, modify, merge, publish, distribute, sublicense,
+	and/or sell copies of the Software, and to permit persons to whom the
+	Software is furnished to do so, subject to the following conditions:
+
+	The above copyright notice and this permission notice shall be included in
+	all copies or substantial portions of the Software.
+
+	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+	IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.  IN NO EVENT SHALL
+	RICHARD CORNWELL BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+	IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+	CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+package device
+
+// Sense bytes kept by a device and returned by the Sense command. Byte 0
+// holds the basic sense bits, devices may add model specific bytes.
+type Sense []uint8
+
+// Add basic sense bits to byte 0.
+func (s Sense) Set(bits uint8) {
+	s[0] |= bits
+}
+
+// Replace sense with just basic sense bits.
+func (s Sense) Put(bits uint8) {
+	clear(s)
+	s[0] = bits
+}
+
+// Clear all sense bytes.
+func (s Sense) Clear() {
+	clear(s)
+}
+
+// Return true if unit check sense is posted.
+func (s Sense) Check() bool {
+	return s[0] != 0
+}
@@ -183,6 +183,7 @@ var opMap = map[int]opcode{
 	op.OpHIO:   {"HIO", tyS, 0},
 	op.OpTCH:   {"TCH", tyS, 0},
 	op.OpLRA:   {"LRA", tyRX, 0},
+	op.OpTRTR:  {"TRTR", tySS, 0},
 	op.OpMVN:   {"MVN", tySS, 0},
 	op.OpMVC:   {"MVC", tySS, 0},
 	op.OpMVZ:   {"MVZ", tySS, 0},
@@ -0,0 +1,87 @@
+package event
+
+/*
+ * S370  - Clock source for timers
+ *
+ * Copyright 2024, Richard Cornwell
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+import (
+	"sync"
+	"time"
+)
+
+// Period of interval timer, TOD clock and CPU timer updates, 2/300 of a second.
+const TickPeriod = 6666666 * time.Nanosecond
+
+// Time base for TOD clock, interval timer and CPU timer.
+type Clock interface {
+	Now() time.Time
+}
+
+// Clock that follows host wall clock.
+type RealClock struct{}
+
+// Return current host time.
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+// Clock that only moves when advanced, used for deterministic tests.
+type ManualClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// Create manual clock starting at given time.
+func NewManualClock(start time.Time) *ManualClock {
+	return &ManualClock{now: start}
+}
+
+// Return current time of manual clock.
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Move manual clock forward.
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+var timeBase Clock = RealClock{}
+
+// Set clock source, nil selects host clock.
+func SetClock(c Clock) {
+	if c == nil {
+		c = RealClock{}
+	}
+	timeBase = c
+}
+
+// Return current clock source.
+func TimeBase() Clock {
+	return timeBase
+}
@@ -48,6 +48,7 @@ type eventList struct {
 var (
 	freeList *Event
 	el       eventList
+	clock    int // Number of cycles advanced
 )
 
 // Grab event off free list, or create new one.
@@ -74,6 +75,10 @@ func freeEvent(ev *Event) {
 
 // Add an event.
 func AddEvent(dev D.Device, cb Callback, time int, iarg int) bool {
+	if recordW != nil || replaying {
+		time = logEvent("add", dev, time, iarg)
+	}
+
 	// If time is 0 process event immediately
 	if time == 0 {
 		cb(iarg)
@@ -131,6 +136,9 @@ func CancelEvent(dev D.Device, iarg int) {
 	// Scan list
 	for evptr != nil {
 		if evptr.dev == dev && evptr.iarg == iarg {
+			if recordW != nil || replaying {
+				logEvent("cancel", dev, 0, iarg)
+			}
 			nxt := evptr.next
 			// If next event give time to next event
 			if nxt != nil {
@@ -162,14 +170,15 @@ func CancelEvent(dev D.Device, iarg int) {
 
 // Advance time by one clock cycle.
 func Advance(t int) {
-	if el.head == nil {
-		return
-	}
 	for t > 0 && el.head != nil {
 		evptr := el.head
 		t--
+		clock++
 		evptr.time--
 		for evptr != nil && evptr.time <= 0 {
+			if recordW != nil || replaying {
+				logEvent("fire", evptr.dev, 0, evptr.iarg)
+			}
 			evptr.cb(evptr.iarg)
 			el.head = evptr.next
 			evptr = nil
@@ -181,9 +190,44 @@ func Advance(t int) {
 			}
 		}
 	}
+	clock += t
+}
+
+// Return number of cycles advanced so far.
+func Now() int {
+	return clock
 }
 
 // Return true if an event is scheduled.
 func AnyEvent() bool {
 	return el.head != nil
 }
+
+// Pending event information saved in a checkpoint.
+type Pending struct {
+	Time int // Number of cycles until event fires
+	Iarg int // Integer argument
+}
+
+// Return events pending for a device, times relative to now.
+func PendingEvents(dev D.Device) []Pending {
+	pend := []Pending{}
+	time := 0
+	for evptr := el.head; evptr != nil; evptr = evptr.next {
+		time += evptr.time
+		if evptr.dev == dev {
+			pend = append(pend, Pending{Time: time, Iarg: evptr.iarg})
+		}
+	}
+	return pend
+}
+
+// Remove all pending events.
+func Reset() {
+	for el.head != nil {
+		evptr := el.head
+		el.head = evptr.next
+		freeEvent(evptr)
+	}
+	el.tail = nil
+}
@@ -27,6 +27,7 @@ package event
 
 import (
 	"testing"
+	"time"
 )
 
 var stepCount uint64
@@ -348,3 +349,52 @@ func TestAddEvent9(t *testing.T) {
 		t.Errorf("Event A did not set data correct %d got %d", 5, deviceA.iarg)
 	}
 }
+
+// Pending events are reported for one device and cleared by reset.
+func TestPendingEvents(t *testing.T) {
+	initTest()
+	AddEvent(&deviceA, deviceA.aCallback, 10, 1)
+	AddEvent(&deviceB, deviceB.bCallback, 5, 2)
+	AddEvent(&deviceA, deviceA.aCallback, 20, 3)
+	Advance(2)
+	pend := PendingEvents(&deviceA)
+	if len(pend) != 2 {
+		t.Fatalf("Pending events expected %d got %d", 2, len(pend))
+	}
+	if pend[0].Time != 8 || pend[0].Iarg != 1 {
+		t.Errorf("Pending event 0 expected 8,1 got %d,%d", pend[0].Time, pend[0].Iarg)
+	}
+	if pend[1].Time != 18 || pend[1].Iarg != 3 {
+		t.Errorf("Pending event 1 expected 18,3 got %d,%d", pend[1].Time, pend[1].Iarg)
+	}
+	Reset()
+	if AnyEvent() {
+		t.Error("Events still pending after reset")
+	}
+	for range 30 {
+		stepCount++
+		Advance(1)
+	}
+	if deviceA.time != 0 || deviceB.time != 0 {
+		t.Errorf("Event fired after reset A %d B %d", deviceA.time, deviceB.time)
+	}
+}
+
+// Manual clock only moves when advanced.
+func TestManualClock(t *testing.T) {
+	start := time.Date(2024, time.June, 30, 0, 0, 0, 0, time.UTC)
+	clk := NewManualClock(start)
+	SetClock(clk)
+	defer SetClock(nil)
+	if TimeBase().Now() != start {
+		t.Errorf("Manual clock got: %v expected: %v", TimeBase().Now(), start)
+	}
+	clk.Advance(3 * TickPeriod)
+	if d := clk.Now().Sub(start); d != 3*TickPeriod {
+		t.Errorf("Manual clock advanced got: %v expected: %v", d, 3*TickPeriod)
+	}
+	SetClock(nil)
+	if _, ok := TimeBase().(RealClock); !ok {
+		t.Errorf("Default clock not real time clock")
+	}
+}
@@ -0,0 +1,145 @@
+package event
+
+/*
+ * S370  - Event record and replay
+ *
+ * Copyright 2024, Richard Cornwell
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	D "github.com/rcornwell/S370/emu/device"
+)
+
+/*
+   Each event scheduled, fired or canceled is written as one line:
+
+      <type> <clock> <device> <time> <iarg>
+
+   Clock is relative to start of recording. On replay, scheduled events
+   take the time from the log, and each event must match the log. The
+   first difference stops the replay and is reported by ReplayDone.
+*/
+
+// One recorded event.
+type record struct {
+	kind  string // add, fire or cancel
+	clock int    // Cycles since start of record
+	dev   string // Device event is for
+	time  int    // Cycles until event
+	iarg  int    // Integer argument
+}
+
+var (
+	recordW   io.Writer // Where to record events
+	recordErr error     // First error writing record
+	recordLog []record  // Events to replay
+	replayPos int       // Next event to replay
+	replayErr error     // First difference on replay
+	replaying bool      // Replay in progress
+	baseClock int       // Clock at start of record or replay
+)
+
+// Start recording events to w, nil stops recording.
+func Record(w io.Writer) {
+	recordW = w
+	recordErr = nil
+	baseClock = clock
+}
+
+// Return first error from writing record.
+func RecordErr() error {
+	return recordErr
+}
+
+// Start replay of events recorded in r.
+func Replay(r io.Reader) error {
+	recordLog = []record{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		rec := record{}
+		_, err := fmt.Sscanf(line, "%s %d %s %d %d", &rec.kind, &rec.clock, &rec.dev, &rec.time, &rec.iarg)
+		if err != nil {
+			return fmt.Errorf("event replay bad line: %s", line)
+		}
+		recordLog = append(recordLog, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	replayPos = 0
+	replayErr = nil
+	replaying = true
+	baseClock = clock
+	return nil
+}
+
+// Stop replay, return error if events did not match the log.
+func ReplayDone() error {
+	if replaying && replayPos != len(recordLog) {
+		replayErr = fmt.Errorf("event replay stopped at %d of %d events", replayPos, len(recordLog))
+	}
+	replaying = false
+	recordLog = nil
+	return replayErr
+}
+
+// Name of device for log.
+func devName(dev D.Device) string {
+	if d, ok := dev.(interface{ GetAddr() uint16 }); ok {
+		return fmt.Sprintf("%03x", d.GetAddr())
+	}
+	return strings.ReplaceAll(fmt.Sprintf("%T", dev), " ", "")
+}
+
+// Record event and check it against replay log. Returns time to use.
+func logEvent(kind string, dev D.Device, time int, iarg int) int {
+	rec := record{kind: kind, clock: clock - baseClock, dev: devName(dev), time: time, iarg: iarg}
+	if replaying {
+		if replayPos >= len(recordLog) {
+			replayErr = fmt.Errorf("event replay extra event: %s %s %d", kind, rec.dev, iarg)
+			replaying = false
+		} else {
+			want := recordLog[replayPos]
+			if want.kind != rec.kind || want.clock != rec.clock || want.dev != rec.dev || want.iarg != rec.iarg {
+				replayErr = fmt.Errorf("event replay %d expected %s %d %s %d got: %s %d %s %d", replayPos,
+					want.kind, want.clock, want.dev, want.iarg, rec.kind, rec.clock, rec.dev, rec.iarg)
+				replaying = false
+			} else {
+				replayPos++
+				rec.time = want.time
+			}
+		}
+	}
+	if recordW != nil && recordErr == nil {
+		_, recordErr = fmt.Fprintf(recordW, "%s %d %s %d %d\n", rec.kind, rec.clock, rec.dev, rec.time, rec.iarg)
+	}
+	return rec.time
+}
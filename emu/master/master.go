@@ -39,6 +39,10 @@ const (
 	Start
 	Shutdown
 	DeviceEnd
+	Step
+	ExtInterrupt
+	Reset
+	Diagnostic
 )
 
 // Packet to send to master.
@@ -47,4 +51,5 @@ type Packet struct {
 	Msg    int      // Message to process.
 	Data   []byte   // Data associated with message.
 	Conn   net.Conn // Connection for terminal type devices.
+	Count  int      // Number of instructions to step, type of reset.
 }
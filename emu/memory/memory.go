@@ -25,16 +25,36 @@ package memory
  *
  */
 
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
 type mem struct {
 	mem  [4 * 1024 * 1024]uint32
 	key  [8192]uint8
 	size uint32
+	gen  uint32 // Bumped on every store
 }
 
 var memory mem
 
+// Storage interlock for read-modify-write between processors.
+var interlock sync.Mutex
+
 const (
-	AMASK uint32 = 0x00ffffff // Mask address bits
+	AMASK   uint32 = 0x00ffffff       // Mask address bits
+	MaxSize int    = 16 * 1024 * 1024 // Largest memory with 24 bit addresses
+
+	// Storage key bits.
+	KeyMask   uint8 = 0xf0 // Access control bits
+	KeyFetch  uint8 = 0x08 // Fetch protection
+	KeyRef    uint8 = 0x04 // Reference bit, set on any access
+	KeyChange uint8 = 0x02 // Change bit, set on any store
 )
 
 // Set size in K.
@@ -45,29 +65,106 @@ func SetSize(k int) {
 	memory.size = uint32(k * 1024)
 }
 
+// Set size in bytes, rounded down to a multiple of 2K storage key block.
+func SetSizeBytes(size int) error {
+	if size < 2048 || size > MaxSize {
+		return errors.New("memory size must be between 2K and 16M")
+	}
+	memory.size = uint32(size) &^ 0x7ff
+	return nil
+}
+
+// Clear all of storage and storage keys.
+func Clear() {
+	clear(memory.mem[:(memory.size+3)>>2])
+	clear(memory.key[:])
+	memory.gen++
+}
+
 // Return size of memory in bytes.
 func GetSize() uint32 {
 	return memory.size
 }
 
+// Return store generation, changes whenever any store is made to memory.
+func Generation() uint32 {
+	return memory.gen
+}
+
 // Get memory value without range check.
 func GetMemory(addr uint32) uint32 {
-	memory.key[addr>>11] |= 0x4 // Update access bits
+	memory.key[addr>>11] |= KeyRef // Update access bits
 	return memory.mem[addr>>2]
 }
 
 // Set memory to a value, without range check.
 func SetMemory(addr, data uint32) {
-	memory.key[addr>>11] |= 0x6 // Update Access and modify bits
+	memory.key[addr>>11] |= KeyRef | KeyChange // Update Access and modify bits
 	memory.mem[addr>>2] = data
+	memory.gen++
 }
 
 // Set memory to a value, without range check.
 func SetMemoryMask(addr uint32, data uint32, mask uint32) {
-	memory.key[addr>>11] |= 0x6 // Update Access and modify bits
+	memory.key[addr>>11] |= KeyRef | KeyChange // Update Access and modify bits
 	addr >>= 2
 	memory.mem[addr] &= ^mask
 	memory.mem[addr] |= data & mask
+	memory.gen++
+}
+
+// Get byte from memory, without range check.
+func GetByte(addr uint32) uint8 {
+	return uint8(GetMemory(addr) >> (8 * (3 - (addr & 3))))
+}
+
+// Set byte in memory, without range check.
+func SetByte(addr uint32, data uint8) {
+	offset := 8 * (3 - (addr & 3))
+	SetMemoryMask(addr, uint32(data)<<offset, 0xff<<offset)
+}
+
+// Get halfword from memory, may cross a word boundary, without range check.
+func GetHalf(addr uint32) uint16 {
+	if (addr & 3) != 3 {
+		return uint16(GetMemory(addr) >> (8 * (2 - (addr & 3))))
+	}
+	return (uint16(GetByte(addr)) << 8) | uint16(GetByte(addr+1))
+}
+
+// Set halfword in memory, may cross a word boundary, without range check.
+func SetHalf(addr uint32, data uint16) {
+	if (addr & 3) != 3 {
+		offset := 8 * (2 - (addr & 3))
+		SetMemoryMask(addr, uint32(data)<<offset, 0xffff<<offset)
+		return
+	}
+	SetByte(addr, uint8(data>>8))
+	SetByte(addr+1, uint8(data))
+}
+
+// Get doubleword from memory, any alignment, without range check.
+func GetDouble(addr uint32) uint64 {
+	if (addr & 3) == 0 {
+		return (uint64(GetMemory(addr)) << 32) | uint64(GetMemory(addr+4))
+	}
+	var value uint64
+	for i := range uint32(8) {
+		value = (value << 8) | uint64(GetByte(addr+i))
+	}
+	return value
+}
+
+// Set doubleword in memory, any alignment, without range check.
+func SetDouble(addr uint32, data uint64) {
+	if (addr & 3) == 0 {
+		SetMemory(addr, uint32(data>>32))
+		SetMemory(addr+4, uint32(data))
+		return
+	}
+	for i := range uint32(8) {
+		SetByte(addr+i, uint8(data>>(8*(7-i))))
+	}
 }
 
 // Check if address out of range.
@@ -80,7 +177,7 @@ func GetWord(addr uint32) (value uint32, err bool) {
 	if addr >= memory.size {
 		return 0, true
 	}
-	memory.key[addr>>11] |= 0x4 // Update Access bits
+	memory.key[addr>>11] |= KeyRef // Update Access bits
 	return memory.mem[addr>>2], false
 }
 
@@ -89,8 +186,9 @@ func PutWord(addr, data uint32) bool {
 	if addr >= memory.size {
 		return true
 	}
-	memory.key[addr>>11] |= 0x6 // Update Access and modify bits
+	memory.key[addr>>11] |= KeyRef | KeyChange // Update Access and modify bits
 	memory.mem[addr>>2] = data
+	memory.gen++
 	return false
 }
 
@@ -99,10 +197,11 @@ func PutWordMask(addr, data, mask uint32) bool {
 	if addr >= memory.size {
 		return true
 	}
-	memory.key[addr>>11] |= 0x6 // Update Access and modify bits
+	memory.key[addr>>11] |= KeyRef | KeyChange // Update Access and modify bits
 	addr >>= 2
 	memory.mem[addr] &= ^mask
 	memory.mem[addr] |= data & mask
+	memory.gen++
 	return false
 }
 
@@ -121,29 +220,105 @@ func PutKey(addr uint32, key uint8) {
 	}
 }
 
+// Clear reference bit for address, returning the old key.
+func ResetRef(addr uint32) uint8 {
+	if addr >= memory.size {
+		return 0
+	}
+	key := memory.key[addr>>11]
+	memory.key[addr>>11] = key &^ KeyRef
+	return key
+}
+
+// Hold storage interlock, no other processor may do an interlocked update.
+func Lock() {
+	interlock.Lock()
+}
+
+// Release storage interlock.
+func Unlock() {
+	interlock.Unlock()
+}
+
 // Get number of bytes starting at address.
 func GetBytes(addr uint32, num int) []byte {
-	result := []byte{}
+	result := make([]byte, num)
+	ReadBytes(addr, result)
+	return result
+}
 
-	word := GetMemory(addr)
-	for range num {
-		by := (word >> (8 * (3 - addr&3))) & 0xff
-		result = append(result, byte(by))
-		addr++
-		if (addr & 3) == 0 {
-			word = GetMemory(addr)
+// Fill buf with bytes starting at address, without range check.
+func ReadBytes(addr uint32, buf []byte) {
+	i := 0
+	for i < len(buf) {
+		memory.key[addr>>11] |= KeyRef
+		// Copy whole words when aligned.
+		if (addr&3) == 0 && len(buf)-i >= 4 {
+			binary.BigEndian.PutUint32(buf[i:], memory.mem[addr>>2])
+			i += 4
+			addr += 4
+			continue
 		}
+		buf[i] = byte(memory.mem[addr>>2] >> (8 * (3 - addr&3)))
+		i++
+		addr++
 	}
-	return result
 }
 
 // Set number of bytes into memory starting at address.
 func SetBytes(addr uint32, data []byte) {
 	for i := range data {
-		var mask uint32 = 0x000000ff
-
-		offset := 8 * (3 - (addr & 0x3))
-		SetMemoryMask(addr, uint32(data[i])<<offset, mask<<offset)
+		SetByte(addr, data[i])
 		addr++
 	}
 }
+
+// Check that range of num bytes at addr is inside storage.
+func checkRange(addr uint32, num int) error {
+	if num < 0 || uint64(addr)+uint64(num) > uint64(memory.size) {
+		return fmt.Errorf("storage range %06x length %x outside of memory", addr, num)
+	}
+	return nil
+}
+
+// Read host file into storage at addr, return number of bytes loaded.
+func LoadFile(name string, addr uint32) (int, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return 0, err
+	}
+	if err := checkRange(addr, len(data)); err != nil {
+		return 0, err
+	}
+	SetBytes(addr, data)
+	return len(data), nil
+}
+
+// Write num bytes of storage at addr to host file.
+func DumpFile(name string, addr uint32, num int) error {
+	if err := checkRange(addr, num); err != nil {
+		return err
+	}
+	return os.WriteFile(name, GetBytes(addr, num), 0o644)
+}
+
+// Write contents of memory to w, storage keys are not saved.
+func Save(w io.Writer) error {
+	if err := binary.Write(w, binary.BigEndian, memory.size); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.BigEndian, memory.mem[:memory.size>>2])
+}
+
+// Read contents of memory from r, size must match current memory size.
+func Load(r io.Reader) error {
+	var size uint32
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return err
+	}
+	if size != memory.size {
+		return errors.New("checkpoint memory size does not match current memory")
+	}
+	memory.gen++
+	return binary.Read(r, binary.BigEndian, memory.mem[:size>>2])
+}
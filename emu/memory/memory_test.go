@@ -26,6 +26,8 @@ package memory
  */
 
 import (
+	"bytes"
+	"path/filepath"
 	"testing"
 )
 
@@ -442,3 +444,174 @@ func TestPutKey(t *testing.T) {
 		}
 	}
 }
+
+// Save memory and load it back.
+func TestSaveLoad(t *testing.T) {
+	SetSize(16)
+	for i := uint32(0); i < 0x4000; i += 4 {
+		SetMemory(i, i*3)
+	}
+	var buf bytes.Buffer
+	if err := Save(&buf); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	for i := uint32(0); i < 0x4000; i += 4 {
+		SetMemory(i, 0)
+	}
+	if err := Load(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	for i := uint32(0); i < 0x4000; i += 4 {
+		if v := GetMemory(i); v != i*3 {
+			t.Errorf("Memory %06x not restored got: %08x expected: %08x", i, v, i*3)
+			break
+		}
+	}
+	SetSize(32)
+	if err := Load(bytes.NewReader(buf.Bytes())); err == nil {
+		t.Error("Load with wrong memory size did not fail")
+	}
+}
+
+// Dump storage range to file and load it back.
+func TestDumpLoadFile(t *testing.T) {
+	SetSize(16)
+	for i := uint32(0x1000); i < 0x1100; i += 4 {
+		SetMemory(i, ^i)
+	}
+	name := filepath.Join(t.TempDir(), "core.bin")
+	if err := DumpFile(name, 0x1002, 0xfc); err != nil {
+		t.Fatalf("Dump failed: %v", err)
+	}
+	for i := uint32(0x1000); i < 0x1100; i += 4 {
+		SetMemory(i, 0)
+	}
+	num, err := LoadFile(name, 0x1002)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if num != 0xfc {
+		t.Errorf("Load size got: %x expected: %x", num, 0xfc)
+	}
+	// Partial words at each end.
+	if v := GetMemory(0x1000); v != 0x0000efff {
+		t.Errorf("Memory %06x got: %08x expected: %08x", 0x1000, v, 0x0000efff)
+	}
+	if v := GetMemory(0x10fc); v != 0xffff0000 {
+		t.Errorf("Memory %06x got: %08x expected: %08x", 0x10fc, v, 0xffff0000)
+	}
+	for i := uint32(0x1004); i < 0x10fc; i += 4 {
+		if v := GetMemory(i); v != ^i {
+			t.Errorf("Memory %06x not restored got: %08x expected: %08x", i, v, ^i)
+			break
+		}
+	}
+
+	// Ranges past end of storage.
+	if err := DumpFile(name, 0x3f00, 0x101); err == nil {
+		t.Error("Dump past end of storage did not fail")
+	}
+	if _, err := LoadFile(name, 0x3f80); err == nil {
+		t.Error("Load past end of storage did not fail")
+	}
+}
+
+// Set size in bytes.
+func TestSetSizeBytes(t *testing.T) {
+	if err := SetSizeBytes(256 * 1024); err != nil || GetSize() != 256*1024 {
+		t.Errorf("SetSizeBytes 256K got: %d %v", GetSize(), err)
+	}
+	if err := SetSizeBytes(0x4100); err != nil || GetSize() != 0x4000 {
+		t.Errorf("SetSizeBytes not rounded got: %x %v", GetSize(), err)
+	}
+	if err := SetSizeBytes(MaxSize + 2048); err == nil {
+		t.Error("SetSizeBytes larger than 16M did not fail")
+	}
+	if err := SetSizeBytes(0); err == nil {
+		t.Error("SetSizeBytes of zero did not fail")
+	}
+}
+
+// Read bytes at any alignment.
+func TestReadBytes(t *testing.T) {
+	SetSize(16)
+	for i := uint32(0); i < 0x100; i += 4 {
+		SetMemory(i, (i<<24)|((i+1)<<16)|((i+2)<<8)|(i+3))
+	}
+	for start := uint32(0); start < 4; start++ {
+		for n := range 11 {
+			buf := make([]byte, n)
+			ReadBytes(0x10+start, buf)
+			for i, b := range buf {
+				if b != byte(0x10+start+uint32(i)) {
+					t.Errorf("ReadBytes %x %d byte %d got: %02x expected: %02x", start, n, i, b, 0x10+start+uint32(i))
+				}
+			}
+		}
+	}
+}
+
+// Check byte, half and double accessors against word access.
+func TestByteHalfDouble(t *testing.T) {
+	halfWant := [4][2]uint32{
+		{0x1234ffff, 0xffffffff},
+		{0xff1234ff, 0xffffffff},
+		{0xffff1234, 0xffffffff},
+		{0xffffff12, 0x34ffffff},
+	}
+	SetSize(16)
+	for off := uint32(0); off < 4; off++ {
+		addr := 0x600 + off
+		SetMemory(0x600, 0xffffffff)
+		SetMemory(0x604, 0xffffffff)
+		SetMemory(0x608, 0xffffffff)
+		SetHalf(addr, 0x1234)
+		want := halfWant[off]
+		if v := GetMemory(0x600); v != want[0] {
+			t.Errorf("SetHalf %d word 0 got: %08x expected: %08x", off, v, want[0])
+		}
+		if v := GetMemory(0x604); v != want[1] {
+			t.Errorf("SetHalf %d word 1 got: %08x expected: %08x", off, v, want[1])
+		}
+		if v := GetHalf(addr); v != 0x1234 {
+			t.Errorf("GetHalf %d got: %04x expected: %04x", off, v, 0x1234)
+		}
+
+		SetMemory(0x600, 0x00112233)
+		SetMemory(0x604, 0x44556677)
+		SetMemory(0x608, 0x8899aabb)
+		for i := range uint32(8) {
+			if b := GetByte(addr + i); b != uint8((addr+i-0x600)*0x11) {
+				t.Errorf("GetByte %x got: %02x expected: %02x", addr+i, b, (addr+i-0x600)*0x11)
+			}
+		}
+		d := GetDouble(addr)
+		var wantD uint64
+		for i := range uint32(8) {
+			wantD = (wantD << 8) | uint64((off+i)*0x11)
+		}
+		if d != wantD {
+			t.Errorf("GetDouble %d got: %016x expected: %016x", off, d, wantD)
+		}
+
+		SetMemory(0x600, 0xffffffff)
+		SetMemory(0x604, 0xffffffff)
+		SetMemory(0x608, 0xffffffff)
+		SetDouble(addr, 0x0123456789abcdef)
+		if d := GetDouble(addr); d != 0x0123456789abcdef {
+			t.Errorf("SetDouble %d got: %016x expected: %016x", off, d, uint64(0x0123456789abcdef))
+		}
+		if off == 1 {
+			if v := GetMemory(0x600); v != 0xff012345 {
+				t.Errorf("SetDouble word 0 got: %08x expected: %08x", v, 0xff012345)
+			}
+			if v := GetMemory(0x608); v != 0xefffffff {
+				t.Errorf("SetDouble word 2 got: %08x expected: %08x", v, 0xefffffff)
+			}
+		}
+		SetByte(addr, 0x5a)
+		if v := GetMemory(0x600) >> (24 - 8*off) & 0xff; v != 0x5a {
+			t.Errorf("SetByte %d got: %02x expected: %02x", off, v, 0x5a)
+		}
+	}
+}
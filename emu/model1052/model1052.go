@@ -36,6 +36,7 @@ import (
 	"fmt"
 	"net"
 	"strconv"
+	"strings"
 
 	"github.com/rcornwell/S370/command/command"
 	config "github.com/rcornwell/S370/config/configparser"
@@ -45,7 +46,7 @@ import (
 	ch "github.com/rcornwell/S370/emu/sys_channel"
 	"github.com/rcornwell/S370/telnet"
 	"github.com/rcornwell/S370/util/debug"
-	xlat "github.com/rcornwell/S370/util/xlat"
+	"github.com/rcornwell/S370/util/ebcdic"
 )
 
 const (
@@ -70,24 +71,25 @@ var debugOption = map[string]int{
 }
 
 type Model1052ctx struct {
-	addr     uint16        // Current device address.
-	col      int           // Current column.
-	busy     bool          // Reader busy.
-	halt     bool          // Signal halt requested.
-	sense    uint8         // Current sense byte.
-	read     bool          // Currently waiting on read.
-	request  bool          // Console request.
-	input    bool          // Input mode.
-	output   bool          // Output mode.
-	cr       bool          // Output CR.
-	cancel   bool          // Cancel ^C pressed.
-	inPtr    int           // Input pointer.
-	inSize   int           // Size of input pending input.
-	inBuff   [512]byte     // Place to save pending input.
-	port     string        // Port number attached to.
-	telctx   *model1052tel // Pointer to telnet device.
-	debugMsk int           // Debug option mask.
-	outLine  string        // Line being output for debug purposes.
+	addr     uint16           // Current device address.
+	col      int              // Current column.
+	busy     bool             // Reader busy.
+	halt     bool             // Signal halt requested.
+	sense    dev.Sense        // Sense bytes.
+	read     bool             // Currently waiting on read.
+	request  bool             // Console request.
+	input    bool             // Input mode.
+	output   bool             // Output mode.
+	cr       bool             // Output CR.
+	cancel   bool             // Cancel ^C pressed.
+	inPtr    int              // Input pointer.
+	inSize   int              // Size of input pending input.
+	inBuff   [512]byte        // Place to save pending input.
+	port     string           // Port number attached to.
+	telctx   *model1052tel    // Pointer to telnet device.
+	codePage *ebcdic.CodePage // Keyboard code page.
+	debugMsk int              // Debug option mask.
+	outLine  string           // Line being output for debug purposes.
 }
 
 type model1052tel struct {
@@ -119,7 +121,7 @@ func (device *Model1052ctx) StartCmd(cmd uint8) uint8 {
 		device.halt = false
 		// If not connected, return Unit Check status.
 		if !tel.connected {
-			device.sense = dev.SenseINTVENT
+			device.sense.Put(dev.SenseINTVENT)
 			return dev.CStatusChnEnd | dev.CStatusDevEnd | dev.CStatusCheck
 		}
 
@@ -151,7 +153,7 @@ func (device *Model1052ctx) StartCmd(cmd uint8) uint8 {
 
 		// Set up for read command.
 		device.inPtr = 0
-		device.sense = 0
+		device.sense.Clear()
 		device.busy = true
 		device.read = true
 		ev.AddEvent(device, device.callback, 10, int(cmd))
@@ -162,7 +164,7 @@ func (device *Model1052ctx) StartCmd(cmd uint8) uint8 {
 
 		// If not connected return unit check.
 		if !tel.connected {
-			device.sense = dev.SenseINTVENT
+			device.sense.Put(dev.SenseINTVENT)
 			return dev.CStatusChnEnd | dev.CStatusDevEnd | dev.CStatusCheck
 		}
 
@@ -196,13 +198,15 @@ func (device *Model1052ctx) StartCmd(cmd uint8) uint8 {
 		device.busy = true
 		ev.AddEvent(device, device.callback, 10, int(cmd))
 		debug.DebugDevf(device.addr, device.debugMsk, debugCmd, "Cmd: %02x", cmd)
+		// Pending sense is returned, not posted as unit check.
+		return 0
 
 	case cmdAlarm:
 		device.halt = false
 
 		// If not connected send unit check status
 		if !tel.connected {
-			device.sense = dev.SenseINTVENT
+			device.sense.Put(dev.SenseINTVENT)
 			return dev.CStatusChnEnd | dev.CStatusDevEnd | dev.CStatusCheck
 		}
 
@@ -214,7 +218,7 @@ func (device *Model1052ctx) StartCmd(cmd uint8) uint8 {
 
 		// Send '\b'
 		r = dev.CStatusChnEnd
-		device.sense = 0
+		device.sense.Clear()
 		device.busy = true
 		ev.AddEvent(device, device.callback, 1000, int(cmd))
 		debug.DebugDevf(device.addr, device.debugMsk, debugCmd, "Cmd: %02x", cmd)
@@ -223,10 +227,10 @@ func (device *Model1052ctx) StartCmd(cmd uint8) uint8 {
 		r = dev.CStatusChnEnd | dev.CStatusDevEnd
 		debug.DebugDevf(device.addr, device.debugMsk, debugCmd, "Cmd: %02x", cmd)
 	default:
-		device.sense = dev.SenseCMDREJ
+		device.sense.Put(dev.SenseCMDREJ)
 	}
 
-	if device.sense != 0 {
+	if device.sense.Check() {
 		r = dev.CStatusChnEnd | dev.CStatusDevEnd | dev.CStatusCheck
 	}
 	device.halt = false
@@ -242,7 +246,7 @@ func (device *Model1052ctx) HaltIO() uint8 {
 // Initialize a device.
 func (device *Model1052ctx) InitDev() uint8 {
 	device.col = 0
-	device.sense = 0
+	device.sense.Clear()
 	device.busy = false
 	device.halt = false
 	return 0
@@ -313,7 +317,7 @@ func (device *Model1052ctx) GetAddr() uint16 {
 func (device *Model1052ctx) finishWrite() {
 	line := ""
 	for i := range device.inSize {
-		line += string(xlat.EBCDICToASCII[device.inBuff[i]])
+		line += string(device.codePage.ToASCII[device.inBuff[i]])
 	}
 	debug.DebugDevf(device.addr, device.debugMsk, debugLine, "Send: %s", line)
 	device.input = false
@@ -338,7 +342,8 @@ func (device *Model1052ctx) callback(cmd int) {
 	case dev.CmdSense:
 		device.busy = false
 		device.halt = false
-		_ = ch.ChanWriteByte(device.addr, device.sense)
+		ch.ChanWriteSense(device.addr, device.sense)
+		device.sense.Clear()
 		ch.ChanEnd(device.addr, (dev.CStatusChnEnd | dev.CStatusDevEnd))
 		return
 
@@ -376,14 +381,14 @@ func (device *Model1052ctx) callback(cmd int) {
 			debug.DebugDevf(device.addr, device.debugMsk, debugLine, "Output: %s", device.outLine)
 			device.outLine = ""
 		} else {
-			out := xlat.EBCDICToASCII[by]
+			out := device.codePage.ToASCII[by]
 			if out != 0 {
 				if !strconv.IsPrint(rune(out)) {
 					out = '_'
 				}
 				device.outLine += string(out)
 				// send out
-				_, err = tel.conn.Write([]byte{out})
+				_, err = tel.conn.Write([]byte(string(out)))
 				if err != nil {
 					fmt.Println("Telnet error: ", err)
 				}
@@ -490,7 +495,7 @@ func (telConn *model1052tel) ReceiveChar(data []byte) {
 
 			default:
 				if device.inPtr < len(device.inBuff) {
-					inChar := xlat.ASCIIToEBCDIC[by]
+					inChar := device.codePage.FromASCII[by]
 					if inChar == 0xff {
 						_, err = telConn.conn.Write([]byte{'\007'})
 						if err != nil {
@@ -498,11 +503,11 @@ func (telConn *model1052tel) ReceiveChar(data []byte) {
 						}
 					} else {
 						// Convert back to ascii
-						replyChar := xlat.EBCDICToASCII[inChar]
+						replyChar := device.codePage.ToASCII[inChar]
 						// send out
 						device.inBuff[device.inPtr] = inChar
 						device.inPtr++
-						_, err = telConn.conn.Write([]byte{replyChar})
+						_, err = telConn.conn.Write([]byte(string(replyChar)))
 						if err != nil {
 							fmt.Println("Telnet error: ", err)
 						}
@@ -531,7 +536,7 @@ func (telConn *model1052tel) ReceiveChar(data []byte) {
 	}
 	//	fmt.Printf("Done processing input: %t request: %t\n", device.busy, device.request)
 	if !device.busy && device.request {
-		ch.SetDevAttn(device.addr, dev.CStatusAttn)
+		ch.PostAttention(device.addr)
 		device.request = false
 	}
 }
@@ -539,20 +544,29 @@ func (telConn *model1052tel) ReceiveChar(data []byte) {
 // register a device on initialize.
 func init() {
 	config.RegisterModel("1052", config.TypeModel, create)
+	config.RegisterModel("3215", config.TypeModel, create)
 }
 
 // Create a device.
 func create(devNum uint16, _ string, options []config.Option) error {
-	dev := Model1052ctx{addr: devNum}
+	dev := Model1052ctx{addr: devNum, codePage: ebcdic.US, sense: make([]uint8, 1)}
 	err := ch.AddDevice(&dev, &dev, devNum)
 	if err != nil {
-		return fmt.Errorf("unable to create 1052 at %03x", devNum)
+		return fmt.Errorf("unable to create console at %03x", devNum)
 	}
 	console := model1052tel{ctx: &dev}
 	dev.telctx = &console
 	port := ""
 	group := ""
 	for _, option := range options {
+		if strings.ToUpper(option.Name) == "CODEPAGE" {
+			page, err := ebcdic.GetCodePage(option.EqualOpt)
+			if err != nil {
+				return err
+			}
+			dev.codePage = page
+			continue
+		}
 		if option.EqualOpt != "" {
 			return errors.New("equal option not supported on: " + option.Name)
 		}
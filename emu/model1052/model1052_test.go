@@ -0,0 +1,274 @@
+/* IBM 360 Inquiry console tests.
+
+   Copyright (c) 2024, Richard Cornwell
+
+   Permission is hereby granted, free of charge, to any person obtaining a
+   copy of this software and associated documentation files (the "Software"),
+   to deal in the Software without restriction, including without limitation
+   the rights to use, copy, modify, merge, publish, distribute, sublicense,
+   and/or sell copies of the Software, and to permit persons to whom the
+   Software is furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in
+   all copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.  IN NO EVENT SHALL
+   RICHARD CORNWELL BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+   IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+   CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+*/
+
+package model1052
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	config "github.com/rcornwell/S370/config/configparser"
+	D "github.com/rcornwell/S370/emu/device"
+	ev "github.com/rcornwell/S370/emu/event"
+	"github.com/rcornwell/S370/emu/master"
+	mem "github.com/rcornwell/S370/emu/memory"
+	Ch "github.com/rcornwell/S370/emu/sys_channel"
+)
+
+const conAddr uint16 = 0x01f
+
+// Terminal side of connection, collects output.
+type fakeTerm struct {
+	lock   sync.Mutex
+	output []byte
+}
+
+// Read output from console until connection closed.
+func (term *fakeTerm) run(conn net.Conn) {
+	buf := make([]byte, 128)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+		term.lock.Lock()
+		term.output = append(term.output, buf[:n]...)
+		term.lock.Unlock()
+	}
+}
+
+// Return output received so far.
+func (term *fakeTerm) String() string {
+	term.lock.Lock()
+	defer term.lock.Unlock()
+	return string(term.output)
+}
+
+// Wait for expected output to arrive.
+func (term *fakeTerm) wait(expect string) string {
+	for range 100 {
+		if term.String() == expect {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return term.String()
+}
+
+// Deliver packets sent to master channel, as the core does.
+func deliver(masterChan chan master.Packet) {
+	for {
+		select {
+		case packet := <-masterChan:
+			switch packet.Msg {
+			case master.TelConnect:
+				Ch.SendConnect(packet.DevNum, packet.Conn)
+			case master.TelDisconnect:
+				Ch.SendDisconnect(packet.DevNum)
+			case master.TelReceive:
+				Ch.SendReceiveChar(packet.DevNum, packet.Data)
+			}
+		default:
+			return
+		}
+	}
+}
+
+// Create console and connect a fake terminal to it.
+func setup(t *testing.T) (chan master.Packet, *fakeTerm) {
+	t.Helper()
+	mem.SetSize(64)
+	Ch.InitializeChannels()
+	Ch.AddChannel(0, D.TypeMux, 192)
+	err := create(conAddr, "", []config.Option{{Name: "3270"}})
+	if err != nil {
+		t.Fatalf("Unable to create console: %v", err)
+	}
+
+	masterChan := make(chan master.Packet, 10)
+	client, server := net.Pipe()
+	term := &fakeTerm{}
+	go term.run(client)
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	masterChan <- master.Packet{DevNum: conAddr, Msg: master.TelConnect, Conn: server}
+	deliver(masterChan)
+	return masterChan, term
+}
+
+// Run channel until interrupt, feeding master packets.
+func runChannel(t *testing.T, masterChan chan master.Packet) uint16 {
+	t.Helper()
+	d := D.NoDev
+	for range 100000 {
+		deliver(masterChan)
+		ev.Advance(10)
+		d = Ch.ChanScan(0xffff, true)
+		if d != D.NoDev {
+			break
+		}
+	}
+	Ch.IrqPending = false
+	return d
+}
+
+// Read a line of input from the terminal.
+func TestConsoleRead(t *testing.T) {
+	masterChan, term := setup(t)
+
+	mem.SetMemory(0x40, 0)
+	mem.SetMemory(0x44, 0)
+	mem.SetMemory(0x48, 0x500)
+	mem.SetMemory(0x500, 0x0a000600) // Read inquiry
+	mem.SetMemory(0x504, 0x20000020)
+	for i := uint32(0x600); i < 0x620; i += 4 {
+		mem.SetMemory(i, 0x55555555)
+	}
+
+	cc := Ch.StartIO(conAddr)
+	if cc != 0 {
+		t.Fatalf("Start I/O expected %d got: %d", 0, cc)
+	}
+
+	// Let read wait before operator types a line.
+	for range 100 {
+		ev.Advance(10)
+	}
+	masterChan <- master.Packet{DevNum: conAddr, Msg: master.TelReceive, Data: []byte("HELLO\r")}
+
+	d := runChannel(t, masterChan)
+	if d != conAddr {
+		t.Fatalf("Read expected device %03x got: %03x", conAddr, d)
+	}
+	v := mem.GetMemory(0x44)
+	if v != 0x0c00001b {
+		t.Errorf("Read CSW2 expected %08x got: %08x", 0x0c00001b, v)
+	}
+	expect := []uint8{0xc8, 0xc5, 0xd3, 0xd3, 0xd6, 0x55}
+	for i, e := range expect {
+		b := uint8(mem.GetMemory(0x600+uint32(i&^3)) >> (8 * (3 - (i & 3))))
+		if b != e {
+			t.Errorf("Read data %d expected %02x got: %02x", i, e, b)
+		}
+	}
+	out := term.wait("I HELLO\r\n")
+	if out != "I HELLO\r\n" {
+		t.Errorf("Read echo expected %q got: %q", "I HELLO\r\n", out)
+	}
+}
+
+// Write a line to the terminal.
+func TestConsoleWrite(t *testing.T) {
+	masterChan, term := setup(t)
+
+	mem.SetMemory(0x40, 0)
+	mem.SetMemory(0x44, 0)
+	mem.SetMemory(0x48, 0x500)
+	mem.SetMemory(0x500, 0x09000600) // Write with carrier return
+	mem.SetMemory(0x504, 0x00000002)
+	mem.SetMemory(0x600, 0xc8c90000) // HI
+
+	cc := Ch.StartIO(conAddr)
+	if cc != 0 {
+		t.Fatalf("Start I/O expected %d got: %d", 0, cc)
+	}
+	d := runChannel(t, masterChan)
+	if d != conAddr {
+		t.Fatalf("Write expected device %03x got: %03x", conAddr, d)
+	}
+	v := mem.GetMemory(0x44)
+	if v != 0x0c000000 {
+		t.Errorf("Write CSW2 expected %08x got: %08x", 0x0c000000, v)
+	}
+	out := term.wait("HI\r\n")
+	if out != "HI\r\n" {
+		t.Errorf("Write output expected %q got: %q", "HI\r\n", out)
+	}
+}
+
+// Unsupported command gives command reject, returned by Sense.
+func TestConsoleSense(t *testing.T) {
+	masterChan, _ := setup(t)
+
+	mem.SetMemory(0x40, 0)
+	mem.SetMemory(0x44, 0)
+	mem.SetMemory(0x48, 0x500)
+	mem.SetMemory(0x500, 0x07000600) // Unsupported command
+	mem.SetMemory(0x504, 0x00000001)
+	mem.SetMemory(0x508, 0x04000600) // Sense
+	mem.SetMemory(0x50c, 0x00000001)
+	mem.SetMemory(0x600, 0x55555555)
+
+	cc := Ch.StartIO(conAddr)
+	if cc != 1 {
+		t.Fatalf("Start I/O expected %d got: %d", 1, cc)
+	}
+	v := mem.GetMemory(0x44) & 0xffff0000
+	if v != 0x0e000000 {
+		t.Errorf("Reject CSW status expected %08x got: %08x", 0x0e000000, v)
+	}
+
+	for _, expect := range []uint8{D.SenseCMDREJ, 0} {
+		mem.SetMemory(0x48, 0x508)
+		cc = Ch.StartIO(conAddr)
+		if cc != 0 {
+			t.Fatalf("Sense Start I/O expected %d got: %d", 0, cc)
+		}
+		d := runChannel(t, masterChan)
+		if d != conAddr {
+			t.Fatalf("Sense expected device %03x got: %03x", conAddr, d)
+		}
+		v = mem.GetMemory(0x44)
+		if v != 0x0c000000 {
+			t.Errorf("Sense CSW2 expected %08x got: %08x", 0x0c000000, v)
+		}
+		b := uint8(mem.GetMemory(0x600) >> 24)
+		if b != expect {
+			t.Errorf("Sense byte expected %02x got: %02x", expect, b)
+		}
+	}
+}
+
+// Request key on idle console gives attention interrupt.
+func TestConsoleAttention(t *testing.T) {
+	masterChan, _ := setup(t)
+
+	mem.SetMemory(0x40, 0xffffffff)
+	mem.SetMemory(0x44, 0xffffffff)
+	masterChan <- master.Packet{DevNum: conAddr, Msg: master.TelReceive, Data: []byte{0o033}}
+
+	d := runChannel(t, masterChan)
+	if d != conAddr {
+		t.Fatalf("Attention expected device %03x got: %03x", conAddr, d)
+	}
+	if v := mem.GetMemory(0x40); v != 0 {
+		t.Errorf("Attention CSW1 expected %08x got: %08x", 0, v)
+	}
+	if v := mem.GetMemory(0x44); v != 0x80000000 {
+		t.Errorf("Attention CSW2 expected %08x got: %08x", 0x80000000, v)
+	}
+}
@@ -44,7 +44,7 @@ import (
 	dev "github.com/rcornwell/S370/emu/device"
 	event "github.com/rcornwell/S370/emu/event"
 	ch "github.com/rcornwell/S370/emu/sys_channel"
-	"github.com/rcornwell/S370/util/xlat"
+	"github.com/rcornwell/S370/util/ebcdic"
 )
 
 const (
@@ -61,21 +61,22 @@ var debugOption = map[string]int{
 }
 
 type Model1403ctx struct {
-	addr     uint16      // Current device address.
-	busy     bool        // Reader busy.
-	halt     bool        // Signal halt requested.
-	sense    uint8       // Current sense byte.
-	file     *os.File    // Printer file.
-	fcb      [100]uint16 // FCB tape.
-	fcbName  string      // Name of current FCB.
-	lpp      uint32      // Lines per page
-	lineNum  uint32      // Current line number.
-	detachk  bool        // Don't return data-check.
-	ch12     bool        // Channel 12 sense.
-	buffer   [140]uint8  // buffer.
-	bufPtr   int         // Pointer to where in buffer we are.
-	full     bool        // Buffer full.
-	debugMsk int         // Debug option mask.
+	addr     uint16           // Current device address.
+	busy     bool             // Reader busy.
+	halt     bool             // Signal halt requested.
+	sense    dev.Sense        // Sense bytes.
+	file     *os.File         // Printer file.
+	fcb      [100]uint16      // FCB tape.
+	fcbName  string           // Name of current FCB.
+	lpp      uint32           // Lines per page
+	lineNum  uint32           // Current line number.
+	detachk  bool             // Don't return data-check.
+	ch12     bool             // Channel 12 sense.
+	buffer   [140]uint8       // buffer.
+	bufPtr   int              // Pointer to where in buffer we are.
+	full     bool             // Buffer full.
+	codePage *ebcdic.CodePage // Print chain code page.
+	debugMsk int              // Debug option mask.
 }
 
 var legacy = []uint16{
@@ -139,11 +140,11 @@ func (device *Model1403ctx) StartCmd(cmd uint8) uint8 {
 	switch cmd & 3 {
 	case dev.CmdWrite:
 		if device.file == nil {
-			device.sense = dev.SenseINTVENT
+			device.sense.Put(dev.SenseINTVENT)
 			return dev.CStatusChnEnd | dev.CStatusDevEnd | dev.CStatusCheck
 		}
 		device.bufPtr = 0
-		device.sense = 0
+		device.sense.Clear()
 		device.busy = true
 		event.AddEvent(device, device.callback, 100, int(cmd))
 	case dev.CmdCTL:
@@ -151,27 +152,29 @@ func (device *Model1403ctx) StartCmd(cmd uint8) uint8 {
 			return dev.CStatusChnEnd | dev.CStatusDevEnd
 		}
 		if device.file == nil {
-			device.sense = dev.SenseINTVENT
+			device.sense.Put(dev.SenseINTVENT)
 			return dev.CStatusChnEnd | dev.CStatusDevEnd | dev.CStatusCheck
 		}
 		device.bufPtr = 0
-		device.sense = 0
+		device.sense.Clear()
 		device.busy = true
 		event.AddEvent(device, device.callback, 100, int(cmd))
 	case 0: // Sense command
 		if cmd != dev.CmdSense {
-			device.sense |= dev.SenseCMDREJ
+			device.sense.Set(dev.SenseCMDREJ)
 		} else {
 			device.busy = true
 			event.AddEvent(device, device.callback, 10, int(cmd))
-			status = 0
+			// Pending sense is returned, not posted as unit check.
+			device.halt = false
+			return 0
 		}
 
 	default:
-		device.sense = dev.SenseCMDREJ
+		device.sense.Put(dev.SenseCMDREJ)
 	}
 
-	if device.sense != 0 {
+	if device.sense.Check() {
 		status = dev.CStatusChnEnd | dev.CStatusDevEnd | dev.CStatusCheck
 	}
 	device.halt = false
@@ -186,7 +189,7 @@ func (device *Model1403ctx) HaltIO() uint8 {
 
 // Initialize a device.
 func (device *Model1403ctx) InitDev() uint8 {
-	device.sense = 0
+	device.sense.Clear()
 	device.busy = false
 	device.halt = false
 	return 0
@@ -410,7 +413,7 @@ func (device *Model1403ctx) printLine(cmd int) {
 		// Convert line to EBCDIC and output.
 		for i := range device.bufPtr {
 			ch := device.buffer[i]
-			ch = xlat.EBCDICToASCII[ch]
+			ch = device.codePage.ToASCII[ch]
 			if !unicode.IsPrint(rune(ch)) {
 				ch = '.'
 			}
@@ -433,7 +436,7 @@ func (device *Model1403ctx) printLine(cmd int) {
 			fcb := device.fcb[device.lineNum]
 			if (cmd & 3) != 1 {
 				if (fcb & (0x1000 >> 9)) != 0 {
-					device.sense |= dev.SenseOPRCHK // Channel 9
+					device.sense.Set(dev.SenseOPRCHK) // Channel 9
 				}
 				if (fcb & (0x1000 >> 12)) != 0 {
 					device.ch12 = true
@@ -479,7 +482,8 @@ func (device *Model1403ctx) callback(cmd int) {
 	if cmd == int(dev.CmdSense) {
 		device.busy = false
 		device.halt = false
-		_ = ch.ChanWriteByte(device.addr, device.sense)
+		ch.ChanWriteSense(device.addr, device.sense)
+		device.sense.Clear()
 		ch.ChanEnd(device.addr, (dev.CStatusChnEnd | dev.CStatusDevEnd))
 		return
 	}
@@ -525,7 +529,7 @@ func (device *Model1403ctx) callback(cmd int) {
 	space := (cmd >> 3) & 0x1f
 	// Check for valid form motion.
 	if (cmd&0x6) == 1 && ((space > 3 && space < 0x10) || space > 0x1d) {
-		device.sense |= dev.SenseCMDREJ
+		device.sense.Set(dev.SenseCMDREJ)
 		device.busy = false
 		device.halt = false
 		if (cmd & 0x7) == 3 {
@@ -545,7 +549,7 @@ func (device *Model1403ctx) callback(cmd int) {
 			status |= dev.CStatusExpt
 			device.ch12 = false
 		}
-		if device.sense != 0 {
+		if device.sense.Check() {
 			status |= dev.CStatusCheck
 		}
 		ch.SetDevAttn(device.addr, status)
@@ -586,7 +590,7 @@ func init() {
 
 // Create a card punch device.
 func create(devNum uint16, _ string, options []config.Option) error {
-	device := Model1403ctx{addr: devNum}
+	device := Model1403ctx{addr: devNum, codePage: ebcdic.US, sense: make([]uint8, 1)}
 	err := ch.AddDevice(&device, &device, devNum)
 	if err != nil {
 		return fmt.Errorf("unable to create 1403 at %03x", devNum)
@@ -619,6 +623,12 @@ func create(devNum uint16, _ string, options []config.Option) error {
 				return errors.New("lines per page not a number")
 			}
 			device.lpp = uint32(lines)
+		case "CODEPAGE":
+			page, errx := ebcdic.GetCodePage(option.EqualOpt)
+			if errx != nil {
+				return errx
+			}
+			device.codePage = page
 		case "FILE":
 			if device.file != nil {
 				return errors.New("file option duplicated")
@@ -43,6 +43,7 @@ import (
 	ch "github.com/rcornwell/S370/emu/sys_channel"
 	card "github.com/rcornwell/S370/util/card"
 	"github.com/rcornwell/S370/util/debug"
+	"github.com/rcornwell/S370/util/ebcdic"
 )
 
 const (
@@ -64,7 +65,7 @@ type Model2540Pctx struct {
 	err        bool          // Error pending
 	ready      bool          // Have card ready to punch
 	halt       bool          // Signal halt requested
-	sense      uint8         // Current sense byte
+	sense      dev.Sense     // Sense bytes
 	image      card.Card     // Current card image
 	context    *card.Context // Context for card reader.
 	debugMsk   int           // Debug option mask.
@@ -92,10 +93,10 @@ func (device *Model2540Pctx) StartCmd(cmd uint8) uint8 {
 	case dev.CmdWrite:
 		device.halt = false
 		device.currentCol = 0
-		device.sense = 0
+		device.sense.Clear()
 		device.ready = false
 		if !device.context.Attached() {
-			device.sense = dev.SenseINTVENT
+			device.sense.Put(dev.SenseINTVENT)
 			status = dev.CStatusChnEnd | dev.CStatusDevEnd
 		} else {
 			device.busy = true
@@ -105,29 +106,31 @@ func (device *Model2540Pctx) StartCmd(cmd uint8) uint8 {
 	// Queue up sense command
 	case dev.CmdSense:
 		if cmd != dev.CmdSense {
-			device.sense |= dev.SenseCMDREJ
+			device.sense.Set(dev.SenseCMDREJ)
 		} else {
 			device.busy = true
 			event.AddEvent(device, device.callback, 100, int(cmd))
-			status = 0
+			// Pending sense is returned, not posted as unit check.
+			device.halt = false
+			return 0
 		}
 
 	case dev.CmdCTL:
-		device.sense = 0
+		device.sense.Clear()
 		status = dev.CStatusChnEnd | dev.CStatusDevEnd
 		if cmd != dev.CmdCTL {
-			device.sense |= dev.SenseCMDREJ
+			device.sense.Set(dev.SenseCMDREJ)
 		}
 		if !device.context.Attached() {
-			device.sense = dev.SenseINTVENT
+			device.sense.Put(dev.SenseINTVENT)
 		}
 
 	default:
-		device.sense = dev.SenseCMDREJ
+		device.sense.Put(dev.SenseCMDREJ)
 	}
 
 	debug.DebugDevf(device.addr, device.debugMsk, debugCmd, "Punch cmd: %d", cmd)
-	if device.sense != 0 {
+	if device.sense.Check() {
 		status = dev.CStatusChnEnd | dev.CStatusDevEnd | dev.CStatusCheck
 	}
 	device.halt = false
@@ -145,7 +148,7 @@ func (device *Model2540Pctx) HaltIO() uint8 {
 
 // Initialize a device.
 func (device *Model2540Pctx) InitDev() uint8 {
-	device.sense = 0
+	device.sense.Clear()
 	device.busy = false
 	device.halt = false
 	device.eof = false
@@ -319,7 +322,8 @@ func (device *Model2540Pctx) callback(cmd int) {
 	if cmd == int(dev.CmdSense) {
 		device.busy = false
 		device.halt = false
-		_ = ch.ChanWriteByte(device.addr, device.sense)
+		ch.ChanWriteSense(device.addr, device.sense)
+		device.sense.Clear()
 		ch.ChanEnd(device.addr, (dev.CStatusChnEnd | dev.CStatusDevEnd))
 		return
 	}
@@ -369,7 +373,7 @@ func init() {
 
 // Create a card punch device.
 func create(devNum uint16, _ string, options []config.Option) error {
-	dev := Model2540Pctx{addr: devNum}
+	dev := Model2540Pctx{addr: devNum, sense: make([]uint8, 1)}
 	err := ch.AddDevice(&dev, &dev, devNum)
 	if err != nil {
 		return fmt.Errorf("Unable to create 2540R at %03x", devNum)
@@ -382,6 +386,12 @@ func create(devNum uint16, _ string, options []config.Option) error {
 			if !dev.context.SetFormat(option.EqualOpt) {
 				return errors.New("Invalid Card format type: " + option.EqualOpt)
 			}
+		case "CODEPAGE":
+			page, err := ebcdic.GetCodePage(option.EqualOpt)
+			if err != nil {
+				return err
+			}
+			dev.context.SetCodePage(page)
 		case "FILE":
 			if option.EqualOpt == "" {
 				return errors.New("File option missing filename")
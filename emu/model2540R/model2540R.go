@@ -43,6 +43,7 @@ import (
 	ch "github.com/rcornwell/S370/emu/sys_channel"
 	card "github.com/rcornwell/S370/util/card"
 	"github.com/rcornwell/S370/util/debug"
+	"github.com/rcornwell/S370/util/ebcdic"
 )
 
 const (
@@ -71,7 +72,7 @@ type Model2540Rctx struct {
 	err        bool          // Error pending
 	ready      bool          // Have card ready to read
 	halt       bool          // Signal halt requested
-	sense      uint8         // Current sense byte
+	sense      dev.Sense     // Sense bytes
 	image      card.Card     // Current card image
 	context    *card.Context // Context for card reader.
 	debugMsk   int           // Debug mask.
@@ -99,11 +100,11 @@ func (device *Model2540Rctx) StartCmd(cmd uint8) uint8 {
 		var err int
 		if !device.context.Attached() {
 			device.halt = false
-			device.sense = dev.SenseINTVENT
+			device.sense.Put(dev.SenseINTVENT)
 			return dev.CStatusChnEnd | dev.CStatusDevEnd | dev.CStatusCheck
 		}
 		debug.DebugDevf(device.addr, device.debugMsk, debugCmd, "Reader cmd: %d", cmd)
-		device.sense = 0
+		device.sense.Clear()
 		device.currentCol = 0
 		if device.eof {
 			device.eof = false
@@ -129,7 +130,7 @@ func (device *Model2540Rctx) StartCmd(cmd uint8) uint8 {
 
 		// Check if no more cards left in deck
 		if device.context.HopperSize() == 0 {
-			device.sense = dev.SenseINTVENT
+			device.sense.Put(dev.SenseINTVENT)
 		} else {
 			device.busy = true
 			if device.ready {
@@ -142,27 +143,29 @@ func (device *Model2540Rctx) StartCmd(cmd uint8) uint8 {
 	case dev.CmdSense:
 		debug.DebugDevf(device.addr, device.debugMsk, debugCmd, "Reader cmd: %d", cmd)
 		if cmd != dev.CmdSense {
-			device.sense |= dev.SenseCMDREJ
+			device.sense.Set(dev.SenseCMDREJ)
 		} else {
 			device.busy = true
 			event.AddEvent(device, device.callback, 100, int(cmd))
-			r = 0
+			// Pending sense is returned, not posted as unit check.
+			device.halt = false
+			return 0
 		}
 
 	case dev.CmdCTL: // Feed or nop.
 		debug.DebugDevf(device.addr, device.debugMsk, debugCmd, "Reader cmd: %d", cmd)
-		device.sense = 0
+		device.sense.Clear()
 		if cmd == dev.CmdCTL {
 			r = dev.CStatusChnEnd | dev.CStatusDevEnd
 			break
 		}
 		if !device.context.Attached() {
 			device.halt = false
-			device.sense = dev.SenseINTVENT
+			device.sense.Put(dev.SenseINTVENT)
 			break
 		}
 		if (cmd&0x30) != 0x20 || (cmd&maskStack) == maskStack {
-			device.sense |= dev.SenseCMDREJ
+			device.sense.Set(dev.SenseCMDREJ)
 			break
 		} else {
 			device.busy = true
@@ -171,10 +174,10 @@ func (device *Model2540Rctx) StartCmd(cmd uint8) uint8 {
 		}
 
 	default:
-		device.sense = dev.SenseCMDREJ
+		device.sense.Put(dev.SenseCMDREJ)
 	}
 
-	if device.sense != 0 {
+	if device.sense.Check() {
 		r = dev.CStatusChnEnd | dev.CStatusDevEnd | dev.CStatusCheck
 	}
 	device.halt = false
@@ -193,7 +196,7 @@ func (device *Model2540Rctx) HaltIO() uint8 {
 // Initialize a device.
 func (device *Model2540Rctx) InitDev() uint8 {
 	device.currentCol = 0
-	device.sense = 0
+	device.sense.Clear()
 	device.busy = false
 	device.halt = false
 	device.eof = false
@@ -396,7 +399,8 @@ func (device *Model2540Rctx) callback(cmd int) {
 	if cmd == int(dev.CmdSense) {
 		device.busy = false
 		device.halt = false
-		_ = ch.ChanWriteByte(device.addr, device.sense)
+		ch.ChanWriteSense(device.addr, device.sense)
+		device.sense.Clear()
 		ch.ChanEnd(device.addr, (dev.CStatusChnEnd | dev.CStatusDevEnd))
 		return
 	}
@@ -424,11 +428,11 @@ func (device *Model2540Rctx) callback(cmd int) {
 		case card.CardError:
 			device.err = true
 			device.ready = true
-			device.sense = dev.SenseDATCHK
+			device.sense.Put(dev.SenseDATCHK)
 		}
 
 		// If we did not get a card, return error status
-		if !device.ready || device.sense != 0 {
+		if !device.ready || device.sense.Check() {
 			device.busy = false
 			device.halt = false
 			ch.ChanEnd(device.addr, (dev.CStatusChnEnd | dev.CStatusDevEnd | dev.CStatusCheck))
@@ -460,7 +464,7 @@ func (device *Model2540Rctx) callback(cmd int) {
 	// Copy next column of card over
 	xlat = card.HolToEBCDIC(device.image.Image[device.currentCol])
 	if xlat == 0x100 {
-		device.sense = dev.SenseDATCHK
+		device.sense.Put(dev.SenseDATCHK)
 		xlat = 0
 	} else {
 		xlat &= 0xff
@@ -504,13 +508,14 @@ func init() {
 
 // Create a card reader device.
 func create(devNum uint16, _ string, options []config.Option) error {
-	dev := Model2540Rctx{addr: devNum}
+	dev := Model2540Rctx{addr: devNum, sense: make([]uint8, 1)}
 	err := ch.AddDevice(&dev, &dev, devNum)
 	if err != nil {
 		return fmt.Errorf("unable to create 2540R at %03x", devNum)
 	}
 	dev.context = card.NewCardContext(card.ModeAuto)
 	eof := false
+	file := ""
 	for _, option := range options {
 		switch strings.ToUpper(option.Name) {
 		case "FORMAT", "FMT":
@@ -521,14 +526,17 @@ func create(devNum uint16, _ string, options []config.Option) error {
 			eof = true
 		case "NOEOF":
 			eof = false
+		case "CODEPAGE":
+			page, err := ebcdic.GetCodePage(option.EqualOpt)
+			if err != nil {
+				return err
+			}
+			dev.context.SetCodePage(page)
 		case "FILE":
 			if option.EqualOpt == "" {
 				return errors.New("file option missing filename")
 			}
-			err := dev.context.Attach(option.EqualOpt, false, eof)
-			if err != nil {
-				return err
-			}
+			file = option.EqualOpt
 		default:
 			return errors.New("reader invalid option: " + option.Name)
 		}
@@ -536,5 +544,10 @@ func create(devNum uint16, _ string, options []config.Option) error {
 			return errors.New("extra options not supported on: " + option.Name)
 		}
 	}
+
+	// Read deck once all options are known.
+	if file != "" {
+		return dev.context.Attach(file, false, eof)
+	}
 	return nil
 }
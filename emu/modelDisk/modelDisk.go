@@ -0,0 +1,973 @@
+/*
+ * S370 - 2314/3330 count key data disk device.
+ *
+ * Copyright 2024, Richard Cornwell
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package modelDisk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/rcornwell/S370/command/command"
+	config "github.com/rcornwell/S370/config/configparser"
+	dev "github.com/rcornwell/S370/emu/device"
+	event "github.com/rcornwell/S370/emu/event"
+	ch "github.com/rcornwell/S370/emu/sys_channel"
+	debug "github.com/rcornwell/S370/util/debug"
+	"github.com/rcornwell/S370/util/disk"
+)
+
+const (
+	// Debug options.
+	debugCmd = 1 << iota
+	debugData
+	debugDetail
+)
+
+var debugOption = map[string]int{
+	"CMD":    debugCmd,
+	"DATA":   debugData,
+	"DETAIL": debugDetail,
+}
+
+type Model2314ctx struct {
+	addr     uint16        // Current device address
+	halt     bool          // Halt current operation
+	busy     bool          // Disk is busy
+	cyl      int           // Current cylinder
+	head     int           // Current head
+	fileMask uint8         // Current file mask
+	recPos   int           // Offset of current record on track
+	state    int           // Orientation within current record
+	index    int           // Number of index points passed
+	target   []byte        // Field being searched
+	xfer     []byte        // Data being transferred
+	xferPos  int           // Position in transfer buffer
+	xferLen  int           // Number of bytes to receive
+	sense    [24]uint8     // Sense data
+	senseLen int           // Number of sense bytes
+	rotation int           // Cycles per revolution
+	context  *disk.Context // Context for disk drive
+	debugMsk int           // Debug options mask
+}
+
+const (
+	// Command codes.
+	cmdNOP      uint8 = 0x03 // No operation
+	cmdSeek     uint8 = 0x07 // Seek cylinder and head
+	cmdSeekCyl  uint8 = 0x0b // Seek cylinder
+	cmdSeekHead uint8 = 0x1b // Seek head
+	cmdRecal    uint8 = 0x13 // Recalibrate
+	cmdSetMask  uint8 = 0x1f // Set file mask
+	cmdSrchHA   uint8 = 0x39 // Search home address equal
+	cmdSrchEQ   uint8 = 0x31 // Search ID equal
+	cmdSrchHI   uint8 = 0x51 // Search ID high
+	cmdSrchHE   uint8 = 0x71 // Search ID high or equal
+	cmdSrchKEQ  uint8 = 0x29 // Search key equal
+	cmdSrchKHI  uint8 = 0x49 // Search key high
+	cmdSrchKHE  uint8 = 0x69 // Search key high or equal
+	cmdReadHA   uint8 = 0x1a // Read home address
+	cmdReadR0   uint8 = 0x16 // Read record zero
+	cmdReadCnt  uint8 = 0x12 // Read count
+	cmdReadCKD  uint8 = 0x1e // Read count, key and data
+	cmdReadKD   uint8 = 0x0e // Read key and data
+	cmdReadD    uint8 = 0x06 // Read data
+	cmdWriteHA  uint8 = 0x19 // Write home address
+	cmdWriteR0  uint8 = 0x15 // Write record zero
+	cmdWriteCKD uint8 = 0x1d // Write count, key and data
+	cmdWriteKD  uint8 = 0x0d // Write key and data
+	cmdWriteD   uint8 = 0x05 // Write data
+	cmdSetSect  uint8 = 0x23 // Set sector
+	cmdReadSect uint8 = 0x22 // Read sector
+
+	sectorCount = 128  // Number of sectors per track
+	defRotation = 2000 // Default cycles per revolution
+
+	// Sense byte 0 values.
+	senseTrkCond uint8 = 0x02 // Track condition check
+	senseSeekChk uint8 = 0x01 // Seek check
+
+	// Sense byte 1 values.
+	senseCntChk   uint8 = 0x80 // Data check in count field
+	senseTrkOvr   uint8 = 0x40 // Track overrun
+	senseEndCyl   uint8 = 0x20 // End of cylinder
+	senseInvSeq   uint8 = 0x10 // Invalid sequence
+	senseNoRec    uint8 = 0x08 // No record found
+	senseFileProt uint8 = 0x04 // File protected
+
+	// File mask values.
+	maskWrite    uint8 = 0xc0 // Write permission bits
+	maskInhHAR0  uint8 = 0x00 // Inhibit write home address and record zero
+	maskInhWrite uint8 = 0x40 // Inhibit all writes
+	maskInhHA    uint8 = 0x80 // Inhibit write home address
+	maskAllowAll uint8 = 0xc0 // Permit all writes
+	maskSeek     uint8 = 0x18 // Seek permission bits
+	maskSeekCyl  uint8 = 0x08 // Permit seek cylinder and head only
+	maskSeekHd   uint8 = 0x10 // Permit seek head only
+	maskInhSeek  uint8 = 0x18 // Inhibit all seeks
+	maskInvalid  uint8 = 0x27 // Bits that must be zero
+)
+
+const (
+	// Orientation within a record.
+	stateCount = iota // Before count field
+	stateKey          // After count field
+	stateData         // After key field
+	stateEnd          // After data field
+)
+
+// Handle start of CCW chain.
+func (device *Model2314ctx) StartIO() uint8 {
+	// If busy return busy status right away
+	if device.busy {
+		return dev.CStatusBusy
+	}
+	device.fileMask = 0
+	device.index = 0
+	return 0
+}
+
+// Start a disk command.
+func (device *Model2314ctx) StartCmd(cmd uint8) uint8 {
+	// If busy return busy status right away
+	if device.busy {
+		return dev.CStatusBusy
+	}
+
+	debug.DebugDevf(device.addr, device.debugMsk, debugCmd, "cmd %02x", cmd)
+	switch cmd {
+	case 0:
+		return 0
+
+	// Queue up sense command
+	case dev.CmdSense:
+		device.busy = true
+		event.AddEvent(device, device.callback, 10, int(cmd))
+		return 0
+
+	case cmdNOP:
+		clear(device.sense[:])
+		if !device.context.Attached() {
+			device.sense[0] |= dev.SenseINTVENT
+			return dev.CStatusChnEnd | dev.CStatusDevEnd | dev.CStatusCheck
+		}
+		return dev.CStatusChnEnd | dev.CStatusDevEnd
+
+	case cmdSeek, cmdSeekCyl, cmdSeekHead, cmdRecal, cmdSetMask,
+		cmdSrchHA, cmdSrchEQ, cmdSrchHI, cmdSrchHE, cmdSrchKEQ, cmdSrchKHI, cmdSrchKHE,
+		cmdReadHA, cmdReadR0, cmdReadCnt, cmdReadCKD, cmdReadKD, cmdReadD,
+		cmdWriteHA, cmdWriteR0, cmdWriteCKD, cmdWriteKD, cmdWriteD,
+		cmdSetSect, cmdReadSect:
+		clear(device.sense[:])
+		if !device.context.Attached() {
+			device.sense[0] |= dev.SenseINTVENT
+			return dev.CStatusChnEnd | dev.CStatusDevEnd | dev.CStatusCheck
+		}
+		device.busy = true
+		device.halt = false
+		if cmd == cmdRecal {
+			event.AddEvent(device, device.callback, 100, int(cmd))
+			return dev.CStatusChnEnd
+		}
+		event.AddEvent(device, device.callback, 50, int(cmd))
+		return 0
+	}
+
+	device.sense[0] = dev.SenseCMDREJ
+	return dev.CStatusChnEnd | dev.CStatusDevEnd | dev.CStatusCheck
+}
+
+// Handle HIO instruction.
+func (device *Model2314ctx) HaltIO() uint8 {
+	device.halt = true
+	return 1
+}
+
+// Initialize a device.
+func (device *Model2314ctx) InitDev() uint8 {
+	device.busy = false
+	device.halt = false
+	device.fileMask = 0
+	device.recPos = disk.HALen
+	device.state = stateCount
+	return 0
+}
+
+// Shutdown device.
+func (device *Model2314ctx) Shutdown() {
+	_ = device.context.Detach()
+}
+
+// Enable debug options.
+func (device *Model2314ctx) Debug(opt string) error {
+	flag, ok := debugOption[opt]
+	if !ok {
+		return errors.New("2314 debug option invalid: " + opt)
+	}
+	device.debugMsk |= flag
+	return nil
+}
+
+// Options for attach command.
+func (device *Model2314ctx) Options(_ string) []command.Options {
+	types := disk.GetTypeList()
+	return []command.Options{
+		{
+			Name:        "file",
+			OptionType:  command.OptionFile,
+			OptionValid: command.ValidAttach | command.ValidShow,
+		},
+		{
+			Name:        "type",
+			OptionType:  command.OptionList,
+			OptionValid: command.ValidAttach | command.ValidSet | command.ValidShow,
+			OptionList:  types,
+		},
+		{
+			Name:        "ro",
+			OptionType:  command.OptionSwitch,
+			OptionValid: command.ValidAttach | command.ValidSet,
+		},
+		{
+			Name:        "rw",
+			OptionType:  command.OptionSwitch,
+			OptionValid: command.ValidAttach | command.ValidSet,
+		},
+		{
+			OptionValid: command.ValidIPL,
+		},
+	}
+}
+
+// Attach file to device.
+func (device *Model2314ctx) Attach(opts []*command.CmdOption) error {
+	err := device.Detach()
+	if err != nil {
+		return err
+	}
+
+	fileName := ""
+	for _, opt := range opts {
+		switch opt.Name {
+		case "file":
+			if opt.EqualOpt == "" {
+				return errors.New("file requires file name")
+			}
+			if fileName != "" {
+				return errors.New("only one file name option allowd")
+			}
+			fileName = opt.EqualOpt
+
+		case "type":
+			if opt.EqualOpt == "" {
+				return errors.New("type requires disk type")
+			}
+			err = device.context.SetType(opt.EqualOpt)
+			if err != nil {
+				return err
+			}
+
+		case "ro":
+			device.context.SetReadOnly()
+
+		case "rw":
+			device.context.SetReadWrite()
+
+		default:
+			return errors.New("invalid option: " + opt.Name)
+		}
+	}
+
+	if fileName == "" {
+		return errors.New("attach requires file name")
+	}
+	return device.attach(fileName)
+}
+
+// Detach device.
+func (device *Model2314ctx) Detach() error {
+	return device.context.Detach()
+}
+
+// Set command.
+func (device *Model2314ctx) Set(unset bool, opts []*command.CmdOption) error {
+	for _, opt := range opts {
+		switch opt.Name {
+		case "type":
+			if opt.EqualOpt == "" {
+				return errors.New("type requires disk type")
+			}
+			err := device.context.SetType(opt.EqualOpt)
+			if err != nil {
+				return err
+			}
+			device.senseLen = device.context.SenseLen()
+
+		case "ro":
+			if unset {
+				device.context.SetReadWrite()
+			} else {
+				device.context.SetReadOnly()
+			}
+
+		case "rw":
+			if unset {
+				device.context.SetReadOnly()
+			} else {
+				device.context.SetReadWrite()
+			}
+
+		default:
+			return errors.New("invalid option: " + opt.Name)
+		}
+	}
+	return nil
+}
+
+// Show command.
+func (device *Model2314ctx) Show(opts []*command.CmdOption) (string, error) {
+	flags := 0
+
+	str := fmt.Sprintf("%03x:", device.addr)
+	for _, opt := range opts {
+		switch opt.Name {
+		case "file":
+			flags |= 1
+		case "type":
+			flags |= 2
+		default:
+			return "", errors.New("invalid option: " + opt.Name)
+		}
+	}
+
+	if flags == 0 {
+		flags = 0x3
+	}
+	if (flags & 2) != 0 {
+		str += " TYPE=" + device.context.GetType()
+		if device.context.ReadOnly() {
+			str += " RO"
+		}
+	}
+	if (flags & 1) != 0 {
+		if device.context.Attached() {
+			str += " " + device.context.FileName()
+		} else {
+			str += " not attached"
+		}
+	}
+
+	return str, nil
+}
+
+// Rewind not supported on disk.
+func (device *Model2314ctx) Rewind() error {
+	return errors.New("rewind not supported on disk")
+}
+
+// Reset a device.
+func (device *Model2314ctx) Reset() error {
+	if device.InitDev() != 0 {
+		return errors.New("device failed to reset")
+	}
+	return nil
+}
+
+// Position of disk saved in a checkpoint.
+type diskState struct {
+	Cyl      int32     // Current cylinder
+	Head     int32     // Current head
+	FileMask uint8     // Current file mask
+	RecPos   int32     // Offset of current record on track
+	State    int32     // Orientation within current record
+	Index    int32     // Number of index points passed
+	Sense    [24]uint8 // Sense data
+}
+
+// Save disk position, disk must not be in the middle of a command.
+func (device *Model2314ctx) SaveState(w io.Writer) error {
+	if device.busy {
+		return errors.New("disk busy")
+	}
+	if err := device.context.Flush(); err != nil {
+		return err
+	}
+	state := diskState{
+		Cyl:      int32(device.cyl),
+		Head:     int32(device.head),
+		FileMask: device.fileMask,
+		RecPos:   int32(device.recPos),
+		State:    int32(device.state),
+		Index:    int32(device.index),
+		Sense:    device.sense,
+	}
+	return binary.Write(w, binary.BigEndian, &state)
+}
+
+// Restore disk position.
+func (device *Model2314ctx) LoadState(r io.Reader) error {
+	var state diskState
+	if err := binary.Read(r, binary.BigEndian, &state); err != nil {
+		return err
+	}
+	device.busy = false
+	device.halt = false
+	device.cyl = int(state.Cyl)
+	device.head = int(state.Head)
+	device.fileMask = state.FileMask
+	device.recPos = int(state.RecPos)
+	device.state = int(state.State)
+	device.index = int(state.Index)
+	device.sense = state.Sense
+	return nil
+}
+
+// Return device address.
+func (device *Model2314ctx) GetAddr() uint16 {
+	return device.addr
+}
+
+// Attach image and position heads at cylinder zero.
+func (device *Model2314ctx) attach(fileName string) error {
+	err := device.context.Attach(fileName)
+	if err != nil {
+		return err
+	}
+	device.senseLen = device.context.SenseLen()
+	device.cyl = 0
+	device.head = 0
+	device.recPos = disk.HALen
+	device.state = stateCount
+	return nil
+}
+
+// Return length of record starting at pos.
+func recLen(track []byte, pos int) int {
+	return disk.CountLen + int(track[pos+5]) + ((int(track[pos+6]) << 8) | int(track[pos+7]))
+}
+
+// Check if pos points at end of track marker.
+func isEOT(track []byte, pos int) bool {
+	if pos+4 > len(track) {
+		return true
+	}
+	return track[pos] == 0xff && track[pos+1] == 0xff && track[pos+2] == 0xff && track[pos+3] == 0xff
+}
+
+// Mark end of track at pos.
+func setEOT(track []byte, pos int) {
+	copy(track[pos:], []byte{0xff, 0xff, 0xff, 0xff})
+}
+
+// Advance to next count field. Passing the index point skips record zero
+// if skipR0 is set. Returns false if index passed twice.
+func (device *Model2314ctx) nextCount(track []byte, skipR0 bool) bool {
+	if device.state != stateCount {
+		device.recPos += recLen(track, device.recPos)
+		device.state = stateCount
+	}
+
+	for isEOT(track, device.recPos) {
+		device.index++
+		if device.index >= 2 {
+			return false
+		}
+		device.recPos = disk.HALen
+		if skipR0 && !isEOT(track, device.recPos) {
+			device.recPos += recLen(track, device.recPos)
+		}
+	}
+	return true
+}
+
+// Finish command with unit check.
+func (device *Model2314ctx) unitCheck(sense0, sense1 uint8) {
+	device.sense[0] |= sense0
+	device.sense[1] |= sense1
+	device.busy = false
+	device.halt = false
+	ch.ChanEnd(device.addr, dev.CStatusChnEnd|dev.CStatusDevEnd|dev.CStatusCheck)
+}
+
+// Check that file mask allows write command.
+func (device *Model2314ctx) writeAllowed(cmd uint8) bool {
+	if device.context.ReadOnly() {
+		return false
+	}
+	switch device.fileMask & maskWrite {
+	case maskInhWrite:
+		return false
+	case maskInhHAR0:
+		return cmd != cmdWriteHA && cmd != cmdWriteR0
+	case maskInhHA:
+		return cmd != cmdWriteHA
+	}
+	return true
+}
+
+// Start data transfer from device to channel.
+func (device *Model2314ctx) sendData(cmd int, data []byte) {
+	device.xfer = data
+	device.xferPos = 0
+	event.AddEvent(device, device.callbackOut, 10, cmd)
+}
+
+// Start data transfer from channel to device.
+func (device *Model2314ctx) recvData(cmd int, length int) {
+	device.xfer = make([]byte, 0, length)
+	device.xferLen = length
+	event.AddEvent(device, device.callbackIn, 10, cmd)
+}
+
+// Send one byte to channel.
+func (device *Model2314ctx) callbackOut(cmd int) {
+	if device.halt || device.xferPos >= len(device.xfer) {
+		device.busy = false
+		device.halt = false
+		ch.ChanEnd(device.addr, dev.CStatusChnEnd|dev.CStatusDevEnd)
+		return
+	}
+
+	debug.DebugDevf(device.addr, device.debugMsk, debugData, "read %02x", device.xfer[device.xferPos])
+	if ch.ChanWriteByte(device.addr, device.xfer[device.xferPos]) {
+		device.xferPos = len(device.xfer)
+	} else {
+		device.xferPos++
+	}
+	event.AddEvent(device, device.callbackOut, 10, cmd)
+}
+
+// Receive one byte from channel.
+func (device *Model2314ctx) callbackIn(cmd int) {
+	if device.halt || len(device.xfer) >= device.xferLen {
+		device.finishIn(cmd)
+		return
+	}
+
+	data, end := ch.ChanReadByte(device.addr)
+	if end {
+		device.finishIn(cmd)
+		return
+	}
+	debug.DebugDevf(device.addr, device.debugMsk, debugData, "write %02x", data)
+	device.xfer = append(device.xfer, data)
+
+	// Once count field received, find length of record.
+	if (uint8(cmd) == cmdWriteCKD || uint8(cmd) == cmdWriteR0) && len(device.xfer) == disk.CountLen {
+		device.xferLen = recLen(device.xfer, 0)
+	}
+	event.AddEvent(device, device.callbackIn, 10, cmd)
+}
+
+// Return sector currently under the heads.
+func (device *Model2314ctx) sector() int {
+	return (event.Now() % device.rotation) * sectorCount / device.rotation
+}
+
+// Return cycles until sector comes under the heads.
+func (device *Model2314ctx) sectorDelay(sect int) int {
+	target := sect * device.rotation / sectorCount
+	delay := (target - event.Now()%device.rotation + device.rotation) % device.rotation
+	if delay == 0 {
+		delay = device.rotation
+	}
+	return delay
+}
+
+// Seek or set sector completed.
+func (device *Model2314ctx) callbackSeek(_ int) {
+	device.busy = false
+	device.halt = false
+	ch.SetDevAttn(device.addr, dev.CStatusDevEnd)
+}
+
+// Move heads to new cylinder and head.
+func (device *Model2314ctx) seek(cmd int, cyl, head int) {
+	if cyl >= device.context.Cylinders() || head >= device.context.Heads() {
+		device.unitCheck(dev.SenseCMDREJ|senseSeekChk, 0)
+		return
+	}
+	err := device.context.SeekTrack(cyl, head)
+	if err != nil {
+		slog.Error(err.Error())
+		device.unitCheck(dev.SenseEQUCHK, 0)
+		return
+	}
+
+	diff := cyl - device.cyl
+	if diff < 0 {
+		diff = -diff
+	}
+	device.cyl = cyl
+	device.head = head
+	device.recPos = disk.HALen
+	device.state = stateCount
+	device.index = 0
+	debug.DebugDevf(device.addr, device.debugMsk, debugCmd, "seek %d %d", cyl, head)
+	if uint8(cmd) == cmdRecal {
+		event.AddEvent(device, device.callbackSeek, 100+diff*20, cmd)
+		return
+	}
+	if diff == 0 {
+		device.busy = false
+		ch.ChanEnd(device.addr, dev.CStatusChnEnd|dev.CStatusDevEnd)
+		return
+	}
+	// Release channel while arm moves.
+	ch.ChanEnd(device.addr, dev.CStatusChnEnd)
+	event.AddEvent(device, device.callbackSeek, 100+diff*20, cmd)
+}
+
+// Finish commands which received data from channel.
+func (device *Model2314ctx) finishIn(cmd int) {
+	track := device.context.Track()
+	data := device.xfer
+	switch uint8(cmd) {
+	case cmdSeek, cmdSeekCyl, cmdSeekHead:
+		if len(data) < 6 || data[0] != 0 || data[1] != 0 {
+			device.unitCheck(dev.SenseCMDREJ, 0)
+			return
+		}
+		cyl := (int(data[2]) << 8) | int(data[3])
+		head := (int(data[4]) << 8) | int(data[5])
+		switch device.fileMask & maskSeek {
+		case maskInhSeek:
+			device.unitCheck(0, senseFileProt)
+			return
+		case maskSeekHd:
+			if cmd != int(cmdSeekHead) && cyl != device.cyl {
+				device.unitCheck(0, senseFileProt)
+				return
+			}
+		case maskSeekCyl:
+			if cmd == int(cmdSeek) && cyl != device.cyl {
+				device.unitCheck(0, senseFileProt)
+				return
+			}
+		}
+		device.seek(cmd, cyl, head)
+		return
+
+	case cmdSetMask:
+		if len(data) < 1 || (data[0]&maskInvalid) != 0 {
+			device.unitCheck(dev.SenseCMDREJ, 0)
+			return
+		}
+		device.fileMask = data[0]
+
+	case cmdSetSect:
+		if len(data) < 1 || int(data[0]) >= sectorCount {
+			device.unitCheck(dev.SenseCMDREJ, 0)
+			return
+		}
+		// Release channel until sector comes around.
+		delay := device.sectorDelay(int(data[0]))
+		debug.DebugDevf(device.addr, device.debugMsk, debugDetail, "set sector %d wait %d", data[0], delay)
+		ch.ChanEnd(device.addr, dev.CStatusChnEnd)
+		event.AddEvent(device, device.callbackSeek, delay, cmd)
+		return
+
+	case cmdSrchHA, cmdSrchEQ, cmdSrchHI, cmdSrchHE, cmdSrchKEQ, cmdSrchKHI, cmdSrchKHE:
+		target := device.target[:len(data)]
+		result := bytes.Compare(target, data)
+		found := false
+		switch uint8(cmd) {
+		case cmdSrchHA, cmdSrchEQ, cmdSrchKEQ:
+			found = result == 0
+		case cmdSrchHI, cmdSrchKHI:
+			found = result > 0
+		case cmdSrchHE, cmdSrchKHE:
+			found = result >= 0
+		}
+		debug.DebugDevf(device.addr, device.debugMsk, debugDetail, "search %x %x %t", target, data, found)
+		device.busy = false
+		device.halt = false
+		if found && len(data) != 0 {
+			device.index = 0
+			ch.ChanEnd(device.addr, dev.CStatusChnEnd|dev.CStatusDevEnd|dev.CStatusSMS)
+		} else {
+			ch.ChanEnd(device.addr, dev.CStatusChnEnd|dev.CStatusDevEnd)
+		}
+		return
+
+	case cmdWriteHA:
+		copy(track[:disk.HALen], data)
+		setEOT(track, disk.HALen)
+		device.recPos = disk.HALen
+		device.state = stateCount
+
+	case cmdWriteR0, cmdWriteCKD:
+		pos := disk.HALen
+		if uint8(cmd) == cmdWriteCKD {
+			pos = device.recPos + recLen(track, device.recPos)
+		}
+		if len(data) < disk.CountLen {
+			device.unitCheck(dev.SenseCMDREJ, 0)
+			return
+		}
+		length := recLen(data, 0)
+		if pos+length+4 > device.context.TrackSize() {
+			device.unitCheck(0, senseTrkOvr)
+			return
+		}
+		clear(track[pos : pos+length])
+		copy(track[pos:], data)
+		setEOT(track, pos+length)
+		device.recPos = pos
+		device.state = stateEnd
+
+	case cmdWriteKD, cmdWriteD:
+		pos := device.recPos + disk.CountLen
+		length := recLen(track, device.recPos) - disk.CountLen
+		if uint8(cmd) == cmdWriteD {
+			pos += int(track[device.recPos+5])
+			length -= int(track[device.recPos+5])
+		}
+		clear(track[pos : pos+length])
+		copy(track[pos:pos+length], data)
+		device.state = stateEnd
+	}
+
+	switch uint8(cmd) {
+	case cmdWriteHA, cmdWriteR0, cmdWriteCKD, cmdWriteKD, cmdWriteD:
+		err := device.context.MarkDirty()
+		if err != nil {
+			device.unitCheck(0, senseFileProt)
+			return
+		}
+	}
+	device.busy = false
+	device.halt = false
+	ch.ChanEnd(device.addr, dev.CStatusChnEnd|dev.CStatusDevEnd)
+}
+
+// Process disk operations.
+func (device *Model2314ctx) callback(cmd int) {
+	if uint8(cmd) == dev.CmdSense {
+		device.sense[4] = uint8(device.addr & 0x7)
+		data := append([]byte{}, device.sense[:device.senseLen]...)
+		clear(device.sense[:])
+		device.sendData(cmd, data)
+		return
+	}
+
+	err := device.context.SeekTrack(device.cyl, device.head)
+	if err != nil {
+		slog.Error(err.Error())
+		device.unitCheck(dev.SenseEQUCHK, 0)
+		return
+	}
+	track := device.context.Track()
+
+	switch uint8(cmd) {
+	case cmdSeek, cmdSeekCyl, cmdSeekHead:
+		device.recvData(cmd, 6)
+
+	case cmdRecal:
+		if device.fileMask&maskSeek != 0 {
+			device.busy = false
+			device.sense[1] |= senseFileProt
+			ch.SetDevAttn(device.addr, dev.CStatusDevEnd|dev.CStatusCheck)
+			return
+		}
+		device.seek(cmd, 0, 0)
+
+	case cmdSetMask, cmdSetSect:
+		device.recvData(cmd, 1)
+
+	case cmdReadSect:
+		device.sendData(cmd, []byte{uint8(device.sector())})
+
+	case cmdSrchHA:
+		device.recPos = disk.HALen
+		device.state = stateCount
+		device.target = track[1:disk.HALen]
+		device.recvData(cmd, len(device.target))
+
+	case cmdSrchEQ, cmdSrchHI, cmdSrchHE:
+		if !device.nextCount(track, false) {
+			device.unitCheck(0, senseNoRec)
+			return
+		}
+		device.target = track[device.recPos : device.recPos+5]
+		device.state = stateKey
+		device.recvData(cmd, len(device.target))
+
+	case cmdSrchKEQ, cmdSrchKHI, cmdSrchKHE:
+		if !device.nextCount(track, true) {
+			device.unitCheck(0, senseNoRec)
+			return
+		}
+		pos := device.recPos + disk.CountLen
+		device.target = track[pos : pos+int(track[device.recPos+5])]
+		device.state = stateData
+		device.recvData(cmd, len(device.target))
+
+	case cmdReadHA:
+		device.recPos = disk.HALen
+		device.state = stateCount
+		device.index = 0
+		device.sendData(cmd, track[:disk.HALen])
+
+	case cmdReadR0:
+		device.recPos = disk.HALen
+		if isEOT(track, device.recPos) {
+			device.unitCheck(0, senseNoRec)
+			return
+		}
+		device.state = stateEnd
+		device.sendData(cmd, track[device.recPos:device.recPos+recLen(track, device.recPos)])
+
+	case cmdReadCnt, cmdReadCKD, cmdReadKD, cmdReadD:
+		oriented := device.state == stateKey || device.state == stateData
+		if uint8(cmd) == cmdReadKD {
+			oriented = device.state == stateKey
+		}
+		if !oriented || uint8(cmd) == cmdReadCnt || uint8(cmd) == cmdReadCKD {
+			if !device.nextCount(track, true) {
+				device.unitCheck(0, senseNoRec)
+				return
+			}
+		}
+		start := device.recPos
+		end := start + recLen(track, start)
+		switch uint8(cmd) {
+		case cmdReadCnt:
+			end = start + disk.CountLen
+			device.state = stateKey
+		case cmdReadKD:
+			start += disk.CountLen
+			device.state = stateEnd
+		case cmdReadD:
+			start += disk.CountLen + int(track[start+5])
+			device.state = stateEnd
+		default:
+			device.state = stateEnd
+		}
+		device.sendData(cmd, track[start:end])
+
+	case cmdWriteHA, cmdWriteR0, cmdWriteCKD, cmdWriteKD, cmdWriteD:
+		if !device.writeAllowed(uint8(cmd)) {
+			device.unitCheck(dev.SenseCMDREJ, senseFileProt)
+			return
+		}
+		length := 0
+		switch uint8(cmd) {
+		case cmdWriteHA:
+			length = disk.HALen
+		case cmdWriteR0:
+			length = disk.CountLen
+		case cmdWriteCKD:
+			// Must follow a count field.
+			if device.state == stateCount || isEOT(track, device.recPos) {
+				device.unitCheck(dev.SenseCMDREJ, senseInvSeq)
+				return
+			}
+			length = disk.CountLen
+		case cmdWriteKD:
+			if device.state != stateKey {
+				device.unitCheck(dev.SenseCMDREJ, senseInvSeq)
+				return
+			}
+			length = recLen(track, device.recPos) - disk.CountLen
+		case cmdWriteD:
+			if device.state != stateKey && device.state != stateData {
+				device.unitCheck(dev.SenseCMDREJ, senseInvSeq)
+				return
+			}
+			length = recLen(track, device.recPos) - disk.CountLen - int(track[device.recPos+5])
+		}
+		device.recvData(cmd, length)
+	}
+}
+
+// register a device on initialize.
+func init() {
+	config.RegisterModel("2314", config.TypeModel, create2314)
+	config.RegisterModel("3330", config.TypeModel, create3330)
+}
+
+// Create a 2314 disk device.
+func create2314(devNum uint16, _ string, options []config.Option) error {
+	return create(devNum, "2314", options)
+}
+
+// Create a 3330 disk device.
+func create3330(devNum uint16, _ string, options []config.Option) error {
+	return create(devNum, "3330", options)
+}
+
+// Create a disk device.
+func create(devNum uint16, model string, options []config.Option) error {
+	device := Model2314ctx{addr: devNum}
+	err := ch.AddDevice(&device, &device, devNum)
+	if err != nil {
+		return fmt.Errorf("unable to create %s at %03x: %w", model, devNum, err)
+	}
+	device.context = disk.NewDiskContext()
+	_ = device.context.SetType(model)
+	device.senseLen = device.context.SenseLen()
+	device.recPos = disk.HALen
+	device.rotation = defRotation
+	fileName := ""
+	for _, option := range options {
+		switch strings.ToUpper(option.Name) {
+		case "-r", "RO":
+			device.context.SetReadOnly()
+
+		case "-rw", "RW":
+			device.context.SetReadWrite()
+
+		case "FILE":
+			if option.EqualOpt == "" {
+				return errors.New("file option missing filename")
+			}
+			fileName = option.EqualOpt
+
+		case "ROTATION":
+			rotation, err := strconv.Atoi(option.EqualOpt)
+			if err != nil || rotation < sectorCount {
+				return errors.New("rotation option invalid: " + option.EqualOpt)
+			}
+			device.rotation = rotation
+
+		default:
+			return errors.New(model + " invalid option " + option.Name)
+		}
+		if option.Value != nil {
+			return errors.New("extra options not supported on: " + option.Name)
+		}
+	}
+
+	if fileName != "" {
+		return device.attach(fileName)
+	}
+	return nil
+}
@@ -0,0 +1,291 @@
+/*
+ * S370 - 2314/3330 count key data disk device tests.
+ *
+ * Copyright 2024, Richard Cornwell
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package modelDisk
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	config "github.com/rcornwell/S370/config/configparser"
+	D "github.com/rcornwell/S370/emu/device"
+	ev "github.com/rcornwell/S370/emu/event"
+	mem "github.com/rcornwell/S370/emu/memory"
+	Ch "github.com/rcornwell/S370/emu/sys_channel"
+)
+
+const diskAddr uint16 = 0x190
+
+// Create channel and attach disk image.
+func setup(t *testing.T, options ...config.Option) {
+	t.Helper()
+	mem.SetSize(64)
+	Ch.InitializeChannels()
+	Ch.AddChannel(1, D.TypeSel, 0)
+	fileName := filepath.Join(t.TempDir(), "disk.ckd")
+	opts := []config.Option{{Name: "FILE", EqualOpt: fileName}}
+	opts = append(opts, options...)
+	err := create2314(diskAddr, "", opts)
+	if err != nil {
+		t.Fatalf("Unable to create disk: %v", err)
+	}
+}
+
+// Read byte from main memory.
+func getMemByte(addr uint32) uint8 {
+	v := mem.GetMemory(addr)
+	b := uint8((v >> (8 * (3 - (addr & 3))) & 0xff))
+	return b
+}
+
+// Start channel program at addr and wait for it to finish.
+func runProgram(t *testing.T, addr uint32) (uint32, uint32) {
+	t.Helper()
+	mem.SetMemory(0x40, 0)
+	mem.SetMemory(0x44, 0)
+	mem.SetMemory(0x48, addr)
+	cc := Ch.StartIO(diskAddr)
+	if cc != 0 {
+		t.Fatalf("Start I/O expected %d got: %d", 0, cc)
+	}
+
+	d := D.NoDev
+	for range 100000 {
+		ev.Advance(1)
+		d = Ch.ChanScan(0xffff, true)
+		if d != D.NoDev {
+			break
+		}
+	}
+	Ch.IrqPending = false
+	if d != diskAddr {
+		t.Fatalf("Channel program did not finish got: %04x", d)
+	}
+	return mem.GetMemory(0x40), mem.GetMemory(0x44)
+}
+
+// Format one track and read back a record.
+func TestFormatTrack(t *testing.T) {
+	setup(t)
+
+	// Format cylinder 1 head 2.
+	mem.SetMemory(0x500, 0x07000600) // Seek
+	mem.SetMemory(0x504, 0x40000006)
+	mem.SetMemory(0x508, 0x1f000608) // Set file mask
+	mem.SetMemory(0x50c, 0x40000001)
+	mem.SetMemory(0x510, 0x19000610) // Write home address
+	mem.SetMemory(0x514, 0x40000005)
+	mem.SetMemory(0x518, 0x15000618) // Write R0
+	mem.SetMemory(0x51c, 0x40000010)
+	mem.SetMemory(0x520, 0x1d000628) // Write R1
+	mem.SetMemory(0x524, 0x0000001c)
+
+	mem.SetMemory(0x600, 0x00000001) // Seek address
+	mem.SetMemory(0x604, 0x0002ffff)
+	mem.SetMemory(0x608, 0xc0ffffff) // File mask
+	mem.SetMemory(0x60c, 0xffffffff)
+	mem.SetMemory(0x610, 0x00000100) // Home address
+	mem.SetMemory(0x614, 0x02ffffff)
+	mem.SetMemory(0x618, 0x00010002) // R0
+	mem.SetMemory(0x61c, 0x00000008)
+	mem.SetMemory(0x620, 0x00000000)
+	mem.SetMemory(0x624, 0x00000000)
+	mem.SetMemory(0x628, 0x00010002) // R1
+	mem.SetMemory(0x62c, 0x01040010)
+	mem.SetMemory(0x630, 0xd2c5e8f1) // Key
+	for i := range uint32(4) {
+		mem.SetMemory(0x634+(i*4), 0xf0f1f2f3+(i*0x04040404))
+	}
+
+	csw1, csw2 := runProgram(t, 0x500)
+	if csw1 != 0x00000528 {
+		t.Errorf("Format CSW1 expected %08x got: %08x", 0x00000528, csw1)
+	}
+	if csw2 != 0x0c000000 {
+		t.Errorf("Format CSW2 expected %08x got: %08x", 0x0c000000, csw2)
+	}
+
+	// Search for R1 and read the data.
+	mem.SetMemory(0x540, 0x07000600) // Seek
+	mem.SetMemory(0x544, 0x40000006)
+	mem.SetMemory(0x548, 0x31000660) // Search ID equal
+	mem.SetMemory(0x54c, 0x40000005)
+	mem.SetMemory(0x550, 0x08000548) // TIC
+	mem.SetMemory(0x554, 0x00000000)
+	mem.SetMemory(0x558, 0x06000700) // Read data
+	mem.SetMemory(0x55c, 0x00000010)
+	mem.SetMemory(0x660, 0x00010002) // Search argument
+	mem.SetMemory(0x664, 0x01ffffff)
+	for i := uint32(0x700); i < 0x720; i += 4 {
+		mem.SetMemory(i, 0x55555555)
+	}
+
+	csw1, csw2 = runProgram(t, 0x540)
+	if csw1 != 0x00000560 {
+		t.Errorf("Read CSW1 expected %08x got: %08x", 0x00000560, csw1)
+	}
+	if csw2 != 0x0c000000 {
+		t.Errorf("Read CSW2 expected %08x got: %08x", 0x0c000000, csw2)
+	}
+	for i := range uint32(0x10) {
+		b := getMemByte(0x700 + i)
+		if b != uint8(0xf0+i) {
+			t.Errorf("Read invalid data %02x expected: %02x got %02x", i, 0xf0+i, b)
+		}
+	}
+	if getMemByte(0x710) != 0x55 {
+		t.Errorf("Read modified memory past record got %02x", getMemByte(0x710))
+	}
+
+	// Read key and data of R1 after reading count.
+	mem.SetMemory(0x580, 0x07000600) // Seek
+	mem.SetMemory(0x584, 0x40000006)
+	mem.SetMemory(0x588, 0x16000670) // Read R0
+	mem.SetMemory(0x58c, 0x40000010)
+	mem.SetMemory(0x590, 0x12000688) // Read count
+	mem.SetMemory(0x594, 0x40000008)
+	mem.SetMemory(0x598, 0x0e000700) // Read key and data
+	mem.SetMemory(0x59c, 0x00000014)
+
+	csw1, csw2 = runProgram(t, 0x580)
+	if csw1 != 0x000005a0 {
+		t.Errorf("Read KD CSW1 expected %08x got: %08x", 0x000005a0, csw1)
+	}
+	if csw2 != 0x0c000000 {
+		t.Errorf("Read KD CSW2 expected %08x got: %08x", 0x0c000000, csw2)
+	}
+	if v := mem.GetMemory(0x688); v != 0x00010002 {
+		t.Errorf("Read count expected %08x got: %08x", 0x00010002, v)
+	}
+	if v := mem.GetMemory(0x700); v != 0xd2c5e8f1 {
+		t.Errorf("Read key expected %08x got: %08x", 0xd2c5e8f1, v)
+	}
+}
+
+// Search for missing record should report no record found.
+func TestNoRecord(t *testing.T) {
+	setup(t)
+
+	mem.SetMemory(0x500, 0x31000600) // Search ID equal
+	mem.SetMemory(0x504, 0x40000005)
+	mem.SetMemory(0x508, 0x08000500) // TIC
+	mem.SetMemory(0x50c, 0x00000000)
+	mem.SetMemory(0x510, 0x06000700) // Read data
+	mem.SetMemory(0x514, 0x00000010)
+	mem.SetMemory(0x518, 0x04000700) // Sense
+	mem.SetMemory(0x51c, 0x00000006)
+	mem.SetMemory(0x600, 0x00000000) // Search argument
+	mem.SetMemory(0x604, 0x05ffffff)
+
+	_, csw2 := runProgram(t, 0x500)
+	if csw2 != 0x0e400005 {
+		t.Errorf("Search CSW2 expected %08x got: %08x", 0x0e400005, csw2)
+	}
+
+	csw1, csw2 := runProgram(t, 0x518)
+	if csw1 != 0x00000520 {
+		t.Errorf("Sense CSW1 expected %08x got: %08x", 0x00000520, csw1)
+	}
+	if csw2 != 0x0c000000 {
+		t.Errorf("Sense CSW2 expected %08x got: %08x", 0x0c000000, csw2)
+	}
+	if b := getMemByte(0x701); b != senseNoRec {
+		t.Errorf("Sense byte 1 expected %02x got: %02x", senseNoRec, b)
+	}
+}
+
+// Set sector should wait less than one revolution before search.
+func TestSetSector(t *testing.T) {
+	rotation := 1280
+	setup(t, config.Option{Name: "ROTATION", EqualOpt: "1280"})
+
+	mem.SetMemory(0x500, 0x23000600) // Set sector
+	mem.SetMemory(0x504, 0x40000001)
+	mem.SetMemory(0x508, 0x22000601) // Read sector
+	mem.SetMemory(0x50c, 0x40000001)
+	mem.SetMemory(0x510, 0x31000610) // Search ID equal
+	mem.SetMemory(0x514, 0x40000005)
+	mem.SetMemory(0x518, 0x08000510) // TIC
+	mem.SetMemory(0x51c, 0x00000000)
+	mem.SetMemory(0x520, 0x03000000) // NOP
+	mem.SetMemory(0x524, 0x00000001)
+	mem.SetMemory(0x600, 0x40ff0000) // Sector 64
+	mem.SetMemory(0x610, 0x00000000) // Search argument R0
+	mem.SetMemory(0x614, 0x00ffffff)
+
+	start := ev.Now()
+	csw1, csw2 := runProgram(t, 0x500)
+	elapsed := ev.Now() - start
+	if csw1 != 0x00000528 {
+		t.Errorf("Set sector CSW1 expected %08x got: %08x", 0x00000528, csw1)
+	}
+	if csw2 != 0x0c000001 {
+		t.Errorf("Set sector CSW2 expected %08x got: %08x", 0x0c000001, csw2)
+	}
+	if elapsed > rotation+500 {
+		t.Errorf("Set sector took longer than one revolution: %d", elapsed)
+	}
+	if sect := getMemByte(0x601); sect < 0x40 || sect >= 0x50 {
+		t.Errorf("Read sector expected near %02x got: %02x", 0x40, sect)
+	}
+}
+
+// Disk position should survive a checkpoint.
+func TestCheckpoint(t *testing.T) {
+	setup(t)
+
+	mem.SetMemory(0x500, 0x07000600) // Seek
+	mem.SetMemory(0x504, 0x40000006)
+	mem.SetMemory(0x508, 0x03000000) // NOP
+	mem.SetMemory(0x50c, 0x00000001)
+	mem.SetMemory(0x600, 0x00000005)
+	mem.SetMemory(0x604, 0x0003ffff)
+	runProgram(t, 0x500)
+
+	d, err := Ch.GetDevice(diskAddr)
+	if err != nil {
+		t.Fatalf("Unable to find disk: %v", err)
+	}
+	device := d.(*Model2314ctx)
+	var buf bytes.Buffer
+	if err := device.SaveState(&buf); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	device.cyl = 0
+	device.head = 0
+	if err := device.LoadState(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if device.cyl != 5 || device.head != 3 {
+		t.Errorf("Position expected 5/3 got: %d/%d", device.cyl, device.head)
+	}
+
+	device.busy = true
+	if err := device.SaveState(&buf); err == nil {
+		t.Error("Save of busy disk did not fail")
+	}
+	device.busy = false
+}
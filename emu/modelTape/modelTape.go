@@ -24,8 +24,10 @@
 package modelTape
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"strings"
 
@@ -531,6 +533,47 @@ func (device *Model2400ctx) Reset() error {
 	return nil
 }
 
+// Position of tape saved in a checkpoint.
+type tapeState struct {
+	Position int64    // Offset of tape in file
+	Frame    int64    // Current frame
+	Mark     bool     // Last read detected a mark
+	Sense    [6]uint8 // Sense data
+}
+
+// Save tape position, tape must not be moving.
+func (device *Model2400ctx) SaveState(w io.Writer) error {
+	if device.busy || device.rewind {
+		return errors.New("tape busy")
+	}
+	state := tapeState{Mark: device.mark, Sense: device.sense}
+	if device.context.Attached() {
+		pos, frame, err := device.context.Position()
+		if err != nil {
+			return err
+		}
+		state.Position = pos
+		state.Frame = int64(frame)
+	}
+	return binary.Write(w, binary.BigEndian, &state)
+}
+
+// Restore tape position.
+func (device *Model2400ctx) LoadState(r io.Reader) error {
+	var state tapeState
+	if err := binary.Read(r, binary.BigEndian, &state); err != nil {
+		return err
+	}
+	device.busy = false
+	device.halt = false
+	device.mark = state.Mark
+	device.sense = state.Sense
+	if !device.context.Attached() {
+		return nil
+	}
+	return device.context.SetPosition(state.Position, int(state.Frame))
+}
+
 // Return device address.
 func (device *Model2400ctx) GetAddr() uint16 {
 	return device.addr
@@ -870,7 +913,6 @@ func (device *Model2400ctx) callback(cmd int) {
 			device.halt = false
 			device.mark = true
 			event.AddEvent(device, device.callbackFinish, 1000, cmd)
-			ch.ChanEnd(device.addr, dev.CStatusChnEnd)
 			return
 		}
 		if err != nil {
@@ -894,7 +936,6 @@ func (device *Model2400ctx) callback(cmd int) {
 			device.halt = false
 			device.mark = true
 			event.AddEvent(device, device.callbackFinish, 1000, cmd)
-			ch.ChanEnd(device.addr, dev.CStatusChnEnd)
 			return
 		}
 		if err != nil {
@@ -987,20 +1028,31 @@ func (device *Model2400ctx) callback(cmd int) {
 
 // register a device on initialize.
 func init() {
-	config.RegisterModel("2400", config.TypeModel, create)
+	config.RegisterModel("2400", config.TypeModel, create2400)
+	config.RegisterModel("3420", config.TypeModel, create3420)
+}
+
+// Create a 2400 tape drive.
+func create2400(devNum uint16, _ string, options []config.Option) error {
+	return create(devNum, "2400", 6, options)
+}
+
+// Create a 3420 tape drive.
+func create3420(devNum uint16, _ string, options []config.Option) error {
+	return create(devNum, "3420", 24, options)
 }
 
-// Create a card punch device.
-func create(devNum uint16, _ string, options []config.Option) error {
+// Create a tape drive.
+func create(devNum uint16, model string, senseLen int, options []config.Option) error {
 	device := Model2400ctx{addr: devNum}
 	err := ch.AddDevice(&device, &device, devNum)
 	if err != nil {
-		return fmt.Errorf("unable to create 2400 at %03x: %w", devNum, err)
+		return fmt.Errorf("unable to create %s at %03x: %w", model, devNum, err)
 	}
 	device.context = tape.NewTapeContext()
 	device.conv = true
 	device.odd = true
-	device.senseLen = 6
+	device.senseLen = senseLen
 	for _, option := range options {
 		switch strings.ToUpper(option.Name) {
 		case "FORMAT", "FMT":
@@ -1032,7 +1084,7 @@ func create(devNum uint16, _ string, options []config.Option) error {
 			}
 
 		default:
-			return errors.New("2400 invalid option " + option.Name)
+			return errors.New(model + " invalid option " + option.Name)
 		}
 		if option.Value != nil {
 			return errors.New("extra options not supported on: " + option.Name)
@@ -0,0 +1,177 @@
+/*
+ * S370 - 2400/3420 tape device tests.
+ *
+ * Copyright 2024, Richard Cornwell
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package modelTape
+
+import (
+	"path/filepath"
+	"testing"
+
+	config "github.com/rcornwell/S370/config/configparser"
+	D "github.com/rcornwell/S370/emu/device"
+	ev "github.com/rcornwell/S370/emu/event"
+	mem "github.com/rcornwell/S370/emu/memory"
+	Ch "github.com/rcornwell/S370/emu/sys_channel"
+)
+
+const tapeAddr uint16 = 0x180
+
+// Create channel and attach empty tape.
+func setup(t *testing.T) {
+	t.Helper()
+	mem.SetSize(64)
+	Ch.InitializeChannels()
+	Ch.AddChannel(1, D.TypeSel, 0)
+	fileName := filepath.Join(t.TempDir(), "test.tap")
+	opts := []config.Option{{Name: "FORMAT", EqualOpt: "TAP"}, {Name: "RING"}, {Name: "FILE", EqualOpt: fileName}}
+	err := create2400(tapeAddr, "", opts)
+	if err != nil {
+		t.Fatalf("Unable to create tape: %v", err)
+	}
+}
+
+// Read byte from main memory.
+func getMemByte(addr uint32) uint8 {
+	v := mem.GetMemory(addr)
+	b := uint8((v >> (8 * (3 - (addr & 3))) & 0xff))
+	return b
+}
+
+// Start channel program at addr, return condition code.
+func startProgram(addr uint32) uint8 {
+	mem.SetMemory(0x40, 0)
+	mem.SetMemory(0x44, 0)
+	mem.SetMemory(0x48, addr)
+	return Ch.StartIO(tapeAddr)
+}
+
+// Wait for channel program to finish and return CSW.
+func waitProgram(t *testing.T) (uint32, uint32) {
+	t.Helper()
+	d := D.NoDev
+	for range 1000000 {
+		ev.Advance(1)
+		d = Ch.ChanScan(0xffff, true)
+		if d != D.NoDev {
+			break
+		}
+	}
+	Ch.IrqPending = false
+	if d != tapeAddr {
+		t.Fatalf("Channel program did not finish got: %04x", d)
+	}
+	return mem.GetMemory(0x40), mem.GetMemory(0x44)
+}
+
+// Write two records and a mark, rewind and read them back.
+func TestWriteRead(t *testing.T) {
+	setup(t)
+
+	mem.SetMemory(0x500, 0x01000600) // Write record 1
+	mem.SetMemory(0x504, 0x40000010)
+	mem.SetMemory(0x508, 0x01000610) // Write record 2
+	mem.SetMemory(0x50c, 0x40000008)
+	mem.SetMemory(0x510, 0x1f000000) // Write tape mark
+	mem.SetMemory(0x514, 0x40000001)
+	mem.SetMemory(0x518, 0x07000000) // Rewind
+	mem.SetMemory(0x51c, 0x00000001)
+	for i := range uint32(4) {
+		mem.SetMemory(0x600+(i*4), 0xf0f1f2f3+(i*0x04040404))
+	}
+	mem.SetMemory(0x610, 0x00010203)
+	mem.SetMemory(0x614, 0x04050607)
+
+	cc := startProgram(0x500)
+	if cc != 0 {
+		t.Fatalf("Start I/O expected %d got: %d", 0, cc)
+	}
+	csw1, csw2 := waitProgram(t)
+	if csw1 != 0x00000520 {
+		t.Errorf("Write CSW1 expected %08x got: %08x", 0x00000520, csw1)
+	}
+	if csw2 != 0x08000001 {
+		t.Errorf("Write CSW2 expected %08x got: %08x", 0x08000001, csw2)
+	}
+
+	// Wait for rewind to finish.
+	_, csw2 = waitProgram(t)
+	if csw2 != 0x04000000 {
+		t.Errorf("Rewind CSW2 expected %08x got: %08x", 0x04000000, csw2)
+	}
+
+	// Read records back until tape mark.
+	mem.SetMemory(0x540, 0x02000700) // Read record 1
+	mem.SetMemory(0x544, 0x60000020)
+	mem.SetMemory(0x548, 0x02000720) // Read record 2
+	mem.SetMemory(0x54c, 0x60000020)
+	mem.SetMemory(0x550, 0x02000740) // Read tape mark
+	mem.SetMemory(0x554, 0x20000020)
+	for i := uint32(0x700); i < 0x760; i += 4 {
+		mem.SetMemory(i, 0x55555555)
+	}
+
+	cc = startProgram(0x540)
+	if cc != 0 {
+		t.Fatalf("Start I/O expected %d got: %d", 0, cc)
+	}
+	csw1, csw2 = waitProgram(t)
+	if csw1 != 0x00000558 {
+		t.Errorf("Read CSW1 expected %08x got: %08x", 0x00000558, csw1)
+	}
+	if csw2 != 0x0d000020 {
+		t.Errorf("Read CSW2 expected %08x got: %08x", 0x0d000020, csw2)
+	}
+	for i := range uint32(0x10) {
+		b := getMemByte(0x700 + i)
+		if b != uint8(0xf0+i) {
+			t.Errorf("Record 1 invalid data %02x expected: %02x got %02x", i, 0xf0+i, b)
+		}
+	}
+	for i := range uint32(0x8) {
+		b := getMemByte(0x720 + i)
+		if b != uint8(0x00+i) {
+			t.Errorf("Record 2 invalid data %02x expected: %02x got %02x", i, 0x00+i, b)
+		}
+	}
+	if getMemByte(0x728) != 0x55 || getMemByte(0x740) != 0x55 {
+		t.Errorf("Read modified memory past record")
+	}
+}
+
+// Back space at load point should give unit check.
+func TestBackspaceLoadPoint(t *testing.T) {
+	setup(t)
+
+	mem.SetMemory(0x500, 0x27000000) // Back space record
+	mem.SetMemory(0x504, 0x00000001)
+	cc := startProgram(0x500)
+	if cc != 1 {
+		t.Errorf("Start I/O expected %d got: %d", 1, cc)
+	}
+	csw2 := mem.GetMemory(0x44)
+	if (csw2 & 0xffff0000) != 0x0e000000 {
+		t.Errorf("Backspace CSW2 expected %08x got: %08x", 0x0e000000, csw2&0xffff0000)
+	}
+}
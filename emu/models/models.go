@@ -33,4 +33,6 @@ import (
 	_ "github.com/rcornwell/S370/emu/model2540P"
 
 	_ "github.com/rcornwell/S370/emu/modelTape"
+
+	_ "github.com/rcornwell/S370/emu/modelDisk"
 )
@@ -171,6 +171,7 @@ const (
 	OpCLM   = 0xBD // 370 Compare character under mask
 	OpSTCM  = 0xBE // 370 Store character under mask
 	OpICM   = 0xBF // 370 Insert character under mask
+	OpTRTR  = 0xD0 // Translate and test reverse
 	OpMVN   = 0xD1
 	OpMVC   = 0xD2
 	OpMVZ   = 0xD3
@@ -34,6 +34,7 @@ import (
 	command "github.com/rcornwell/S370/command/command"
 	config "github.com/rcornwell/S370/config/configparser"
 	dev "github.com/rcornwell/S370/emu/device"
+	event "github.com/rcornwell/S370/emu/event"
 	mem "github.com/rcornwell/S370/emu/memory"
 	tel "github.com/rcornwell/S370/telnet"
 	debug "github.com/rcornwell/S370/util/debug"
@@ -62,9 +63,26 @@ const (
 	bufEmpty uint8 = 0x04 // Buffer is empty
 	bufEnd   uint8 = 0x10 // Device has returned channel end, no more data
 
+	reconnectTime = 10 // Cycles before disconnected subchannel retries
+
+	defaultMaxCCW = 100000 // Default most CCWs fetched by one channel program
+
+	// Device status requesting the current CCW be executed again.
+	retryStatus = dev.CStatusSMS | dev.CStatusCheck
+
 	// Addresses for reading and writing channel status to.
 	CSW uint32 = 0x40 // Channel Status Word
 	CAW uint32 = 0x48 // Channel Address Word
+	LCL uint32 = 0xb0 // Limited Channel Logout
+
+	// Limited channel logout, detected by channel during data transfer.
+	logoutDetect   uint32 = 0x04000000 // Detected by channel
+	logoutSource   uint32 = 0x00400000 // Source is channel
+	logoutSeqValid uint32 = 0x00002000 // Sequence code valid
+	logoutSeqData  uint32 = 0x00000500 // Data transfer in progress
+
+	// Channel checks requiring a logout.
+	chanCheck = statusCDChk | statusCCChk | statusCIChk
 
 	// Channel status information.
 	statusAttn   uint16 = 0x8000 // Device raised attention
@@ -114,6 +132,8 @@ type chanCtl struct {
 	devAddr    uint16     // Device on channel
 	chanByte   uint8      // Current byte, dirty/full
 	chainFlg   bool       // Holding on chain
+	reconnect  bool       // Waiting to reconnect to block multiplexer
+	ccwFetch   int        // Number of CCWs fetched by channel program
 }
 
 // Holds channel information.
@@ -123,11 +143,17 @@ type chanDev struct {
 	devTab     [256]dev.Device      // Pointer to device interfaces
 	devCmd     [256]command.Command // Pointer to command options for device.
 	devTel     [256]tel.Telnet      // Telnet device.
+	offline    [256]bool            // Device varied offline by operator
 	chanType   int                  // Type of channel
 	numSubChan int                  // Number of subchannels
 	irqPending bool                 // Channel has pending IRQ
 	subChans   []chanCtl            // Subchannel control
+	connected  *chanCtl             // Subchannel transferring data on block multiplexer
+	availIrq   bool                 // Present channel available interrupts
+	availWait  bool                 // SIO found channel busy
+	availPend  bool                 // Channel available interrupt pending
 	debugMsk   int                  // Debug mask for channel
+	maxCCW     int                  // Most CCWs in one channel program, 0 no limit
 }
 
 var (
@@ -146,6 +172,36 @@ func SetBMUXenable(enable bool) {
 	bmuxEnable = enable
 }
 
+// Return true if channel is operating as block multiplexer.
+func blockMux(cUnit *chanDev) bool {
+	return cUnit.chanType == dev.TypeBMux && bmuxEnable
+}
+
+// Release channel if subchannel is connected to it.
+func disconnect(cUnit *chanDev, subChan *chanCtl) {
+	if cUnit.connected == subChan {
+		cUnit.connected = nil
+	}
+}
+
+// Check if another subchannel is transferring data on channel.
+func channelBusy(cUnit *chanDev, subChan *chanCtl) bool {
+	return blockMux(cUnit) && cUnit.connected != nil && cUnit.connected != subChan
+}
+
+// Retry subchannel once block multiplexer channel might be free.
+func waitReconnect(cUnit *chanDev, subChan *chanCtl) {
+	if subChan.reconnect || subChan.dev == nil {
+		return
+	}
+	subChan.reconnect = true
+	event.AddEvent(subChan.dev, func(_ int) {
+		subChan.reconnect = false
+		cUnit.irqPending = true
+		IrqPending = true
+	}, reconnectTime, 0)
+}
+
 // Return type of channel.
 func GetType(devNum uint16) int {
 	cUnit := chanUnit[(devNum>>8)&0xf]
@@ -166,8 +222,8 @@ func StartIO(devNum uint16) uint8 {
 
 	subChan := findSubChannel(devNum)
 	dNum := devNum & 0xff
-	// If no device or channel, return CC = 3
-	if cUnit.devTab[dNum] == nil || subChan == nil {
+	// If no device or channel, or device offline, return CC = 3
+	if cUnit.devTab[dNum] == nil || subChan == nil || cUnit.offline[dNum] {
 		return 3
 	}
 
@@ -179,6 +235,13 @@ func StartIO(devNum uint16) uint8 {
 
 	// If channel is active return cc = 2
 	if subChan.ccwCmd != 0 || (subChan.ccwFlags&(chainCmd|chainData)) != 0 || subChan.chanStatus != 0 {
+		cUnit.availWait = cUnit.availIrq
+		return 2
+	}
+
+	// Block multiplexer transferring data for another subchannel
+	if channelBusy(cUnit, subChan) {
+		cUnit.availWait = cUnit.availIrq
 		return 2
 	}
 
@@ -212,6 +275,7 @@ func StartIO(devNum uint16) uint8 {
 	subChan.caw &= addrMask
 	subChan.devAddr = devNum
 	subChan.dev = cUnit.devTab[dNum]
+	subChan.ccwFetch = 0
 	cUnit.devStatus[dNum] = 0
 
 	if loadCCW(cUnit, subChan, false) {
@@ -274,11 +338,18 @@ func TestIO(devNum uint16) uint8 {
 	subChan := findSubChannel(devNum)
 	dNum := devNum & 0xff
 
-	// If no device or channel, return CC = 3
-	if cUnit.devTab[dNum] == nil || subChan == nil {
+	// If no device or channel, or device offline, return CC = 3
+	if cUnit.devTab[dNum] == nil || subChan == nil || cUnit.offline[dNum] {
 		return 3
 	}
 
+	// Shared subchannel working for or holding status of another device on
+	// the control unit, return cc=2
+	if subChan.devAddr != devNum && subChan.devAddr != dev.NoDev &&
+		(subChan.ccwCmd != 0 || (subChan.ccwFlags&(chainCmd|chainData)) != 0 || subChan.chanStatus != 0) {
+		return 2
+	}
+
 	// If any error pending save csw and return cc=1
 	if (subChan.chanStatus & errorStatus) != 0 {
 		storeCSW(cUnit, subChan)
@@ -324,18 +395,12 @@ func TestIO(devNum uint16) uint8 {
 	// Nothing pending, send a 0 command to device to get status
 	status := uint16(cUnit.devTab[dNum].StartCmd(0)) << 8
 
-	// If we get a error, save csw and return cc = 1
-	if (status & errorStatus) != 0 {
+	// If we get a error or device is busy, save csw and return cc = 1
+	if (status & (errorStatus | statusBusy)) != 0 {
 		mem.SetMemoryMask(CSW+4, uint32(status)<<16, statusMask)
 		return 1
 	}
 
-	// Check if device BUSY
-	if (status & statusBusy) != 0 {
-		// fmt.Println("TIO cc = 2")
-		return 2
-	}
-
 	// Everything ok, return cc = 0
 	// fmt.Println("TIO cc = 0")
 	return 0
@@ -352,8 +417,8 @@ func HaltIO(devNum uint16) uint8 {
 
 	subChan := findSubChannel(devNum)
 	dNum := devNum & 0xff
-	// If no device or channel, return CC = 3
-	if cUnit.devTab[dNum] == nil || subChan == nil {
+	// If no device or channel, or device offline, return CC = 3
+	if cUnit.devTab[dNum] == nil || subChan == nil || cUnit.offline[dNum] {
 		return 3
 	}
 
@@ -395,6 +460,106 @@ func HaltIO(devNum uint16) uint8 {
 	return cc
 }
 
+// Handle HDV instruction.
+func HaltDevice(devNum uint16) uint8 {
+	ch := (devNum >> 8) & 0xf
+	cUnit := chanUnit[ch]
+	// Check if channel disabled
+	if cUnit == nil {
+		return 3
+	}
+
+	subChan := findSubChannel(devNum)
+	dNum := devNum & 0xff
+	// If no device or channel, or device offline, return CC = 3
+	if cUnit.devTab[dNum] == nil || subChan == nil || cUnit.offline[dNum] {
+		return 3
+	}
+
+	// Channel working with another device, leave it alone.
+	if subChan.devAddr != devNum && (subChan.ccwCmd != 0 || (subChan.ccwFlags&(chainCmd|chainData)) != 0) {
+		return 2
+	}
+
+	// Interrupt pending for device, nothing to halt.
+	if cUnit.devStatus[dNum] != 0 {
+		return 0
+	}
+	if subChan.devAddr == devNum && subChan.ccwCmd == 0 &&
+		(subChan.ccwFlags&(chainCmd|chainData)) == 0 && subChan.chanStatus != 0 {
+		return 0
+	}
+
+	// Device not working with channel.
+	if subChan.devAddr != devNum {
+		_ = cUnit.devTab[dNum].HaltIO()
+		mem.SetMemoryMask(CSW+4, 0, statusMask)
+		return 1
+	}
+
+	// Device transfering data, stop transfer but leave channel running.
+	_ = cUnit.devTab[dNum].HaltIO()
+	subChan.chanByte = bufEnd
+	subChan.ccwFlags &= ^(chainCmd | chainData)
+	subChan.ccwFlags |= flagSLI
+	mem.SetMemory(CSW, (uint32(subChan.ccwKey)<<24)|subChan.caw)
+	mem.SetMemory(CSW+4, uint32(subChan.ccwCount)|(uint32(subChan.chanStatus&errorStatus)<<16))
+	debug.DebugChanf(cUnit.number, cUnit.debugMsk, debugCmd, "HDV CSW %08x %08x", mem.GetMemory(CSW), mem.GetMemory(CSW+4))
+	return 1
+}
+
+// Handle CLRIO instruction.
+func ClearIO(devNum uint16) uint8 {
+	ch := (devNum >> 8) & 0xf
+	cUnit := chanUnit[ch]
+	// Check if channel disabled
+	if cUnit == nil {
+		return 3
+	}
+
+	subChan := findSubChannel(devNum)
+	dNum := devNum & 0xff
+	// If no device or channel, or device offline, return CC = 3
+	if cUnit.devTab[dNum] == nil || subChan == nil || cUnit.offline[dNum] {
+		return 3
+	}
+
+	if subChan.devAddr == devNum {
+		// If device is active, tell it to stop.
+		if subChan.ccwCmd != 0 || (subChan.ccwFlags&(chainCmd|chainData)) != 0 {
+			_ = cUnit.devTab[dNum].HaltIO()
+		}
+
+		// Store status and release subchannel.
+		storeCSW(cUnit, subChan)
+		subChan.chanStatus = 0
+		subChan.ccwCmd = 0
+		subChan.ccwFlags = 0
+		subChan.chanByte = bufEnd
+		subChan.chanDirty = false
+		subChan.chainFlg = false
+		subChan.devAddr = dev.NoDev
+		subChan.dev = nil
+		disconnect(cUnit, subChan)
+		cUnit.devStatus[dNum] = 0
+		return 1
+	}
+
+	// Channel working with another device.
+	if subChan.ccwCmd != 0 || (subChan.ccwFlags&(chainCmd|chainData)) != 0 {
+		return 2
+	}
+
+	// Clear any pending status for device.
+	if cUnit.devStatus[dNum] != 0 {
+		mem.SetMemory(CSW, 0)
+		mem.SetMemory(CSW+4, uint32(cUnit.devStatus[dNum])<<24)
+		cUnit.devStatus[dNum] = 0
+		return 1
+	}
+	return 0
+}
+
 // Handle TCH instruction.
 func TestChan(devNum uint16) uint8 {
 	/* 360 Principles of Operation says, "Bit positions 21-23 of the
@@ -462,7 +627,7 @@ func TestChan(devNum uint16) uint8 {
 func ChanReadByte(devNum uint16) (uint8, bool) {
 	// Return abort if no channel
 	subChan := findSubChannel(devNum)
-	if subChan == nil {
+	if subChan == nil || subChan.devAddr != devNum {
 		return 0, true
 	}
 	// Channel has pending system status
@@ -529,11 +694,20 @@ func ChanReadByte(devNum uint16) (uint8, bool) {
 	return data, false
 }
 
+// Write sense bytes to memory, stop when channel ends transfer.
+func ChanWriteSense(devNum uint16, sense []uint8) {
+	for _, by := range sense {
+		if ChanWriteByte(devNum, by) {
+			return
+		}
+	}
+}
+
 // Write a byte to memory.
 func ChanWriteByte(devNum uint16, data uint8) bool {
 	// Return abort if no channel
 	subChan := findSubChannel(devNum)
-	if subChan == nil {
+	if subChan == nil || subChan.devAddr != devNum {
 		return true
 	}
 	// Channel has pending system status
@@ -642,7 +816,7 @@ func ChanWriteByte(devNum uint16, data uint8) bool {
 func nextAddress(cUnit *chanDev, subChan *chanCtl) bool {
 	if (subChan.ccwFlags & flagIDA) != 0 {
 		if (subChan.ccwCmd & 0xf) == dev.CmdRDBWD {
-			subChan.ccwIAddr--
+			subChan.ccwIAddr -= 1 + (subChan.ccwIAddr & 0x3)
 			if (subChan.ccwIAddr & 0x7ff) == 0x7ff {
 				subChan.ccwAddr += 4
 				word, err := readFullWord(cUnit, subChan, subChan.ccwAddr)
@@ -652,7 +826,7 @@ func nextAddress(cUnit *chanDev, subChan *chanCtl) bool {
 				subChan.ccwIAddr = word & mem.AMASK
 			}
 		} else {
-			subChan.ccwIAddr++
+			subChan.ccwIAddr += 4 - (subChan.ccwIAddr & 0x3)
 			if (subChan.ccwIAddr & 0x7ff) == 0x000 {
 				subChan.ccwAddr += 4
 				word, err := readFullWord(cUnit, subChan, subChan.ccwAddr)
@@ -662,7 +836,6 @@ func nextAddress(cUnit *chanDev, subChan *chanCtl) bool {
 				subChan.ccwIAddr = word & mem.AMASK
 			}
 		}
-		subChan.chanByte = uint8(subChan.ccwIAddr & 3)
 		return false
 	}
 	if (subChan.ccwCmd & 0xf) == dev.CmdRDBWD {
@@ -681,6 +854,11 @@ func ChanEnd(devNum uint16, flags uint8) {
 		return
 	}
 
+	// Device no longer connected to subchannel.
+	if subChan.devAddr != devNum {
+		return
+	}
+
 	ch := (devNum >> 8) & 0xf
 	cUnit := chanUnit[ch]
 	if subChan.chanDirty {
@@ -688,9 +866,23 @@ func ChanEnd(devNum uint16, flags uint8) {
 			subChan.devAddr, subChan.ccwAddr, subChan.chanBuffer)
 		_ = writeBuffer(cUnit, subChan)
 	}
+
+	// Device requested command retry, back up and chain to same CCW.
+	if (flags&retryStatus) == retryStatus && (subChan.chanStatus&0xff) == 0 {
+		subChan.caw = (subChan.caw - 8) & addrMask
+		subChan.chanStatus = statusChnEnd
+		subChan.ccwFlags = chainCmd
+		subChan.ccwCmd = 0
+		disconnect(cUnit, subChan)
+		debug.DebugChanf(cUnit.number, cUnit.debugMsk, debugDetail, "Channel %03x retry: %08x", subChan.devAddr, subChan.caw)
+		cUnit.irqPending = true
+		IrqPending = true
+		return
+	}
 	subChan.chanStatus |= statusChnEnd
 	subChan.chanStatus |= uint16(flags) << 8
 	subChan.ccwCmd = 0
+	disconnect(cUnit, subChan)
 
 	// If count not zero and not suppressing length, report error
 	if subChan.ccwCount != 0 && (subChan.ccwFlags&flagSLI) == 0 {
@@ -716,6 +908,15 @@ func ChanEnd(devNum uint16, flags uint8) {
 	IrqPending = true
 }
 
+// Device detected bad data during transfer, stop transfer.
+func ChanDataCheck(devNum uint16) {
+	subChan := findSubChannel(devNum)
+	if subChan == nil || subChan.devAddr != devNum {
+		return
+	}
+	subChan.chanStatus |= statusCDChk
+}
+
 // A device wishes to inform the CPU it needs some service.
 func SetDevAttn(devNum uint16, flags uint8) {
 	subChan := findSubChannel(devNum)
@@ -734,6 +935,7 @@ func SetDevAttn(devNum uint16, flags uint8) {
 			((subChan.chanStatus&statusChnEnd) != 0 || subChan.ccwCmd != 0) {
 			subChan.chanStatus |= uint16(flags) << 8
 			subChan.ccwCmd = 0
+			disconnect(cUnit, subChan)
 		} else { // Device reporting status change
 			cUnit.devStatus[devNum&0xff] = flags
 		}
@@ -743,6 +945,13 @@ func SetDevAttn(devNum uint16, flags uint8) {
 	IrqPending = true
 }
 
+// Post unsolicited attention from an idle device. The status is held
+// until the channel is enabled for interrupts, then stored in the CSW
+// with a zero command address.
+func PostAttention(devNum uint16) {
+	SetDevAttn(devNum, dev.CStatusAttn)
+}
+
 // Reset all channels.
 func ResetChannels() {
 	for _, cUnit := range chanUnit {
@@ -759,9 +968,13 @@ func ResetChannels() {
 			subChan.chanStatus = 0
 			subChan.chanDirty = false
 			subChan.chainFlg = false
+			subChan.reconnect = false
 			subChan.dev = nil
 		}
 
+		cUnit.connected = nil
+		cUnit.availWait = false
+		cUnit.availPend = false
 		cUnit.irqPending = false
 		// Call initialize function for each device.
 		for j := range 256 {
@@ -809,9 +1022,9 @@ func Debug(number int, opt string) error {
 	return nil
 }
 
-// Scan channels looking for ready device.
+// Scan channels looking for ready device. Channels are scanned from 0 up
+// and subchannels in order, the first enabled device found has priority.
 func scanChannels(mask uint16, irqEnb bool) uint16 {
-	pendDev := dev.NoDev // Device with Pending interrupt
 	// Start with channel 0 and work through all channels
 	for i, cUnit := range chanUnit {
 		if cUnit == nil {
@@ -834,18 +1047,21 @@ func scanChannels(mask uint16, irqEnb bool) uint16 {
 
 			// Check if PCI pending
 			if irqEnb && (imask&mask) != 0 && (subChan.chanStatus&statusPCI) != 0 {
-				pendDev = subChan.devAddr
-				break
+				return subChan.devAddr
 			}
 
 			// If device has hard error, store CSW and end.
 			if irqEnb && (imask&mask) != 0 && (subChan.chanStatus&0xff) != 0 {
-				pendDev = subChan.devAddr
-				break
+				return subChan.devAddr
 			}
 
 			// If chaining and device end continue
 			if subChan.chainFlg && (subChan.chanStatus&statusDevEnd) != 0 {
+				// Wait for block multiplexer to be free
+				if channelBusy(cUnit, subChan) {
+					waitReconnect(cUnit, subChan)
+					continue
+				}
 				// Restart command that was flagged as an issue
 				_ = loadCCW(cUnit, subChan, true)
 				continue
@@ -854,19 +1070,23 @@ func scanChannels(mask uint16, irqEnb bool) uint16 {
 			if (subChan.chanStatus & statusChnEnd) != 0 {
 				// Grab another command if command chaining in effect
 				if (subChan.ccwFlags & chainCmd) != 0 {
+					// Wait for block multiplexer to be free
+					if channelBusy(cUnit, subChan) {
+						waitReconnect(cUnit, subChan)
+						continue
+					}
 					// If channel end, check if we should continue
 					_ = loadCCW(cUnit, subChan, true)
 				} else if irqEnb || Loading != dev.NoDev {
 					// Disconnect from device
 					if (imask&mask) != 0 || Loading != dev.NoDev {
-						pendDev = subChan.devAddr
-						break
+						return subChan.devAddr
 					}
 				}
 			}
 		}
 	}
-	return pendDev
+	return dev.NoDev
 }
 
 // Scan all channels and see if one is ready to start or has interrupt pending.
@@ -899,6 +1119,12 @@ func ChanScan(mask uint16, irqEnb bool) uint16 {
 		if Loading == dev.NoDev {
 			storeCSW(cUnit, subChan)
 			cUnit.devStatus[pendDev&0xff] = 0
+			// Let waiting program know channel is free
+			if cUnit.availWait && subChan.chanStatus == 0 {
+				cUnit.availWait = false
+				cUnit.availPend = true
+				cUnit.irqPending = true
+			}
 			return pendDev
 		}
 	} else if irqEnb {
@@ -914,6 +1140,16 @@ func ChanScan(mask uint16, irqEnb bool) uint16 {
 				continue
 			}
 			cUnit.irqPending = false
+			// Channel available, report with device address of zero
+			if cUnit.availPend {
+				cUnit.availPend = false
+				cUnit.irqPending = true
+				IrqPending = true
+				mem.SetMemory(CSW, 0)
+				mem.SetMemory(CSW+4, 0)
+				debug.DebugChanf(cUnit.number, cUnit.debugMsk, debugDetail, "Channel %d available", cUnit.number)
+				return uint16(i) << 8
+			}
 			for j := range 256 {
 				// Look for device with pending status
 				if cUnit.devStatus[j] != 0 {
@@ -973,6 +1209,7 @@ func IPLDevice(devNum uint16) error {
 	subChan.ccwCmd = dev.CmdRead
 	subChan.chanByte = bufEmpty
 	subChan.chanDirty = false
+	subChan.ccwFetch = 0
 
 	subChan.chanStatus |= uint16(subChan.dev.StartCmd(subChan.ccwCmd)) << 8
 
@@ -1073,7 +1310,39 @@ func DelDevice(devNum uint16) {
 	if cUnit != nil {
 		cUnit.devTab[dNum] = nil
 		cUnit.devStatus[dNum] = 0
+		cUnit.offline[dNum] = false
+	}
+}
+
+// Vary a device online or offline. Offline devices appear not operational.
+func SetOnline(devNum uint16, online bool) error {
+	cUnit := chanUnit[(devNum>>8)&0xf]
+	if cUnit == nil || cUnit.devTab[devNum&0xff] == nil {
+		return fmt.Errorf("device %03x doesn't exist", devNum)
+	}
+	cUnit.offline[devNum&0xff] = !online
+	return nil
+}
+
+// Enable or disable channel available interrupts on a channel.
+func SetChanAvail(cNum int, enable bool) error {
+	if cNum >= len(chanUnit) || chanUnit[cNum] == nil {
+		return fmt.Errorf("channel %d doesn't exist", cNum)
+	}
+	chanUnit[cNum].availIrq = enable
+	if !enable {
+		chanUnit[cNum].availWait = false
 	}
+	return nil
+}
+
+// Return true if device exists and is online.
+func IsOnline(devNum uint16) bool {
+	cUnit := chanUnit[(devNum>>8)&0xf]
+	if cUnit == nil || cUnit.devTab[devNum&0xff] == nil {
+		return false
+	}
+	return !cUnit.offline[devNum&0xff]
 }
 
 // Add a telnet connection for device.
@@ -1155,6 +1424,7 @@ func AddChannel(cNum int, ty int, subchan int) {
 	chanUnit[cNum] = &cUnit
 	cUnit.numSubChan = numSubChan
 	cUnit.chanType = ty
+	cUnit.maxCCW = defaultMaxCCW
 	sc := [256]chanCtl{}
 	cUnit.subChans = sc[:numSubChan]
 }
@@ -1204,8 +1474,14 @@ func findSubChannel(devNum uint16) *chanCtl {
 
 // Save full csw.
 func storeCSW(cUnit *chanDev, subChan *chanCtl) {
+	if traceCCW {
+		traceStatus(subChan)
+	}
 	mem.SetMemory(CSW, (uint32(subChan.ccwKey)<<24)|subChan.caw)
 	mem.SetMemory(CSW+4, uint32(subChan.ccwCount)|(uint32(subChan.chanStatus)<<16))
+	if (subChan.chanStatus & chanCheck) != 0 {
+		mem.SetMemory(LCL, logoutDetect|logoutSource|logoutSeqValid|logoutSeqData)
+	}
 	debug.DebugChanf(cUnit.number, cUnit.debugMsk, debugCmd, "CSW %08x %08x", mem.GetMemory(CSW), mem.GetMemory(CSW+4))
 	if (subChan.chanStatus & statusPCI) != 0 {
 		subChan.chanStatus &= ^statusPCI
@@ -1216,6 +1492,9 @@ func storeCSW(cUnit *chanDev, subChan *chanCtl) {
 }
 
 // Load in the next CCW, return true if failure, false if success.
+// CCWs are not prefetched, each one is read from main memory only when
+// the channel reaches it. A program may therefore modify later CCWs, for
+// example from a PCI interrupt handler, and the channel will see the change.
 func loadCCW(cUnit *chanDev, subChan *chanCtl, ticOk bool) bool {
 	var word uint32
 	var err bool
@@ -1243,6 +1522,17 @@ loop:
 		// Remember if we were chainging
 		chain = (subChan.ccwFlags & chainCmd) != 0
 
+		// Stop runaway channel program
+		subChan.ccwFetch++
+		if cUnit.maxCCW != 0 && subChan.ccwFetch > cUnit.maxCCW {
+			subChan.chanStatus |= statusCCChk
+			subChan.ccwCmd = 0
+			subChan.ccwFlags = 0
+			cUnit.irqPending = true
+			IrqPending = true
+			return true
+		}
+
 		// Check if we have status modifier set
 		if (subChan.chanStatus & statusSMS) != 0 {
 			subChan.caw += 8
@@ -1300,6 +1590,9 @@ loop:
 		}
 		subChan.ccwFlags = uint16(word>>16) & 0xff00
 		subChan.chanByte = bufEmpty
+		if traceCCW {
+			traceFetch(subChan, subChan.caw-8, cmd)
+		}
 
 		// Check if invalid count
 		if subChan.ccwCount == 0 {
@@ -1347,11 +1640,11 @@ loop:
 		}
 		subChan.chanStatus &= 0xff
 		subChan.chanStatus |= status
-		// Check if any errors from initial command
+		// Check if any errors from initial command, status stays with
+		// subchannel so CSW shows the failing CCW.
 		if (subChan.chanStatus & (statusAttn | statusCheck | statusExcept)) != 0 {
 			subChan.ccwCmd = 0
 			subChan.ccwFlags = 0
-			cUnit.devStatus[subChan.devAddr&0xff] = uint8((subChan.chanStatus >> 8) & 0xff)
 			cUnit.irqPending = true
 			IrqPending = true
 			return true
@@ -1365,6 +1658,9 @@ loop:
 				cUnit.irqPending = true
 				IrqPending = true
 			}
+		} else if blockMux(cUnit) {
+			// Device holds channel until it disconnects
+			cUnit.connected = subChan
 		}
 	}
 
@@ -1387,7 +1683,7 @@ func readFullWord(cUnit *chanDev, subChan *chanCtl, addr uint32) (uint32, bool)
 	}
 	if subChan.ccwKey != 0 {
 		key := mem.GetKey(addr)
-		if (key&0x8) != 0 && (key&0xf0) != subChan.ccwKey {
+		if (key&mem.KeyFetch) != 0 && (key&mem.KeyMask) != subChan.ccwKey {
 			subChan.chanStatus |= statusProt
 			cUnit.irqPending = true
 			IrqPending = true
@@ -1443,7 +1739,7 @@ func writeBuffer(cUnit *chanDev, subChan *chanCtl) bool {
 	// Check protection key
 	if subChan.ccwKey != 0 {
 		k := mem.GetKey(addr)
-		if (k & 0xf0) != subChan.ccwKey {
+		if (k & mem.KeyMask) != subChan.ccwKey {
 			subChan.chanStatus |= statusProt
 			subChan.chanByte = bufEnd
 			subChan.chanDirty = false
@@ -1463,6 +1759,15 @@ func writeBuffer(cUnit *chanDev, subChan *chanCtl) bool {
 // register a channel create on initialize.
 func init() {
 	config.RegisterModel("CHANNEL", config.TypeOptions, create)
+	config.RegisterModel("OFFLINE", config.TypeOption, setOffline)
+}
+
+// Mark device offline at startup.
+func setOffline(devNum uint16, _ string, _ []config.Option) error {
+	if devNum == dev.NoDev {
+		return errors.New("offline requires device number")
+	}
+	return SetOnline(devNum, false)
 }
 
 // Create a channel.
@@ -1484,6 +1789,8 @@ func create(_ uint16, number string, options []config.Option) error {
 
 	chanType := 0
 	subChans := uint64(0)
+	maxCCW := -1
+	availIrq := false
 	for _, option := range options {
 		switch strings.ToUpper(option.Name) {
 		case "MPX", "MUX":
@@ -1510,6 +1817,14 @@ func create(_ uint16, number string, options []config.Option) error {
 			if err != nil || subChans > 256 {
 				return errors.New("subchannel option: " + option.EqualOpt + " invalid must be less than 256")
 			}
+		case "MAXCCW":
+			count, err := strconv.ParseUint(option.EqualOpt, 10, 31)
+			if err != nil {
+				return errors.New("maxccw option: " + option.EqualOpt + " invalid")
+			}
+			maxCCW = int(count)
+		case "AVAIL":
+			availIrq = true
 		default:
 			return errors.New("channel invalid option: " + option.Name)
 		}
@@ -1527,5 +1842,9 @@ func create(_ uint16, number string, options []config.Option) error {
 	}
 
 	AddChannel(chanNum, chanType, int(subChans))
+	if maxCCW >= 0 {
+		chanUnit[chanNum].maxCCW = maxCCW
+	}
+	chanUnit[chanNum].availIrq = availIrq
 	return nil
 }
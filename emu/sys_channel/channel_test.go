@@ -26,11 +26,21 @@
 package syschannel_test
 
 import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	config "github.com/rcornwell/S370/config/configparser"
+	_ "github.com/rcornwell/S370/emu/cpu"
 	D "github.com/rcornwell/S370/emu/device"
 	ev "github.com/rcornwell/S370/emu/event"
 	mem "github.com/rcornwell/S370/emu/memory"
+	_ "github.com/rcornwell/S370/emu/model1403"
+	_ "github.com/rcornwell/S370/emu/modelTape"
 	Ch "github.com/rcornwell/S370/emu/sys_channel"
 	Td "github.com/rcornwell/S370/emu/test_dev"
 )
@@ -53,17 +63,12 @@ func setup() *Td.TestDev {
 
 // Read byte from main memory.
 func getMemByte(addr uint32) uint8 {
-	v := mem.GetMemory(addr)
-	b := uint8((v >> (8 * (3 - (addr & 3))) & 0xff))
-	return b
+	return mem.GetByte(addr)
 }
 
 // write byte to main memory.
 func setMemByte(addr uint32, data uint32) {
-	off := 8 * (3 - (addr & 3))
-	m := uint32(0xff << off)
-	d := (data & 0xff) << off
-	mem.SetMemoryMask(addr, d, m)
+	mem.SetByte(addr, uint8(data))
 }
 
 func runChannel() uint16 {
@@ -1183,6 +1188,82 @@ func TestStartIOCChainNop(t *testing.T) {
 	}
 }
 
+// Unit check on second CCW ends chain, CSW points after failing CCW.
+func TestStartIOCChainCheck(t *testing.T) {
+	d := setup()
+	d.Max = 0x10
+
+	mem.SetMemory(0x40, 0xffffffff)
+	mem.SetMemory(0x44, 0xffffffff)
+	mem.SetMemory(0x48, 0x500)
+	mem.SetMemory(0x500, 0x03000600) // NOP, command chain
+	mem.SetMemory(0x504, 0x40000001)
+	mem.SetMemory(0x508, 0x23000600) // Invalid command, command chain
+	mem.SetMemory(0x50c, 0x40000010)
+	mem.SetMemory(0x510, 0x02000600) // Read
+	mem.SetMemory(0x514, 0x00000010)
+	mem.SetMemory(0x600, 0x55555555)
+
+	cc := Ch.StartIO(0x00f)
+	if cc != 0 {
+		t.Errorf("Start I/O CChain check expected %d got: %d", 0, cc)
+	}
+
+	dev := runChannel()
+	if dev != 0xf {
+		t.Errorf("Start I/O CChain check expected %d got: %d", 0xf, dev)
+	}
+	if v := mem.GetMemory(0x40); v != 0x00000510 {
+		t.Errorf("Start I/O CChain check CSW1 expected %08x got: %08x", 0x00000510, v)
+	}
+	if v := mem.GetMemory(0x44); v != 0x0e000010 {
+		t.Errorf("Start I/O CChain check CSW2 expected %08x got: %08x", 0x0e000010, v)
+	}
+	if v := mem.GetMemory(0x600); v != 0x55555555 {
+		t.Errorf("Start I/O CChain check read executed got: %08x", v)
+	}
+}
+
+// Unit exception on second CCW ends chain without error.
+func TestStartIOCChainExpt(t *testing.T) {
+	d := setup()
+	d.Max = 0x10
+	d.Expt = true
+
+	mem.SetMemory(0x40, 0xffffffff)
+	mem.SetMemory(0x44, 0xffffffff)
+	mem.SetMemory(0x48, 0x500)
+	mem.SetMemory(0x500, 0x03000600) // NOP, command chain
+	mem.SetMemory(0x504, 0x40000001)
+	mem.SetMemory(0x508, 0x02000600) // Read, command chain
+	mem.SetMemory(0x50c, 0x40000010)
+	mem.SetMemory(0x510, 0x02000700) // Read
+	mem.SetMemory(0x514, 0x00000010)
+	mem.SetMemory(0x700, 0x55555555)
+
+	cc := Ch.StartIO(0x00f)
+	if cc != 0 {
+		t.Errorf("Start I/O CChain exception expected %d got: %d", 0, cc)
+	}
+
+	dev := runChannel()
+	if dev != 0xf {
+		t.Errorf("Start I/O CChain exception expected %d got: %d", 0xf, dev)
+	}
+	if v := mem.GetMemory(0x40); v != 0x00000510 {
+		t.Errorf("Start I/O CChain exception CSW1 expected %08x got: %08x", 0x00000510, v)
+	}
+	if v := mem.GetMemory(0x44); v != 0x0d000000 {
+		t.Errorf("Start I/O CChain exception CSW2 expected %08x got: %08x", 0x0d000000, v)
+	}
+	if v := mem.GetMemory(0x600); v != 0xf0f1f2f3 {
+		t.Errorf("Start I/O CChain exception data expected %08x got: %08x", 0xf0f1f2f3, v)
+	}
+	if v := mem.GetMemory(0x700); v != 0x55555555 {
+		t.Errorf("Start I/O CChain exception chained read executed got: %08x", v)
+	}
+}
+
 // Test TIC.
 func TestStartIOTic(t *testing.T) {
 	var v uint32
@@ -1577,6 +1658,65 @@ func TestStartIOHaltIO2(t *testing.T) {
 	}
 }
 
+// Halt I/O part way through read, check residual count.
+func TestStartIOHaltIOCount(t *testing.T) {
+	var v uint32
+
+	d := setup()
+
+	// Load Data
+	for i := range 0x80 {
+		d.Data[i] = uint8(0x10 + i)
+	}
+	d.Max = 0x80
+
+	mem.SetMemory(0x40, 0xffffffff)
+	mem.SetMemory(0x44, 0xffffffff)
+	mem.SetMemory(0x48, 0x500)
+	mem.SetMemory(0x500, 0x02000600) // Read 0x80 bytes
+	mem.SetMemory(0x504, 0x00000080)
+	for i := uint32(0); i < 0x100; i += 4 {
+		mem.SetMemory(0x600+i, 0x55555555) // Invalid data
+	}
+
+	cc := Ch.StartIO(0x00f)
+	if cc != 0 {
+		t.Errorf("Start I/O HaltIO count expected %d got: %d", 0, cc)
+	}
+
+	// Device moves one byte every 10 cycles.
+	ev.Advance(10 * 0x12)
+
+	cc = Ch.HaltIO(0x00f)
+	if cc != 1 {
+		t.Errorf("Start I/O HaltIO count expected %d got: %d", 1, cc)
+	}
+
+	dev := runChannel()
+	if dev != 0xf {
+		t.Errorf("Start I/O HaltIO count expected %d got: %d", 0xf, dev)
+	}
+	v = mem.GetMemory(0x40)
+	if v != 0x00000508 {
+		t.Errorf("Start I/O HaltIO count CSW1 expected %08x got: %08x", 0x00000508, v)
+	}
+	v = mem.GetMemory(0x44)
+	if v != 0x0c400080-0x12 {
+		t.Errorf("Start I/O HaltIO count CSW2 expected %08x got: %08x", 0x0c400080-0x12, v)
+	}
+
+	for i := range uint32(0x20) {
+		vb := getMemByte(0x600 + i)
+		mb := uint8(0x10 + i)
+		if i >= 0x12 {
+			mb = 0x55
+		}
+		if vb != mb {
+			t.Errorf("Start I/O HaltIO count data expected %02x got: %02x at: %08x", mb, vb, 0x600+i)
+		}
+	}
+}
+
 func TestStartIOTIOBusy(t *testing.T) {
 	var v uint32
 
@@ -1851,3 +1991,689 @@ func TestStartIOWriteProt2(t *testing.T) {
 	}
 	mem.PutKey(0x4000, 0x0)
 }
+
+// Interrupts presented in channel order, masked channels are held.
+func TestIrqPriority(t *testing.T) {
+	mem.SetSize(64)
+	ev.Reset()
+	Ch.InitializeChannels()
+	Ch.AddChannel(1, D.TypeSel, 0)
+	Ch.AddChannel(2, D.TypeSel, 0)
+	for _, addr := range []uint16{0x10f, 0x20f} {
+		d := &Td.TestDev{Addr: addr, Mask: 0xff}
+		Ch.AddDevice(d, nil, addr)
+		_ = d.InitDev()
+		d.Max = 0x10
+	}
+
+	// Start a read on channel 2 then channel 1.
+	mem.SetMemory(0x500, 0x02000600)
+	mem.SetMemory(0x504, 0x00000010)
+	mem.SetMemory(0x510, 0x02000700)
+	mem.SetMemory(0x514, 0x00000010)
+	mem.SetMemory(0x48, 0x510)
+	if cc := Ch.StartIO(0x20f); cc != 0 {
+		t.Fatalf("Start I/O 20f expected %d got: %d", 0, cc)
+	}
+	mem.SetMemory(0x48, 0x500)
+	if cc := Ch.StartIO(0x10f); cc != 0 {
+		t.Fatalf("Start I/O 10f expected %d got: %d", 0, cc)
+	}
+	for ev.AnyEvent() {
+		ev.Advance(1)
+	}
+
+	// Channel 1 masked, only channel 2 may interrupt.
+	Ch.IrqPending = true
+	if d := Ch.ChanScan(0x2000, true); d != 0x20f {
+		t.Errorf("Masked scan expected %03x got: %03x", 0x20f, d)
+	}
+	if d := Ch.ChanScan(0x2000, true); d != D.NoDev {
+		t.Errorf("Masked scan expected no device got: %03x", d)
+	}
+
+	// Start channel 2 again, with both enabled channel 1 goes first.
+	mem.SetMemory(0x48, 0x510)
+	if cc := Ch.StartIO(0x20f); cc != 0 {
+		t.Fatalf("Start I/O 20f expected %d got: %d", 0, cc)
+	}
+	for ev.AnyEvent() {
+		ev.Advance(1)
+	}
+	Ch.IrqPending = true
+	if d := Ch.ChanScan(0x6000, true); d != 0x10f {
+		t.Errorf("First scan expected %03x got: %03x", 0x10f, d)
+	}
+	if v := mem.GetMemory(0x40); v != 0x00000508 {
+		t.Errorf("First scan CSW1 expected %08x got: %08x", 0x00000508, v)
+	}
+	if d := Ch.ChanScan(0x6000, true); d != 0x20f {
+		t.Errorf("Second scan expected %03x got: %03x", 0x20f, d)
+	}
+	if v := mem.GetMemory(0x40); v != 0x00000518 {
+		t.Errorf("Second scan CSW1 expected %08x got: %08x", 0x00000518, v)
+	}
+	if d := Ch.ChanScan(0x6000, true); d != D.NoDev {
+		t.Errorf("Third scan expected no device got: %03x", d)
+	}
+}
+
+// Device signals data check in middle of read.
+func TestStartIOReadDataCheck(t *testing.T) {
+	d := setup()
+
+	for i := range 0x20 {
+		d.Data[i] = uint8(0x10 + i)
+	}
+	d.Max = 0x20
+	d.DCheck = 0x10
+
+	mem.SetMemory(0x40, 0xffffffff)
+	mem.SetMemory(0x44, 0xffffffff)
+	mem.SetMemory(0xb0, 0)
+	mem.SetMemory(0x48, 0x500)
+	mem.SetMemory(0x500, 0x02000600) // Read 32 bytes
+	mem.SetMemory(0x504, 0x00000020)
+	for i := uint32(0x600); i < 0x620; i += 4 {
+		mem.SetMemory(i, 0x55555555)
+	}
+
+	cc := Ch.StartIO(0x00f)
+	if cc != 0 {
+		t.Errorf("Start I/O Data Check expected %d got: %d", 0, cc)
+	}
+
+	dev := runChannel()
+	if dev != 0xf {
+		t.Errorf("Start I/O Data Check expected %d got: %d", 0xf, dev)
+	}
+	v := mem.GetMemory(0x40)
+	if v != 0x00000508 {
+		t.Errorf("Start I/O Data Check CSW1 expected %08x got: %08x", 0x00000508, v)
+	}
+	v = mem.GetMemory(0x44)
+	if (v & 0x00080000) == 0 {
+		t.Errorf("Start I/O Data Check CSW2 channel data check not set got: %08x", v)
+	}
+	if (v & statusMask) != 0x0c480000 {
+		t.Errorf("Start I/O Data Check CSW2 expected %08x got: %08x", 0x0c480000, v&statusMask)
+	}
+	if (v & 0xffff) != 0x10 {
+		t.Errorf("Start I/O Data Check count expected %04x got: %04x", 0x10, v&0xffff)
+	}
+	v = mem.GetMemory(0xb0)
+	if v != 0x04402500 {
+		t.Errorf("Start I/O Data Check logout expected %08x got: %08x", 0x04402500, v)
+	}
+	for i := range 0x10 {
+		vb := getMemByte(uint32(0x600 + i))
+		if vb != uint8(0x10+i) {
+			t.Errorf("Start I/O Data Check Data expected %02x got: %02x at: %02x", 0x10+i, vb, i)
+		}
+	}
+}
+
+// Selector channel is busy for whole channel program.
+func TestSelectorBusy(t *testing.T) {
+	mem.SetSize(64)
+	ev.Reset()
+	Ch.InitializeChannels()
+	Ch.AddChannel(1, D.TypeSel, 0)
+	for _, addr := range []uint16{0x10e, 0x10f} {
+		d := &Td.TestDev{Addr: addr, Mask: 0xff}
+		Ch.AddDevice(d, nil, addr)
+		_ = d.InitDev()
+		d.Max = 0x10
+	}
+
+	mem.SetMemory(0x500, 0x02000600) // Read 16 bytes
+	mem.SetMemory(0x504, 0x00000010)
+	mem.SetMemory(0x48, 0x500)
+	if cc := Ch.StartIO(0x10f); cc != 0 {
+		t.Fatalf("Start I/O 10f expected %d got: %d", 0, cc)
+	}
+
+	// Second device can't start until first finishes.
+	busy := 0
+	for ev.AnyEvent() {
+		if cc := Ch.StartIO(0x10e); cc != 2 {
+			t.Fatalf("Start I/O 10e during transfer expected %d got: %d", 2, cc)
+		}
+		busy++
+		ev.Advance(1)
+	}
+	if busy == 0 {
+		t.Error("Start I/O 10f finished immediately")
+	}
+
+	// Still busy until status is taken.
+	if cc := Ch.StartIO(0x10e); cc != 2 {
+		t.Errorf("Start I/O 10e with pending status expected %d got: %d", 2, cc)
+	}
+	Ch.IrqPending = true
+	if d := Ch.ChanScan(0x4000, true); d != 0x10f {
+		t.Errorf("Scan expected %03x got: %03x", 0x10f, d)
+	}
+	if v := mem.GetMemory(0x44); (v & statusMask) != 0x0c000000 {
+		t.Errorf("CSW2 expected %08x got: %08x", 0x0c000000, v&statusMask)
+	}
+
+	mem.SetMemory(0x500, 0x02000700)
+	if cc := Ch.StartIO(0x10e); cc != 0 {
+		t.Errorf("Start I/O 10e after completion expected %d got: %d", 0, cc)
+	}
+}
+
+// Status stacked while channel masked is taken by TIO.
+func TestStackedStatus(t *testing.T) {
+	setup()
+
+	mem.SetMemory(0x40, 0xffffffff)
+	mem.SetMemory(0x44, 0xffffffff)
+	mem.SetMemory(0x48, 0x500)
+	mem.SetMemory(0x500, 0x02000600) // Read 16 bytes
+	mem.SetMemory(0x504, 0x00000010)
+	if cc := Ch.StartIO(0x00f); cc != 0 {
+		t.Fatalf("Start I/O expected %d got: %d", 0, cc)
+	}
+	for ev.AnyEvent() {
+		ev.Advance(1)
+	}
+
+	// Channel masked, status stays at device.
+	Ch.IrqPending = true
+	if d := Ch.ChanScan(0x0000, true); d != D.NoDev {
+		t.Errorf("Masked scan expected no device got: %03x", d)
+	}
+	if d := Ch.ChanScan(0x8000, false); d != D.NoDev {
+		t.Errorf("Disabled scan expected no device got: %03x", d)
+	}
+
+	if cc := Ch.TestIO(0x00f); cc != 1 {
+		t.Errorf("Test I/O expected %d got: %d", 1, cc)
+	}
+	if v := mem.GetMemory(0x40); v != 0x00000508 {
+		t.Errorf("Test I/O CSW1 expected %08x got: %08x", 0x00000508, v)
+	}
+	if v := mem.GetMemory(0x44); v != 0x0c000000 {
+		t.Errorf("Test I/O CSW2 expected %08x got: %08x", 0x0c000000, v)
+	}
+
+	// Unmasked, status already taken.
+	Ch.IrqPending = true
+	if d := Ch.ChanScan(0x8000, true); d != D.NoDev {
+		t.Errorf("Unmasked scan expected no device got: %03x", d)
+	}
+	if cc := Ch.TestIO(0x00f); cc != 0 {
+		t.Errorf("Second Test I/O expected %d got: %d", 0, cc)
+	}
+
+	// Device end posted while masked.
+	Ch.SetDevAttn(0x00f, D.CStatusDevEnd)
+	if d := Ch.ChanScan(0x0000, true); d != D.NoDev {
+		t.Errorf("Masked attention scan expected no device got: %03x", d)
+	}
+	if cc := Ch.TestIO(0x00f); cc != 1 {
+		t.Errorf("Attention Test I/O expected %d got: %d", 1, cc)
+	}
+	if v := mem.GetMemory(0x44); v != 0x04000000 {
+		t.Errorf("Attention Test I/O CSW2 expected %08x got: %08x", 0x04000000, v)
+	}
+	Ch.IrqPending = true
+	if d := Ch.ChanScan(0x8000, true); d != D.NoDev {
+		t.Errorf("Unmasked attention scan expected no device got: %03x", d)
+	}
+}
+
+// Runaway TIC loop ends with channel control check.
+func TestStartIOTicLoop(t *testing.T) {
+	setup()
+
+	mem.SetMemory(0x40, 0xffffffff)
+	mem.SetMemory(0x44, 0xffffffff)
+	mem.SetMemory(0x48, 0x500)
+	mem.SetMemory(0x500, 0x03000000) // NOP, command chain
+	mem.SetMemory(0x504, 0x40000001)
+	mem.SetMemory(0x508, 0x08000500) // TIC 500
+	mem.SetMemory(0x50c, 0x00000000)
+
+	if cc := Ch.StartIO(0x00f); cc != 0 {
+		t.Fatalf("Start I/O TIC loop expected %d got: %d", 0, cc)
+	}
+
+	d := D.NoDev
+	for range 1000000 {
+		ev.Advance(1)
+		d = Ch.ChanScan(0x8000, true)
+		if d != D.NoDev {
+			break
+		}
+	}
+	Ch.IrqPending = false
+	if d != 0xf {
+		t.Fatalf("Start I/O TIC loop did not end, got: %03x", d)
+	}
+	v := mem.GetMemory(0x44)
+	if (v & 0x00040000) == 0 {
+		t.Errorf("Start I/O TIC loop CSW2 channel control check not set got: %08x", v)
+	}
+}
+
+// Read using two IDAWs crossing a 2K boundary.
+func TestStartIOReadIDA(t *testing.T) {
+	var v uint32
+
+	d := setup()
+
+	// Load Data
+	for i := range 0x20 {
+		d.Data[i] = uint8(0x10 + i)
+	}
+	d.Max = 0x20
+
+	mem.SetMemory(0x40, 0xffffffff)
+	mem.SetMemory(0x44, 0xffffffff)
+	mem.SetMemory(0x48, 0x500)
+	mem.SetMemory(0x500, 0x02000600) // Read, IDA list at 600
+	mem.SetMemory(0x504, 0x04000020)
+	mem.SetMemory(0x600, 0x000017f0) // Last 16 bytes of 2K block
+	mem.SetMemory(0x604, 0x00003000) // Start of another 2K block
+	// Load memory with value not equal to read data.
+	for i := uint32(0); i < 0x20; i += 4 {
+		mem.SetMemory(0x17f0+i, 0x55555555)
+		mem.SetMemory(0x3000+i, 0x55555555)
+	}
+
+	cc := Ch.StartIO(0x00f)
+	if cc != 0 {
+		t.Errorf("Start I/O Read IDA expected %d got: %d", 0, cc)
+	}
+
+	dev := runChannel()
+	if dev != 0xf {
+		t.Errorf("Start I/O Read IDA expected %d got: %d", 0xf, dev)
+	}
+	v = mem.GetMemory(0x40)
+	if v != 0x00000508 {
+		t.Errorf("Start I/O Read IDA CSW1 expected %08x got: %08x", 0x00000508, v)
+	}
+	v = mem.GetMemory(0x44)
+	if v != 0x0c000000 {
+		t.Errorf("Start I/O Read IDA CSW2 expected %08x got: %08x", 0x0c000000, v)
+	}
+
+	for i := range uint32(0x10) {
+		vb := getMemByte(0x17f0 + i)
+		if vb != uint8(0x10+i) {
+			t.Errorf("Start I/O Read IDA expected %02x got: %02x at: %08x", 0x10+i, vb, 0x17f0+i)
+		}
+		vb = getMemByte(0x3000 + i)
+		if vb != uint8(0x20+i) {
+			t.Errorf("Start I/O Read IDA expected %02x got: %02x at: %08x", 0x20+i, vb, 0x3000+i)
+		}
+		vb = getMemByte(0x3010 + i)
+		if vb != 0x55 {
+			t.Errorf("Start I/O Read IDA expected %02x got: %02x at: %08x", 0x55, vb, 0x3010+i)
+		}
+	}
+	vb := getMemByte(0x1800)
+	if vb == 0x20 {
+		t.Errorf("Start I/O Read IDA data written past 2K boundary")
+	}
+}
+
+// Device varied offline is not operational until varied back online.
+func TestVaryOffline(t *testing.T) {
+	_ = setup()
+	mem.SetMemory(0x40, 0xffffffff)
+	mem.SetMemory(0x44, 0xffffffff)
+	mem.SetMemory(0x48, 0x500)
+	mem.SetMemory(0x500, 0x03000600) // NOP
+	mem.SetMemory(0x504, 0x00000001)
+
+	if err := Ch.SetOnline(0x00f, false); err != nil {
+		t.Fatalf("Vary offline failed: %v", err)
+	}
+	if Ch.IsOnline(0x00f) {
+		t.Error("Device still online")
+	}
+	if cc := Ch.StartIO(0x00f); cc != 3 {
+		t.Errorf("Start I/O offline expected %d got: %d", 3, cc)
+	}
+	if cc := Ch.TestIO(0x00f); cc != 3 {
+		t.Errorf("Test I/O offline expected %d got: %d", 3, cc)
+	}
+	if v := mem.GetMemory(0x44); v != 0xffffffff {
+		t.Errorf("Start I/O offline CSW2 expected %08x got: %08x", 0xffffffff, v)
+	}
+
+	if err := Ch.SetOnline(0x00f, true); err != nil {
+		t.Fatalf("Vary online failed: %v", err)
+	}
+	if cc := Ch.StartIO(0x00f); cc != 1 {
+		t.Errorf("Start I/O online expected %d got: %d", 1, cc)
+	}
+	if v := mem.GetMemory(0x44); v != 0x0c000001 {
+		t.Errorf("Start I/O online CSW2 expected %08x got: %08x", 0x0c000001, v)
+	}
+
+	if err := Ch.SetOnline(0x00e, false); err == nil {
+		t.Error("Vary of missing device did not fail")
+	}
+}
+
+// Configure channels and devices from config file.
+func TestConfigChannels(t *testing.T) {
+	Ch.InitializeChannels()
+	dir := t.TempDir()
+	name := filepath.Join(dir, "machine.cfg")
+	cfg := "channel 0 mpx sub=32\n" +
+		"channel 1 sel\n" +
+		"1403 00e file=\"" + filepath.Join(dir, "print.log") + "\"\n" +
+		"2400 130-131\n" +
+		"offline 131\n"
+	if err := os.WriteFile(name, []byte(cfg), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.LoadConfigFile(name); err != nil {
+		t.Fatalf("Config load failed: %v", err)
+	}
+
+	if ty := Ch.GetType(0x000); ty != D.TypeMux {
+		t.Errorf("Channel 0 type expected %d got: %d", D.TypeMux, ty)
+	}
+	if ty := Ch.GetType(0x100); ty != D.TypeSel {
+		t.Errorf("Channel 1 type expected %d got: %d", D.TypeSel, ty)
+	}
+	if ty := Ch.GetType(0x200); ty != D.TypeUNA {
+		t.Errorf("Channel 2 type expected %d got: %d", D.TypeUNA, ty)
+	}
+	for _, devNum := range []uint16{0x00e, 0x130, 0x131} {
+		if d, err := Ch.GetDevice(devNum); err != nil || d == nil {
+			t.Errorf("Device %03x not configured: %v", devNum, err)
+		}
+	}
+	for _, devNum := range []uint16{0x00c, 0x132} {
+		if d, err := Ch.GetDevice(devNum); err == nil && d != nil {
+			t.Errorf("Device %03x configured", devNum)
+		}
+	}
+	if !Ch.IsOnline(0x130) || Ch.IsOnline(0x131) {
+		t.Errorf("Device online expected 130 only got: 130 %v 131 %v", Ch.IsOnline(0x130), Ch.IsOnline(0x131))
+	}
+
+	// Duplicate device address is rejected.
+	if err := os.WriteFile(name, []byte("2400 131\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.LoadConfigFile(name); err == nil {
+		t.Error("Config accepted duplicate device address")
+	}
+	Ch.Shutdown()
+}
+
+// Snapshot of channel types and configured devices.
+func configTables() []string {
+	tables := []string{fmt.Sprintf("memory %x", mem.GetSize())}
+	for ch := range uint16(16) {
+		tables = append(tables, fmt.Sprintf("channel %x %d", ch, Ch.GetType(ch<<8)))
+	}
+	for devNum := range uint16(0x1000) {
+		if d, err := Ch.GetDevice(devNum); err == nil && d != nil {
+			tables = append(tables, fmt.Sprintf("device %03x %T %v", devNum, d, Ch.IsOnline(devNum)))
+		}
+	}
+	return tables
+}
+
+// Text and JSON configuration give same channel and device tables.
+func TestConfigJSON(t *testing.T) {
+	dir := t.TempDir()
+	printFile := filepath.Join(dir, "print log")
+	text := filepath.Join(dir, "machine.cfg")
+	cfg := "memsize 128K\n" +
+		"channel 0 mpx sub=32\n" +
+		"channel 1 sel avail\n" +
+		"1403 00e file=\"" + printFile + "\"\n" +
+		"2400 130-131\n" +
+		"offline 131\n"
+	if err := os.WriteFile(text, []byte(cfg), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	desc := `{
+  "memory": "128K",
+  "channels": [
+    { "address": "0", "options": ["mpx", "sub=32"] },
+    { "address": "1", "options": ["sel", "avail"] }
+  ],
+  "devices": [
+    { "model": "1403", "address": "00e", "options": ["file=` + printFile + `"] },
+    { "model": "2400", "address": "130-131" }
+  ],
+  "directives": ["offline 131"]
+}`
+	jsonFile := filepath.Join(dir, "machine.json")
+	if err := os.WriteFile(jsonFile, []byte(desc), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	mem.SetSize(64)
+	Ch.InitializeChannels()
+	if err := config.LoadConfigFile(text); err != nil {
+		t.Fatalf("Config load failed: %v", err)
+	}
+	want := configTables()
+	Ch.Shutdown()
+
+	mem.SetSize(64)
+	Ch.InitializeChannels()
+	if err := config.LoadConfigFile(jsonFile); err != nil {
+		t.Fatalf("JSON config load failed: %v", err)
+	}
+	got := configTables()
+	Ch.Shutdown()
+
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("JSON config tables differ got:\n%s\nwanted:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if want[0] != "memory 20000" {
+		t.Errorf("Config memory expected %s got: %s", "memory 20000", want[0])
+	}
+	if len(want) != 20 {
+		t.Errorf("Config tables expected %d entries got: %d", 20, len(want))
+	}
+
+	// Unknown field is rejected.
+	if err := os.WriteFile(jsonFile, []byte(`{"memroy": "64K"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.LoadConfigFile(jsonFile); err == nil {
+		t.Error("JSON config accepted unknown field")
+	}
+}
+
+// YAML machine description builds same tables as text configuration.
+func TestConfigYAML(t *testing.T) {
+	dir := t.TempDir()
+	printFile := filepath.Join(dir, "print log")
+	text := filepath.Join(dir, "machine.cfg")
+	cfg := "memsize 128K\n" +
+		"channel 0 mpx sub=32\n" +
+		"channel 1 sel avail\n" +
+		"1403 00e file=\"" + printFile + "\"\n" +
+		"2400 130-131\n" +
+		"offline 131\n"
+	if err := os.WriteFile(text, []byte(cfg), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	desc := `# Test machine
+memory: 128K
+channels:
+  - address: "0"
+    options: [mpx, sub=32]
+  - { address: "1", options: [sel, avail] }
+devices:
+  - model: "1403"
+    address: 00e
+    options:
+      - "file=` + printFile + `"
+  - { model: "2400", address: 130-131 }
+directives:
+  - offline 131
+`
+	yamlFile := filepath.Join(dir, "machine.yaml")
+	if err := os.WriteFile(yamlFile, []byte(desc), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	mem.SetSize(64)
+	Ch.InitializeChannels()
+	if err := config.LoadConfigFile(text); err != nil {
+		t.Fatalf("Config load failed: %v", err)
+	}
+	want := configTables()
+	Ch.Shutdown()
+
+	mem.SetSize(64)
+	Ch.InitializeChannels()
+	if err := config.LoadConfigFile(yamlFile); err != nil {
+		t.Fatalf("YAML config load failed: %v", err)
+	}
+	got := configTables()
+	Ch.Shutdown()
+
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("YAML config tables differ got:\n%s\nwanted:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if len(want) != 20 {
+		t.Errorf("Config tables expected %d entries got: %d", 20, len(want))
+	}
+
+	// Unknown field is rejected.
+	ymlFile := filepath.Join(dir, "machine.yml")
+	if err := os.WriteFile(ymlFile, []byte("memroy: 64K\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.LoadConfigFile(ymlFile); err == nil {
+		t.Error("YAML config accepted unknown field")
+	}
+}
+
+// Trace should log each CCW of a data chained read and the CSW.
+func TestTraceReadCDA(t *testing.T) {
+	d := setup()
+	for i := range 0x20 {
+		d.Data[i] = uint8(0x10 + i)
+	}
+	d.Max = 0x20
+
+	var buf bytes.Buffer
+	old := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+	Ch.SetTrace(true)
+	defer func() {
+		Ch.SetTrace(false)
+		slog.SetDefault(old)
+	}()
+
+	mem.SetMemory(0x48, 0x500)
+	mem.SetMemory(0x500, 0x02000600) // Set channel words
+	mem.SetMemory(0x504, 0x80000010)
+	mem.SetMemory(0x508, 0x01000700)
+	mem.SetMemory(0x50c, 0x20000010)
+
+	cc := Ch.StartIO(0x00f)
+	if cc != 0 {
+		t.Errorf("Start I/O trace expected %d got: %d", 0, cc)
+	}
+	dev := runChannel()
+	if dev != 0xf {
+		t.Errorf("Start I/O trace expected %d got: %d", 0xf, dev)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Trace expected %d lines got: %d\n%s", 3, len(lines), buf.String())
+	}
+	expect := [][]string{
+		{"msg=CCW", "dev=00f", "caw=000500", "cmd=02", "addr=000600", "flags=CD", "count=16"},
+		{"msg=CCW", "dev=00f", "caw=000508", "cmd=01", "addr=000700", "flags=SLI", "count=16"},
+		{"msg=CSW", "dev=00f", "caw=000510", "status=0c00", "count=0"},
+	}
+	for i, fields := range expect {
+		for _, f := range fields {
+			if !strings.Contains(lines[i], f) {
+				t.Errorf("Trace line %d missing %s got: %s", i, f, lines[i])
+			}
+		}
+	}
+
+	// Nothing logged when off.
+	Ch.SetTrace(false)
+	buf.Reset()
+	cc = Ch.StartIO(0x00f)
+	if cc != 0 {
+		t.Errorf("Start I/O trace off expected %d got: %d", 0, cc)
+	}
+	_ = runChannel()
+	if buf.Len() != 0 {
+		t.Errorf("Trace off logged: %s", buf.String())
+	}
+}
+
+// Record events of a read, replay them and get same result.
+func TestEventReplay(t *testing.T) {
+	run := func() (uint32, uint32) {
+		d := setup()
+		for i := range 0x10 {
+			d.Data[i] = uint8(0xf0 + i)
+		}
+		d.Max = 0x10
+		mem.SetMemory(0x40, 0xffffffff)
+		mem.SetMemory(0x44, 0xffffffff)
+		mem.SetMemory(0x48, 0x500)
+		mem.SetMemory(0x500, 0x02000600) // Read
+		mem.SetMemory(0x504, 0x00000010)
+		if cc := Ch.StartIO(0x00f); cc != 0 {
+			t.Fatalf("Start I/O replay expected %d got: %d", 0, cc)
+		}
+		if dev := runChannel(); dev != 0xf {
+			t.Fatalf("Start I/O replay expected %03x got: %03x", 0xf, dev)
+		}
+		return mem.GetMemory(0x40), mem.GetMemory(0x44)
+	}
+
+	var first bytes.Buffer
+	ev.Record(&first)
+	csw1, csw2 := run()
+	ev.Record(nil)
+	if first.Len() == 0 {
+		t.Fatal("No events recorded")
+	}
+
+	var second bytes.Buffer
+	if err := ev.Replay(bytes.NewReader(first.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	ev.Record(&second)
+	rcsw1, rcsw2 := run()
+	ev.Record(nil)
+	if err := ev.ReplayDone(); err != nil {
+		t.Errorf("Replay differed: %v", err)
+	}
+	if rcsw1 != csw1 || rcsw2 != csw2 {
+		t.Errorf("Replay CSW expected %08x %08x got: %08x %08x", csw1, csw2, rcsw1, rcsw2)
+	}
+	if first.String() != second.String() {
+		t.Errorf("Replay log expected:\n%s got:\n%s", first.String(), second.String())
+	}
+
+	// Log of another program should not match.
+	if err := ev.Replay(strings.NewReader("add 0 other 5 1\n")); err != nil {
+		t.Fatal(err)
+	}
+	_, _ = run()
+	if err := ev.ReplayDone(); err == nil {
+		t.Error("Replay of different log did not report difference")
+	}
+}
@@ -0,0 +1,330 @@
+/*
+   Channel checkpoint save and restore.
+
+   Copyright (c) 2024, Richard Cornwell
+
+   Permission is hereby granted, free of charge, to any person obtaining a
+   copy of this software and associated documentation files (the "Software"),
+   to deal in the Software without restriction, including without limitation
+   the rights to use, copy, modify, merge, publish, distribute, sublicense,
+   and/or sell copies of the Software, and to permit persons to whom the
+   Software is furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in
+   all copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.  IN NO EVENT SHALL
+   ROBERT M SUPNIK BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+   IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+   CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+*/
+
+package syschannel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	dev "github.com/rcornwell/S370/emu/device"
+)
+
+/*
+ * Checkpoint layout, all values big endian:
+ *
+ *   Magic:     "S370CHN"              (7 bytes)
+ *   Version:   chanCheckpointVersion  (1 byte)
+ *   Globals:   chanGlobal
+ *   Channels:  for each of chanUnit, present flag (1 byte), then
+ *              chanState and one subState per subchannel.
+ *   Devices:   device number (2 bytes), state length (4 bytes), state.
+ *              Ends with device number NoDev.
+ */
+
+const (
+	chanCheckpointMagic   = "S370CHN"
+	chanCheckpointVersion = 1
+)
+
+var errConfig = errors.New("channel configuration does not match checkpoint")
+
+// Global channel state.
+type chanGlobal struct {
+	IrqPending bool   // Interrupt pending on some channel
+	Loading    uint16 // Device being IPLed
+	BmuxEnable bool   // Block multiplexer enabled
+}
+
+// Channel state saved in a checkpoint.
+type chanState struct {
+	ChanType   int32      // Type of channel
+	NumSubChan int32      // Number of subchannels
+	IrqPending bool       // Channel has pending IRQ
+	AvailWait  bool       // SIO found channel busy
+	AvailPend  bool       // Channel available interrupt pending
+	Connected  int32      // Connected subchannel, -1 if none
+	DevStatus  [256]uint8 // Status from each device
+}
+
+// Subchannel state saved in a checkpoint.
+type subState struct {
+	Caw        uint32 // Channel command address word
+	CcwAddr    uint32 // Channel address
+	CcwIAddr   uint32 // Channel indirect address
+	CcwCount   uint16 // Channel count
+	CcwCmd     uint8  // Channel command and flags
+	CcwKey     uint8  // Channel key
+	CcwFlags   uint16 // Channel control flags
+	ChanBuffer uint32 // Channel data buffer
+	ChanStatus uint16 // Channel status
+	ChanDirty  bool   // Buffer has been modified
+	DevAddr    uint16 // Device on channel
+	ChanByte   uint8  // Current byte, dirty/full
+	ChainFlg   bool   // Holding on chain
+	Reconnect  bool   // Waiting to reconnect to block multiplexer
+}
+
+// Write state of all channels and devices to w.
+func SaveState(w io.Writer) error {
+	if _, err := w.Write(append([]byte(chanCheckpointMagic), chanCheckpointVersion)); err != nil {
+		return err
+	}
+	global := chanGlobal{IrqPending: IrqPending, Loading: Loading, BmuxEnable: bmuxEnable}
+	if err := binary.Write(w, binary.BigEndian, &global); err != nil {
+		return err
+	}
+
+	for _, cUnit := range chanUnit {
+		if err := saveChannel(w, cUnit); err != nil {
+			return err
+		}
+	}
+
+	for _, cUnit := range chanUnit {
+		if cUnit == nil {
+			continue
+		}
+		for i, d := range cUnit.devTab {
+			if d == nil {
+				continue
+			}
+			devNum := uint16(cUnit.number<<8 | i)
+			if err := saveDevice(w, devNum, d); err != nil {
+				return fmt.Errorf("device %03x: %w", devNum, err)
+			}
+		}
+	}
+	return binary.Write(w, binary.BigEndian, dev.NoDev)
+}
+
+// Read state of all channels and devices from r. Devices which can't be
+// checkpointed are reset.
+func LoadState(r io.Reader) error {
+	hdr := make([]byte, len(chanCheckpointMagic)+1)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return err
+	}
+	if string(hdr[:len(chanCheckpointMagic)]) != chanCheckpointMagic {
+		return errors.New("not a channel checkpoint")
+	}
+	if hdr[len(chanCheckpointMagic)] != chanCheckpointVersion {
+		return fmt.Errorf("unsupported channel checkpoint version: %d", hdr[len(chanCheckpointMagic)])
+	}
+	var global chanGlobal
+	if err := binary.Read(r, binary.BigEndian, &global); err != nil {
+		return err
+	}
+
+	IrqPending = global.IrqPending
+	Loading = global.Loading
+	bmuxEnable = global.BmuxEnable
+	for _, cUnit := range chanUnit {
+		if err := loadChannel(r, cUnit); err != nil {
+			return err
+		}
+	}
+
+	for _, cUnit := range chanUnit {
+		if cUnit == nil {
+			continue
+		}
+		for _, d := range cUnit.devTab {
+			if _, ok := d.(dev.Checkpoint); !ok && d != nil {
+				_ = d.InitDev()
+			}
+		}
+	}
+
+	for {
+		var devNum uint16
+		if err := binary.Read(r, binary.BigEndian, &devNum); err != nil {
+			return err
+		}
+		if devNum == dev.NoDev {
+			return nil
+		}
+		if err := loadDevice(r, devNum); err != nil {
+			return fmt.Errorf("device %03x: %w", devNum, err)
+		}
+	}
+}
+
+// Save one channel and its subchannels.
+func saveChannel(w io.Writer, cUnit *chanDev) error {
+	if cUnit == nil {
+		_, err := w.Write([]byte{0})
+		return err
+	}
+	if _, err := w.Write([]byte{1}); err != nil {
+		return err
+	}
+
+	state := chanState{
+		ChanType:   int32(cUnit.chanType),
+		NumSubChan: int32(cUnit.numSubChan),
+		IrqPending: cUnit.irqPending,
+		AvailWait:  cUnit.availWait,
+		AvailPend:  cUnit.availPend,
+		Connected:  -1,
+		DevStatus:  cUnit.devStatus,
+	}
+	subs := make([]subState, cUnit.numSubChan)
+	for i := range cUnit.subChans {
+		subChan := &cUnit.subChans[i]
+		if cUnit.connected == subChan {
+			state.Connected = int32(i)
+		}
+		subs[i] = subState{
+			Caw:        subChan.caw,
+			CcwAddr:    subChan.ccwAddr,
+			CcwIAddr:   subChan.ccwIAddr,
+			CcwCount:   subChan.ccwCount,
+			CcwCmd:     subChan.ccwCmd,
+			CcwKey:     subChan.ccwKey,
+			CcwFlags:   subChan.ccwFlags,
+			ChanBuffer: subChan.chanBuffer,
+			ChanStatus: subChan.chanStatus,
+			ChanDirty:  subChan.chanDirty,
+			DevAddr:    subChan.devAddr,
+			ChanByte:   subChan.chanByte,
+			ChainFlg:   subChan.chainFlg,
+			Reconnect:  subChan.reconnect,
+		}
+	}
+	if err := binary.Write(w, binary.BigEndian, &state); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.BigEndian, subs)
+}
+
+// Restore one channel, it must be configured the same as when saved.
+func loadChannel(r io.Reader, cUnit *chanDev) error {
+	present := []byte{0}
+	if _, err := io.ReadFull(r, present); err != nil {
+		return err
+	}
+	if (present[0] != 0) != (cUnit != nil) {
+		return errConfig
+	}
+	if cUnit == nil {
+		return nil
+	}
+
+	var state chanState
+	if err := binary.Read(r, binary.BigEndian, &state); err != nil {
+		return err
+	}
+	if int(state.ChanType) != cUnit.chanType || int(state.NumSubChan) != cUnit.numSubChan {
+		return errConfig
+	}
+	subs := make([]subState, cUnit.numSubChan)
+	if err := binary.Read(r, binary.BigEndian, subs); err != nil {
+		return err
+	}
+
+	cUnit.irqPending = state.IrqPending
+	cUnit.availWait = state.AvailWait
+	cUnit.availPend = state.AvailPend
+	cUnit.devStatus = state.DevStatus
+	cUnit.connected = nil
+	if state.Connected >= 0 && int(state.Connected) < cUnit.numSubChan {
+		cUnit.connected = &cUnit.subChans[state.Connected]
+	}
+	for i := range cUnit.subChans {
+		subChan := &cUnit.subChans[i]
+		sub := &subs[i]
+		subChan.caw = sub.Caw
+		subChan.ccwAddr = sub.CcwAddr
+		subChan.ccwIAddr = sub.CcwIAddr
+		subChan.ccwCount = sub.CcwCount
+		subChan.ccwCmd = sub.CcwCmd
+		subChan.ccwKey = sub.CcwKey
+		subChan.ccwFlags = sub.CcwFlags
+		subChan.chanBuffer = sub.ChanBuffer
+		subChan.chanStatus = sub.ChanStatus
+		subChan.chanDirty = sub.ChanDirty
+		subChan.devAddr = sub.DevAddr
+		subChan.chanByte = sub.ChanByte
+		subChan.chainFlg = sub.ChainFlg
+		subChan.reconnect = false
+		subChan.dev = nil
+		if sub.DevAddr != dev.NoDev {
+			subChan.dev = cUnit.devTab[sub.DevAddr&0xff]
+		}
+
+		// Reconnect timer is not saved, retry on next scan.
+		if sub.Reconnect {
+			cUnit.irqPending = true
+			IrqPending = true
+		}
+	}
+	return nil
+}
+
+// Save state of one device, devices which can't be checkpointed save nothing.
+func saveDevice(w io.Writer, devNum uint16, d dev.Device) error {
+	var buf bytes.Buffer
+	if cp, ok := d.(dev.Checkpoint); ok {
+		if err := cp.SaveState(&buf); err != nil {
+			return err
+		}
+	}
+	if err := binary.Write(w, binary.BigEndian, devNum); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.BigEndian, uint32(buf.Len())); err != nil {
+		return err
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// Restore state of one device.
+func loadDevice(r io.Reader, devNum uint16) error {
+	var size uint32
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return err
+	}
+	state := make([]byte, size)
+	if _, err := io.ReadFull(r, state); err != nil {
+		return err
+	}
+
+	cUnit := chanUnit[(devNum>>8)&0xf]
+	if cUnit == nil || cUnit.devTab[devNum&0xff] == nil {
+		return errors.New("device not configured")
+	}
+	cp, ok := cUnit.devTab[devNum&0xff].(dev.Checkpoint)
+	if !ok {
+		if size != 0 {
+			return errors.New("device does not support checkpoint")
+		}
+		return nil
+	}
+	return cp.LoadState(bytes.NewReader(state))
+}
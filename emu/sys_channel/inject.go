@@ -0,0 +1,65 @@
+/*
+   Interrupt injection for testing.
+
+   Copyright (c) 2024, Richard Cornwell
+
+   Permission is hereby granted, free of charge, to any person obtaining a
+   copy of this software and associated documentation files (the "Software"),
+   to deal in the Software without restriction, including without limitation
+   the rights to use, copy, modify, merge, publish, distribute, sublicense,
+   and/or sell copies of the Software, and to permit persons to whom the
+   Software is furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in
+   all copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.  IN NO EVENT SHALL
+   ROBERT M SUPNIK BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+   IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+   CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+*/
+
+package syschannel
+
+import (
+	"fmt"
+
+	dev "github.com/rcornwell/S370/emu/device"
+)
+
+// Post device status as if the device had raised it. Intended for tests of
+// interrupt handling, no device code is called.
+func InjectIO(devNum uint16, status uint8) error {
+	subChan := findSubChannel(devNum)
+	if subChan == nil || chanUnit[(devNum>>8)&0xf].devTab[devNum&0xff] == nil {
+		return fmt.Errorf("device %03x does not exist", devNum)
+	}
+	SetDevAttn(devNum, status)
+	return nil
+}
+
+// Return devices which have an interrupt pending, in channel order.
+func PendingIO() []uint16 {
+	pending := []uint16{}
+	for i, cUnit := range chanUnit {
+		if cUnit == nil {
+			continue
+		}
+		for j := range cUnit.subChans {
+			subChan := &cUnit.subChans[j]
+			if subChan.devAddr != dev.NoDev && (subChan.chanStatus&statusChnEnd) != 0 &&
+				(subChan.ccwFlags&chainCmd) == 0 {
+				pending = append(pending, subChan.devAddr)
+			}
+		}
+		for j, status := range cUnit.devStatus {
+			if status != 0 {
+				pending = append(pending, (uint16(i)<<8)|uint16(j))
+			}
+		}
+	}
+	return pending
+}
@@ -0,0 +1,76 @@
+/*
+   Channel program trace.
+
+   Copyright (c) 2024, Richard Cornwell
+
+   Permission is hereby granted, free of charge, to any person obtaining a
+   copy of this software and associated documentation files (the "Software"),
+   to deal in the Software without restriction, including without limitation
+   the rights to use, copy, modify, merge, publish, distribute, sublicense,
+   and/or sell copies of the Software, and to permit persons to whom the
+   Software is furnished to do so, subject to the following conditions:
+
+   The above copyright notice and this permission notice shall be included in
+   all copies or substantial portions of the Software.
+
+   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.  IN NO EVENT SHALL
+   ROBERT M SUPNIK BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+   IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+   CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+*/
+
+package syschannel
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// Log each CCW fetched and CSW stored.
+var traceCCW bool
+
+// Names of CCW flags in order.
+var ccwFlagNames = []struct {
+	flag uint16
+	name string
+}{
+	{chainData, "CD"},
+	{chainCmd, "CC"},
+	{flagSLI, "SLI"},
+	{flagSkip, "SKIP"},
+	{flagPCI, "PCI"},
+	{flagIDA, "IDA"},
+}
+
+// Turn channel program trace on or off.
+func SetTrace(enable bool) {
+	traceCCW = enable
+}
+
+// Return names of flags set in CCW.
+func ccwFlagString(flags uint16) string {
+	names := []string{}
+	for _, f := range ccwFlagNames {
+		if (flags & f.flag) != 0 {
+			names = append(names, f.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// Log CCW just fetched from addr.
+func traceFetch(subChan *chanCtl, addr uint32, cmd uint8) {
+	slog.Info("CCW", "dev", fmt.Sprintf("%03x", subChan.devAddr), "caw", fmt.Sprintf("%06x", addr),
+		"cmd", fmt.Sprintf("%02x", cmd), "addr", fmt.Sprintf("%06x", subChan.ccwAddr),
+		"flags", ccwFlagString(subChan.ccwFlags), "count", subChan.ccwCount)
+}
+
+// Log status about to be stored in CSW.
+func traceStatus(subChan *chanCtl) {
+	slog.Info("CSW", "dev", fmt.Sprintf("%03x", subChan.devAddr), "caw", fmt.Sprintf("%06x", subChan.caw),
+		"status", fmt.Sprintf("%04x", subChan.chanStatus), "count", subChan.ccwCount)
+}
@@ -26,21 +26,28 @@
 package cpu
 
 import (
+	"encoding/binary"
+	"io"
+
 	Dv "github.com/rcornwell/S370/emu/device"
 	Ev "github.com/rcornwell/S370/emu/event"
 	Ch "github.com/rcornwell/S370/emu/sys_channel"
 )
 
 type TestDev struct {
-	Addr  uint16     // Current device address
-	Mask  uint16     // Mask for unit
-	Data  [256]uint8 // Data to read/write
-	count int        // Pointer to input/output
-	Max   int        // Maximum size of date
-	Sense uint8      // Current sense byte
-	halt  bool       // Halt I/O requested
-	busy  bool       // Device is busy
-	Sms   bool       // Return SMS at end of command
+	Addr   uint16     // Current device address
+	Mask   uint16     // Mask for unit
+	Data   [256]uint8 // Data to read/write
+	count  int        // Pointer to input/output
+	Max    int        // Maximum size of date
+	Sense  uint8      // Current sense byte
+	halt   bool       // Halt I/O requested
+	busy   bool       // Device is busy
+	Sms    bool       // Return SMS at end of command
+	Retry  bool       // Request command retry at end of read
+	Expt   bool       // Return unit exception at end of read
+	Delay  int        // Cycles from channel end to device end on seek
+	DCheck int        // Signal channel data check at this byte of read
 }
 
 //  /*
@@ -48,11 +55,12 @@ type TestDev struct {
 //   *
 //   *            01234567
 //   *  Write     00000001
-//   *  Read      00000010
+//   *  Read      00000010    If Retry set, return bad data and request retry.
 //   *  Nop       00000011
 //   *  One Byte  00001011    Read one byte of option.
 //   *  End       00010011    Immediate channel end, device end after 100 cycles.
 //   *  Sense     00000100    Return one byte of sense data.
+//   *  Seek      00000111    Accept data, channel end, device end after delay.
 //   *  Read Bk   00001100
 //   */
 
@@ -93,6 +101,14 @@ func (d *TestDev) StartCmd(cmd uint8) uint8 {
 		default:
 			d.Sense = Dv.SenseCMDREJ
 		}
+	case 7: // Seek
+		if cmd == 0x07 {
+			d.Sense = 0
+			d.count = 0
+			d.busy = true
+		} else {
+			d.Sense = Dv.SenseCMDREJ
+		}
 	case 4: // Sense
 		switch cmd {
 		case 0x0c: // Read backward
@@ -133,9 +149,85 @@ func (d *TestDev) InitDev() uint8 {
 	d.Max = 0
 	d.Sense = 0
 	d.Sms = false
+	d.Retry = false
 	return 0
 }
 
+// State of test device saved in a checkpoint.
+type testDevState struct {
+	Data   [256]uint8
+	Count  int32
+	Max    int32
+	Delay  int32
+	Sense  uint8
+	Halt   bool
+	Busy   bool
+	Sms    bool
+	Retry  bool
+	Events int32 // Number of pending events which follow
+}
+
+// Pending event saved in a checkpoint.
+type testDevEvent struct {
+	Time int32
+	Cmd  int32
+}
+
+// Save device state and pending events.
+func (d *TestDev) SaveState(w io.Writer) error {
+	pend := Ev.PendingEvents(d)
+	state := testDevState{
+		Data:   d.Data,
+		Count:  int32(d.count),
+		Max:    int32(d.Max),
+		Delay:  int32(d.Delay),
+		Sense:  d.Sense,
+		Halt:   d.halt,
+		Busy:   d.busy,
+		Sms:    d.Sms,
+		Retry:  d.Retry,
+		Events: int32(len(pend)),
+	}
+	if err := binary.Write(w, binary.BigEndian, &state); err != nil {
+		return err
+	}
+	for _, p := range pend {
+		e := testDevEvent{Time: int32(p.Time), Cmd: int32(p.Iarg)}
+		if err := binary.Write(w, binary.BigEndian, &e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Restore device state and reschedule pending events.
+func (d *TestDev) LoadState(r io.Reader) error {
+	var state testDevState
+	if err := binary.Read(r, binary.BigEndian, &state); err != nil {
+		return err
+	}
+	d.Data = state.Data
+	d.count = int(state.Count)
+	d.Max = int(state.Max)
+	d.Delay = int(state.Delay)
+	d.Sense = state.Sense
+	d.halt = state.Halt
+	d.busy = state.Busy
+	d.Sms = state.Sms
+	d.Retry = state.Retry
+	for range state.Events {
+		var e testDevEvent
+		if err := binary.Read(r, binary.BigEndian, &e); err != nil {
+			return err
+		}
+		// Command zero is the channel reconnect timer, not ours.
+		if e.Cmd != 0 {
+			Ev.AddEvent(d, d.callback, int(e.Time), int(e.Cmd))
+		}
+	}
+	return nil
+}
+
 // Enable debug option.
 func (d *TestDev) Debug(_ string) error {
 	return nil
@@ -185,9 +277,18 @@ func (d *TestDev) callback(cmd int) {
 		if d.Sms {
 			r |= Dv.CStatusSMS
 		}
+		if d.Expt {
+			r |= Dv.CStatusExpt
+		}
+		// Bad read, ask channel to retry command.
+		if d.Retry {
+			r = Dv.CStatusChnEnd | Dv.CStatusDevEnd | Dv.CStatusSMS | Dv.CStatusCheck
+		}
 		if d.count >= d.Max {
 			d.busy = false
 			d.Sms = false
+			d.Retry = false
+			d.Expt = false
 			Ch.ChanEnd(d.Addr, r)
 			return
 		}
@@ -197,9 +298,17 @@ func (d *TestDev) callback(cmd int) {
 			d.halt = false
 			return
 		}
-		if Ch.ChanWriteByte(d.Addr, d.Data[d.count]) {
+		v = d.Data[d.count]
+		if d.Retry {
+			v = ^v
+		}
+		if d.DCheck != 0 && d.count == d.DCheck {
+			Ch.ChanDataCheck(d.Addr)
+		}
+		if Ch.ChanWriteByte(d.Addr, v) {
 			d.busy = false
 			d.Sms = false
+			d.Retry = false
 			Ch.ChanEnd(d.Addr, r)
 		} else {
 			d.count++
@@ -216,6 +325,19 @@ func (d *TestDev) callback(cmd int) {
 			Ch.ChanEnd(d.Addr, Dv.CStatusChnEnd|Dv.CStatusDevEnd)
 		}
 		d.busy = false
+	case 0x07: // Seek
+		_, e = Ch.ChanReadByte(d.Addr)
+		if !e {
+			d.count++
+			Ev.AddEvent(d, d.callback, 10, cmd)
+			return
+		}
+		Ch.ChanEnd(d.Addr, Dv.CStatusChnEnd)
+		delay := d.Delay
+		if delay == 0 {
+			delay = 100
+		}
+		Ev.AddEvent(d, d.callback, delay, 0x13)
 	case 0x13: // Return channel end
 		d.busy = false
 		Ch.SetDevAttn(d.Addr, Dv.CStatusDevEnd)
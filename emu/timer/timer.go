@@ -29,6 +29,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/rcornwell/S370/emu/event"
 	"github.com/rcornwell/S370/emu/master"
 )
 
@@ -86,7 +87,7 @@ func (timer *Timer) Shutdown() {
 // Internval timer routine to send timer events on master channel.
 func (timer *Timer) run() {
 	defer timer.wg.Done()
-	timer.ticker = time.NewTicker(6666666 * time.Nanosecond)
+	timer.ticker = time.NewTicker(event.TickPeriod)
 	defer timer.ticker.Stop()
 	timer.running = false
 
@@ -98,7 +99,7 @@ func (timer *Timer) run() {
 			}
 		case timer.running = <-timer.enable:
 			if timer.running {
-				timer.ticker.Reset(6666666 * time.Nanosecond)
+				timer.ticker.Reset(event.TickPeriod)
 			}
 		case <-timer.done:
 			return
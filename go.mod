@@ -5,6 +5,7 @@ go 1.22.3
 require (
 	github.com/pborman/getopt/v2 v2.1.0
 	github.com/peterh/liner v1.2.2
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
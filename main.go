@@ -26,9 +26,12 @@
 package main
 
 import (
+	"context"
 	"log/slog"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
 
 	getopt "github.com/pborman/getopt/v2"
 	reader "github.com/rcornwell/S370/command/reader"
@@ -89,7 +92,7 @@ func main() {
 	masterChannel := make(chan master.Packet)
 
 	// Create new routine to run CPU.
-	cpu := core.NewCPU(masterChannel)
+	cpu := core.NewCPU(masterChannel, nil, 0)
 
 	// Configure I/O devices.
 	syschannel.ResetChannels()
@@ -104,9 +107,13 @@ func main() {
 	// Start main emulator.
 	go cpu.Start()
 
+	// Terminate signal stops console reader even with no input.
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
 	msg := make(chan string, 1)
 	go func() {
-		reader.ConsoleReader(cpu)
+		reader.ConsoleReader(ctx, cpu)
 		msg <- ""
 	}()
 
@@ -0,0 +1,417 @@
+/*
+ * S370 - 3270 data stream handling.
+ *
+ * Copyright 2024, Richard Cornwell
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package telnet
+
+import (
+	"errors"
+	"net"
+)
+
+const (
+	// 3270 write commands, remote and local forms.
+	Cmd3270W    byte = 0xf1 // Write
+	Cmd3270EW   byte = 0xf5 // Erase/Write
+	Cmd3270EWA  byte = 0x7e // Erase/Write alternate
+	Cmd3270EAU  byte = 0x6f // Erase all unprotected
+	Cmd3270LW   byte = 0x01 // Local Write
+	Cmd3270LEW  byte = 0x05 // Local Erase/Write
+	Cmd3270LEWA byte = 0x0d // Local Erase/Write alternate
+	Cmd3270LEAU byte = 0x0f // Local Erase all unprotected
+
+	// Buffer orders.
+	order3270SF  byte = 0x1d // Start field
+	order3270SFE byte = 0x29 // Start field extended
+	order3270SBA byte = 0x11 // Set buffer address
+	order3270SA  byte = 0x28 // Set attribute
+	order3270MF  byte = 0x2c // Modify field
+	order3270IC  byte = 0x13 // Insert cursor
+	order3270PT  byte = 0x05 // Program tab
+	order3270RA  byte = 0x3c // Repeat to address
+	order3270EUA byte = 0x12 // Erase unprotected to address
+	order3270GE  byte = 0x08 // Graphic escape
+
+	// Write control character bits.
+	wccAlarm   byte = 0x04 // Sound alarm
+	wccRestore byte = 0x02 // Restore keyboard
+	wccReset   byte = 0x01 // Reset modified data tags
+
+	// Field attribute bits.
+	attrProtect byte = 0x20 // Protected field
+	attrMDT     byte = 0x01 // Modified data tag
+
+	// Attention identifiers.
+	AIDNone  byte = 0x60 // No AID
+	AIDEnter byte = 0x7d // Enter key
+	AIDClear byte = 0x6d // Clear key
+	AIDPA1   byte = 0x6c // Program attention 1
+	AIDPA2   byte = 0x6e // Program attention 2
+	AIDPA3   byte = 0x6b // Program attention 3
+)
+
+// Codes for 12 bit buffer addresses.
+var addrCode = [64]byte{
+	0x40, 0xc1, 0xc2, 0xc3, 0xc4, 0xc5, 0xc6, 0xc7,
+	0xc8, 0xc9, 0x4a, 0x4b, 0x4c, 0x4d, 0x4e, 0x4f,
+	0x50, 0xd1, 0xd2, 0xd3, 0xd4, 0xd5, 0xd6, 0xd7,
+	0xd8, 0xd9, 0x5a, 0x5b, 0x5c, 0x5d, 0x5e, 0x5f,
+	0x60, 0x61, 0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7,
+	0xe8, 0xe9, 0x6a, 0x6b, 0x6c, 0x6d, 0x6e, 0x6f,
+	0xf0, 0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7,
+	0xf8, 0xf9, 0x7a, 0x7b, 0x7c, 0x7d, 0x7e, 0x7f,
+}
+
+var errShortRecord = errors.New("3270 record too short")
+
+// Image of 3270 display buffer.
+type Screen struct {
+	Rows    int    // Number of rows
+	Cols    int    // Number of columns
+	Cursor  int    // Cursor address
+	Alarm   bool   // Last write sounded alarm
+	Restore bool   // Last write restored keyboard
+	Buffer  []byte // Characters and field attributes
+	field   []bool // Position holds a field attribute
+}
+
+// Create new display buffer.
+func NewScreen(rows, cols int) *Screen {
+	return &Screen{
+		Rows:   rows,
+		Cols:   cols,
+		Buffer: make([]byte, rows*cols),
+		field:  make([]bool, rows*cols),
+	}
+}
+
+// Return screen size for terminal model.
+func ScreenSize(model byte) (int, int) {
+	switch model {
+	case '3':
+		return 32, 80
+	case '4':
+		return 43, 80
+	case '5':
+		return 27, 132
+	}
+	return 24, 80
+}
+
+// Decode a 12 or 14 bit buffer address.
+func DecodeAddr(hi, lo byte) int {
+	if (hi & 0xc0) == 0 {
+		return (int(hi&0x3f) << 8) | int(lo)
+	}
+	return (int(hi&0x3f) << 6) | int(lo&0x3f)
+}
+
+// Encode a 12 bit buffer address.
+func EncodeAddr(addr int) []byte {
+	return []byte{addrCode[(addr>>6)&0x3f], addrCode[addr&0x3f]}
+}
+
+// Send a 3270 record to terminal, escaping IAC and adding end of record.
+func Send3270(conn net.Conn, data []byte) error {
+	out := make([]byte, 0, len(data)+2)
+	for _, by := range data {
+		if by == tnIAC {
+			out = append(out, tnIAC)
+		}
+		out = append(out, by)
+	}
+	out = append(out, tnIAC, tnEOR)
+	_, err := conn.Write(out)
+	return err
+}
+
+// Return true if position holds a field attribute.
+func (screen *Screen) IsField(addr int) bool {
+	return screen.field[addr]
+}
+
+// Find field attribute that controls addr, -1 if unformatted.
+func (screen *Screen) fieldStart(addr int) int {
+	size := len(screen.Buffer)
+	for range size {
+		if screen.field[addr] {
+			return addr
+		}
+		addr--
+		if addr < 0 {
+			addr = size - 1
+		}
+	}
+	return -1
+}
+
+// Place a character in the buffer.
+func (screen *Screen) putChar(addr int, by byte) {
+	screen.Buffer[addr] = by
+	screen.field[addr] = false
+}
+
+// Advance buffer address with wrap.
+func (screen *Screen) next(addr int) int {
+	addr++
+	if addr >= len(screen.Buffer) {
+		addr = 0
+	}
+	return addr
+}
+
+// Clear screen.
+func (screen *Screen) erase() {
+	clear(screen.Buffer)
+	clear(screen.field)
+	screen.Cursor = 0
+}
+
+// Clear unprotected positions from addr up to stop.
+func (screen *Screen) eraseUnprotected(addr, stop int) {
+	fa := screen.fieldStart(addr)
+	for {
+		if screen.field[addr] {
+			fa = addr
+		} else if fa >= 0 && (screen.Buffer[fa]&attrProtect) == 0 {
+			screen.Buffer[addr] = 0
+		}
+		addr = screen.next(addr)
+		if addr == stop {
+			return
+		}
+	}
+}
+
+// Process an outbound write record.
+func (screen *Screen) Write(data []byte) error {
+	if len(data) < 1 {
+		return errShortRecord
+	}
+
+	switch data[0] {
+	case Cmd3270EW, Cmd3270EWA, Cmd3270LEW, Cmd3270LEWA:
+		screen.erase()
+	case Cmd3270EAU, Cmd3270LEAU:
+		for i := range screen.Buffer {
+			if screen.field[i] {
+				screen.Buffer[i] &^= attrMDT
+			}
+		}
+		screen.eraseUnprotected(0, 0)
+		screen.Cursor = 0
+		screen.Restore = true
+		return nil
+	case Cmd3270W, Cmd3270LW:
+	default:
+		return errors.New("not a 3270 write command")
+	}
+
+	if len(data) < 2 {
+		return errShortRecord
+	}
+	wcc := data[1]
+	screen.Alarm = (wcc & wccAlarm) != 0
+	screen.Restore = (wcc & wccRestore) != 0
+	if (wcc & wccReset) != 0 {
+		for i := range screen.Buffer {
+			if screen.field[i] {
+				screen.Buffer[i] &^= attrMDT
+			}
+		}
+	}
+
+	addr := screen.Cursor
+	for i := 2; i < len(data); i++ {
+		switch data[i] {
+		case order3270SF:
+			if i+1 >= len(data) {
+				return errShortRecord
+			}
+			i++
+			screen.Buffer[addr] = data[i]
+			screen.field[addr] = true
+			addr = screen.next(addr)
+
+		case order3270SFE:
+			if i+1 >= len(data) || i+1+2*int(data[i+1]) >= len(data) {
+				return errShortRecord
+			}
+			count := int(data[i+1])
+			attr := byte(0)
+			for j := range count {
+				// Only basic attribute type is supported.
+				if data[i+2+2*j] == 0xc0 {
+					attr = data[i+3+2*j]
+				}
+			}
+			i += 1 + 2*count
+			screen.Buffer[addr] = attr
+			screen.field[addr] = true
+			addr = screen.next(addr)
+
+		case order3270SBA:
+			if i+2 >= len(data) {
+				return errShortRecord
+			}
+			addr = DecodeAddr(data[i+1], data[i+2]) % len(screen.Buffer)
+			i += 2
+
+		case order3270SA:
+			i += 2
+
+		case order3270MF:
+			if i+1 >= len(data) {
+				return errShortRecord
+			}
+			i += 1 + 2*int(data[i+1])
+
+		case order3270IC:
+			screen.Cursor = addr
+
+		case order3270PT:
+			addr = screen.nextUnprotected(addr)
+
+		case order3270RA:
+			if i+3 >= len(data) {
+				return errShortRecord
+			}
+			stop := DecodeAddr(data[i+1], data[i+2]) % len(screen.Buffer)
+			by := data[i+3]
+			i += 3
+			if by == order3270GE && i+1 < len(data) {
+				i++
+				by = data[i]
+			}
+			for {
+				screen.putChar(addr, by)
+				addr = screen.next(addr)
+				if addr == stop {
+					break
+				}
+			}
+
+		case order3270EUA:
+			if i+2 >= len(data) {
+				return errShortRecord
+			}
+			stop := DecodeAddr(data[i+1], data[i+2]) % len(screen.Buffer)
+			i += 2
+			screen.eraseUnprotected(addr, stop)
+			addr = stop
+
+		case order3270GE:
+			if i+1 >= len(data) {
+				return errShortRecord
+			}
+			i++
+			screen.putChar(addr, data[i])
+			addr = screen.next(addr)
+
+		default:
+			screen.putChar(addr, data[i])
+			addr = screen.next(addr)
+		}
+	}
+	return nil
+}
+
+// Find first data position of next unprotected field after addr.
+func (screen *Screen) nextUnprotected(addr int) int {
+	for range len(screen.Buffer) {
+		if screen.field[addr] && (screen.Buffer[addr]&attrProtect) == 0 {
+			return screen.next(addr)
+		}
+		addr = screen.next(addr)
+	}
+	return 0
+}
+
+// Apply inbound record from terminal, setting modified data tags.
+func (screen *Screen) Input(data []byte) (byte, error) {
+	if len(data) < 1 {
+		return 0, errShortRecord
+	}
+	aid := data[0]
+	if len(data) < 3 {
+		return aid, nil
+	}
+	screen.Cursor = DecodeAddr(data[1], data[2]) % len(screen.Buffer)
+	addr := screen.Cursor
+	for i := 3; i < len(data); i++ {
+		if data[i] == order3270SBA {
+			if i+2 >= len(data) {
+				return aid, errShortRecord
+			}
+			addr = DecodeAddr(data[i+1], data[i+2]) % len(screen.Buffer)
+			i += 2
+			fa := screen.fieldStart(addr)
+			if fa >= 0 {
+				screen.Buffer[fa] |= attrMDT
+			}
+			continue
+		}
+		if !screen.field[addr] {
+			screen.Buffer[addr] = data[i]
+		}
+		addr = screen.next(addr)
+	}
+	return aid, nil
+}
+
+// Build inbound Read Modified record for aid.
+func (screen *Screen) ReadModified(aid byte) []byte {
+	out := []byte{aid}
+	switch aid {
+	case AIDClear, AIDPA1, AIDPA2, AIDPA3:
+		return out
+	}
+	out = append(out, EncodeAddr(screen.Cursor)...)
+
+	// Unformatted screen sends all data.
+	if screen.fieldStart(0) < 0 {
+		for _, by := range screen.Buffer {
+			if by != 0 {
+				out = append(out, by)
+			}
+		}
+		return out
+	}
+
+	for fa := range screen.Buffer {
+		if !screen.field[fa] || (screen.Buffer[fa]&attrMDT) == 0 {
+			continue
+		}
+		addr := screen.next(fa)
+		out = append(out, order3270SBA)
+		out = append(out, EncodeAddr(addr)...)
+		for !screen.field[addr] {
+			if screen.Buffer[addr] != 0 {
+				out = append(out, screen.Buffer[addr])
+			}
+			addr = screen.next(addr)
+		}
+	}
+	return out
+}
@@ -49,6 +49,7 @@ const (
 	tnGA      byte = 249 // Go ahead
 	tnIP      byte = 244 // Interrupt process
 	tnBRK     byte = 243 // break
+	tnEOR     byte = 239 // End of record
 	tnSE      byte = 240 // Sub negotiations end
 	tnIS      byte = 0
 	tnSend    byte = 1
@@ -110,6 +111,13 @@ var initString = []byte{
 	tnIAC, tnDO, tnOptionTerm,
 }
 
+// Sent once a 3270 terminal type is known.
+var init3270String = []byte{
+	tnIAC, tnDO, tnOptionEOR,
+	tnIAC, tnWILL, tnOptionEOR,
+	tnIAC, tnDO, tnOptionBinary,
+}
+
 // Convert option number to string.
 // func optName(opt byte) string {
 // 	switch opt {
@@ -141,6 +149,8 @@ type tnState struct {
 	extatr      byte       // Extra type
 	port        string     // Port number device came from.
 	group       string     // Group user wants
+	tn3270      bool       // Connection uses 3270 data stream
+	record      []byte     // 3270 record being received
 	//	luname      []byte             // Current user name
 	dev    Telnet             // Pointer to where to send data.
 	devNum uint16             // Device address
@@ -260,6 +270,15 @@ func (state *tnState) handleSE(term []byte) {
 		}
 		msg := fmt.Sprintf("Connected to device: %03x", state.devNum)
 		slog.Info(msg)
+		if state.model != 0 {
+			state.tn3270 = true
+			_, err := state.conn.Write(init3270String)
+			if err != nil {
+				slog.Warn("Send error: " + err.Error() + "on Port: " + state.port)
+			}
+			state.optionState[tnOptionEOR] |= tnFlagDo | tnFlagWill
+			state.optionState[tnOptionBinary] |= tnFlagDo
+		}
 		state.SendConnect()
 	}
 }
@@ -303,6 +322,8 @@ func handleClient(conn net.Conn, master chan master.Packet, port string) {
 				if input == tnIAC {
 					state.state = tnStateIAC
 					//	fmt.Println("data: IAC")
+				} else if state.tn3270 {
+					state.record = append(state.record, input)
 				} else {
 					//		fmt.Printf("data: %02x %c\n", input, input)
 					out = append(out, input)
@@ -313,7 +334,19 @@ func handleClient(conn net.Conn, master chan master.Packet, port string) {
 				case tnIAC:
 					// Send character to device
 					state.state = tnStateData
+					if state.tn3270 {
+						state.record = append(state.record, input)
+					} else {
+						out = append(out, input)
+					}
 					// fmt.Println("IAC")
+				case tnEOR:
+					// Send 3270 record to device.
+					state.state = tnStateData
+					if state.tn3270 {
+						state.SendReceiveChar(state.record)
+						state.record = nil
+					}
 				case tnBRK:
 					state.state = tnStateData
 					//		fmt.Println("BRK")
@@ -447,11 +480,13 @@ func (state *tnState) determineTerm(termType []byte) {
 	i := strings.Index(termStr, "@")
 	if i >= 0 {
 		state.group = termStr[i+1:]
+		termStr = termStr[:i]
+		termType = termType[:i]
 	}
-	if termStr[0:4] == "IBM-" {
+	if len(termStr) >= 8 && termStr[0:4] == "IBM-" {
 		state.model = term[termStr[4:8]]
 		state.extatr = 'N'
-		if termType[8] != '-' {
+		if len(termStr) < 10 || termType[8] != '-' {
 			return
 		}
 		if termType[9] < '1' || termType[9] > '5' {
@@ -461,7 +496,7 @@ func (state *tnState) determineTerm(termType []byte) {
 		if termStr[4:7] == "328" {
 			state.model = '2'
 		}
-		if termStr[10:11] == "-E" {
+		if len(termStr) >= 12 && termStr[10:12] == "-E" {
 			state.extatr = 'Y'
 		}
 	}
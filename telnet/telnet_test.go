@@ -0,0 +1,264 @@
+/*
+ * S370 - telnet and 3270 data stream tests.
+ *
+ * Copyright 2024, Richard Cornwell
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package telnet
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rcornwell/S370/emu/master"
+)
+
+// Dummy device for terminal registration.
+type testTerm struct{}
+
+func (*testTerm) Connect(_ net.Conn)   {}
+func (*testTerm) ReceiveChar(_ []byte) {}
+func (*testTerm) Disconnect()          {}
+
+// Collect everything server sends to client.
+type clientOutput struct {
+	lock sync.Mutex
+	data []byte
+}
+
+func (client *clientOutput) run(conn net.Conn) {
+	buf := make([]byte, 256)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+		client.lock.Lock()
+		client.data = append(client.data, buf[:n]...)
+		client.lock.Unlock()
+	}
+}
+
+// Wait for server to have sent pattern.
+func (client *clientOutput) wait(pattern []byte) bool {
+	for range 100 {
+		client.lock.Lock()
+		found := bytes.Contains(client.data, pattern)
+		client.lock.Unlock()
+		if found {
+			return true
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return false
+}
+
+// Wait for packet from server.
+func getPacket(t *testing.T, masterChan chan master.Packet) master.Packet {
+	t.Helper()
+	select {
+	case packet := <-masterChan:
+		return packet
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for master packet")
+	}
+	return master.Packet{}
+}
+
+// Feed recorded negotiation and an inbound record.
+func TestNegotiate3270(t *testing.T) {
+	err := RegisterTerminal(&testTerm{}, 0x0c0, '2', "tn3270test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	masterChan := make(chan master.Packet, 10)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		server, err := listener.Accept()
+		if err == nil {
+			handleClient(server, masterChan, "tn3270test")
+		}
+	}()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := &clientOutput{}
+	go output.run(client)
+
+	if !output.wait(initString) {
+		t.Fatal("Did not receive initial negotiation")
+	}
+
+	// Client agrees to send terminal type.
+	_, _ = client.Write([]byte{tnIAC, tnWILL, tnOptionTerm})
+	if !output.wait([]byte{tnIAC, tnSB, tnOptionTerm, tnSend, tnIAC, tnSE}) {
+		t.Fatal("Did not receive terminal type request")
+	}
+
+	_, _ = client.Write([]byte{tnIAC, tnSB, tnOptionTerm, tnIS})
+	_, _ = client.Write([]byte("IBM-3278-2"))
+	_, _ = client.Write([]byte{tnIAC, tnSE})
+	packet := getPacket(t, masterChan)
+	if packet.Msg != master.TelConnect || packet.DevNum != 0x0c0 {
+		t.Errorf("Connect packet expected %d %03x got: %d %03x", master.TelConnect, 0x0c0, packet.Msg, packet.DevNum)
+	}
+	if !output.wait(init3270String) {
+		t.Error("Did not receive 3270 negotiation")
+	}
+
+	// Enter with one modified field, containing an escaped IAC.
+	_, _ = client.Write([]byte{tnIAC, tnDO, tnOptionEOR, tnIAC, tnWILL, tnOptionEOR, tnIAC, tnWILL, tnOptionBinary})
+	_, _ = client.Write([]byte{AIDEnter, 0x40, 0xc5, order3270SBA, 0x40, 0xc1, 0xc8, tnIAC, tnIAC, 0xc9, tnIAC, tnEOR})
+	packet = getPacket(t, masterChan)
+	expect := []byte{AIDEnter, 0x40, 0xc5, order3270SBA, 0x40, 0xc1, 0xc8, 0xff, 0xc9}
+	if packet.Msg != master.TelReceive || !bytes.Equal(packet.Data, expect) {
+		t.Errorf("Receive packet expected %d %x got: %d %x", master.TelReceive, expect, packet.Msg, packet.Data)
+	}
+
+	client.Close()
+	packet = getPacket(t, masterChan)
+	if packet.Msg != master.TelDisconnect {
+		t.Errorf("Disconnect packet expected %d got: %d", master.TelDisconnect, packet.Msg)
+	}
+	<-done
+}
+
+// Line mode terminals should not use 3270 records.
+func TestDetermineTerm(t *testing.T) {
+	tests := []struct {
+		term  string
+		model byte
+		group string
+	}{
+		{"IBM-3278-2", '2', ""},
+		{"IBM-3279-4-E@tso", '4', "tso"},
+		{"IBM-3278", '2', ""},
+		{"VT100", 0, ""},
+		{"ANSI@grp", 0, "grp"},
+	}
+
+	for _, test := range tests {
+		state := tnState{}
+		state.determineTerm([]byte(test.term))
+		if state.model != test.model {
+			t.Errorf("Term %s model expected %d got: %d", test.term, test.model, state.model)
+		}
+		if state.group != test.group {
+			t.Errorf("Term %s group expected %s got: %s", test.term, test.group, state.group)
+		}
+	}
+}
+
+// Buffer addresses encode and decode.
+func TestAddress(t *testing.T) {
+	for addr := range 4096 {
+		b := EncodeAddr(addr)
+		if DecodeAddr(b[0], b[1]) != addr {
+			t.Errorf("Address %d encoded %x decoded %d", addr, b, DecodeAddr(b[0], b[1]))
+		}
+	}
+	if DecodeAddr(0x01, 0x02) != 0x102 {
+		t.Errorf("14 bit address expected %d got: %d", 0x102, DecodeAddr(0x01, 0x02))
+	}
+}
+
+// Process a simple Erase/Write and read back modified fields.
+func TestWriteStream(t *testing.T) {
+	screen := NewScreen(ScreenSize('2'))
+	data := []byte{
+		Cmd3270EW, wccRestore | wccReset,
+		order3270SBA, 0x40, 0x40, order3270SF, 0x60, 0xc8, 0xc9, // Protected "HI"
+		order3270SF, 0x40, order3270IC, // Unprotected input field
+		order3270SBA, 0x40, 0x4a, order3270SF, 0x60, // End of input field at 10
+		order3270SBA, 0x5d, 0x7f, order3270RA, 0x40, 0x40, 0x5c, // Fill last row with *
+	}
+	err := screen.Write(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !screen.Restore || screen.Alarm {
+		t.Errorf("WCC restore %t alarm %t", screen.Restore, screen.Alarm)
+	}
+	if screen.Cursor != 4 {
+		t.Errorf("Cursor expected %d got: %d", 4, screen.Cursor)
+	}
+	if !screen.IsField(0) || screen.Buffer[0] != 0x60 {
+		t.Errorf("Field at 0 expected %02x got: %02x", 0x60, screen.Buffer[0])
+	}
+	if screen.Buffer[1] != 0xc8 || screen.Buffer[2] != 0xc9 {
+		t.Errorf("Data expected c8c9 got: %02x%02x", screen.Buffer[1], screen.Buffer[2])
+	}
+	if !screen.IsField(3) || !screen.IsField(10) {
+		t.Error("Fields at 3 and 10 not set")
+	}
+	for i := 1919; i < 1920; i++ {
+		if screen.Buffer[i] != 0x5c {
+			t.Errorf("Repeat at %d expected %02x got: %02x", i, 0x5c, screen.Buffer[i])
+		}
+	}
+	if screen.Buffer[1918] != 0 {
+		t.Errorf("Repeat overwrote %d got: %02x", 1918, screen.Buffer[1918])
+	}
+
+	// Nothing modified yet.
+	out := screen.ReadModified(AIDEnter)
+	expect := []byte{AIDEnter, 0x40, 0xc4}
+	if !bytes.Equal(out, expect) {
+		t.Errorf("Read modified expected %x got: %x", expect, out)
+	}
+
+	// Operator types into input field.
+	aid, err := screen.Input([]byte{AIDEnter, 0x40, 0xc6, order3270SBA, 0x40, 0xc4, 0xd6, 0xd2})
+	if err != nil || aid != AIDEnter {
+		t.Errorf("Input aid %02x error %v", aid, err)
+	}
+	out = screen.ReadModified(AIDEnter)
+	expect = []byte{AIDEnter, 0x40, 0xc6, order3270SBA, 0x40, 0xc4, 0xd6, 0xd2}
+	if !bytes.Equal(out, expect) {
+		t.Errorf("Read modified expected %x got: %x", expect, out)
+	}
+
+	// Erase all unprotected clears input and tags.
+	err = screen.Write([]byte{Cmd3270EAU})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if screen.Buffer[4] != 0 || screen.Buffer[1] != 0xc8 {
+		t.Errorf("Erase unprotected got %02x %02x", screen.Buffer[4], screen.Buffer[1])
+	}
+	out = screen.ReadModified(AIDPA1)
+	if !bytes.Equal(out, []byte{AIDPA1}) {
+		t.Errorf("Short read expected %x got: %x", []byte{AIDPA1}, out)
+	}
+}
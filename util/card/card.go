@@ -34,6 +34,7 @@ import (
 	"os"
 	"strings"
 
+	"github.com/rcornwell/S370/util/ebcdic"
 	"github.com/rcornwell/S370/util/xlat"
 )
 
@@ -71,15 +72,16 @@ type Card struct {
 }
 
 type Context struct {
-	file        *os.File // Fle handle.
-	fileName    string   // Name of last file attached.
-	mode        int      // Current input/output mode.
-	hopperCards int      // Number of cards in hopper.
-	hopperPos   int      // Position in hopper.
-	eofPending  bool     // Next return should be EOF.
-	table       int      // Translation table to use.
-	attached    bool     // Attached to a file.
-	deck        []Card   // Card images.
+	file        *os.File         // Fle handle.
+	fileName    string           // Name of last file attached.
+	mode        int              // Current input/output mode.
+	hopperCards int              // Number of cards in hopper.
+	hopperPos   int              // Position in hopper.
+	eofPending  bool             // Next return should be EOF.
+	table       int              // Translation table to use.
+	codePage    *ebcdic.CodePage // EBCDIC code page for text, nil for table.
+	attached    bool             // Attached to a file.
+	deck        []Card           // Card images.
 }
 
 type cardBuffer struct {
@@ -330,14 +332,7 @@ func (ctx *Context) PunchCard(img Card) int {
 		ok := true
 		// Try to convert each column to ascii
 		for i := range 80 {
-			var ch uint8
-			c := img.Image[i]
-			switch ctx.table {
-			case Type029, TypeASCII:
-				ch = holToASCIITable29[c]
-			case Type026:
-				ch = holToASCIITable26[c]
-			}
+			ch := ctx.holToChar(img.Image[i])
 			if ch == 0xff {
 				ok = false
 				break
@@ -357,14 +352,7 @@ func (ctx *Context) PunchCard(img Card) int {
 		// Scan each column
 		// Try to convert each column to ascii
 		for i := range 80 {
-			var ch uint8
-			c := img.Image[i]
-			switch ctx.table {
-			case Type029, TypeASCII:
-				ch = holToASCIITable29[c]
-			case Type026:
-				ch = holToASCIITable26[c]
-			}
+			ch := ctx.holToChar(img.Image[i])
 			if ch == 0xff {
 				ch = '?'
 			}
@@ -484,6 +472,53 @@ func (ctx *Context) SetTable(table int) {
 	ctx.table = table
 }
 
+// Select EBCDIC code page used to translate text cards, nil
+// uses the keypunch table.
+func (ctx *Context) SetCodePage(page *ebcdic.CodePage) {
+	ctx.codePage = page
+}
+
+// Convert Hollerith code to character, 0xff if none.
+func (ctx *Context) holToChar(c uint16) uint8 {
+	if ctx.codePage != nil {
+		e := holToEBCDICTable[c]
+		if e == 0x100 {
+			return 0xff
+		}
+		return ctx.codePage.ToASCII[e]
+	}
+	switch ctx.table {
+	case Type029, TypeASCII:
+		return holToASCIITable29[c]
+	case Type026:
+		return holToASCIITable26[c]
+	}
+	return 0xff
+}
+
+// Convert character to Hollerith code, error flag set if none.
+func (ctx *Context) charToHol(ch uint8) uint16 {
+	if ctx.codePage != nil {
+		e := ctx.codePage.FromASCII[ch]
+		if e == 0xff {
+			return 0xf000
+		}
+		return ebcdicToHolTable[e]
+	}
+	if ch >= 0x80 {
+		return 0xf000
+	}
+	switch ctx.table {
+	case Type029:
+		return asciiToHol29[ch]
+	case Type026:
+		return asciiToHol26[ch]
+	case TypeASCII:
+		return asciiToHolEbcdic[ch]
+	}
+	return 0
+}
+
 func NewCardContext(mode int) *Context {
 	return &Context{
 		mode:  mode,
@@ -654,16 +689,7 @@ func (ctx *Context) parseCard(buf *cardBuffer) {
 				col = 80
 				p--
 			default:
-				var t uint16
-
-				switch ctx.table {
-				case Type029:
-					t = asciiToHol29[ch]
-				case Type026:
-					t = asciiToHol26[ch]
-				case TypeASCII:
-					t = asciiToHolEbcdic[ch]
-				}
+				t := ctx.charToHol(ch)
 				if (t & 0xf000) != 0 {
 					t = 0xfff
 				}
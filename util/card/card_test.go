@@ -30,6 +30,7 @@ import (
 	"os"
 	"testing"
 
+	"github.com/rcornwell/S370/util/ebcdic"
 	"github.com/rcornwell/S370/util/xlat"
 )
 
@@ -1551,3 +1552,34 @@ card7:
 	}
 	ctx.Detach()
 }
+
+// Check text translation through code page.
+func TestCardCodePage(t *testing.T) {
+	ctx = NewCardContext(ModeText)
+	defer freeCtx()
+	german, err := ebcdic.GetCodePage("GERMAN")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Keypunch table has no national characters.
+	if h := ctx.charToHol(0xc4); (h & 0xf000) == 0 {
+		t.Errorf("029 translated 0xc4 to %03x", h)
+	}
+
+	ctx.SetCodePage(german)
+	if h := ctx.charToHol(0xc4); h != EBCDICToHol(0x4a) {
+		t.Errorf("German Ä got: %03x wanted: %03x", h, EBCDICToHol(0x4a))
+	}
+	if ch := ctx.holToChar(EBCDICToHol(0x4a)); ch != 0xc4 {
+		t.Errorf("German 0x4a got: %02x wanted: c4", ch)
+	}
+	if ch := ctx.holToChar(EBCDICToHol(0xc1)); ch != 'A' {
+		t.Errorf("German 0xc1 got: %02x wanted: %02x", ch, 'A')
+	}
+
+	ctx.SetCodePage(nil)
+	if ch := ctx.holToChar(EBCDICToHol(0xc1)); ch != 'A' {
+		t.Errorf("029 0xc1 got: %02x wanted: %02x", ch, 'A')
+	}
+}
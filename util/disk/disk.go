@@ -0,0 +1,354 @@
+/*
+ * S370 - Generic count key data disk interface.
+ *
+ * Copyright 2024, Richard Cornwell
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package disk
+
+/*
+ * Disk images start with a header block, followed by one fixed size
+ * slot per track. Each track holds:
+ *
+ *   Home address:  flag, CC, HH                (5 bytes)
+ *   Records:       CC, HH, R, KL, DL, key, data (8 + KL + DL bytes)
+ *   End of track:  0xff, 0xff, 0xff, 0xff
+ *
+ * Record zero always follows the home address.
+ */
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	headerSize = 512        // Size of image header
+	headerID   = "CKD_P370" // Header identifier
+
+	HALen    = 5 // Length of home address
+	CountLen = 8 // Length of count field
+)
+
+var (
+	errNotAttached = errors.New("not attached")
+	errFormat      = errors.New("disk image format error")
+	errType        = errors.New("disk type not supported")
+	errAddress     = errors.New("invalid track address")
+	errReadOnly    = errors.New("disk write protected")
+)
+
+// Disk geometry information.
+type diskType struct {
+	typeCode  uint8 // Device type code
+	cyls      int   // Number of cylinders, including alternates
+	heads     int   // Number of heads per cylinder
+	trackSize int   // Bytes per track
+	senseLen  int   // Number of sense bytes
+}
+
+var diskTypes = map[string]diskType{
+	"2314": {typeCode: 0x14, cyls: 203, heads: 20, trackSize: 7294, senseLen: 6},
+	"3330": {typeCode: 0x30, cyls: 411, heads: 19, trackSize: 13165, senseLen: 24},
+}
+
+// Structure to hold disk information.
+type Context struct {
+	file     *os.File // File handle
+	dType    string   // Name of disk type
+	geom     diskType // Geometry of disk
+	readOnly bool     // Disk is write protected
+	tsize    int      // Size of track slot in file
+	cyl      int      // Cylinder in buffer
+	head     int      // Head in buffer
+	valid    bool     // Buffer holds a track
+	dirty    bool     // Buffer is dirty
+	buffer   []byte   // Track buffer
+}
+
+// Create a new disk context.
+func NewDiskContext() *Context {
+	return &Context{dType: "2314", geom: diskTypes["2314"]}
+}
+
+// Set type of disk.
+func (disk *Context) SetType(name string) error {
+	name = strings.ToUpper(name)
+	geom, ok := diskTypes[name]
+	if !ok {
+		return errType
+	}
+	if disk.file != nil {
+		return errors.New("can't change type while attached")
+	}
+	disk.dType = name
+	disk.geom = geom
+	return nil
+}
+
+// Return current disk type.
+func (disk *Context) GetType() string {
+	return disk.dType
+}
+
+// Get list of supported disk types.
+func GetTypeList() []string {
+	typeList := []string{}
+	for k := range diskTypes {
+		typeList = append(typeList, k)
+	}
+	return typeList
+}
+
+// Return device type code.
+func (disk *Context) TypeCode() uint8 {
+	return disk.geom.typeCode
+}
+
+// Return number of cylinders.
+func (disk *Context) Cylinders() int {
+	return disk.geom.cyls
+}
+
+// Return number of heads.
+func (disk *Context) Heads() int {
+	return disk.geom.heads
+}
+
+// Return number of bytes per track.
+func (disk *Context) TrackSize() int {
+	return disk.geom.trackSize
+}
+
+// Return number of sense bytes.
+func (disk *Context) SenseLen() int {
+	return disk.geom.senseLen
+}
+
+// Set disk read only.
+func (disk *Context) SetReadOnly() {
+	disk.readOnly = true
+}
+
+// Set disk read/write.
+func (disk *Context) SetReadWrite() {
+	disk.readOnly = false
+}
+
+// Return if disk is write protected.
+func (disk *Context) ReadOnly() bool {
+	return disk.readOnly
+}
+
+// Return if attached to a file.
+func (disk *Context) Attached() bool {
+	return disk.file != nil
+}
+
+// Return file name attached.
+func (disk *Context) FileName() string {
+	if disk.file != nil {
+		return disk.file.Name()
+	}
+	return ""
+}
+
+// Attach file to disk context, create the image if it does not exist.
+func (disk *Context) Attach(fileName string) error {
+	var err error
+	if disk.readOnly {
+		disk.file, err = os.Open(fileName)
+	} else {
+		disk.file, err = os.OpenFile(fileName, os.O_RDWR|os.O_CREATE, 0o644)
+	}
+	if err != nil {
+		disk.file = nil
+		return err
+	}
+
+	disk.valid = false
+	disk.dirty = false
+	err = disk.readHeader()
+	if err != nil {
+		disk.file.Close()
+		disk.file = nil
+		return err
+	}
+	disk.buffer = make([]byte, disk.tsize)
+	return nil
+}
+
+// Detach a disk file from a disk context.
+func (disk *Context) Detach() error {
+	if disk.file == nil {
+		return nil
+	}
+	err := disk.Flush()
+	disk.file.Close()
+	disk.file = nil
+	disk.valid = false
+	return err
+}
+
+// Make track at cylinder and head current, flushing previous track.
+func (disk *Context) SeekTrack(cyl, head int) error {
+	if disk.file == nil {
+		return errNotAttached
+	}
+	if cyl < 0 || cyl >= disk.geom.cyls || head < 0 || head >= disk.geom.heads {
+		return errAddress
+	}
+
+	if disk.valid && disk.cyl == cyl && disk.head == head {
+		return nil
+	}
+
+	err := disk.Flush()
+	if err != nil {
+		return err
+	}
+
+	disk.cyl = cyl
+	disk.head = head
+	disk.valid = true
+	n, err := disk.file.ReadAt(disk.buffer, disk.trackOffset())
+	if err != nil && !errors.Is(err, io.EOF) {
+		disk.valid = false
+		return err
+	}
+
+	// Tracks not yet written to the image are returned formatted.
+	if n < HALen+CountLen || isEmpty(disk.buffer[:HALen+CountLen]) {
+		disk.formatTrack()
+	}
+	return nil
+}
+
+// Return current track buffer.
+func (disk *Context) Track() []byte {
+	if !disk.valid {
+		return nil
+	}
+	return disk.buffer
+}
+
+// Mark current track as modified.
+func (disk *Context) MarkDirty() error {
+	if disk.readOnly {
+		return errReadOnly
+	}
+	disk.dirty = true
+	return nil
+}
+
+// Write current track back to the image if modified.
+func (disk *Context) Flush() error {
+	if !disk.dirty || disk.file == nil {
+		return nil
+	}
+	disk.dirty = false
+	n, err := disk.file.WriteAt(disk.buffer, disk.trackOffset())
+	if err == nil && n != len(disk.buffer) {
+		err = errors.New("Write error on: " + disk.file.Name())
+	}
+	return err
+}
+
+// Compute offset of current track in file.
+func (disk *Context) trackOffset() int64 {
+	track := int64(disk.cyl*disk.geom.heads + disk.head)
+	return headerSize + track*int64(disk.tsize)
+}
+
+// Fill buffer with an empty track, home address and record zero.
+func (disk *Context) formatTrack() {
+	buf := disk.buffer
+	clear(buf)
+	cc := []byte{byte(disk.cyl >> 8), byte(disk.cyl), byte(disk.head >> 8), byte(disk.head)}
+	copy(buf[1:], cc)
+	pos := HALen
+	copy(buf[pos:], cc)
+	buf[pos+7] = 8 // R0 with 8 bytes of data.
+	pos += CountLen + 8
+	copy(buf[pos:], []byte{0xff, 0xff, 0xff, 0xff})
+}
+
+// Read header of image, or write a new one for an empty file.
+func (disk *Context) readHeader() error {
+	hdr := make([]byte, headerSize)
+	n, err := disk.file.ReadAt(hdr, 0)
+	if n == 0 && errors.Is(err, io.EOF) {
+		if disk.readOnly {
+			return errFormat
+		}
+		return disk.writeHeader()
+	}
+	if n != headerSize {
+		return errFormat
+	}
+	if !bytes.Equal(hdr[:8], []byte(headerID)) {
+		return errFormat
+	}
+
+	// Find type matching image.
+	heads := int(binary.LittleEndian.Uint32(hdr[8:]))
+	typeCode := hdr[16]
+	for name, geom := range diskTypes {
+		if geom.typeCode == typeCode && geom.heads == heads {
+			disk.dType = name
+			disk.geom = geom
+			disk.tsize = int(binary.LittleEndian.Uint32(hdr[12:]))
+			if disk.tsize < geom.trackSize {
+				return errFormat
+			}
+			return nil
+		}
+	}
+	return errType
+}
+
+// Write header for new disk image.
+func (disk *Context) writeHeader() error {
+	hdr := make([]byte, headerSize)
+	disk.tsize = (disk.geom.trackSize + 0x1ff) &^ 0x1ff
+	copy(hdr, headerID)
+	binary.LittleEndian.PutUint32(hdr[8:], uint32(disk.geom.heads))
+	binary.LittleEndian.PutUint32(hdr[12:], uint32(disk.tsize))
+	hdr[16] = disk.geom.typeCode
+	binary.LittleEndian.PutUint16(hdr[17:], uint16(disk.geom.cyls))
+	_, err := disk.file.WriteAt(hdr, 0)
+	return err
+}
+
+// Check if buffer is all zeros.
+func isEmpty(buf []byte) bool {
+	for _, b := range buf {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,180 @@
+/*
+ * S370 - EBCDIC and ASCII translation.
+ *
+ * Copyright 2024, Richard Cornwell
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package ebcdic
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/rcornwell/S370/util/xlat"
+)
+
+/*
+   Code pages give the national use characters of each EBCDIC variant.
+   Characters outside of ASCII are given in Latin-1. The US page is
+   the default translation used by all devices.
+*/
+
+// EBCDIC to ASCII, characters with no translation give 0xff.
+var ToASCII = xlat.EBCDICToASCII
+
+// ASCII to EBCDIC, characters above 0x7f give 0xff.
+var FromASCII [256]uint8
+
+// Translation tables for one EBCDIC variant.
+type CodePage struct {
+	Name      string     // Name of code page.
+	ToASCII   [256]uint8 // EBCDIC to ASCII/Latin-1.
+	FromASCII [256]uint8 // ASCII/Latin-1 to EBCDIC.
+}
+
+// Character that differs from the US code page.
+type nationalChar struct {
+	ebcdic uint8 // EBCDIC code point.
+	ascii  uint8 // Latin-1 character.
+}
+
+// Differences of each code page from the US table.
+var nationalChars = map[string][]nationalChar{
+	"US": {},
+	// Code page 273.
+	"GERMAN": {
+		{0x4a, 0xc4}, {0x4f, '!'}, {0x5a, 0xdc}, {0x6a, 0xf6}, {0x7c, 0xa7},
+		{0xa1, 0xdf}, {0xc0, 0xe4}, {0xd0, 0xfc}, {0xe0, 0xd6}, {0x43, '{'},
+		{0x59, '~'}, {0x63, '['}, {0xb5, '@'}, {0xbb, '|'}, {0xdc, '}'},
+		{0xec, '\\'}, {0xfc, ']'},
+	},
+	// Code page 285.
+	"UK": {
+		{0x4a, '$'}, {0x5b, 0xa3},
+	},
+}
+
+// Default code page.
+var US *CodePage
+
+var codePages = map[string]*CodePage{}
+
+func init() {
+	for i := range FromASCII {
+		if i < len(xlat.ASCIIToEBCDIC) {
+			FromASCII[i] = xlat.ASCIIToEBCDIC[i]
+		} else {
+			FromASCII[i] = 0xff
+		}
+	}
+
+	for name, chars := range nationalChars {
+		page := &CodePage{Name: name, ToASCII: ToASCII, FromASCII: FromASCII}
+		for _, ch := range chars {
+			page.ToASCII[ch.ebcdic] = ch.ascii
+			page.FromASCII[ch.ascii] = ch.ebcdic
+		}
+		codePages[name] = page
+	}
+	US = codePages["US"]
+}
+
+// Return code page by name.
+func GetCodePage(name string) (*CodePage, error) {
+	page, ok := codePages[strings.ToUpper(name)]
+	if !ok {
+		return nil, errors.New("unknown code page: " + name)
+	}
+	return page, nil
+}
+
+// Return sorted list of code page names.
+func CodePages() []string {
+	names := []string{}
+	for name := range codePages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Translate buffer of EBCDIC characters to ASCII.
+func (page *CodePage) BytesToASCII(in []byte) []byte {
+	out := make([]byte, len(in))
+	for i, by := range in {
+		out[i] = page.ToASCII[by]
+	}
+	return out
+}
+
+// Translate buffer of ASCII characters to EBCDIC.
+func (page *CodePage) BytesFromASCII(in []byte) []byte {
+	out := make([]byte, len(in))
+	for i, by := range in {
+		out[i] = page.FromASCII[by]
+	}
+	return out
+}
+
+// Translate EBCDIC buffer to string, Latin-1 characters are
+// returned as UTF-8.
+func (page *CodePage) String(in []byte) string {
+	var str strings.Builder
+	for _, by := range in {
+		str.WriteRune(rune(page.ToASCII[by]))
+	}
+	return str.String()
+}
+
+// Translate string to EBCDIC buffer.
+func (page *CodePage) FromString(str string) []byte {
+	out := []byte{}
+	for _, r := range str {
+		if r > 0xff {
+			out = append(out, 0xff)
+		} else {
+			out = append(out, page.FromASCII[r])
+		}
+	}
+	return out
+}
+
+// Translate buffer of EBCDIC characters to ASCII.
+func BytesToASCII(in []byte) []byte {
+	return US.BytesToASCII(in)
+}
+
+// Translate buffer of ASCII characters to EBCDIC.
+func BytesFromASCII(in []byte) []byte {
+	return US.BytesFromASCII(in)
+}
+
+// Translate EBCDIC buffer to ASCII string.
+func String(in []byte) string {
+	return string(BytesToASCII(in))
+}
+
+// Translate ASCII string to EBCDIC buffer.
+func FromString(str string) []byte {
+	return BytesFromASCII([]byte(str))
+}
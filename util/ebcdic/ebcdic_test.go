@@ -0,0 +1,118 @@
+/*
+ * S370 - EBCDIC and ASCII translation tests.
+ *
+ * Copyright 2024, Richard Cornwell
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package ebcdic
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Test round trip of string and some code points.
+func TestRoundTrip(t *testing.T) {
+	str := "  UNPK  PROUT(9),WORD(5) 0123456789 abc"
+	buf := FromString(str)
+	if len(buf) != len(str) {
+		t.Fatalf("Length got: %d wanted: %d", len(buf), len(str))
+	}
+
+	// Same text as used by TestCycleTRT.
+	want := []byte{
+		0x40, 0x40, 0xe4, 0xd5, 0xd7, 0xd2, 0x40, 0x40, 0xd7, 0xd9,
+		0xd6, 0xe4, 0xe3, 0x4d, 0xf9, 0x5d, 0x6b, 0xe6, 0xd6, 0xd9, 0xc4, 0x4d, 0xf5, 0x5d,
+	}
+	if !bytes.Equal(buf[:len(want)], want) {
+		t.Errorf("Translate got: %x wanted: %x", buf[:len(want)], want)
+	}
+
+	points := []struct {
+		ch  byte
+		val byte
+	}{
+		{' ', 0x40}, {'A', 0xc1}, {'0', 0xf0}, {'9', 0xf9}, {'a', 0x81}, {'Z', 0xe9},
+	}
+	for _, p := range points {
+		if FromASCII[p.ch] != p.val {
+			t.Errorf("FromASCII %q got: %02x wanted: %02x", p.ch, FromASCII[p.ch], p.val)
+		}
+		if ToASCII[p.val] != p.ch {
+			t.Errorf("ToASCII %02x got: %q wanted: %q", p.val, ToASCII[p.val], p.ch)
+		}
+	}
+
+	if out := String(buf); out != str {
+		t.Errorf("Round trip got: %q wanted: %q", out, str)
+	}
+
+	if FromASCII[0x80] != 0xff {
+		t.Errorf("FromASCII 0x80 got: %02x wanted: ff", FromASCII[0x80])
+	}
+}
+
+// Test same text printed under different code pages.
+func TestCodePages(t *testing.T) {
+	us, err := GetCodePage("us")
+	if err != nil {
+		t.Fatal(err)
+	}
+	german, err := GetCodePage("GERMAN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	uk, err := GetCodePage("UK")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetCodePage("KLINGON"); err == nil {
+		t.Error("Unknown code page did not return error")
+	}
+	if us != US {
+		t.Error("US code page is not default")
+	}
+
+	// A1 $5 #7 @X{Y}! in US code page.
+	buf := []byte{0xc1, 0xf1, 0x40, 0x5b, 0xf5, 0x40, 0x7b, 0xf7, 0x40, 0x7c, 0xe7, 0xc0, 0xe8, 0xd0, 0x5a}
+	tests := []struct {
+		page *CodePage
+		want string
+	}{
+		{us, "A1 $5 #7 @X{Y}!"},
+		{german, "A1 $5 #7 §XäYüÜ"},
+		{uk, "A1 £5 #7 @X{Y}!"},
+	}
+	for _, test := range tests {
+		if out := test.page.String(buf); out != test.want {
+			t.Errorf("%s got: %q wanted: %q", test.page.Name, out, test.want)
+		}
+		if back := test.page.FromString(test.want); !bytes.Equal(back, buf) {
+			t.Errorf("%s back got: %x wanted: %x", test.page.Name, back, buf)
+		}
+	}
+
+	// Default page should be same as package tables.
+	if out := us.String(buf); out != String(buf) {
+		t.Errorf("US got: %q wanted: %q", out, String(buf))
+	}
+}
@@ -746,6 +746,40 @@ func (tape *Context) RewindFrames(frames int) bool {
 	return false
 }
 
+// Return position of tape in file and frame count, writing any buffered data.
+func (tape *Context) Position() (int64, int, error) {
+	if tape.dirty {
+		_, _ = tape.file.Seek(tape.position, io.SeekStart)
+		n, err := tape.file.Write(tape.buffer[:tape.bufLen])
+		if err != nil {
+			return 0, 0, err
+		}
+		if n != tape.bufLen {
+			return 0, 0, errors.New("Write error on: " + tape.file.Name())
+		}
+		tape.dirty = false
+	}
+	return tape.position + int64(tape.bufPos), tape.frame, nil
+}
+
+// Position tape at offset in file, buffer is flushed first.
+func (tape *Context) SetPosition(pos int64, frame int) error {
+	if tape.file == nil {
+		return errNotAttached
+	}
+	err := tape.StartRewind()
+	if err != nil {
+		return err
+	}
+	tape.position = pos
+	tape.frame = frame
+	tape.mark = false
+	tape.eot = false
+	tape.bot = pos == 0
+	tape.mode = funcNone
+	return nil
+}
+
 func NewTapeContext() *Context {
 	return &Context{}
 }